@@ -0,0 +1,671 @@
+// Package addrmgr tracks peer addresses of any network in new and tried buckets, as
+// Bitcoin Core's address manager does, and persists them to a local file.
+package addrmgr
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	mrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrAddrFile        = errors.New("addrmgr: bad address file")
+	ErrAddrFileVersion = errors.New("addrmgr: unknown address file version")
+)
+
+const (
+	// NewBucketCount is the number of buckets of addresses never connected to
+	NewBucketCount = 1024
+	// TriedBucketCount is the number of buckets of addresses connected to
+	TriedBucketCount = 256
+	// BucketSize is the number of addresses in a bucket
+	BucketSize = 64
+
+	// newBucketsPerSourceGroup is the number of new buckets the addresses from one
+	// source group spread over
+	newBucketsPerSourceGroup = 64
+	// triedBucketsPerGroup is the number of tried buckets the addresses of one group spread over
+	triedBucketsPerGroup = 8
+	// maxNewRefs is the number of new buckets an address can be in
+	maxNewRefs = 8
+
+	// Horizon is the age after which an address is forgotten
+	Horizon = 30 * 24 * time.Hour
+	// Retries is the number of failed attempts after which an address never connected to is forgotten
+	Retries = 3
+	// MaxFailures is the number of failed attempts after which an address is forgotten,
+	// when not connected to for MinFailTime
+	MaxFailures = 10
+	MinFailTime = 7 * 24 * time.Hour
+
+	// fileVersion is the version of the address file format
+	fileVersion = 1
+)
+
+// Non routable IP ranges, besides the loopback, private, link local and multicast ones
+var unroutableNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // RFC1122
+		"100.64.0.0/10",   // RFC6598, shared address space
+		"192.0.2.0/24",    // RFC5737, documentation
+		"198.18.0.0/15",   // RFC2544, benchmarking
+		"198.51.100.0/24", // RFC5737
+		"203.0.113.0/24",  // RFC5737
+		"240.0.0.0/4",     // RFC1112, reserved
+		"2001:db8::/32",   // RFC3849, documentation
+		"2001:10::/28",    // RFC4843, ORCHID
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// routable reports whether peers may be reached at na
+func routable(na *wire.NetAddressV2) bool {
+	if !na.Supported() {
+		return false
+	}
+
+	switch na.Network {
+	case wire.NetTorV3, wire.NetI2P:
+		return true
+	case wire.NetCJDNS:
+		return na.Addr[0] == 0xfc
+	}
+
+	ip := na.IP()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range unroutableNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Group returns the network group of na, addresses of a group being likely run by the
+// same operator: /16 of IPv4, /32 of IPv6 and the first 4 bits of other networks.
+func Group(na *wire.NetAddressV2) []byte {
+	if !routable(na) {
+		return []byte{0}
+	}
+
+	switch na.Network {
+	case wire.NetIPv4:
+		return []byte{na.Network, na.Addr[0], na.Addr[1]}
+	case wire.NetIPv6:
+		return []byte{na.Network, na.Addr[0], na.Addr[1], na.Addr[2], na.Addr[3]}
+	case wire.NetCJDNS:
+		// The first byte is always 0xfc
+		return []byte{na.Network, na.Addr[1] | 0x0f}
+	}
+
+	return []byte{na.Network, na.Addr[0] | 0x0f}
+}
+
+// knownAddress is an address with the history of our connections to it
+type knownAddress struct {
+	addr *wire.NetAddressV2
+	// The peer we learnt the address from
+	source      *wire.NetAddressV2
+	lastAttempt time.Time
+	lastSuccess time.Time
+	attempts    int
+	tried       bool
+	// Number of new buckets the address is in
+	refs int
+}
+
+// isTerrible reports whether the address is not worth keeping
+func (ka *knownAddress) isTerrible(now time.Time) bool {
+	// Never remove an address just tried
+	if now.Sub(ka.lastAttempt) <= time.Minute {
+		return false
+	}
+
+	seen := time.Unix(int64(ka.addr.Timestamp), 0)
+	if seen.After(now.Add(10*time.Minute)) || now.Sub(seen) > Horizon {
+		return true
+	}
+
+	if ka.lastSuccess.IsZero() && ka.attempts >= Retries {
+		return true
+	}
+
+	return now.Sub(ka.lastSuccess) > MinFailTime && ka.attempts >= MaxFailures
+}
+
+// chance is the relative chance of selecting the address, lowered by recent and failed attempts
+func (ka *knownAddress) chance(now time.Time) float64 {
+	c := 1.0
+	if now.Sub(ka.lastAttempt) < 10*time.Minute {
+		c *= 0.01
+	}
+
+	attempts := ka.attempts
+	if attempts > 8 {
+		attempts = 8
+	}
+	return c * math.Pow(0.66, float64(attempts))
+}
+
+func copyAddress(na *wire.NetAddressV2) *wire.NetAddressV2 {
+	c := *na
+	c.Addr = append([]byte{}, na.Addr...)
+	return &c
+}
+
+// AddrManager keeps the addresses we never connected to in new buckets, chosen by
+// the groups of the address and of its source, and those we connected to in tried
+// buckets, chosen by the group of the address. A group, or the peers of a group,
+// can thus fill only a few buckets. The buckets depend on a secret key.
+type AddrManager struct {
+	mu    sync.Mutex
+	path  string
+	key   [32]byte
+	rand  *mrand.Rand
+	index map[string]*knownAddress
+
+	newTable   [][BucketSize]*knownAddress
+	triedTable [][BucketSize]*knownAddress
+	nNew       int
+	nTried     int
+
+	// Clock, overridden in tests
+	now func() time.Time
+}
+
+func newAddrManager(path string) (*AddrManager, error) {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+
+	return &AddrManager{
+		path:       path,
+		rand:       mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+		index:      make(map[string]*knownAddress),
+		newTable:   make([][BucketSize]*knownAddress, NewBucketCount),
+		triedTable: make([][BucketSize]*knownAddress, TriedBucketCount),
+		now:        time.Now,
+	}, nil
+}
+
+// NewAddrManager returns the address manager persisted at path, empty with a new
+// key when the file does not exist. An empty path is never persisted.
+func NewAddrManager(path string) (*AddrManager, error) {
+	a, err := newAddrManager(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := a.load(data); err != nil {
+				return nil, err
+			}
+			return a, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if _, err := rand.Read(a.key[:]); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// hash returns the keyed hash of data
+func (a *AddrManager) hash(data ...[]byte) uint64 {
+	buffer := NewBuffer().PutBytes(a.key[:])
+	for _, d := range data {
+		buffer.PutBytes(d)
+	}
+
+	h := DHash256(buffer.Bytes())
+	return binary.LittleEndian.Uint64(h[:8])
+}
+
+func uint64Bytes(n uint64) []byte {
+	return NewBuffer().PutUint64(n).Bytes()
+}
+
+func (a *AddrManager) newBucket(na, source *wire.NetAddressV2) int {
+	sourceGroup := Group(source)
+	h := a.hash(Group(na), sourceGroup) % newBucketsPerSourceGroup
+	return int(a.hash(sourceGroup, uint64Bytes(h)) % NewBucketCount)
+}
+
+func (a *AddrManager) triedBucket(na *wire.NetAddressV2) int {
+	h := a.hash([]byte(na.Key())) % triedBucketsPerGroup
+	return int(a.hash(Group(na), uint64Bytes(h)) % TriedBucketCount)
+}
+
+func (a *AddrManager) bucketPosition(na *wire.NetAddressV2, isNew bool, bucket int) int {
+	table := byte('K')
+	if isNew {
+		table = 'N'
+	}
+	return int(a.hash([]byte{table}, uint64Bytes(uint64(bucket)), []byte(na.Key())) % BucketSize)
+}
+
+// clearNew empties a new bucket slot, forgetting its address when in no other bucket
+func (a *AddrManager) clearNew(bucket, pos int) {
+	ka := a.newTable[bucket][pos]
+	if ka == nil {
+		return
+	}
+
+	a.newTable[bucket][pos] = nil
+	ka.refs--
+	if ka.refs == 0 {
+		delete(a.index, ka.addr.Key())
+		a.nNew--
+	}
+}
+
+// Add adds addresses learnt from source, returning how many were added to a new bucket.
+// Non routable addresses are ignored, those without time or from the future are
+// given a time 5 days ago. A nil source is the address itself.
+func (a *AddrManager) Add(addrs []*wire.NetAddressV2, source *wire.NetAddressV2) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+	for _, na := range addrs {
+		if a.add(na, source) {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *AddrManager) add(na, source *wire.NetAddressV2) bool {
+	if !routable(na) {
+		return false
+	}
+	if source == nil {
+		source = na
+	}
+
+	now := a.now()
+	addr := copyAddress(na)
+	if addr.Timestamp <= 100000000 || int64(addr.Timestamp) > now.Add(10*time.Minute).Unix() {
+		addr.Timestamp = uint32(now.Add(-5 * 24 * time.Hour).Unix())
+	}
+
+	ka, ok := a.index[addr.Key()]
+	if ok {
+		if addr.Timestamp > ka.addr.Timestamp {
+			ka.addr.Timestamp = addr.Timestamp
+		}
+		ka.addr.Services |= addr.Services
+
+		if ka.tried || ka.refs == maxNewRefs {
+			return false
+		}
+
+		// The more buckets the address is in, the less likely it is added to another
+		if a.rand.Intn(1<<ka.refs) != 0 {
+			return false
+		}
+	} else {
+		ka = &knownAddress{addr: addr, source: copyAddress(source)}
+		a.index[addr.Key()] = ka
+		a.nNew++
+	}
+
+	bucket := a.newBucket(ka.addr, source)
+	pos := a.bucketPosition(ka.addr, true, bucket)
+	if existing := a.newTable[bucket][pos]; existing != ka {
+		// Only replace terrible addresses, or addresses in other buckets with a new one
+		if existing == nil || existing.isTerrible(now) || (existing.refs > 1 && ka.refs == 0) {
+			a.clearNew(bucket, pos)
+			a.newTable[bucket][pos] = ka
+			ka.refs++
+			return true
+		}
+	}
+
+	if ka.refs == 0 {
+		delete(a.index, ka.addr.Key())
+		a.nNew--
+	}
+	return false
+}
+
+// Attempt records a connection attempt to na
+func (a *AddrManager) Attempt(na *wire.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ka, ok := a.index[na.Key()]; ok {
+		ka.lastAttempt = a.now()
+		ka.attempts++
+	}
+}
+
+// Connected records that we are still connected to na, refreshing its time
+func (a *AddrManager) Connected(na *wire.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if ka, ok := a.index[na.Key()]; ok && now.Unix()-int64(ka.addr.Timestamp) > 20*60 {
+		ka.addr.Timestamp = uint32(now.Unix())
+	}
+}
+
+// Good records a successful connection to na, moving it to a tried bucket. The
+// address in its tried slot goes back to a new bucket.
+func (a *AddrManager) Good(na *wire.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka, ok := a.index[na.Key()]
+	if !ok {
+		return
+	}
+
+	now := a.now()
+	ka.lastSuccess = now
+	ka.lastAttempt = now
+	ka.attempts = 0
+	if ka.tried {
+		return
+	}
+
+	for bucket := range a.newTable {
+		pos := a.bucketPosition(ka.addr, true, bucket)
+		if a.newTable[bucket][pos] == ka {
+			a.newTable[bucket][pos] = nil
+			ka.refs--
+		}
+	}
+	a.nNew--
+
+	a.makeTried(ka)
+}
+
+// makeTried puts an address in no new bucket into its tried slot
+func (a *AddrManager) makeTried(ka *knownAddress) {
+	bucket := a.triedBucket(ka.addr)
+	pos := a.bucketPosition(ka.addr, false, bucket)
+
+	if evicted := a.triedTable[bucket][pos]; evicted != nil {
+		evicted.tried = false
+		a.nTried--
+
+		newBucket := a.newBucket(evicted.addr, evicted.source)
+		newPos := a.bucketPosition(evicted.addr, true, newBucket)
+		a.clearNew(newBucket, newPos)
+		a.newTable[newBucket][newPos] = evicted
+		evicted.refs = 1
+		a.nNew++
+	}
+
+	a.triedTable[bucket][pos] = ka
+	ka.tried = true
+	a.nTried++
+}
+
+// Select returns a random address to connect to, from the new buckets only when
+// newOnly is set, nil when there is none. Addresses recently tried or failing are
+// less likely.
+func (a *AddrManager) Select(newOnly bool) *wire.NetAddressV2 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.nNew+a.nTried == 0 || (newOnly && a.nNew == 0) {
+		return nil
+	}
+
+	table := a.newTable
+	if !newOnly && a.nTried > 0 && (a.nNew == 0 || a.rand.Intn(2) == 0) {
+		table = a.triedTable
+	}
+
+	now := a.now()
+	factor := 1.0
+	for {
+		bucket := a.rand.Intn(len(table))
+		start := a.rand.Intn(BucketSize)
+
+		var ka *knownAddress
+		for i := 0; i < BucketSize && ka == nil; i++ {
+			ka = table[bucket][(start+i)%BucketSize]
+		}
+		if ka == nil {
+			continue
+		}
+
+		if a.rand.Float64() < factor*ka.chance(now) {
+			return copyAddress(ka.addr)
+		}
+		factor *= 1.2
+	}
+}
+
+// Addresses returns up to max random addresses, and up to maxPct percent of the
+// known ones, to relay to peers. Terrible addresses are left out. A zero limit is
+// no limit.
+func (a *AddrManager) Addresses(max, maxPct int) []*wire.NetAddressV2 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := len(a.index)
+	if maxPct > 0 {
+		n = n * maxPct / 100
+	}
+	if max > 0 && max < n {
+		n = max
+	}
+
+	all := make([]*knownAddress, 0, len(a.index))
+	for _, ka := range a.index {
+		all = append(all, ka)
+	}
+	a.rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+
+	now := a.now()
+	addrs := make([]*wire.NetAddressV2, 0, n)
+	for _, ka := range all {
+		if len(addrs) == n {
+			break
+		}
+		if !ka.isTerrible(now) {
+			addrs = append(addrs, copyAddress(ka.addr))
+		}
+	}
+
+	return addrs
+}
+
+// Size returns the number of known addresses
+func (a *AddrManager) Size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nNew + a.nTried
+}
+
+func putTime(buffer *Buffer, t time.Time) {
+	if t.IsZero() {
+		buffer.PutUint64(0)
+		return
+	}
+	buffer.PutUint64(uint64(t.Unix()))
+}
+
+func getTime(buffer *Buffer) (time.Time, error) {
+	n, err := buffer.GetUint64()
+	if err != nil || n == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(n), 0), nil
+}
+
+// Bytes serializes the key and the addresses with their history, bucket positions
+// being computed again when loaded
+func (a *AddrManager) Bytes() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	buffer := NewBuffer().
+		PutUint8(fileVersion).
+		PutBytes(a.key[:]).
+		PutVarInt(uint64(len(a.index)))
+
+	for _, ka := range a.index {
+		buffer.PutBytes(ka.addr.Bytes()).PutBytes(ka.source.Bytes())
+		putTime(buffer, ka.lastAttempt)
+		putTime(buffer, ka.lastSuccess)
+		buffer.PutUint32(uint32(ka.attempts))
+		if ka.tried {
+			buffer.PutUint8(1)
+		} else {
+			buffer.PutUint8(0)
+		}
+	}
+
+	checksum := wire.Checksum(buffer.Bytes())
+	return buffer.PutBytes(checksum[:]).Bytes()
+}
+
+func (a *AddrManager) load(data []byte) error {
+	if len(data) < 4 {
+		return ErrAddrFile
+	}
+	if checksum := wire.Checksum(data[:len(data)-4]); string(checksum[:]) != string(data[len(data)-4:]) {
+		return ErrAddrFile
+	}
+	buffer := NewReadBuffer(data[:len(data)-4])
+
+	version, err := buffer.GetUint8()
+	if err != nil {
+		return ErrAddrFile
+	}
+	if version != fileVersion {
+		return ErrAddrFileVersion
+	}
+
+	key, err := buffer.GetBytes(len(a.key))
+	if err != nil {
+		return ErrAddrFile
+	}
+	copy(a.key[:], key)
+
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return ErrAddrFile
+	}
+
+	var tried, fresh []*knownAddress
+	for i := uint64(0); i < n; i++ {
+		ka, isTried, err := decodeKnownAddress(buffer)
+		if err != nil {
+			return ErrAddrFile
+		}
+
+		if isTried {
+			tried = append(tried, ka)
+		} else {
+			fresh = append(fresh, ka)
+		}
+	}
+
+	// Tried addresses first, those colliding go back to a new bucket
+	for _, ka := range tried {
+		if _, ok := a.index[ka.addr.Key()]; ok || !routable(ka.addr) {
+			continue
+		}
+
+		bucket := a.triedBucket(ka.addr)
+		if a.triedTable[bucket][a.bucketPosition(ka.addr, false, bucket)] == nil {
+			a.index[ka.addr.Key()] = ka
+			a.makeTried(ka)
+		} else {
+			fresh = append(fresh, ka)
+		}
+	}
+
+	// New addresses take their slot when free
+	for _, ka := range fresh {
+		if _, ok := a.index[ka.addr.Key()]; ok || !routable(ka.addr) {
+			continue
+		}
+
+		bucket := a.newBucket(ka.addr, ka.source)
+		pos := a.bucketPosition(ka.addr, true, bucket)
+		if a.newTable[bucket][pos] == nil {
+			a.index[ka.addr.Key()] = ka
+			a.newTable[bucket][pos] = ka
+			ka.refs = 1
+			a.nNew++
+		}
+	}
+
+	return nil
+}
+
+func decodeKnownAddress(buffer *Buffer) (*knownAddress, bool, error) {
+	ka := &knownAddress{}
+
+	var err error
+	if ka.addr, err = wire.NewNetAddressV2FromBuffer(buffer); err != nil {
+		return nil, false, err
+	}
+	if ka.source, err = wire.NewNetAddressV2FromBuffer(buffer); err != nil {
+		return nil, false, err
+	}
+
+	if ka.lastAttempt, err = getTime(buffer); err != nil {
+		return nil, false, err
+	}
+	if ka.lastSuccess, err = getTime(buffer); err != nil {
+		return nil, false, err
+	}
+
+	attempts, err := buffer.GetUint32()
+	if err != nil {
+		return nil, false, err
+	}
+	ka.attempts = int(attempts)
+
+	tried, err := buffer.GetUint8()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return ka, tried == 1, nil
+}
+
+// Save writes the addresses to the file of the manager, through a temporary file
+// so that a crash never leaves a partial file
+func (a *AddrManager) Save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, a.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
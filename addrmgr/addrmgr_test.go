@@ -0,0 +1,272 @@
+package addrmgr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/detailyang/go-bcore/wire"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+func newTestAddrManager(t *testing.T, path string) *AddrManager {
+	a, err := NewAddrManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+func newTestAddress(t *testing.T, host string) *wire.NetAddressV2 {
+	na, err := wire.NewNetAddressV2(host, 8333, wire.SFNodeNetwork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	na.Timestamp = uint32(testNow.Add(-time.Hour).Unix())
+	return na
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		host  string
+		group []byte
+	}{
+		{"1.2.3.4", []byte{wire.NetIPv4, 1, 2}},
+		{"::ffff:1.2.200.4", []byte{wire.NetIPv4, 1, 2}},
+		{"2a01:4f8:1:2::1", []byte{wire.NetIPv6, 0x2a, 0x01, 0x04, 0xf8}},
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", []byte{wire.NetTorV3, 0x7f}},
+		{"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p", []byte{wire.NetI2P, 0xaf}},
+		{"127.0.0.1", []byte{0}},
+		{"10.1.2.3", []byte{0}},
+		{"2001:db8::1", []byte{0}},
+	}
+
+	for _, test := range tests {
+		if group := Group(newTestAddress(t, test.host)); !bytes.Equal(group, test.group) {
+			t.Fatalf("%s: expect group %x, got %x", test.host, test.group, group)
+		}
+	}
+
+	cjdns := &wire.NetAddressV2{Network: wire.NetCJDNS, Addr: append([]byte{0xfc, 0x12}, make([]byte, 14)...)}
+	if group := Group(cjdns); !bytes.Equal(group, []byte{wire.NetCJDNS, 0x1f}) {
+		t.Fatalf("cjdns: unexpected group %x", group)
+	}
+}
+
+func TestAddrManagerAdd(t *testing.T) {
+	a := newTestAddrManager(t, "")
+	source := newTestAddress(t, "5.6.7.8")
+
+	addrs := []*wire.NetAddressV2{
+		newTestAddress(t, "1.2.3.4"),
+		newTestAddress(t, "2a01:4f8:1:2::1"),
+		newTestAddress(t, "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"),
+		// Not routable
+		newTestAddress(t, "192.168.1.1"),
+		{Network: wire.NetTorV2, Addr: make([]byte, 10)},
+		{Network: 42, Addr: []byte{1}},
+	}
+	if n := a.Add(addrs, source); n != 3 || a.Size() != 3 {
+		t.Fatalf("expect 3 addresses added, got %d of %d", n, a.Size())
+	}
+
+	// Known addresses only merge their services and time
+	again := newTestAddress(t, "1.2.3.4")
+	again.Services = wire.SFNodeWitness
+	again.Timestamp = uint32(testNow.Unix())
+	a.Add([]*wire.NetAddressV2{again}, nil)
+	if a.Size() != 3 {
+		t.Fatalf("expect 3 addresses, got %d", a.Size())
+	}
+
+	ka := a.index[again.Key()]
+	if ka.addr.Services != wire.SFNodeNetwork|wire.SFNodeWitness || ka.addr.Timestamp != again.Timestamp {
+		t.Fatalf("unexpected address %+v", ka.addr)
+	}
+
+	// Addresses from the future get an old time
+	future := newTestAddress(t, "9.9.9.9")
+	future.Timestamp = uint32(testNow.Add(time.Hour).Unix())
+	a.Add([]*wire.NetAddressV2{future}, source)
+	if ka := a.index[future.Key()]; ka == nil || int64(ka.addr.Timestamp) != testNow.Add(-5*24*time.Hour).Unix() {
+		t.Fatal("future address: unexpected time")
+	}
+}
+
+func TestAddrManagerSourceGroup(t *testing.T) {
+	a := newTestAddrManager(t, "")
+	source := newTestAddress(t, "5.6.7.8")
+
+	// A single source fills at most newBucketsPerSourceGroup buckets
+	var addrs []*wire.NetAddressV2
+	for i := 0; i < 20000; i++ {
+		addrs = append(addrs, newTestAddress(t, fmt.Sprintf("%d.%d.%d.1", 20+i/65536, i/256%256, i%256)))
+	}
+	a.Add(addrs, source)
+
+	buckets := 0
+	for _, bucket := range a.newTable {
+		for _, ka := range bucket {
+			if ka != nil {
+				buckets++
+				break
+			}
+		}
+	}
+	if buckets > newBucketsPerSourceGroup || a.Size() > newBucketsPerSourceGroup*BucketSize {
+		t.Fatalf("one source filled %d buckets with %d addresses", buckets, a.Size())
+	}
+}
+
+func TestAddrManagerGood(t *testing.T) {
+	a := newTestAddrManager(t, "")
+	na := newTestAddress(t, "1.2.3.4")
+	a.Add([]*wire.NetAddressV2{na}, newTestAddress(t, "5.6.7.8"))
+
+	if got := a.Select(true); got == nil || got.Key() != na.Key() {
+		t.Fatalf("select: unexpected %v", got)
+	}
+
+	a.Attempt(na)
+	a.Good(na)
+	if a.nNew != 0 || a.nTried != 1 || a.Size() != 1 {
+		t.Fatalf("expect one tried address, got %d new and %d tried", a.nNew, a.nTried)
+	}
+
+	ka := a.index[na.Key()]
+	if !ka.tried || ka.refs != 0 || ka.attempts != 0 || !ka.lastSuccess.Equal(testNow) {
+		t.Fatalf("unexpected %+v", ka)
+	}
+	for _, bucket := range a.newTable {
+		for _, e := range bucket {
+			if e != nil {
+				t.Fatal("tried address still in a new bucket")
+			}
+		}
+	}
+
+	if got := a.Select(true); got != nil {
+		t.Fatalf("select new: unexpected %v", got)
+	}
+	if got := a.Select(false); got == nil || got.Key() != na.Key() {
+		t.Fatalf("select: unexpected %v", got)
+	}
+
+	// Tried addresses are not added back to new buckets
+	if n := a.Add([]*wire.NetAddressV2{na}, nil); n != 0 {
+		t.Fatalf("expect no address added, got %d", n)
+	}
+}
+
+func TestAddrManagerTriedCollision(t *testing.T) {
+	a := newTestAddrManager(t, "")
+
+	// Addresses of one group share triedBucketsPerGroup buckets, collisions come fast
+	var evicted bool
+	for i := 0; i < 200 && !evicted; i++ {
+		na := newTestAddress(t, fmt.Sprintf("1.2.%d.%d", i/256, i%256))
+		a.Add([]*wire.NetAddressV2{na}, nil)
+		tried := a.nTried
+		a.Good(na)
+		evicted = a.nTried == tried
+	}
+
+	if !evicted {
+		t.Fatal("no tried collision")
+	}
+
+	tried := 0
+	for _, ka := range a.index {
+		if ka.tried {
+			tried++
+		} else if ka.refs == 0 {
+			t.Fatal("evicted address in no new bucket")
+		}
+	}
+	if tried != a.nTried || a.nNew+a.nTried != len(a.index) {
+		t.Fatalf("inconsistent counts %d new and %d tried of %d", a.nNew, a.nTried, len(a.index))
+	}
+}
+
+func TestAddrManagerAddresses(t *testing.T) {
+	a := newTestAddrManager(t, "")
+	var addrs []*wire.NetAddressV2
+	for i := 0; i < 100; i++ {
+		addrs = append(addrs, newTestAddress(t, fmt.Sprintf("%d.1.2.3", i+1)))
+	}
+	a.Add(addrs, nil)
+
+	if got := a.Addresses(0, 0); len(got) != a.Size() {
+		t.Fatalf("expect %d addresses, got %d", a.Size(), len(got))
+	}
+	if got := a.Addresses(10, 0); len(got) != 10 {
+		t.Fatalf("expect 10 addresses, got %d", len(got))
+	}
+	if got := a.Addresses(0, 23); len(got) != a.Size()*23/100 {
+		t.Fatalf("expect %d addresses, got %d", a.Size()*23/100, len(got))
+	}
+
+	// Addresses failing Retries times are terrible once the last attempt is old
+	for i := 0; i < Retries; i++ {
+		a.Attempt(addrs[0])
+	}
+	if got := a.Addresses(0, 0); len(got) != a.Size() {
+		t.Fatalf("expect %d addresses, got %d", a.Size(), len(got))
+	}
+
+	a.now = func() time.Time { return testNow.Add(time.Hour) }
+	for _, na := range a.Addresses(0, 0) {
+		if na.Key() == addrs[0].Key() {
+			t.Fatal("terrible address relayed")
+		}
+	}
+}
+
+func TestAddrManagerSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.bin")
+	a := newTestAddrManager(t, path)
+
+	var addrs []*wire.NetAddressV2
+	for i := 0; i < 50; i++ {
+		addrs = append(addrs, newTestAddress(t, fmt.Sprintf("%d.1.2.3", i+1)))
+	}
+	addrs = append(addrs, newTestAddress(t, "ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p"))
+	a.Add(addrs, newTestAddress(t, "5.6.7.8"))
+	a.Attempt(addrs[1])
+	a.Good(addrs[2])
+
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestAddrManager(t, path)
+	if b.key != a.key || b.nNew != a.nNew || b.nTried != a.nTried {
+		t.Fatalf("expect %d new and %d tried, got %d and %d", a.nNew, a.nTried, b.nNew, b.nTried)
+	}
+
+	for key, ka := range a.index {
+		kb, ok := b.index[key]
+		if !ok || kb.tried != ka.tried || kb.attempts != ka.attempts || kb.addr.Timestamp != ka.addr.Timestamp ||
+			kb.source.Key() != ka.source.Key() || !kb.lastSuccess.Equal(ka.lastSuccess) || !kb.lastAttempt.Equal(ka.lastAttempt) {
+			t.Fatalf("%s: not restored", ka.addr)
+		}
+	}
+
+	// A corrupted file is refused
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[40] ^= 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAddrManager(path); err != ErrAddrFile {
+		t.Fatalf("expect %v, got %v", ErrAddrFile, err)
+	}
+}
@@ -38,18 +38,18 @@ func NewBlockFromBuffer(buffer *Buffer) (*Block, error) {
 		return nil, err
 	}
 
-	ntx, err := buffer.GetVarInt()
+	ntx, err := getCount(buffer, transactionMinSize)
 	if err != nil {
 		return nil, err
 	}
 
-	transactions := make([]*Transaction, ntx)
-	for i := 0; i < int(ntx); i++ {
+	transactions := make([]*Transaction, 0, preallocate(ntx))
+	for i := 0; i < ntx; i++ {
 		transaction, err := NewTransactionFromBuffer(buffer)
 		if err != nil {
 			return nil, err
 		}
-		transactions[i] = transaction
+		transactions = append(transactions, transaction)
 	}
 
 	return &Block{
@@ -72,8 +72,8 @@ func (b *Block) Bytes() []byte {
 	ntx := len(b.Transactions)
 	buffer.PutVarInt(uint64(ntx))
 	for i := 0; i < ntx; i++ {
-		buffer.PutBytes(b.Transactions[i].Bytes())
+		buffer.PutBytes(b.Transactions[i].BytesWithWitness())
 	}
 
-	return nil
+	return buffer.Bytes()
 }
@@ -1,6 +1,7 @@
 package bcore
 
 import (
+	"encoding/hex"
 	"testing"
 )
 
@@ -39,3 +40,15 @@ func TestNewBlock(t *testing.T) {
 		t.Fatalf("block transactions: got %d", len(b.Transactions))
 	}
 }
+
+func TestBlockBytes(t *testing.T) {
+	s := "01000000ba8b9cda965dd8e536670f9ddec10e53aab14b20bacad27b9137190000000000190760b278fe7b8565fda3b968b918d5fd997f993b23674c0af3b6fde300b38f33a5914ce6ed5b1b01e32f570201000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0704e6ed5b1b014effffffff0100f2052a01000000434104b68a50eaa0287eff855189f949c1c6e5f58b37c88231373d8a59809cbae83059cc6469d65c665ccfd1cfeb75c6e8e19413bba7fbff9bc762419a76d87b16086eac000000000100000001a6b97044d03da79c005b20ea9c0e1a6d9dc12d9f7b91a5911c9030a439eed8f5000000004948304502206e21798a42fae0e854281abd38bacd1aeed3ee3738d9e1446618c4571d1090db022100e2ac980643b0b82c0e88ffdfec6b64e3e6ba35e7ba5fdd7d5d6cc8d25c6b241501ffffffff0100f2052a010000001976a914404371705fa9bd789a2fcd52d2c580b65d35549d88ac00000000"
+	b, err := NewBlockFromHexString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(b.Bytes()) != s {
+		t.Fatalf("block bytes: got %x", b.Bytes())
+	}
+}
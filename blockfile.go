@@ -0,0 +1,325 @@
+package bcore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrBlockFileBadMagic  = errors.New("blockfile: bad network magic")
+	ErrBlockFileBadSize   = errors.New("blockfile: bad block size")
+	ErrBlockFileBadXorKey = errors.New("blockfile: bad obfuscation key")
+)
+
+const (
+	// BlockFileHeaderSize is the size of the magic and length prefix of every block record
+	BlockFileHeaderSize = 8
+	// BlockFileXorKeySize is the size of the obfuscation key stored in xor.dat
+	BlockFileXorKeySize = 8
+	// MaxBlockSerializedSize is the maximal size of a serialized block, witness included
+	MaxBlockSerializedSize = 4000000
+
+	blockFileXorKeyName = "xor.dat"
+)
+
+// BlockFilePosition locates a block record in the blk*.dat files of a blocks directory.
+// Offset points to the serialized block, right after the magic and length prefix,
+// like the FlatFilePos of Bitcoin Core.
+type BlockFilePosition struct {
+	File   int
+	Offset int64
+}
+
+func (p BlockFilePosition) String() string {
+	return fmt.Sprintf("blk%05d.dat:%d", p.File, p.Offset)
+}
+
+// BlockFileName returns the name of the n-th block file
+func BlockFileName(n int) string {
+	return fmt.Sprintf("blk%05d.dat", n)
+}
+
+// ReadBlockFileXorKey reads the obfuscation key of a blocks directory.
+// Directories created before Bitcoin Core 28 have no xor.dat and use a zero key.
+func ReadBlockFileXorKey(dir string) ([]byte, error) {
+	key, err := os.ReadFile(filepath.Join(dir, blockFileXorKeyName))
+	if os.IsNotExist(err) {
+		return make([]byte, BlockFileXorKeySize), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != BlockFileXorKeySize {
+		return nil, ErrBlockFileBadXorKey
+	}
+
+	return key, nil
+}
+
+// xorBlockFile (de)obfuscates data read from or written at offset of a block file
+func xorBlockFile(data, key []byte, offset int64) {
+	for i := range data {
+		data[i] ^= key[(offset+int64(i))%int64(len(key))]
+	}
+}
+
+// flatFileReader iterates the magic and length prefixed records of a sequence of
+// obfuscated files named prefix%05d.dat, like the blk*.dat and rev*.dat files of Bitcoin Core.
+type flatFileReader struct {
+	dir     string
+	prefix  string
+	magic   uint32
+	key     []byte
+	maxSize uint32
+	// Size of the data following each record, not counted in its length prefix
+	trailer int
+	file    int
+	offset  int64
+	f       *os.File
+	r       *bufio.Reader
+}
+
+func newFlatFileReader(dir, prefix string, params *ChainParams, maxSize uint32, trailer int) (*flatFileReader, error) {
+	key, err := ReadBlockFileXorKey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flatFileReader{
+		dir:     dir,
+		prefix:  prefix,
+		magic:   params.Magic,
+		key:     key,
+		maxSize: maxSize,
+		trailer: trailer,
+	}, nil
+}
+
+func (r *flatFileReader) path(n int) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s%05d.dat", r.prefix, n))
+}
+
+func (r *flatFileReader) seek(pos BlockFilePosition) error {
+	if err := r.open(pos.File); err != nil {
+		return err
+	}
+
+	offset := pos.Offset - BlockFileHeaderSize
+	if offset < 0 {
+		offset = 0
+	}
+
+	if _, err := r.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	r.offset = offset
+
+	return nil
+}
+
+func (r *flatFileReader) open(n int) error {
+	f, err := os.Open(r.path(n))
+	if err != nil {
+		return err
+	}
+
+	r.close()
+	r.f = f
+	r.r = bufio.NewReaderSize(f, 1<<20)
+	r.file = n
+	r.offset = 0
+
+	return nil
+}
+
+func (r *flatFileReader) close() {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+func (r *flatFileReader) read(data []byte) error {
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return err
+	}
+
+	xorBlockFile(data, r.key, r.offset)
+	r.offset += int64(len(data))
+	return nil
+}
+
+// next returns the next record with its trailer and its position, io.EOF after the last one
+func (r *flatFileReader) next() ([]byte, BlockFilePosition, error) {
+	if r.f == nil {
+		if err := r.open(0); err != nil {
+			if os.IsNotExist(err) {
+				return nil, BlockFilePosition{}, io.EOF
+			}
+			return nil, BlockFilePosition{}, err
+		}
+	}
+
+	for {
+		header := make([]byte, BlockFileHeaderSize)
+		err := r.read(header)
+
+		// Bitcoin Core preallocates files with zeros, which end the records of a file
+		if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && binary.LittleEndian.Uint32(header) == 0) {
+			if err := r.open(r.file + 1); err != nil {
+				if os.IsNotExist(err) {
+					return nil, BlockFilePosition{}, io.EOF
+				}
+				return nil, BlockFilePosition{}, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, BlockFilePosition{}, err
+		}
+
+		if binary.LittleEndian.Uint32(header) != r.magic {
+			return nil, BlockFilePosition{}, ErrBlockFileBadMagic
+		}
+
+		size := binary.LittleEndian.Uint32(header[4:])
+		if size > r.maxSize {
+			return nil, BlockFilePosition{}, ErrBlockFileBadSize
+		}
+
+		pos := BlockFilePosition{File: r.file, Offset: r.offset}
+		data := make([]byte, int(size)+r.trailer)
+		if err := r.read(data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, BlockFilePosition{}, err
+		}
+
+		return data, pos, nil
+	}
+}
+
+// readAt reads the record with its trailer stored at pos
+func (r *flatFileReader) readAt(pos BlockFilePosition) ([]byte, error) {
+	if pos.Offset < BlockFileHeaderSize {
+		return nil, ErrBlockFileBadSize
+	}
+
+	f, err := os.Open(r.path(pos.File))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, BlockFileHeaderSize)
+	if _, err := f.ReadAt(header, pos.Offset-BlockFileHeaderSize); err != nil {
+		return nil, err
+	}
+	xorBlockFile(header, r.key, pos.Offset-BlockFileHeaderSize)
+
+	if binary.LittleEndian.Uint32(header) != r.magic {
+		return nil, ErrBlockFileBadMagic
+	}
+
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size > r.maxSize {
+		return nil, ErrBlockFileBadSize
+	}
+
+	data := make([]byte, int(size)+r.trailer)
+	if _, err := f.ReadAt(data, pos.Offset); err != nil {
+		return nil, err
+	}
+	xorBlockFile(data, r.key, pos.Offset)
+
+	return data, nil
+}
+
+// BlockFileReader iterates the blocks stored in the blk*.dat files of a
+// Bitcoin Core blocks directory, in file order.
+type BlockFileReader struct {
+	r *flatFileReader
+}
+
+func NewBlockFileReader(dir string, params *ChainParams) (*BlockFileReader, error) {
+	r, err := newFlatFileReader(dir, "blk", params, MaxBlockSerializedSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockFileReader{r: r}, nil
+}
+
+// Seek moves the reader so that Next returns the block stored at pos.
+// A zero offset starts at the beginning of the file.
+func (r *BlockFileReader) Seek(pos BlockFilePosition) error {
+	return r.r.seek(pos)
+}
+
+// NextBytes returns the next serialized block and its position, io.EOF after the last one.
+func (r *BlockFileReader) NextBytes() ([]byte, BlockFilePosition, error) {
+	data, pos, err := r.r.next()
+	if err != nil {
+		return nil, pos, err
+	}
+
+	if len(data) < BlockHeaderSize {
+		return nil, pos, ErrBlockFileBadSize
+	}
+
+	return data, pos, nil
+}
+
+// Next returns the next block and its position, io.EOF after the last one.
+func (r *BlockFileReader) Next() (*Block, BlockFilePosition, error) {
+	data, pos, err := r.NextBytes()
+	if err != nil {
+		return nil, pos, err
+	}
+
+	block, err := NewBlockFromBytes(data)
+	if err != nil {
+		return nil, pos, err
+	}
+
+	return block, pos, nil
+}
+
+// ReadBlockAt reads the block stored at pos without moving the iteration.
+func (r *BlockFileReader) ReadBlockAt(pos BlockFilePosition) (*Block, error) {
+	data, err := r.ReadBytesAt(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBlockFromBytes(data)
+}
+
+// ReadBytesAt reads the serialized block stored at pos without moving the iteration.
+func (r *BlockFileReader) ReadBytesAt(pos BlockFilePosition) ([]byte, error) {
+	data, err := r.r.readAt(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < BlockHeaderSize {
+		return nil, ErrBlockFileBadSize
+	}
+
+	return data, nil
+}
+
+func (r *BlockFileReader) Close() error {
+	r.r.close()
+	return nil
+}
@@ -0,0 +1,132 @@
+package bcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// writeTestBlockFile writes blocks as obfuscated records of a block file followed by zero padding
+func writeTestBlockFile(t *testing.T, dir string, n int, key []byte, blocks ...*Block) []BlockFilePosition {
+	var data []byte
+	var positions []BlockFilePosition
+	for _, block := range blocks {
+		b := block.Bytes()
+		header := make([]byte, BlockFileHeaderSize)
+		binary.LittleEndian.PutUint32(header, RegTestParams.Magic)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(b)))
+		data = append(data, header...)
+		positions = append(positions, BlockFilePosition{File: n, Offset: int64(len(data))})
+		data = append(data, b...)
+	}
+	data = append(data, make([]byte, 64)...)
+	xorBlockFile(data, key, 0)
+
+	if err := os.WriteFile(filepath.Join(dir, BlockFileName(n)), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return positions
+}
+
+func TestBlockFileReader(t *testing.T) {
+	key := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xor.dat"), key, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := newTestSpendChain(103)
+	blocks[102].Transactions[1].Inputs[0].ScriptWitness = NewScriptWitness([][]byte{{1, 2, 3}, {}})
+
+	positions := writeTestBlockFile(t, dir, 0, key, blocks[:100]...)
+	positions = append(positions, writeTestBlockFile(t, dir, 1, key, blocks[100:]...)...)
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; ; i++ {
+		block, pos, err := r.Next()
+		if err == io.EOF {
+			if i != len(blocks) {
+				t.Fatalf("blocks: expect %d, got %d", len(blocks), i)
+			}
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pos != positions[i] {
+			t.Fatalf("block %d position: expect %s, got %s", i, positions[i], pos)
+		}
+
+		if block.Hash() != blocks[i].Hash() || !bytes.Equal(block.Bytes(), blocks[i].Bytes()) {
+			t.Fatalf("block %d: got %s", i, block.Hash())
+		}
+	}
+
+	block, err := r.ReadBlockAt(positions[102])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.Transactions[1].WitnessHash() != blocks[102].Transactions[1].WitnessHash() {
+		t.Fatalf("witness should be read back")
+	}
+
+	if err := r.Seek(positions[50]); err != nil {
+		t.Fatal(err)
+	}
+
+	block, pos, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pos != positions[50] || block.Hash() != blocks[50].Hash() {
+		t.Fatalf("seek: got %s at %s", block.Hash(), pos)
+	}
+}
+
+func TestBlockFileReaderErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+
+	writeTestBlockFile(t, dir, 0, make([]byte, BlockFileXorKeySize), newTestBlock(HashZero, 1))
+
+	r, err = NewBlockFileReader(dir, MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, _, err := r.Next(); err != ErrBlockFileBadMagic {
+		t.Fatalf("expect bad magic, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "xor.dat"), []byte{1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBlockFileReader(dir, RegTestParams); err != ErrBlockFileBadXorKey {
+		t.Fatalf("expect bad key, got %v", err)
+	}
+}
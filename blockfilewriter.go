@@ -0,0 +1,181 @@
+package bcore
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// MaxBlockFileSize is the size at which Bitcoin Core starts a new block file
+	MaxBlockFileSize = 0x8000000
+)
+
+// BlockFileWriter appends blocks to blk*.dat files readable by Bitcoin Core and BlockFileReader.
+// Writing resumes at the end of the last block file of the directory.
+type BlockFileWriter struct {
+	dir         string
+	magic       uint32
+	key         []byte
+	maxFileSize int64
+	file        int
+	size        int64
+	f           *os.File
+	w           *bufio.Writer
+}
+
+// NewBlockFileWriter opens a blocks directory for writing, creating it if needed.
+// A new directory gets a random obfuscation key when obfuscate is set and a zero key
+// otherwise, an existing directory keeps its xor.dat. Block files written without
+// xor.dat are not obfuscated, so their directory gets a zero key as in Bitcoin Core.
+func NewBlockFileWriter(dir string, params *ChainParams, obfuscate bool) (*BlockFileWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	keyPath := filepath.Join(dir, blockFileXorKeyName)
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		files, err := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+		if err != nil {
+			return nil, err
+		}
+
+		key := make([]byte, BlockFileXorKeySize)
+		if obfuscate && len(files) == 0 {
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := os.WriteFile(keyPath, key, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ReadBlockFileXorKey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &BlockFileWriter{
+		dir:         dir,
+		magic:       params.Magic,
+		key:         key,
+		maxFileSize: MaxBlockFileSize,
+	}
+
+	file := 0
+	for {
+		if _, err := os.Stat(filepath.Join(dir, BlockFileName(file+1))); err != nil {
+			break
+		}
+		file++
+	}
+
+	if err := w.open(file); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *BlockFileWriter) open(n int) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, BlockFileName(n)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.f = f
+	w.w = bufio.NewWriterSize(f, 1<<20)
+	w.file = n
+	w.size = info.Size()
+
+	return nil
+}
+
+func (w *BlockFileWriter) close() error {
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+
+	return err
+}
+
+func (w *BlockFileWriter) write(data []byte) error {
+	b := make([]byte, len(data))
+	copy(b, data)
+	xorBlockFile(b, w.key, w.size)
+
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	w.size += int64(len(b))
+
+	return nil
+}
+
+// Write appends block and returns the position of its data
+func (w *BlockFileWriter) Write(block *Block) (BlockFilePosition, error) {
+	return w.WriteBytes(block.Bytes())
+}
+
+// WriteBytes appends a serialized block and returns the position of its data.
+// Like Bitcoin Core, a new file is started when the record would reach the maximal file size.
+func (w *BlockFileWriter) WriteBytes(data []byte) (BlockFilePosition, error) {
+	if len(data) < BlockHeaderSize || len(data) > MaxBlockSerializedSize {
+		return BlockFilePosition{}, ErrBlockFileBadSize
+	}
+
+	add := int64(BlockFileHeaderSize + len(data))
+	if w.size > 0 && w.size+add >= w.maxFileSize {
+		if err := w.close(); err != nil {
+			return BlockFilePosition{}, err
+		}
+
+		if err := w.open(w.file + 1); err != nil {
+			return BlockFilePosition{}, err
+		}
+	}
+
+	header := make([]byte, BlockFileHeaderSize)
+	binary.LittleEndian.PutUint32(header, w.magic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+
+	if err := w.write(header); err != nil {
+		return BlockFilePosition{}, err
+	}
+
+	pos := BlockFilePosition{File: w.file, Offset: w.size}
+	if err := w.write(data); err != nil {
+		return BlockFilePosition{}, err
+	}
+
+	return pos, nil
+}
+
+// Flush writes buffered data to the current file and syncs it to disk
+func (w *BlockFileWriter) Flush() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+func (w *BlockFileWriter) Close() error {
+	return w.close()
+}
@@ -0,0 +1,158 @@
+package bcore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockFileWriter(t *testing.T) {
+	for _, obfuscate := range []bool{false, true} {
+		dir := t.TempDir()
+		blocks := newTestSpendChain(110)
+
+		w, err := NewBlockFileWriter(dir, RegTestParams, obfuscate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every file fits 40 blocks of the test chain
+		w.maxFileSize = int64(40*(BlockFileHeaderSize+len(blocks[0].Bytes())) + 1)
+
+		var positions []BlockFilePosition
+		for _, block := range blocks[:60] {
+			pos, err := w.Write(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			positions = append(positions, pos)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := ReadBlockFileXorKey(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if obfuscate == bytes.Equal(key, make([]byte, BlockFileXorKeySize)) {
+			t.Fatalf("obfuscation key: got %x", key)
+		}
+
+		// reopening resumes at the end of the last file
+		w, err = NewBlockFileWriter(dir, RegTestParams, obfuscate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.maxFileSize = int64(40*(BlockFileHeaderSize+len(blocks[0].Bytes())) + 1)
+
+		for _, block := range blocks[60:] {
+			pos, err := w.Write(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			positions = append(positions, pos)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, pos := range positions {
+			if pos.File != i/40 || (i%40 == 0 && pos.Offset != BlockFileHeaderSize) {
+				t.Fatalf("block %d position: got %s", i, pos)
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, BlockFileName(3))); !os.IsNotExist(err) {
+			t.Fatalf("expect 3 block files, got %v", err)
+		}
+
+		r, err := NewBlockFileReader(dir, RegTestParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; ; i++ {
+			block, pos, err := r.Next()
+			if err == io.EOF {
+				if i != len(blocks) {
+					t.Fatalf("blocks: expect %d, got %d", len(blocks), i)
+				}
+				break
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pos != positions[i] || block.Hash() != blocks[i].Hash() {
+				t.Fatalf("block %d: got %s at %s", i, block.Hash(), pos)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestBlockFileWriterLegacyDir(t *testing.T) {
+	dir := t.TempDir()
+	blocks := newTestSpendChain(10)
+
+	// Block files written before xor.dat existed
+	w, err := NewBlockFileWriter(dir, RegTestParams, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks[:5] {
+		if _, err := w.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, blockFileXorKeyName)); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = NewBlockFileWriter(dir, RegTestParams, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks[5:] {
+		if _, err := w.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, blockFileXorKeyName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, make([]byte, BlockFileXorKeySize)) {
+		t.Fatalf("obfuscation key: expect zero, got %x", key)
+	}
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i, expect := range blocks {
+		block, _, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash() != expect.Hash() {
+			t.Fatalf("block %d: got %s", i, block.Hash())
+		}
+	}
+}
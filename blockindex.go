@@ -0,0 +1,505 @@
+package bcore
+
+import (
+	"errors"
+	"io"
+	"math/big"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrBlockIndexMissing     = errors.New("blockindex: unknown block")
+	ErrBlockIndexTxMissing   = errors.New("blockindex: unknown transaction")
+	ErrBlockIndexBadTxOffset = errors.New("blockindex: bad transaction offset")
+)
+
+// BlockStatus holds the flags of a block index entry, with the values of Bitcoin Core
+type BlockStatus uint32
+
+const (
+	BlockStatusHaveData BlockStatus = 8
+	BlockStatusHaveUndo BlockStatus = 16
+	BlockStatusFailed   BlockStatus = 32
+)
+
+const (
+	// Key prefixes follow the block index and txindex databases of Bitcoin Core
+	blockIndexBlockPrefix = 'b'
+	blockIndexTxPrefix    = 't'
+	// Position right after the last scanned block record
+	blockIndexLastPrefix = 'l'
+	blockIndexTipPrefix  = 'T'
+)
+
+// BlockIndexEntry is what the index knows about a block stored in the block files
+type BlockIndexEntry struct {
+	Hash     Hash
+	Height   uint32
+	Status   BlockStatus
+	Position BlockFilePosition
+	TxCount  uint32
+	Header   *BlockHeader
+	// Total work of the chain up to and including the block
+	ChainWork *big.Int
+}
+
+func (e *BlockIndexEntry) Bytes() []byte {
+	return NewBuffer().
+		PutUint32(e.Height).
+		PutUint32(uint32(e.Status)).
+		PutUint32(uint32(e.Position.File)).
+		PutUint64(uint64(e.Position.Offset)).
+		PutUint32(e.TxCount).
+		PutBytes(e.Header.Bytes()).
+		PutVarBytes(e.ChainWork.Bytes()).
+		Bytes()
+}
+
+func newBlockIndexEntryFromBytes(hash Hash, data []byte) (*BlockIndexEntry, error) {
+	buffer := NewReadBuffer(data)
+	e := &BlockIndexEntry{Hash: hash}
+
+	var err error
+	if e.Height, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	status, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	e.Status = BlockStatus(status)
+
+	file, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+	e.Position = BlockFilePosition{File: int(file), Offset: int64(offset)}
+
+	if e.TxCount, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	if e.Header, err = NewBlockHeaderFromBuffer(buffer); err != nil {
+		return nil, err
+	}
+
+	work, err := buffer.GetVarBytes()
+	if err != nil {
+		return nil, err
+	}
+	e.ChainWork = new(big.Int).SetBytes(work)
+
+	return e, nil
+}
+
+// TxIndexEntry locates a transaction in a block, Offset counts from the start of the serialized block
+type TxIndexEntry struct {
+	BlockHash Hash
+	Offset    uint32
+}
+
+func (e *TxIndexEntry) Bytes() []byte {
+	return NewBuffer().PutHash(e.BlockHash).PutUint32(e.Offset).Bytes()
+}
+
+func newTxIndexEntryFromBytes(data []byte) (*TxIndexEntry, error) {
+	buffer := NewReadBuffer(data)
+
+	hash, err := buffer.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxIndexEntry{BlockHash: hash, Offset: offset}, nil
+}
+
+// blockIndexPending is a scanned block whose parent has not been seen yet
+type blockIndexPending struct {
+	entry *BlockIndexEntry
+	txs   map[Hash]uint32
+}
+
+// BlockIndex maps block hashes to their height, position and header and txids to
+// the block of the most-work chain containing them. It is populated by scanning the
+// block files, like a -reindex of Bitcoin Core with -txindex, the txindex following
+// the tip when it switches branch.
+type BlockIndex struct {
+	db     *leveldb.DB
+	blocks *BlockFileReader
+	// Scanned blocks waiting for their parent, by parent hash
+	pending map[Hash][]*blockIndexPending
+}
+
+// NewBlockIndex opens or creates the index database at path for the blocks read by blocks
+func NewBlockIndex(path string, blocks *BlockFileReader) (*BlockIndex, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		Compression: opt.NoCompression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockIndex{
+		db:      db,
+		blocks:  blocks,
+		pending: make(map[Hash][]*blockIndexPending),
+	}, nil
+}
+
+func blockIndexKey(prefix byte, hash Hash) []byte {
+	return NewBuffer().PutUint8(prefix).PutHash(hash).Bytes()
+}
+
+// Scan indexes the blocks stored after the last scanned one and returns how many were added.
+// Blocks are indexed once their parent is known, the ones still missing it are kept for the
+// next Scan and counted by Orphans.
+func (bi *BlockIndex) Scan() (int, error) {
+	last, err := bi.db.Get([]byte{blockIndexLastPrefix}, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return 0, err
+	}
+
+	if err == nil {
+		buffer := NewReadBuffer(last)
+		file, err := buffer.GetUint32()
+		if err != nil {
+			return 0, err
+		}
+		offset, err := buffer.GetUint64()
+		if err != nil {
+			return 0, err
+		}
+		if err := bi.blocks.Seek(BlockFilePosition{File: int(file), Offset: int64(offset)}); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for {
+		data, pos, err := bi.blocks.NextBytes()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		block, err := NewBlockFromBytes(data)
+		if err != nil {
+			return count, err
+		}
+
+		n, err := bi.add(block, pos, int64(len(data)))
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// add indexes block and the pending blocks it connects
+func (bi *BlockIndex) add(block *Block, pos BlockFilePosition, size int64) (int, error) {
+	hash := block.Hash()
+	if _, err := bi.GetBlock(hash); err != ErrBlockIndexMissing {
+		// Duplicate record of an indexed block
+		return 0, err
+	}
+
+	p := &blockIndexPending{
+		entry: &BlockIndexEntry{
+			Hash:     hash,
+			Status:   BlockStatusHaveData,
+			Position: pos,
+			TxCount:  uint32(len(block.Transactions)),
+			Header:   block.Header,
+		},
+		txs: txOffsets(block),
+	}
+
+	next := BlockFilePosition{File: pos.File, Offset: pos.Offset + size + BlockFileHeaderSize}
+
+	prev := block.Header.PrevHash
+	if !prev.IsZero() {
+		if _, err := bi.GetBlock(prev); err == ErrBlockIndexMissing {
+			for _, q := range bi.pending[prev] {
+				if q.entry.Hash == hash {
+					return 0, nil
+				}
+			}
+			bi.pending[prev] = append(bi.pending[prev], p)
+			return 0, bi.putLast(next)
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	tip, err := bi.Tip()
+	if err != nil && err != ErrBlockIndexMissing {
+		return 0, err
+	}
+
+	count := 0
+	queue := []*blockIndexPending{p}
+	for len(queue) > 0 {
+		p, queue = queue[0], queue[1:]
+
+		p.entry.ChainWork = CalcWork(p.entry.Header.Bits)
+		if !p.entry.Header.PrevHash.IsZero() {
+			parent, err := bi.GetBlock(p.entry.Header.PrevHash)
+			if err != nil {
+				return count, err
+			}
+			p.entry.Height = parent.Height + 1
+			p.entry.ChainWork.Add(p.entry.ChainWork, parent.ChainWork)
+		}
+
+		batch := new(leveldb.Batch)
+		batch.Put(blockIndexKey(blockIndexBlockPrefix, p.entry.Hash), p.entry.Bytes())
+
+		// The first block seen with the most work stays the tip, as in Bitcoin Core
+		if tip == nil || p.entry.ChainWork.Cmp(tip.ChainWork) > 0 {
+			if err := bi.setTip(batch, tip, p.entry, p.txs); err != nil {
+				return count, err
+			}
+			tip = p.entry
+		}
+
+		// Children look their parent up in the database, write it first
+		if err := bi.db.Write(batch, nil); err != nil {
+			return count, err
+		}
+		count++
+
+		queue = append(queue, bi.pending[p.entry.Hash]...)
+		delete(bi.pending, p.entry.Hash)
+	}
+
+	return count, bi.putLast(next)
+}
+
+// txOffsets returns the offsets of the transactions of block by txid
+func txOffsets(block *Block) map[Hash]uint32 {
+	txs := make(map[Hash]uint32, len(block.Transactions))
+	offset := BlockHeaderSize + len(NewBuffer().PutVarInt(uint64(len(block.Transactions))).Bytes())
+	for _, tx := range block.Transactions {
+		txs[tx.Hash()] = uint32(offset)
+		offset += len(tx.BytesWithWitness())
+	}
+	return txs
+}
+
+// parent returns the entry of the parent of e, nil for a genesis block
+func (bi *BlockIndex) parent(e *BlockIndexEntry) (*BlockIndexEntry, error) {
+	if e.Header.PrevHash.IsZero() {
+		return nil, nil
+	}
+	return bi.GetBlock(e.Header.PrevHash)
+}
+
+// setTip makes e, whose transactions are at txs, the tip in batch. The txindex
+// entries of the blocks leaving the active chain are deleted and the ones of the
+// blocks joining it written, so that only the most-work chain is indexed.
+func (bi *BlockIndex) setTip(batch *leveldb.Batch, tip, e *BlockIndexEntry, txs map[Hash]uint32) error {
+	cur, err := bi.parent(e)
+	if err != nil {
+		return err
+	}
+
+	// Walk both branches back to the fork, a nil entry is before the genesis
+	height := func(e *BlockIndexEntry) int64 {
+		if e == nil {
+			return -1
+		}
+		return int64(e.Height)
+	}
+
+	var disconnect, connect []*BlockIndexEntry
+	for tip != cur && (tip == nil || cur == nil || tip.Hash != cur.Hash) {
+		if height(tip) >= height(cur) {
+			disconnect = append(disconnect, tip)
+			tip, err = bi.parent(tip)
+		} else {
+			connect = append(connect, cur)
+			cur, err = bi.parent(cur)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, d := range disconnect {
+		block, err := bi.ReadBlock(d.Hash)
+		if err != nil {
+			return err
+		}
+		for txid := range txOffsets(block) {
+			batch.Delete(blockIndexKey(blockIndexTxPrefix, txid))
+		}
+	}
+
+	// A transaction in both branches now points into the new one
+	putTxs := func(hash Hash, txs map[Hash]uint32) {
+		for txid, offset := range txs {
+			e := &TxIndexEntry{BlockHash: hash, Offset: offset}
+			batch.Put(blockIndexKey(blockIndexTxPrefix, txid), e.Bytes())
+		}
+	}
+
+	for i := len(connect) - 1; i >= 0; i-- {
+		block, err := bi.ReadBlock(connect[i].Hash)
+		if err != nil {
+			return err
+		}
+		putTxs(connect[i].Hash, txOffsets(block))
+	}
+	putTxs(e.Hash, txs)
+
+	batch.Put([]byte{blockIndexTipPrefix}, NewBuffer().PutHash(e.Hash).Bytes())
+	return nil
+}
+
+// putLast records where the next Scan resumes: after the block record ending at next,
+// or at the first pending block since those are only kept in memory.
+func (bi *BlockIndex) putLast(next BlockFilePosition) error {
+	for _, ps := range bi.pending {
+		for _, p := range ps {
+			pos := p.entry.Position
+			if pos.File < next.File || (pos.File == next.File && pos.Offset < next.Offset) {
+				next = pos
+			}
+		}
+	}
+
+	return bi.db.Put([]byte{blockIndexLastPrefix}, NewBuffer().
+		PutUint32(uint32(next.File)).
+		PutUint64(uint64(next.Offset)).
+		Bytes(), &opt.WriteOptions{Sync: true})
+}
+
+// Orphans returns the number of scanned blocks whose parent is still unknown
+func (bi *BlockIndex) Orphans() int {
+	n := 0
+	for _, ps := range bi.pending {
+		n += len(ps)
+	}
+	return n
+}
+
+func (bi *BlockIndex) GetBlock(hash Hash) (*BlockIndexEntry, error) {
+	data, err := bi.db.Get(blockIndexKey(blockIndexBlockPrefix, hash), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrBlockIndexMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockIndexEntryFromBytes(hash, data)
+}
+
+// Tip returns the indexed block with the most chain work, the first one scanned wins
+// between equal works
+func (bi *BlockIndex) Tip() (*BlockIndexEntry, error) {
+	data, err := bi.db.Get([]byte{blockIndexTipPrefix}, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrBlockIndexMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := NewReadBuffer(data).GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return bi.GetBlock(hash)
+}
+
+// SetStatus adds the flags of status to the entry of hash
+func (bi *BlockIndex) SetStatus(hash Hash, status BlockStatus) error {
+	e, err := bi.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	e.Status |= status
+	return bi.db.Put(blockIndexKey(blockIndexBlockPrefix, hash), e.Bytes(), &opt.WriteOptions{Sync: true})
+}
+
+// ReadBlock reads an indexed block from the block files
+func (bi *BlockIndex) ReadBlock(hash Hash) (*Block, error) {
+	e, err := bi.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return bi.blocks.ReadBlockAt(e.Position)
+}
+
+func (bi *BlockIndex) GetTxIndex(txid Hash) (*TxIndexEntry, error) {
+	data, err := bi.db.Get(blockIndexKey(blockIndexTxPrefix, txid), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrBlockIndexTxMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTxIndexEntryFromBytes(data)
+}
+
+// GetTransaction returns the transaction txid and the hash of the block of the most-work
+// chain containing it, like getrawtransaction of a Bitcoin Core node running with -txindex.
+func (bi *BlockIndex) GetTransaction(txid Hash) (*Transaction, Hash, error) {
+	txe, err := bi.GetTxIndex(txid)
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	e, err := bi.GetBlock(txe.BlockHash)
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	data, err := bi.blocks.ReadBytesAt(e.Position)
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	if int(txe.Offset) >= len(data) {
+		return nil, HashZero, ErrBlockIndexBadTxOffset
+	}
+
+	tx, err := NewTransactionFromBytes(data[txe.Offset:])
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	if tx.Hash() != txid {
+		return nil, HashZero, ErrBlockIndexBadTxOffset
+	}
+
+	return tx, txe.BlockHash, nil
+}
+
+func (bi *BlockIndex) Close() error {
+	return bi.db.Close()
+}
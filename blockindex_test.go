@@ -0,0 +1,253 @@
+package bcore
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestBlockIndex(t *testing.T) {
+	key := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xor.dat"), key, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := newTestSpendChain(106)
+	blocks[102].Transactions[1].Inputs[0].ScriptWitness = NewScriptWitness([][]byte{{1, 2, 3}, {}})
+
+	// Block 101 is stored before its parent and block 105 comes in a later file
+	stored := append([]*Block{}, blocks[:100]...)
+	stored = append(stored, blocks[101], blocks[100], blocks[102], blocks[103], blocks[104])
+	writeTestBlockFile(t, dir, 0, key, stored[:60]...)
+	writeTestBlockFile(t, dir, 1, key, stored[60:]...)
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	bi, err := NewBlockIndex(filepath.Join(dir, "index"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bi.Close()
+
+	n, err := bi.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 105 || bi.Orphans() != 0 {
+		t.Fatalf("scan: expect 105 blocks and no orphan, got %d and %d", n, bi.Orphans())
+	}
+
+	for i, block := range blocks[:105] {
+		e, err := bi.GetBlock(block.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Height != uint32(i) || e.Status != BlockStatusHaveData || e.TxCount != uint32(len(block.Transactions)) || !e.Header.Equal(block.Header) {
+			t.Fatalf("block %d: unexpected entry %+v", i, e)
+		}
+
+		b, err := bi.ReadBlock(block.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != block.Hash() {
+			t.Fatalf("block %d: read %s", i, b.Hash())
+		}
+
+		for _, tx := range block.Transactions {
+			got, hash, err := bi.GetTransaction(tx.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != block.Hash() || string(got.BytesWithWitness()) != string(tx.BytesWithWitness()) {
+				t.Fatalf("block %d: bad transaction %s", i, tx.Hash())
+			}
+		}
+	}
+
+	tip, err := bi.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip.Hash != blocks[104].Hash() {
+		t.Fatalf("tip: expect %s, got %s", blocks[104].Hash(), tip.Hash)
+	}
+
+	if _, _, err := bi.GetTransaction(blocks[105].Transactions[1].Hash()); err != ErrBlockIndexTxMissing {
+		t.Fatalf("expect %v, got %v", ErrBlockIndexTxMissing, err)
+	}
+	if _, err := bi.GetBlock(blocks[105].Hash()); err != ErrBlockIndexMissing {
+		t.Fatalf("expect %v, got %v", ErrBlockIndexMissing, err)
+	}
+
+	if err := bi.SetStatus(tip.Hash, BlockStatusHaveUndo); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := bi.GetBlock(tip.Hash); err != nil || e.Status != BlockStatusHaveData|BlockStatusHaveUndo {
+		t.Fatalf("status: got %+v %v", e, err)
+	}
+
+	// A reopened index resumes after the last scanned block
+	bi.Close()
+	writeTestBlockFile(t, dir, 2, key, blocks[105])
+
+	bi, err = NewBlockIndex(filepath.Join(dir, "index"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := bi.Scan(); err != nil || n != 1 {
+		t.Fatalf("rescan: expect 1 block, got %d %v", n, err)
+	}
+
+	if tip, err := bi.Tip(); err != nil || tip.Hash != blocks[105].Hash() || tip.Height != 105 {
+		t.Fatalf("tip: got %+v %v", tip, err)
+	}
+}
+
+func TestBlockIndexOrphans(t *testing.T) {
+	dir := t.TempDir()
+	blocks := newTestSpendChain(4)
+	writeTestBlockFile(t, dir, 0, make([]byte, BlockFileXorKeySize), blocks[0], blocks[2], blocks[3])
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	bi, err := NewBlockIndex(filepath.Join(dir, "index"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bi.Close()
+
+	if n, err := bi.Scan(); err != nil || n != 1 || bi.Orphans() != 2 {
+		t.Fatalf("scan: got %d blocks %d orphans %v", n, bi.Orphans(), err)
+	}
+
+	// The missing parent connects the orphans, which are scanned again
+	writeTestBlockFile(t, dir, 1, make([]byte, BlockFileXorKeySize), blocks[1])
+	if n, err := bi.Scan(); err != nil || n != 3 || bi.Orphans() != 0 {
+		t.Fatalf("rescan: got %d blocks %d orphans %v", n, bi.Orphans(), err)
+	}
+
+	if tip, err := bi.Tip(); err != nil || tip.Hash != blocks[3].Hash() || tip.Height != 3 {
+		t.Fatalf("tip: got %+v %v", tip, err)
+	}
+
+	if _, err := bi.GetBlock(Hash{1}); err != ErrBlockIndexMissing {
+		t.Fatalf("expect %v, got %v", ErrBlockIndexMissing, err)
+	}
+}
+
+func TestBlockIndexChainWork(t *testing.T) {
+	dir := t.TempDir()
+	blocks := newTestSpendChain(5)
+
+	// A one block fork at height 2, with more work than the 5 blocks chain
+	fork := newTestBlock(blocks[1].Hash(), 3)
+	fork.Header.Bits = NewCompact(0x1f00ffff)
+	writeTestBlockFile(t, dir, 0, make([]byte, BlockFileXorKeySize), append(blocks, fork)...)
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	bi, err := NewBlockIndex(filepath.Join(dir, "index"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bi.Close()
+
+	if n, err := bi.Scan(); err != nil || n != 6 {
+		t.Fatalf("scan: got %d blocks %v", n, err)
+	}
+
+	e, err := bi.GetBlock(blocks[4].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := new(big.Int).Mul(CalcWork(blocks[4].Header.Bits), big.NewInt(5)); e.ChainWork.Cmp(expect) != 0 {
+		t.Fatalf("chain work: expect %s, got %s", expect, e.ChainWork)
+	}
+
+	if tip, err := bi.Tip(); err != nil || tip.Hash != fork.Hash() || tip.Height != 2 {
+		t.Fatalf("tip: got %+v %v", tip, err)
+	}
+}
+
+func TestBlockIndexTxIndexFork(t *testing.T) {
+	dir := t.TempDir()
+	blocks := newTestSpendChain(5)
+
+	// A stale block at height 2 with a transaction of its own, its coinbase is the one of blocks[2]
+	forkTx := newTestTransaction([]*OutPoint{NewOutPoint(Hash{9}, 0)}, Coin)
+	stale := newTestBlock(blocks[1].Hash(), 3, forkTx)
+	stale.Header.MerkleRoot = stale.MerkleRoot()
+	writeTestBlockFile(t, dir, 0, make([]byte, BlockFileXorKeySize), append(blocks, stale)...)
+
+	r, err := NewBlockFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	bi, err := NewBlockIndex(filepath.Join(dir, "index"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bi.Close()
+
+	if n, err := bi.Scan(); err != nil || n != 6 {
+		t.Fatalf("scan: got %d blocks %v", n, err)
+	}
+
+	expect := func(tx *Transaction, block *Block) {
+		t.Helper()
+		_, hash, err := bi.GetTransaction(tx.Hash())
+		if block == nil {
+			if err != ErrBlockIndexTxMissing {
+				t.Fatalf("%s: expect %v, got %s %v", tx.Hash(), ErrBlockIndexTxMissing, hash, err)
+			}
+			return
+		}
+		if err != nil || hash != block.Hash() {
+			t.Fatalf("%s: expect block %s, got %s %v", tx.Hash(), block.Hash(), hash, err)
+		}
+	}
+
+	// Only the active chain is indexed
+	expect(forkTx, nil)
+	for _, block := range blocks {
+		expect(block.Transactions[0], block)
+	}
+
+	// A heavier block on top of the stale one switches the tip to its branch
+	heavy := newTestBlock(stale.Hash(), 4)
+	heavy.Header.Bits = NewCompact(0x1f00ffff)
+	writeTestBlockFile(t, dir, 1, make([]byte, BlockFileXorKeySize), heavy)
+
+	if n, err := bi.Scan(); err != nil || n != 1 {
+		t.Fatalf("rescan: got %d blocks %v", n, err)
+	}
+	if tip, err := bi.Tip(); err != nil || tip.Hash != heavy.Hash() {
+		t.Fatalf("tip: got %+v %v", tip, err)
+	}
+
+	expect(blocks[1].Transactions[0], blocks[1])
+	expect(forkTx, stale)
+	expect(blocks[2].Transactions[0], stale)
+	expect(blocks[3].Transactions[0], heavy)
+	expect(blocks[4].Transactions[0], nil)
+}
@@ -0,0 +1,102 @@
+package bcore
+
+import (
+	"errors"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrChainstateBadObfuscateKey = errors.New("chainstate: bad obfuscation key")
+)
+
+// chainstateObfuscateKeyKey is the serialized "\x00obfuscate_key" string under which
+// Bitcoin Core stores the key XORed with every value of the database.
+var chainstateObfuscateKeyKey = append([]byte{14, 0}, "obfuscate_key"...)
+
+// OpenChainstate opens the chainstate directory of a stopped Bitcoin Core node read-only.
+// Values are de-obfuscated with the key stored in the database, so the coins and the best
+// block can be read with the UtxoStore methods.
+func OpenChainstate(path string) (*LevelDBUtxoStore, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := readChainstateObfuscateKey(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &LevelDBUtxoStore{db: db, key: key}, nil
+}
+
+// readChainstateObfuscateKey reads the obfuscation key, a serialized byte vector.
+// Databases created before Bitcoin Core 0.12 have none and are not obfuscated.
+func readChainstateObfuscateKey(db *leveldb.DB) ([]byte, error) {
+	data, err := db.Get(chainstateObfuscateKeyKey, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewReadBuffer(data).GetVarBytes()
+	if err != nil || len(key) != len(data)-1 {
+		return nil, ErrChainstateBadObfuscateKey
+	}
+
+	return key, nil
+}
+
+// UtxoStats summarizes a set of unspent outputs
+type UtxoStats struct {
+	BestBlock   Hash
+	Count       uint64
+	TotalAmount uint64
+	// Number of unspent outputs created by coinbase transactions
+	CoinbaseCount uint64
+	// The hash_serialized_3 and muhash commitments reported by gettxoutsetinfo
+	HashSerialized Hash
+	MuHash         Hash
+}
+
+// ComputeUtxoStats walks every unspent output of store.
+// HashSerialized requires the store to iterate in chainstate key order.
+func ComputeUtxoStats(store UtxoStore) (*UtxoStats, error) {
+	best, err := store.BestBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := NewHashSerialized()
+	muhash := NewMuHash3072()
+
+	stats := &UtxoStats{BestBlock: best}
+	err = store.ForEachUtxo(func(op *OutPoint, e *UtxoEntry) bool {
+		stats.Count++
+		stats.TotalAmount += e.Output.Value
+		if e.Coinbase {
+			stats.CoinbaseCount++
+		}
+		hasher.Add(op, e)
+		muhash.InsertUtxo(op, e)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.HashSerialized = hasher.Sum()
+	stats.MuHash = muhash.Finalize()
+
+	return stats, nil
+}
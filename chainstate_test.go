@@ -0,0 +1,97 @@
+package bcore
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestOpenChainstate(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte{0x3a, 0x57, 0x11, 0x9c, 0x02, 0xe4, 0x71, 0x0f}
+
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Put(chainstateObfuscateKeyKey, append([]byte{byte(len(key))}, key...), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// write the coins the way Bitcoin Core does, through an obfuscating store
+	store := &LevelDBUtxoStore{db: db, key: key}
+	p2pkh, _ := hex.DecodeString("76a914404371705fa9bd789a2fcd52d2c580b65d35549d88ac")
+	ops := []*OutPoint{NewOutPoint(Hash{1}, 0), NewOutPoint(Hash{2}, 300)}
+	entries := []*UtxoEntry{
+		NewUtxoEntry(&TransactionOutput{Value: 50 * Coin, ScriptPubkey: p2pkh}, 1, true),
+		NewUtxoEntry(&TransactionOutput{Value: 12345, ScriptPubkey: []byte{0x51, 0x20}}, 800000, false),
+	}
+	best := Hash{0xbb}
+
+	if err := store.WriteUtxos(map[OutPoint]*UtxoEntry{*ops[0]: entries[0], *ops[1]: entries[1]}, best); err != nil {
+		t.Fatal(err)
+	}
+
+	// values must be stored obfuscated
+	raw, err := db.Get(utxoStoreKey(ops[0]), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(raw) == hex.EncodeToString(entries[0].CompressedBytes()) {
+		t.Fatalf("value should be obfuscated")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	chainstate, err := OpenChainstate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chainstate.Close()
+
+	got, err := chainstate.BestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != best {
+		t.Fatalf("best block: expect %s, got %s", best, got)
+	}
+
+	for i, op := range ops {
+		e, err := chainstate.GetUtxo(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Height != entries[i].Height || e.Coinbase != entries[i].Coinbase || e.Output.Value != entries[i].Output.Value ||
+			hex.EncodeToString(e.Output.ScriptPubkey) != hex.EncodeToString(entries[i].Output.ScriptPubkey) {
+			t.Fatalf("coin %d: expect %+v, got %+v", i, entries[i], e)
+		}
+	}
+
+	if _, err := chainstate.GetUtxo(NewOutPoint(Hash{1}, 1)); err != ErrUtxoMissing {
+		t.Fatalf("expect missing, got %v", err)
+	}
+
+	stats, err := ComputeUtxoStats(chainstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.BestBlock != best || stats.Count != 2 || stats.CoinbaseCount != 1 || stats.TotalAmount != 50*Coin+12345 {
+		t.Fatalf("stats: got %+v", stats)
+	}
+}
+
+func TestOpenChainstateMissing(t *testing.T) {
+	if _, err := OpenChainstate(t.TempDir() + "/chainstate"); err == nil {
+		t.Fatalf("expect error for a missing chainstate")
+	}
+}
@@ -0,0 +1,101 @@
+package bcore
+
+import (
+	"errors"
+)
+
+var (
+	ErrCoinbaseNotCoinbase = errors.New("coinbase: not a coinbase transaction")
+	ErrCoinbaseNoHeight    = errors.New("coinbase: no height in script signature")
+)
+
+const (
+	opcode0         = 0x00
+	opcodePushData1 = 0x4c
+	opcode1         = 0x51
+	opcode16        = 0x60
+
+	// CoinbaseScriptSigMinSize is the minimal size of a coinbase script signature
+	CoinbaseScriptSigMinSize = 2
+	// CoinbaseScriptSigMaxSize is the maximal size of a coinbase script signature
+	CoinbaseScriptSigMaxSize = 100
+)
+
+// NewCoinbaseHeightScript returns the BIP34 height prefix of a coinbase script signature.
+// It matches `CScript() << height` in Bitcoin Core: OP_0 and OP_1 to OP_16 for small
+// heights and a minimal CScriptNum push otherwise.
+func NewCoinbaseHeightScript(height uint32) []byte {
+	if height == 0 {
+		return []byte{opcode0}
+	}
+
+	if height <= 16 {
+		return []byte{byte(opcode1 - 1 + height)}
+	}
+
+	num := EncodeScriptNum(int64(height))
+	return append([]byte{byte(len(num))}, num...)
+}
+
+// NewCoinbaseTransaction builds a coinbase transaction for height whose script signature
+// starts with the BIP34 height followed by extra.
+func NewCoinbaseTransaction(height uint32, extra []byte, outputs []*TransactionOutput) *Transaction {
+	scriptSig := append(NewCoinbaseHeightScript(height), extra...)
+	if len(scriptSig) < CoinbaseScriptSigMinSize {
+		scriptSig = append(scriptSig, opcode0)
+	}
+
+	return &Transaction{
+		Version: 1,
+		Inputs: []*TransactionInput{
+			{
+				PrevOutput:    NewDefaultOutPoint(),
+				ScriptSig:     scriptSig,
+				Sequence:      TransactionFinalSequence,
+				ScriptWitness: NewScriptWitness([][]byte{}),
+			},
+		},
+		Outputs:  outputs,
+		Locktime: 0,
+	}
+}
+
+// CoinbaseHeight extracts the BIP34 block height from the coinbase script signature.
+func (t *Transaction) CoinbaseHeight() (uint32, error) {
+	if !t.IsCoinbase() {
+		return 0, ErrCoinbaseNotCoinbase
+	}
+
+	scriptSig := t.Inputs[0].ScriptSig
+	if len(scriptSig) == 0 {
+		return 0, ErrCoinbaseNoHeight
+	}
+
+	op := scriptSig[0]
+	switch {
+	case op == opcode0:
+		return 0, nil
+
+	case op >= opcode1 && op <= opcode16:
+		return uint32(op - opcode1 + 1), nil
+
+	case op > opcode0 && op < opcodePushData1:
+		n := int(op)
+		if len(scriptSig) < 1+n {
+			return 0, ErrCoinbaseNoHeight
+		}
+
+		height, err := DecodeScriptNum(scriptSig[1:1+n], true, ScriptNumDefaultSize+1)
+		if err != nil {
+			return 0, err
+		}
+
+		if height < 0 || height > 0xffffffff {
+			return 0, ErrCoinbaseNoHeight
+		}
+
+		return uint32(height), nil
+	}
+
+	return 0, ErrCoinbaseNoHeight
+}
@@ -0,0 +1,85 @@
+package bcore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestCoinbaseHeight(t *testing.T) {
+	// coinbase at the mainnet BIP34 activation height 227931
+	s := "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0d035b7a030101062f503253482fffffffff0100f2052a010000002421025b5e3a3f4f0b6e0c4a2d0d0f5e8d9a6d6cbbd2d3e0f1a2b3c4d5e6f708192a3b4cac00000000"
+	tx, err := NewTransactionFromHexString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	height, err := tx.CoinbaseHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if height != 227931 {
+		t.Fatalf("coinbase height: expect 227931, got %d", height)
+	}
+}
+
+func TestCoinbaseHeightScript(t *testing.T) {
+	tests := []struct {
+		height uint32
+		expect string
+	}{
+		{0, "00"},
+		{1, "51"},
+		{16, "60"},
+		{17, "0111"},
+		{127, "017f"},
+		{128, "028000"},
+		{227931, "035b7a03"},
+		{840000, "0340d10c"},
+	}
+
+	for _, test := range tests {
+		script := NewCoinbaseHeightScript(test.height)
+		if hex.EncodeToString(script) != test.expect {
+			t.Fatalf("height %d: expect %s, got %x", test.height, test.expect, script)
+		}
+
+		tx := NewCoinbaseTransaction(test.height, []byte("bcore"), nil)
+		if !tx.IsCoinbase() {
+			t.Fatalf("height %d: expect coinbase", test.height)
+		}
+
+		if !bytes.HasPrefix(tx.Inputs[0].ScriptSig, script) {
+			t.Fatalf("height %d: script signature got %x", test.height, tx.Inputs[0].ScriptSig)
+		}
+
+		height, err := tx.CoinbaseHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if height != test.height {
+			t.Fatalf("height: expect %d, got %d", test.height, height)
+		}
+	}
+}
+
+func TestCoinbaseHeightErrors(t *testing.T) {
+	tx := NewCoinbaseTransaction(1, nil, nil)
+	if len(tx.Inputs[0].ScriptSig) != CoinbaseScriptSigMinSize {
+		t.Fatalf("script signature: got %x", tx.Inputs[0].ScriptSig)
+	}
+
+	tx.Inputs[0].ScriptSig = []byte{0x03, 0x01}
+	if _, err := tx.CoinbaseHeight(); err != ErrCoinbaseNoHeight {
+		t.Fatalf("expect no height, got %v", err)
+	}
+
+	tx.Inputs[0].PrevOutput = NewOutPoint(Hash{1}, 0)
+	if _, err := tx.CoinbaseHeight(); err != ErrCoinbaseNotCoinbase {
+		t.Fatalf("expect not coinbase, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package bcore
+
+import (
+	"crypto/sha256"
+	"hash"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// HashSerialized computes the hash_serialized_3 commitment of Bitcoin Core to a UTXO set:
+// the double SHA256 of every coin serialized as outpoint, uint32(height*2 + coinbase)
+// and the uncompressed output. Coins must be added in the order of the chainstate
+// database, by txid and then output index, which is also the order of UTXO snapshots.
+type HashSerialized struct {
+	h hash.Hash
+}
+
+func NewHashSerialized() *HashSerialized {
+	return &HashSerialized{h: sha256.New()}
+}
+
+func hashSerializedCoinBytes(op *OutPoint, e *UtxoEntry) []byte {
+	code := e.Height << 1
+	if e.Coinbase {
+		code |= 1
+	}
+
+	return NewBuffer().
+		PutBytes(op.Bytes()).
+		PutUint32(code).
+		PutBytes(e.Output.Bytes()).
+		Bytes()
+}
+
+func (hs *HashSerialized) Add(op *OutPoint, e *UtxoEntry) {
+	hs.h.Write(hashSerializedCoinBytes(op, e))
+}
+
+func (hs *HashSerialized) Sum() Hash {
+	first := hs.h.Sum(nil)
+	return Hash(sha256.Sum256(first))
+}
@@ -0,0 +1,290 @@
+package bcore
+
+import (
+	"errors"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrCompressVarIntOverflow = errors.New("compress: varint overflow")
+	ErrCompressInvalidScript  = errors.New("compress: invalid compressed script")
+	ErrCompressScriptTooLarge = errors.New("compress: script larger than a block")
+)
+
+const (
+	// Number of special script types of the script compression
+	compressSpecialScripts = 6
+)
+
+// EncodeVarInt128 serializes n as the VARINT of Bitcoin Core: big-endian base-128 digits
+// where every digit but the last has its high bit set and is offset by one.
+// It is used by the undo and chainstate formats, unlike the CompactSize of the p2p protocol.
+func EncodeVarInt128(n uint64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n > 0x7f {
+		n = (n >> 7) - 1
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+
+	return append([]byte{}, tmp[i:]...)
+}
+
+// compressedReader is what the compressed formats are decoded from, a Buffer or a
+// stream read by the same methods
+type compressedReader interface {
+	GetUint8() (uint8, error)
+	GetBytes(n int) ([]byte, error)
+}
+
+// DecodeVarInt128 reads a VARINT of Bitcoin Core, see EncodeVarInt128
+func DecodeVarInt128(buffer *Buffer) (uint64, error) {
+	return decodeVarInt128(buffer)
+}
+
+func decodeVarInt128(r compressedReader) (uint64, error) {
+	n := uint64(0)
+	for {
+		ch, err := r.GetUint8()
+		if err != nil {
+			return 0, err
+		}
+
+		if n > (^uint64(0) >> 7) {
+			return 0, ErrCompressVarIntOverflow
+		}
+
+		n = (n << 7) | uint64(ch&0x7f)
+		if ch&0x80 == 0 {
+			return n, nil
+		}
+
+		if n == ^uint64(0) {
+			return 0, ErrCompressVarIntOverflow
+		}
+		n++
+	}
+}
+
+// CompressAmount compresses an amount of satoshis, taking advantage of the
+// trailing decimal zeros most amounts have.
+func CompressAmount(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+
+	e := uint64(0)
+	for n%10 == 0 && e < 9 {
+		n /= 10
+		e++
+	}
+
+	if e < 9 {
+		d := n % 10
+		n /= 10
+		return 1 + (n*9+d-1)*10 + e
+	}
+
+	return 1 + (n-1)*10 + 9
+}
+
+// DecompressAmount is the inverse of CompressAmount
+func DecompressAmount(x uint64) uint64 {
+	if x == 0 {
+		return 0
+	}
+
+	x--
+	e := x % 10
+	x /= 10
+
+	n := uint64(0)
+	if e < 9 {
+		d := x%9 + 1
+		x /= 9
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+
+	for ; e > 0; e-- {
+		n *= 10
+	}
+
+	return n
+}
+
+// CompressScript compresses standard pay-to-pubkey-hash, pay-to-script-hash
+// and pay-to-pubkey scripts into a type byte followed by 20 or 32 bytes of payload.
+// It returns false for any other script.
+func CompressScript(script []byte) ([]byte, bool) {
+	switch {
+	// OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+	case len(script) == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 20 &&
+		script[23] == 0x88 && script[24] == 0xac:
+		return append([]byte{0x00}, script[3:23]...), true
+
+	// OP_HASH160 <20 bytes> OP_EQUAL
+	case len(script) == 23 && script[0] == 0xa9 && script[1] == 20 && script[22] == 0x87:
+		return append([]byte{0x01}, script[2:22]...), true
+
+	// <33 bytes compressed pubkey> OP_CHECKSIG
+	case len(script) == 35 && script[0] == 33 && script[34] == 0xac &&
+		(script[1] == 0x02 || script[1] == 0x03):
+		return append([]byte{script[1]}, script[2:34]...), true
+
+	// <65 bytes uncompressed pubkey> OP_CHECKSIG
+	case len(script) == 67 && script[0] == 65 && script[66] == 0xac && script[1] == 0x04 &&
+		isValidPubkey(script[1:66]):
+		return append([]byte{0x04 | (script[65] & 0x01)}, script[2:34]...), true
+	}
+
+	return nil, false
+}
+
+// compressedScriptSize returns the payload size of a special script type
+func compressedScriptSize(kind uint64) int {
+	if kind == 0x00 || kind == 0x01 {
+		return 20
+	}
+
+	return 32
+}
+
+// DecompressScript rebuilds a script from its special type and payload
+func DecompressScript(kind uint64, payload []byte) ([]byte, error) {
+	if kind >= compressSpecialScripts || len(payload) != compressedScriptSize(kind) {
+		return nil, ErrCompressInvalidScript
+	}
+
+	switch kind {
+	case 0x00:
+		script := append([]byte{0x76, 0xa9, 20}, payload...)
+		return append(script, 0x88, 0xac), nil
+
+	case 0x01:
+		script := append([]byte{0xa9, 20}, payload...)
+		return append(script, 0x87), nil
+
+	case 0x02, 0x03:
+		script := append([]byte{33, byte(kind)}, payload...)
+		return append(script, 0xac), nil
+	}
+
+	pubkey, ok := decompressPubkey(append([]byte{byte(kind - 2)}, payload...))
+	if !ok {
+		return nil, ErrCompressInvalidScript
+	}
+
+	script := append([]byte{65}, pubkey...)
+	return append(script, 0xac), nil
+}
+
+// CompressedBytes serializes the output as the compressed TxOut of Bitcoin Core:
+// VARINT(CompressAmount(value)) followed by the compressed script.
+func (to *TransactionOutput) CompressedBytes() []byte {
+	buffer := NewBuffer().PutBytes(EncodeVarInt128(CompressAmount(to.Value)))
+
+	if compressed, ok := CompressScript(to.ScriptPubkey); ok {
+		return buffer.PutBytes(compressed).Bytes()
+	}
+
+	return buffer.
+		PutBytes(EncodeVarInt128(uint64(len(to.ScriptPubkey) + compressSpecialScripts))).
+		PutBytes(to.ScriptPubkey).
+		Bytes()
+}
+
+// NewTransactionOutputFromCompressedBuffer reads an output serialized by CompressedBytes
+func NewTransactionOutputFromCompressedBuffer(buffer *Buffer) (*TransactionOutput, error) {
+	return newTransactionOutputFromCompressed(buffer)
+}
+
+func newTransactionOutputFromCompressed(r compressedReader) (*TransactionOutput, error) {
+	amount, err := decodeVarInt128(r)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := decodeVarInt128(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var script []byte
+	if size < compressSpecialScripts {
+		payload, err := r.GetBytes(compressedScriptSize(size))
+		if err != nil {
+			return nil, err
+		}
+
+		script, err = DecompressScript(size, payload)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		size -= compressSpecialScripts
+		if size > MaxBlockSerializedSize {
+			// No output of a block can be that large, the data is corrupted
+			return nil, ErrCompressScriptTooLarge
+		}
+
+		if size > MaxScriptSize {
+			// Overly long scripts are replaced by a short unspendable one, like Bitcoin Core does
+			if _, err := r.GetBytes(int(size)); err != nil {
+				return nil, err
+			}
+			script = []byte{opcodeReturn}
+		} else {
+			script, err = r.GetBytes(int(size))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &TransactionOutput{
+		Value:        DecompressAmount(amount),
+		ScriptPubkey: script,
+	}, nil
+}
+
+// CompressedBytes serializes the entry as a Coin of Bitcoin Core:
+// VARINT(height*2 + coinbase) followed by the compressed output.
+func (e *UtxoEntry) CompressedBytes() []byte {
+	code := uint64(e.Height) * 2
+	if e.Coinbase {
+		code |= 1
+	}
+
+	return NewBuffer().
+		PutBytes(EncodeVarInt128(code)).
+		PutBytes(e.Output.CompressedBytes()).
+		Bytes()
+}
+
+// NewUtxoEntryFromCompressedBuffer reads an entry serialized by CompressedBytes
+func NewUtxoEntryFromCompressedBuffer(buffer *Buffer) (*UtxoEntry, error) {
+	return newUtxoEntryFromCompressed(buffer)
+}
+
+func newUtxoEntryFromCompressed(r compressedReader) (*UtxoEntry, error) {
+	code, err := decodeVarInt128(r)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := newTransactionOutputFromCompressed(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUtxoEntry(output, uint32(code>>1), code&1 == 1), nil
+}
+
+func NewUtxoEntryFromCompressedBytes(data []byte) (*UtxoEntry, error) {
+	return NewUtxoEntryFromCompressedBuffer(NewReadBuffer(data))
+}
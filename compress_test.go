@@ -0,0 +1,154 @@
+package bcore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	testPubkeyX = "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	testPubkeyY = "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+)
+
+func TestVarInt128(t *testing.T) {
+	tests := []struct {
+		n      uint64
+		expect string
+	}{
+		{0, "00"},
+		{0x7f, "7f"},
+		{0x80, "8000"},
+		{0x1234, "a334"},
+		{0xffff, "82fe7f"},
+		{0x123456, "c7e756"},
+		{0x80123456, "86ffc7e756"},
+		{0xffffffff, "8efefefe7f"},
+		{0xffffffffffffffff, "80fefefefefefefefe7f"},
+	}
+
+	for _, test := range tests {
+		b := EncodeVarInt128(test.n)
+		if hex.EncodeToString(b) != test.expect {
+			t.Fatalf("encode %d: expect %s, got %x", test.n, test.expect, b)
+		}
+
+		n, err := DecodeVarInt128(NewReadBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != test.n {
+			t.Fatalf("decode %x: expect %d, got %d", b, test.n, n)
+		}
+	}
+
+	if _, err := DecodeVarInt128(NewReadBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})); err != ErrCompressVarIntOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+}
+
+func TestCompressAmount(t *testing.T) {
+	tests := []struct {
+		n      uint64
+		expect uint64
+	}{
+		{0, 0x0},
+		{1, 0x1},
+		{1000000, 0x7},
+		{Coin, 0x9},
+		{50 * Coin, 0x32},
+		{21000000 * Coin, 0x1406f40},
+	}
+
+	for _, test := range tests {
+		if x := CompressAmount(test.n); x != test.expect {
+			t.Fatalf("compress %d: expect %x, got %x", test.n, test.expect, x)
+		}
+
+		if n := DecompressAmount(test.expect); n != test.n {
+			t.Fatalf("decompress %x: expect %d, got %d", test.expect, test.n, n)
+		}
+	}
+
+	for n := uint64(0); n < 100000; n++ {
+		if DecompressAmount(CompressAmount(n)) != n {
+			t.Fatalf("amount %d does not roundtrip", n)
+		}
+	}
+}
+
+func TestCompressScript(t *testing.T) {
+	hash := "1111111111111111111111111111111111111111"
+	tests := []struct {
+		script string
+		expect string
+	}{
+		{"76a914" + hash + "88ac", "00" + hash},
+		{"a914" + hash + "87", "01" + hash},
+		{"2102" + testPubkeyX + "ac", "02" + testPubkeyX},
+		{"4104" + testPubkeyX + testPubkeyY + "ac", "04" + testPubkeyX},
+	}
+
+	for _, test := range tests {
+		script, _ := hex.DecodeString(test.script)
+		compressed, ok := CompressScript(script)
+		if !ok || hex.EncodeToString(compressed) != test.expect {
+			t.Fatalf("compress %s: expect %s, got %x", test.script, test.expect, compressed)
+		}
+
+		decompressed, err := DecompressScript(uint64(compressed[0]), compressed[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decompressed, script) {
+			t.Fatalf("decompress %x: expect %s, got %x", compressed, test.script, decompressed)
+		}
+	}
+
+	// an uncompressed pubkey off the curve is kept as is
+	script, _ := hex.DecodeString("4104" + testPubkeyX + testPubkeyX + "ac")
+	if _, ok := CompressScript(script); ok {
+		t.Fatalf("invalid pubkey should not be compressed")
+	}
+}
+
+func TestUtxoEntryCompressedBytes(t *testing.T) {
+	p2pkh, _ := hex.DecodeString("76a914404371705fa9bd789a2fcd52d2c580b65d35549d88ac")
+	witness, _ := hex.DecodeString("0014751e76e8199196d454941c45d1b3a323f1433bd6")
+
+	tests := []*UtxoEntry{
+		NewUtxoEntry(&TransactionOutput{Value: 50 * Coin, ScriptPubkey: p2pkh}, 1, true),
+		NewUtxoEntry(&TransactionOutput{Value: 12345, ScriptPubkey: witness}, 840000, false),
+		NewUtxoEntry(&TransactionOutput{Value: 0, ScriptPubkey: []byte{}}, 0, false),
+	}
+
+	for _, e := range tests {
+		got, err := NewUtxoEntryFromCompressedBytes(e.CompressedBytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Height != e.Height || got.Coinbase != e.Coinbase || got.Output.Value != e.Output.Value ||
+			!bytes.Equal(got.Output.ScriptPubkey, e.Output.ScriptPubkey) {
+			t.Fatalf("expect %+v, got %+v", e, got)
+		}
+	}
+
+	// a corrupted script size fails instead of being read
+	for _, size := range []uint64{MaxBlockSerializedSize + 1, 1 << 63, ^uint64(0) - compressSpecialScripts} {
+		data := append(EncodeVarInt128(2), 0)
+		data = append(data, EncodeVarInt128(size+compressSpecialScripts)...)
+		if _, err := NewUtxoEntryFromCompressedBytes(data); err != ErrCompressScriptTooLarge {
+			t.Fatalf("size %d: expect %v, got %v", size, ErrCompressScriptTooLarge, err)
+		}
+	}
+
+	// height 1 coinbase paying 50 BTC to a pubkey hash
+	if hex.EncodeToString(tests[0].CompressedBytes()) != "033200404371705fa9bd789a2fcd52d2c580b65d35549d" {
+		t.Fatalf("compressed coin: got %x", tests[0].CompressedBytes())
+	}
+}
@@ -0,0 +1,84 @@
+package electrum
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// client is the state of a connection, writes are serialized with notifications
+type client struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	headers bool
+	tipHex  string
+	// Last status sent for every subscribed script hash
+	scripthashes map[Hash]string
+}
+
+func (c *client) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = c.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (c *client) subscribeHeaders(tip *HeaderNotification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.headers = true
+	c.tipHex = tip.Hex
+}
+
+func (c *client) subscribeScriptHash(sh Hash, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scripthashes[sh] = status
+}
+
+// pending returns the notifications due since the last ones and records them as sent
+func (c *client) pending(tip *HeaderNotification, status func(sh Hash) (string, error)) []*notification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var notifications []*notification
+	if c.headers && c.tipHex != tip.Hex {
+		c.tipHex = tip.Hex
+		notifications = append(notifications, &notification{
+			JSONRPC: "2.0",
+			Method:  "blockchain.headers.subscribe",
+			Params:  []interface{}{tip},
+		})
+	}
+
+	for sh, last := range c.scripthashes {
+		current, err := status(sh)
+		if err != nil || current == last {
+			continue
+		}
+
+		c.scripthashes[sh] = current
+		var params interface{}
+		if current != "" {
+			params = current
+		}
+		notifications = append(notifications, &notification{
+			JSONRPC: "2.0",
+			Method:  "blockchain.scripthash.subscribe",
+			Params:  []interface{}{sh.RString(), params},
+		})
+	}
+
+	return notifications
+}
@@ -0,0 +1,569 @@
+// Package electrum serves the local block, transaction and script hash indexes
+// over the Electrum protocol: newline delimited JSON-RPC over TCP.
+package electrum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// ProtocolVersion is the Electrum protocol version implemented by the server
+	ProtocolVersion = "1.4"
+	// ServerVersion is the software version reported by server.version
+	ServerVersion = "go-bcore"
+
+	// MaxRequestSize is the maximal size of a request line
+	MaxRequestSize = 1 << 20
+)
+
+// JSON-RPC error codes, the ones above zero follow ElectrumX
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeBadRequest     = 1
+	CodeDaemonError    = 2
+)
+
+var (
+	ErrServerClosed = errors.New("electrum: server closed")
+)
+
+// Error is a JSON-RPC error returned to the client
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// HeaderNotification is the result of blockchain.headers.subscribe
+type HeaderNotification struct {
+	Hex    string `json:"hex"`
+	Height uint32 `json:"height"`
+}
+
+// HistoryItem is an entry of blockchain.scripthash.get_history
+type HistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	Height uint32 `json:"height"`
+}
+
+// Balance is the result of blockchain.scripthash.get_balance
+type Balance struct {
+	Confirmed   uint64 `json:"confirmed"`
+	Unconfirmed int64  `json:"unconfirmed"`
+}
+
+// Unspent is an entry of blockchain.scripthash.listunspent
+type Unspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height uint32 `json:"height"`
+	Value  uint64 `json:"value"`
+}
+
+// Merkle is the result of blockchain.transaction.get_merkle
+type Merkle struct {
+	BlockHeight uint32   `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+type handler func(c *client, params []json.RawMessage) (interface{}, error)
+
+// Server answers Electrum clients from a script hash index and a block index
+// kept up to date by the caller, who calls Notify after connecting blocks.
+type Server struct {
+	scripthashes *bcore.ScriptHashIndex
+	blocks       *bcore.BlockIndex
+	handlers     map[string]handler
+
+	mu        sync.Mutex
+	clients   map[*client]struct{}
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+func NewServer(scripthashes *bcore.ScriptHashIndex, blocks *bcore.BlockIndex) *Server {
+	s := &Server{
+		scripthashes: scripthashes,
+		blocks:       blocks,
+		clients:      make(map[*client]struct{}),
+		listeners:    make(map[net.Listener]struct{}),
+	}
+
+	s.handlers = map[string]handler{
+		"server.version":                    s.version,
+		"server.ping":                       s.ping,
+		"blockchain.headers.subscribe":      s.headersSubscribe,
+		"blockchain.scripthash.get_history": s.scripthashGetHistory,
+		"blockchain.scripthash.get_balance": s.scripthashGetBalance,
+		"blockchain.scripthash.listunspent": s.scripthashListUnspent,
+		"blockchain.scripthash.subscribe":   s.scripthashSubscribe,
+		"blockchain.transaction.get":        s.transactionGet,
+		"blockchain.transaction.get_merkle": s.transactionGetMerkle,
+	}
+
+	return s
+}
+
+// Serve accepts connections on l until it fails or the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers the requests of a single client until it disconnects
+func (s *Server) ServeConn(conn net.Conn) {
+	c := &client{
+		conn:         conn,
+		scripthashes: make(map[Hash]string),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, 4096)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := c.write(s.handle(c, line)); err != nil {
+			return
+		}
+	}
+}
+
+// readLine reads a request line, failing on lines longer than MaxRequestSize
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, chunk...)
+		if len(line) > MaxRequestSize {
+			return nil, io.ErrShortBuffer
+		}
+
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// handle answers a request or a batch of requests
+func (s *Server) handle(c *client, line []byte) interface{} {
+	if line[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(line, &batch); err != nil || len(batch) == 0 {
+			return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: newError(CodeParseError, "invalid batch")}
+		}
+
+		responses := make([]*response, len(batch))
+		for i, data := range batch {
+			responses[i] = s.call(c, data)
+		}
+		return responses
+	}
+
+	return s.call(c, line)
+}
+
+func (s *Server) call(c *client, data []byte) *response {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: newError(CodeParseError, err.Error())}
+	}
+
+	if req.ID == nil {
+		req.ID = json.RawMessage("null")
+	}
+
+	h, ok := s.handlers[req.Method]
+	if !ok {
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: newError(CodeMethodNotFound, "unknown method "+req.Method)}
+	}
+
+	result, err := h(c, req.Params)
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = newError(CodeDaemonError, err.Error())
+		}
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: e}
+	}
+
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// Notify sends the new tip to the clients subscribed to headers and the new
+// status of the script hashes they subscribed to, when it changed.
+func (s *Server) Notify() error {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	tip, err := s.tip()
+	if err != nil {
+		return err
+	}
+
+	statuses := make(map[Hash]string)
+	for _, c := range clients {
+		for _, n := range c.pending(tip, func(sh Hash) (string, error) {
+			if status, ok := statuses[sh]; ok {
+				return status, nil
+			}
+			status, err := s.status(sh)
+			if err == nil {
+				statuses[sh] = status
+			}
+			return status, err
+		}) {
+			if err := c.write(n); err != nil {
+				c.conn.Close()
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close stops the listeners and disconnects every client
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+
+	return nil
+}
+
+func (s *Server) tip() (*HeaderNotification, error) {
+	e, err := s.blocks.Tip()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeaderNotification{
+		Hex:    hex.EncodeToString(e.Header.Bytes()),
+		Height: e.Height,
+	}, nil
+}
+
+// status returns the hex status of sh, empty when it has no history
+func (s *Server) status(sh Hash) (string, error) {
+	status, ok, err := s.scripthashes.Status(sh)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	return hex.EncodeToString(status[:]), nil
+}
+
+// parseHash decodes a hash displayed in reversed hex, like txids and script hashes
+func parseHash(s string) (Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != HashSize {
+		return HashZero, newError(CodeInvalidParams, "invalid hash "+s)
+	}
+
+	var h Hash
+	for i := range b {
+		h[HashSize-1-i] = b[i]
+	}
+	return h, nil
+}
+
+// params decodes the positional params, the ones past required are optional
+func params(raw []json.RawMessage, required int, values ...interface{}) error {
+	if len(raw) < required || len(raw) > len(values) {
+		return newError(CodeInvalidParams, "wrong number of params")
+	}
+
+	for i, r := range raw {
+		if err := json.Unmarshal(r, values[i]); err != nil {
+			return newError(CodeInvalidParams, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func hashParam(raw []json.RawMessage) (Hash, error) {
+	var s string
+	if err := params(raw, 1, &s); err != nil {
+		return HashZero, err
+	}
+
+	return parseHash(s)
+}
+
+func (s *Server) version(c *client, raw []json.RawMessage) (interface{}, error) {
+	var name string
+	var version interface{}
+	if err := params(raw, 0, &name, &version); err != nil {
+		return nil, err
+	}
+
+	return []string{ServerVersion, ProtocolVersion}, nil
+}
+
+func (s *Server) ping(c *client, raw []json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *Server) headersSubscribe(c *client, raw []json.RawMessage) (interface{}, error) {
+	tip, err := s.tip()
+	if err != nil {
+		return nil, err
+	}
+
+	c.subscribeHeaders(tip)
+	return tip, nil
+}
+
+func (s *Server) scripthashGetHistory(c *client, raw []json.RawMessage) (interface{}, error) {
+	sh, err := hashParam(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.scripthashes.History(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*HistoryItem, len(history))
+	for i, h := range history {
+		items[i] = &HistoryItem{TxHash: h.Txid.RString(), Height: h.Height}
+	}
+	return items, nil
+}
+
+func (s *Server) scripthashGetBalance(c *client, raw []json.RawMessage) (interface{}, error) {
+	sh, err := hashParam(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := s.scripthashes.Balance(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Balance{Confirmed: confirmed}, nil
+}
+
+func (s *Server) scripthashListUnspent(c *client, raw []json.RawMessage) (interface{}, error) {
+	sh, err := hashParam(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	unspent, err := s.scripthashes.Unspent(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Unspent, len(unspent))
+	for i, u := range unspent {
+		items[i] = &Unspent{
+			TxHash: u.OutPoint.Hash.RString(),
+			TxPos:  u.OutPoint.Index,
+			Height: u.Height,
+			Value:  u.Value,
+		}
+	}
+	return items, nil
+}
+
+func (s *Server) scripthashSubscribe(c *client, raw []json.RawMessage) (interface{}, error) {
+	sh, err := hashParam(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := s.status(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	c.subscribeScriptHash(sh, status)
+	if status == "" {
+		return nil, nil
+	}
+	return status, nil
+}
+
+func (s *Server) transactionGet(c *client, raw []json.RawMessage) (interface{}, error) {
+	var txhash string
+	var verbose bool
+	if err := params(raw, 1, &txhash, &verbose); err != nil {
+		return nil, err
+	}
+
+	if verbose {
+		return nil, newError(CodeBadRequest, "verbose transactions are not supported")
+	}
+
+	txid, err := parseHash(txhash)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, _, err := s.blocks.GetTransaction(txid)
+	if err == bcore.ErrBlockIndexTxMissing {
+		return nil, newError(CodeBadRequest, "unknown transaction "+txhash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(tx.BytesWithWitness()), nil
+}
+
+func (s *Server) transactionGetMerkle(c *client, raw []json.RawMessage) (interface{}, error) {
+	var txhash string
+	var height uint32
+	if err := params(raw, 1, &txhash, &height); err != nil {
+		return nil, err
+	}
+
+	txid, err := parseHash(txhash)
+	if err != nil {
+		return nil, err
+	}
+
+	txe, err := s.blocks.GetTxIndex(txid)
+	if err == bcore.ErrBlockIndexTxMissing {
+		return nil, newError(CodeBadRequest, "unknown transaction "+txhash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.blocks.GetBlock(txe.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) > 1 && e.Height != height {
+		return nil, newError(CodeBadRequest, "transaction not in block at height")
+	}
+
+	block, err := s.blocks.ReadBlock(txe.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	for pos, tx := range block.Transactions {
+		if tx.Hash() != txid {
+			continue
+		}
+
+		branch := block.MerkleBranch(pos)
+		merkle := make([]string, len(branch))
+		for i, h := range branch {
+			merkle[i] = h.RString()
+		}
+
+		return &Merkle{BlockHeight: e.Height, Merkle: merkle, Pos: pos}, nil
+	}
+
+	return nil, newError(CodeBadRequest, "transaction not in block at height")
+}
@@ -0,0 +1,349 @@
+package electrum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var testScriptPubkey = []byte{0x00, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+type testNode struct {
+	t      *testing.T
+	dir    string
+	writer *bcore.BlockFileWriter
+	set    *bcore.UtxoSet
+	index  *bcore.ScriptHashIndex
+	blocks *bcore.BlockIndex
+	chain  []*bcore.Block
+	// Script paid by the coinbase of the next blocks
+	coinbaseScript []byte
+}
+
+func newTestNode(t *testing.T) *testNode {
+	dir := t.TempDir()
+
+	writer, err := bcore.NewBlockFileWriter(dir, bcore.RegTestParams, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { writer.Close() })
+
+	reader, err := bcore.NewBlockFileReader(dir, bcore.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+
+	blocks, err := bcore.NewBlockIndex(filepath.Join(dir, "index"), reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { blocks.Close() })
+
+	index, err := bcore.NewScriptHashIndex(filepath.Join(dir, "scripthash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+
+	return &testNode{
+		t:      t,
+		dir:    dir,
+		writer: writer,
+		set:    bcore.NewUtxoSet(bcore.RegTestParams),
+		index:  index,
+		blocks: blocks,
+
+		coinbaseScript: testScriptPubkey,
+	}
+}
+
+// mine connects a block with txs on top of the chain
+func (n *testNode) mine(txs ...*bcore.Transaction) *bcore.Block {
+	height := uint32(len(n.chain))
+	prev := HashZero
+	if height > 0 {
+		prev = n.chain[height-1].Hash()
+	}
+
+	coinbase := bcore.NewCoinbaseTransaction(height, nil, []*bcore.TransactionOutput{
+		{Value: bcore.Subsidy(height, bcore.RegTestParams), ScriptPubkey: n.coinbaseScript},
+	})
+	block := bcore.NewBlock(&bcore.BlockHeader{
+		Version:  4,
+		PrevHash: prev,
+		Time:     1600000000 + height*600,
+		Bits:     NewCompact(0x207fffff),
+	}, append([]*bcore.Transaction{coinbase}, txs...))
+	block.Header.MerkleRoot = block.MerkleRoot()
+
+	undo, err := n.set.ConnectBlock(block, height)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	if err := n.index.ConnectBlock(block, height, undo); err != nil {
+		n.t.Fatal(err)
+	}
+	if _, err := n.writer.Write(block); err != nil {
+		n.t.Fatal(err)
+	}
+	if err := n.writer.Flush(); err != nil {
+		n.t.Fatal(err)
+	}
+	if _, err := n.blocks.Scan(); err != nil {
+		n.t.Fatal(err)
+	}
+
+	n.chain = append(n.chain, block)
+	return block
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+func (c *testClient) send(line string) {
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read(v interface{}) {
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		c.t.Fatalf("%v: %s", err, line)
+	}
+}
+
+type testResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// call sends a request and decodes its result into result
+func (c *testClient) call(method string, result interface{}, params ...interface{}) *Error {
+	c.id++
+	data, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.send(string(data))
+
+	var resp testResponse
+	c.read(&resp)
+	if resp.ID != c.id {
+		c.t.Fatalf("%s: expect id %d, got %d", method, c.id, resp.ID)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			c.t.Fatalf("%s: %v: %s", method, err, resp.Result)
+		}
+	}
+	return nil
+}
+
+func startTestServer(t *testing.T, n *testNode) (*Server, *testClient) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(n.index, n.blocks)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return s, &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func TestServer(t *testing.T) {
+	n := newTestNode(t)
+	for i := 0; i < 101; i++ {
+		n.mine()
+	}
+
+	// Spend the first coinbase to another script and back to testScriptPubkey
+	other := []byte{0x51}
+	spend := &bcore.Transaction{
+		Version: 2,
+		Inputs: []*bcore.TransactionInput{{
+			PrevOutput: bcore.NewOutPoint(n.chain[0].Transactions[0].Hash(), 0),
+			Sequence:   0xffffffff,
+		}},
+		Outputs: []*bcore.TransactionOutput{
+			{Value: 10 * bcore.Coin, ScriptPubkey: testScriptPubkey},
+			{Value: 39 * bcore.Coin, ScriptPubkey: other},
+		},
+	}
+	block := n.mine(spend)
+
+	_, c := startTestServer(t, n)
+	sh := bcore.NewScriptHash(testScriptPubkey).RString()
+
+	var version []string
+	if err := c.call("server.version", &version, "test", ProtocolVersion); err != nil || len(version) != 2 || version[1] != ProtocolVersion {
+		t.Fatalf("server.version: %v %v", version, err)
+	}
+
+	var tip HeaderNotification
+	if err := c.call("blockchain.headers.subscribe", &tip); err != nil {
+		t.Fatal(err)
+	}
+	if tip.Height != 101 || tip.Hex != hex.EncodeToString(block.Header.Bytes()) {
+		t.Fatalf("tip: unexpected %+v", tip)
+	}
+
+	var balance Balance
+	if err := c.call("blockchain.scripthash.get_balance", &balance, sh); err != nil {
+		t.Fatal(err)
+	}
+	if expect := n.set.TotalAmount() - 39*bcore.Coin; balance.Confirmed != expect {
+		t.Fatalf("balance: expect %d, got %d", expect, balance.Confirmed)
+	}
+
+	var history []HistoryItem
+	if err := c.call("blockchain.scripthash.get_history", &history, sh); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 103 || history[102].TxHash != spend.Hash().RString() || history[102].Height != 101 {
+		t.Fatalf("history: unexpected %d items", len(history))
+	}
+
+	var unspent []Unspent
+	if err := c.call("blockchain.scripthash.listunspent", &unspent, sh); err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent) != 102 {
+		t.Fatalf("listunspent: expect 102 outputs, got %d", len(unspent))
+	}
+
+	var status string
+	if err := c.call("blockchain.scripthash.subscribe", &status, sh); err != nil || len(status) != 64 {
+		t.Fatalf("subscribe: %q %v", status, err)
+	}
+
+	var raw string
+	if err := c.call("blockchain.transaction.get", &raw, spend.Hash().RString()); err != nil {
+		t.Fatal(err)
+	}
+	if raw != hex.EncodeToString(spend.Bytes()) {
+		t.Fatalf("transaction.get: unexpected %s", raw)
+	}
+
+	var merkle Merkle
+	if err := c.call("blockchain.transaction.get_merkle", &merkle, spend.Hash().RString(), 101); err != nil {
+		t.Fatal(err)
+	}
+	branch := make([]Hash, len(merkle.Merkle))
+	for i, s := range merkle.Merkle {
+		h, err := parseHash(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		branch[i] = h
+	}
+	if merkle.Pos != 1 || merkle.BlockHeight != 101 || bcore.MerkleRootFromBranch(spend.Hash(), branch, merkle.Pos) != block.Header.MerkleRoot {
+		t.Fatalf("transaction.get_merkle: unexpected %+v", merkle)
+	}
+
+	if err := c.call("blockchain.transaction.get", nil, HashZero.RString()); err == nil || err.Code != CodeBadRequest {
+		t.Fatalf("unknown transaction: got %v", err)
+	}
+	if err := c.call("blockchain.scripthash.get_balance", nil, "00"); err == nil || err.Code != CodeInvalidParams {
+		t.Fatalf("bad script hash: got %v", err)
+	}
+	if err := c.call("blockchain.unknown", nil); err == nil || err.Code != CodeMethodNotFound {
+		t.Fatalf("unknown method: got %v", err)
+	}
+	if err := c.call("server.ping", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch is answered with an array
+	c.send(fmt.Sprintf(`[{"id":1,"method":"server.ping","params":[]},{"id":2,"method":"blockchain.scripthash.get_balance","params":["%s"]}]`, sh))
+	var batch []testResponse
+	c.read(&batch)
+	if len(batch) != 2 || batch[0].ID != 1 || batch[1].ID != 2 || batch[1].Error != nil {
+		t.Fatalf("batch: unexpected %+v", batch)
+	}
+}
+
+func TestServerNotify(t *testing.T) {
+	n := newTestNode(t)
+	n.mine()
+
+	s, c := startTestServer(t, n)
+
+	other := []byte{0x51}
+	sh := bcore.NewScriptHash(other).RString()
+
+	var status *string
+	if err := c.call("blockchain.scripthash.subscribe", &status, sh); err != nil || status != nil {
+		t.Fatalf("subscribe: expect null status, got %v %v", status, err)
+	}
+	if err := c.call("blockchain.headers.subscribe", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed, nothing is sent before the ping response
+	if err := s.Notify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call("server.ping", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n.coinbaseScript = other
+	block := n.mine()
+	if err := s.Notify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var header struct {
+		Method string               `json:"method"`
+		Params []HeaderNotification `json:"params"`
+	}
+	c.read(&header)
+	if header.Method != "blockchain.headers.subscribe" || len(header.Params) != 1 ||
+		header.Params[0].Height != 1 || header.Params[0].Hex != hex.EncodeToString(block.Header.Bytes()) {
+		t.Fatalf("headers notification: unexpected %+v", header)
+	}
+
+	var scripthash struct {
+		Method string   `json:"method"`
+		Params []string `json:"params"`
+	}
+	c.read(&scripthash)
+	if scripthash.Method != "blockchain.scripthash.subscribe" || len(scripthash.Params) != 2 || scripthash.Params[0] != sh {
+		t.Fatalf("scripthash notification: unexpected %+v", scripthash)
+	}
+
+	var current string
+	if err := c.call("blockchain.scripthash.subscribe", &current, sh); err != nil || current != scripthash.Params[1] {
+		t.Fatalf("subscribe: expect status %s, got %s %v", scripthash.Params[1], current, err)
+	}
+}
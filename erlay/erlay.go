@@ -0,0 +1,273 @@
+// Package erlay implements the transaction reconciliation of BIP330: instead of
+// announcing each transaction to a peer, both peers periodically exchange sketches
+// of the short IDs of their pending announcements and only announce the difference.
+package erlay
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/detailyang/go-bcore/internal/minisketch"
+	"github.com/detailyang/go-bcore/internal/siphash"
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrUnexpectedMessage = errors.New("erlay: unexpected reconciliation message")
+	ErrBadSketch         = errors.New("erlay: bad sketch")
+)
+
+const (
+	// DefaultQ is the initial coefficient of the estimate of the set difference
+	DefaultQ = 0.25
+	// qScale scales q in reqrecon messages
+	qScale = 32767
+	// maxQ is the largest q a reqrecon message carries
+	maxQ = math.MaxUint16 / float64(qScale)
+	// MaxSetSize is the maximal number of transactions waiting for a reconciliation round
+	MaxSetSize = 3000
+)
+
+// ShortIDKeys returns the SipHash keys of the short transaction IDs of a connection,
+// from the tagged hash of both salts in increasing order
+func ShortIDKeys(salt1, salt2 uint64) (uint64, uint64) {
+	if salt1 > salt2 {
+		salt1, salt2 = salt2, salt1
+	}
+
+	tag := sha256.Sum256([]byte("Tx Relay Salting"))
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	binary.Write(h, binary.LittleEndian, salt1)
+	binary.Write(h, binary.LittleEndian, salt2)
+	sum := h.Sum(nil)
+
+	return binary.LittleEndian.Uint64(sum), binary.LittleEndian.Uint64(sum[8:])
+}
+
+// ShortID returns the 32 bits short ID of wtxid, never zero
+func ShortID(k0, k1 uint64, wtxid Hash) uint32 {
+	return 1 + uint32(siphash.Sum64(k0, k1, wtxid[:])%0xffffffff)
+}
+
+// Capacity returns the capacity of the sketch of a reconciliation of sets of sizes
+// local and remote, the estimate of their difference: |local - remote| + q*min(local, remote) + 1
+func Capacity(local, remote int, q float64) int {
+	diff, min := local-remote, remote
+	if diff < 0 {
+		diff, min = -diff, local
+	}
+
+	capacity := diff + int(math.Ceil(q*float64(min))) + 1
+	if capacity > wire.MaxSketchCapacity {
+		capacity = wire.MaxSketchCapacity
+	}
+	return capacity
+}
+
+// Reconciler keeps the transactions to announce to one peer until the next
+// reconciliation round, and runs the rounds: the initiator, the peer which opened the
+// connection, sends reqrecon with the size of its set, the responder answers with
+// the sketch of its set, from which the initiator decodes the difference of both
+// sets. It announces the transactions the responder lacks, and asks in reconcildiff
+// for those it lacks. When decoding fails, both peers announce their whole set.
+// A Reconciler is not safe for concurrent use.
+type Reconciler struct {
+	initiator bool
+	k0, k1    uint64
+	q         float64
+
+	// Transactions to announce, by short ID
+	set map[uint32]Hash
+	// Set of the round in progress, nil between rounds
+	round map[uint32]Hash
+}
+
+// NewReconciler returns the reconciler of a connection, initiating rounds when we
+// opened it, with the salts of both peers
+func NewReconciler(initiator bool, salt, remoteSalt uint64) *Reconciler {
+	k0, k1 := ShortIDKeys(salt, remoteSalt)
+	return &Reconciler{
+		initiator: initiator,
+		k0:        k0,
+		k1:        k1,
+		q:         DefaultQ,
+		set:       make(map[uint32]Hash),
+	}
+}
+
+// ShortID returns the short ID of wtxid on the connection
+func (r *Reconciler) ShortID(wtxid Hash) uint32 {
+	return ShortID(r.k0, r.k1, wtxid)
+}
+
+// Add queues wtxid for the next round, false when the set is full or has its short ID
+// already, the transaction then being announced with an inv
+func (r *Reconciler) Add(wtxid Hash) bool {
+	id := r.ShortID(wtxid)
+	if _, ok := r.set[id]; ok || len(r.set) >= MaxSetSize {
+		return false
+	}
+
+	r.set[id] = wtxid
+	return true
+}
+
+// Remove forgets wtxid, announced by the peer or no longer relayed
+func (r *Reconciler) Remove(wtxid Hash) {
+	id := r.ShortID(wtxid)
+	if r.set[id] == wtxid {
+		delete(r.set, id)
+	}
+}
+
+// Len returns the number of transactions queued for reconciliation
+func (r *Reconciler) Len() int { return len(r.set) }
+
+// Q returns the coefficient of the estimate of the set difference
+func (r *Reconciler) Q() float64 { return r.q }
+
+// startRound snapshots the set, later transactions waiting for the next round
+func (r *Reconciler) startRound() {
+	r.round = make(map[uint32]Hash, len(r.set))
+	for id, wtxid := range r.set {
+		r.round[id] = wtxid
+	}
+}
+
+// endRound removes the set of the round, announced or known by the peer
+func (r *Reconciler) endRound() {
+	for id := range r.round {
+		delete(r.set, id)
+	}
+	r.round = nil
+}
+
+func (r *Reconciler) sketch(capacity int) *minisketch.Sketch {
+	s := minisketch.New(capacity)
+	for id := range r.round {
+		s.Add(id)
+	}
+	return s
+}
+
+// roundWtxids returns the transactions of the round
+func (r *Reconciler) roundWtxids() []Hash {
+	wtxids := make([]Hash, 0, len(r.round))
+	for _, wtxid := range r.round {
+		wtxids = append(wtxids, wtxid)
+	}
+	return wtxids
+}
+
+// RequestReconciliation starts a round as initiator
+func (r *Reconciler) RequestReconciliation() (*wire.MsgReqRecon, error) {
+	if !r.initiator || r.round != nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	r.startRound()
+	return &wire.MsgReqRecon{
+		SetSize: uint16(len(r.round)),
+		Q:       uint16(r.q * qScale),
+	}, nil
+}
+
+// HandleReqRecon answers a round as responder with the sketch of our set
+func (r *Reconciler) HandleReqRecon(m *wire.MsgReqRecon) (*wire.MsgSketch, error) {
+	if r.initiator || r.round != nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	r.startRound()
+	capacity := Capacity(len(r.round), int(m.SetSize), float64(m.Q)/qScale)
+	return &wire.MsgSketch{Data: r.sketch(capacity).Bytes()}, nil
+}
+
+// HandleSketch decodes the difference of the sets as initiator, returning the
+// reconcildiff answer and the transactions to announce to the peer
+func (r *Reconciler) HandleSketch(m *wire.MsgSketch) (*wire.MsgReconcilDiff, []Hash, error) {
+	if !r.initiator || r.round == nil {
+		return nil, nil, ErrUnexpectedMessage
+	}
+
+	remote, err := minisketch.NewSketchFromBytes(m.Data)
+	if err != nil || remote.Capacity() > wire.MaxSketchCapacity {
+		return nil, nil, ErrBadSketch
+	}
+
+	var diff []uint32
+	if remote.Capacity() > 0 {
+		local := r.sketch(remote.Capacity())
+		local.Merge(remote)
+		diff, err = local.Decode(remote.Capacity())
+	}
+
+	// An empty sketch, or too many differences, falls back to announcing everything
+	if remote.Capacity() == 0 || err != nil {
+		announce := r.roundWtxids()
+		r.endRound()
+		return &wire.MsgReconcilDiff{Success: false}, announce, nil
+	}
+
+	var announce []Hash
+	var ask []uint32
+	for _, id := range diff {
+		if wtxid, ok := r.round[id]; ok {
+			announce = append(announce, wtxid)
+		} else {
+			ask = append(ask, id)
+		}
+	}
+
+	r.updateQ(len(r.round), len(r.round)-len(announce)+len(ask), len(diff))
+	r.endRound()
+	return &wire.MsgReconcilDiff{Success: true, AskShortIDs: ask}, announce, nil
+}
+
+// updateQ sets q to the one which estimated the actual difference of a round
+func (r *Reconciler) updateQ(local, remote, diff int) {
+	min, sizeDiff := local, local-remote
+	if remote < min {
+		min = remote
+	}
+	if sizeDiff < 0 {
+		sizeDiff = -sizeDiff
+	}
+	if min == 0 {
+		return
+	}
+
+	r.q = float64(diff-sizeDiff) / float64(min)
+	if r.q > maxQ {
+		r.q = maxQ
+	}
+}
+
+// HandleReconcilDiff ends a round as responder, returning the transactions to
+// announce to the peer: those asked for, or our whole set on failure
+func (r *Reconciler) HandleReconcilDiff(m *wire.MsgReconcilDiff) ([]Hash, error) {
+	if r.initiator || r.round == nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	if !m.Success {
+		announce := r.roundWtxids()
+		r.endRound()
+		return announce, nil
+	}
+
+	var announce []Hash
+	for _, id := range m.AskShortIDs {
+		if wtxid, ok := r.round[id]; ok {
+			announce = append(announce, wtxid)
+		}
+	}
+
+	r.endRound()
+	return announce, nil
+}
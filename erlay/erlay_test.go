@@ -0,0 +1,285 @@
+package erlay
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/peer"
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestShortID(t *testing.T) {
+	k0, k1 := ShortIDKeys(1, 2)
+	if k0b, k1b := ShortIDKeys(2, 1); k0 != k0b || k1 != k1b {
+		t.Fatal("short id keys depend on the salts order")
+	}
+	if k0b, _ := ShortIDKeys(1, 3); k0 == k0b {
+		t.Fatal("short id keys do not depend on the salts")
+	}
+
+	for i := 0; i < 1000; i++ {
+		if ShortID(k0, k1, Hash{byte(i), byte(i >> 8)}) == 0 {
+			t.Fatal("zero short id")
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		local, remote int
+		q             float64
+		capacity      int
+	}{
+		{0, 0, DefaultQ, 1},
+		{10, 0, DefaultQ, 11},
+		{10, 20, DefaultQ, 14},
+		{100, 100, 0.1, 11},
+		{100000, 0, DefaultQ, wire.MaxSketchCapacity},
+	}
+
+	for _, test := range tests {
+		if capacity := Capacity(test.local, test.remote, test.q); capacity != test.capacity {
+			t.Fatalf("%+v: got %d", test, capacity)
+		}
+	}
+}
+
+// roundtrip encodes and decodes msg as on the wire
+func roundtrip(t *testing.T, msg wire.Message) wire.Message {
+	decoded, err := wire.NewMessageFromBytes(msg.Command(), msg.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func sortedHashes(hashes []Hash) []Hash {
+	s := append([]Hash{}, hashes...)
+	sort.Slice(s, func(i, j int) bool { return string(s[i][:]) < string(s[j][:]) })
+	return s
+}
+
+func expectHashes(t *testing.T, what string, got, expect []Hash) {
+	got, expect = sortedHashes(got), sortedHashes(expect)
+	if len(got) != len(expect) {
+		t.Fatalf("%s: expect %d transactions, got %d", what, len(expect), len(got))
+	}
+	for i := range got {
+		if got[i] != expect[i] {
+			t.Fatalf("%s: unexpected transactions", what)
+		}
+	}
+}
+
+// newTestSets fills an initiator and a responder with common transactions and their own
+func newTestSets(common, onlyInitiator, onlyResponder int) (*Reconciler, *Reconciler, []Hash, []Hash) {
+	initiator, responder := NewReconciler(true, 7, 9), NewReconciler(false, 9, 7)
+
+	n := 0
+	next := func() Hash {
+		n++
+		return DHash256([]byte{byte(n), byte(n >> 8)})
+	}
+
+	for i := 0; i < common; i++ {
+		wtxid := next()
+		initiator.Add(wtxid)
+		responder.Add(wtxid)
+	}
+
+	var initiatorOnly, responderOnly []Hash
+	for i := 0; i < onlyInitiator; i++ {
+		wtxid := next()
+		initiator.Add(wtxid)
+		initiatorOnly = append(initiatorOnly, wtxid)
+	}
+	for i := 0; i < onlyResponder; i++ {
+		wtxid := next()
+		responder.Add(wtxid)
+		responderOnly = append(responderOnly, wtxid)
+	}
+
+	return initiator, responder, initiatorOnly, responderOnly
+}
+
+func TestReconciliation(t *testing.T) {
+	initiator, responder, initiatorOnly, responderOnly := newTestSets(200, 5, 8)
+
+	req, err := initiator.RequestReconciliation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.SetSize != 205 {
+		t.Fatalf("expect set size 205, got %d", req.SetSize)
+	}
+
+	// Transactions added during the round wait for the next one
+	late := Hash{0xff}
+	initiator.Add(late)
+
+	sketch, err := responder.HandleReqRecon(roundtrip(t, req).(*wire.MsgReqRecon))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, announce, err := initiator.HandleSketch(roundtrip(t, sketch).(*wire.MsgSketch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Success || len(diff.AskShortIDs) != len(responderOnly) {
+		t.Fatalf("unexpected reconcildiff %+v", diff)
+	}
+	expectHashes(t, "initiator", announce, initiatorOnly)
+
+	announce, err = responder.HandleReconcilDiff(roundtrip(t, diff).(*wire.MsgReconcilDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, "responder", announce, responderOnly)
+
+	if initiator.Len() != 1 || responder.Len() != 0 {
+		t.Fatalf("expect sets of 1 and 0, got %d and %d", initiator.Len(), responder.Len())
+	}
+
+	// 13 differences for sets of 205 and 208: q = (13 - 3) / 205
+	if q := initiator.Q(); q != 10.0/205 {
+		t.Fatalf("unexpected q %f", q)
+	}
+}
+
+func TestReconciliationFailure(t *testing.T) {
+	// Disjoint sets of the same size: a capacity of 11 for 80 differences
+	initiator, responder, initiatorOnly, responderOnly := newTestSets(0, 40, 40)
+
+	req, err := initiator.RequestReconciliation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sketch, err := responder.HandleReqRecon(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, announce, err := initiator.HandleSketch(sketch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Success {
+		t.Fatal("unexpected success")
+	}
+	expectHashes(t, "initiator", announce, initiatorOnly)
+
+	announce, err = responder.HandleReconcilDiff(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, "responder", announce, responderOnly)
+}
+
+func TestReconciliationUnexpected(t *testing.T) {
+	initiator, responder, _, _ := newTestSets(1, 1, 1)
+
+	if _, err := responder.RequestReconciliation(); err != ErrUnexpectedMessage {
+		t.Fatalf("expect %v, got %v", ErrUnexpectedMessage, err)
+	}
+	if _, _, err := initiator.HandleSketch(&wire.MsgSketch{}); err != ErrUnexpectedMessage {
+		t.Fatalf("expect %v, got %v", ErrUnexpectedMessage, err)
+	}
+	if _, err := responder.HandleReconcilDiff(&wire.MsgReconcilDiff{}); err != ErrUnexpectedMessage {
+		t.Fatalf("expect %v, got %v", ErrUnexpectedMessage, err)
+	}
+	if _, err := initiator.HandleReqRecon(&wire.MsgReqRecon{}); err != ErrUnexpectedMessage {
+		t.Fatalf("expect %v, got %v", ErrUnexpectedMessage, err)
+	}
+
+	if _, err := initiator.RequestReconciliation(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initiator.RequestReconciliation(); err != ErrUnexpectedMessage {
+		t.Fatalf("expect %v, got %v", ErrUnexpectedMessage, err)
+	}
+	if _, _, err := initiator.HandleSketch(&wire.MsgSketch{Data: []byte{1, 2, 3}}); err != ErrBadSketch {
+		t.Fatalf("expect %v, got %v", ErrBadSketch, err)
+	}
+}
+
+// receive returns the next message of p of type T
+func receive[T wire.Message](t *testing.T, p *peer.Peer) T {
+	select {
+	case msg := <-p.Messages():
+		m, ok := msg.(T)
+		if !ok {
+			t.Fatalf("unexpected message %T", msg)
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	panic("unreachable")
+}
+
+func TestReconciliationPeers(t *testing.T) {
+	a, b := net.Pipe()
+	cfg := &peer.Config{Params: bcore.RegTestParams, Relay: true, TxReconciliation: true}
+	out, in := peer.NewOutboundPeer(a, cfg), peer.NewInboundPeer(b, cfg)
+	defer out.Disconnect()
+	defer in.Disconnect()
+
+	errs := make(chan error, 2)
+	go func() { errs <- out.Start() }()
+	go func() { errs <- in.Start() }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	salt, remoteSalt, ok := out.TxReconciliation()
+	if !ok {
+		t.Fatal("transaction reconciliation not negotiated")
+	}
+	initiator := NewReconciler(true, salt, remoteSalt)
+	salt, remoteSalt, _ = in.TxReconciliation()
+	responder := NewReconciler(false, salt, remoteSalt)
+
+	common, mine, theirs := Hash{1}, Hash{2}, Hash{3}
+	initiator.Add(common)
+	initiator.Add(mine)
+	responder.Add(common)
+	responder.Add(theirs)
+
+	req, err := initiator.RequestReconciliation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Send(req); err != nil {
+		t.Fatal(err)
+	}
+
+	sketch, err := responder.HandleReqRecon(receive[*wire.MsgReqRecon](t, in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Send(sketch); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, announce, err := initiator.HandleSketch(receive[*wire.MsgSketch](t, out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, "initiator", announce, []Hash{mine})
+	if err := out.Send(diff); err != nil {
+		t.Fatal(err)
+	}
+
+	announce, err = responder.HandleReconcilDiff(receive[*wire.MsgReconcilDiff](t, in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, "responder", announce, []Hash{theirs})
+}
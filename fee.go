@@ -0,0 +1,174 @@
+package bcore
+
+import (
+	"errors"
+)
+
+var (
+	ErrPrevoutMissing = errors.New("prevout: missing")
+	ErrFeeNegative    = errors.New("fee: outputs exceed inputs")
+	ErrFeeCoinbase    = errors.New("fee: coinbase has no inputs")
+	ErrFeeOverflow    = errors.New("fee: value out of range")
+)
+
+// PrevoutFetcher returns the outputs spent by transaction inputs
+type PrevoutFetcher interface {
+	// FetchPrevout returns ErrPrevoutMissing if the output is unknown
+	FetchPrevout(op *OutPoint) (*TransactionOutput, error)
+}
+
+// MapPrevoutFetcher is a PrevoutFetcher backed by a map
+type MapPrevoutFetcher map[OutPoint]*TransactionOutput
+
+func NewMapPrevoutFetcher() MapPrevoutFetcher {
+	return make(MapPrevoutFetcher)
+}
+
+func (m MapPrevoutFetcher) Add(op *OutPoint, output *TransactionOutput) {
+	m[*op] = output
+}
+
+// AddTransaction makes every output of tx available
+func (m MapPrevoutFetcher) AddTransaction(tx *Transaction) {
+	txid := tx.Hash()
+	for i, output := range tx.Outputs {
+		m[OutPoint{Hash: txid, Index: uint32(i)}] = output
+	}
+}
+
+func (m MapPrevoutFetcher) FetchPrevout(op *OutPoint) (*TransactionOutput, error) {
+	output, ok := m[*op]
+	if !ok {
+		return nil, ErrPrevoutMissing
+	}
+	return output, nil
+}
+
+func (s *UtxoSet) FetchPrevout(op *OutPoint) (*TransactionOutput, error) {
+	e, ok := s.Get(op)
+	if !ok {
+		return nil, ErrPrevoutMissing
+	}
+	return e.Output, nil
+}
+
+func (c *UtxoCache) FetchPrevout(op *OutPoint) (*TransactionOutput, error) {
+	e, err := c.Get(op)
+	if err == ErrUtxoMissing {
+		return nil, ErrPrevoutMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Output, nil
+}
+
+// InputValue returns the sum of the outputs spent by the transaction
+func (t *Transaction) InputValue(fetcher PrevoutFetcher) (uint64, error) {
+	if t.IsCoinbase() {
+		return 0, ErrFeeCoinbase
+	}
+
+	sum := uint64(0)
+	for _, input := range t.Inputs {
+		output, err := fetcher.FetchPrevout(input.PrevOutput)
+		if err != nil {
+			return 0, err
+		}
+
+		if output.Value > MaxMoney || sum+output.Value > MaxMoney {
+			return 0, ErrFeeOverflow
+		}
+		sum += output.Value
+	}
+
+	return sum, nil
+}
+
+// OutputValue returns the sum of the transaction outputs, failing when an output or
+// the sum exceeds MaxMoney
+func (t *Transaction) OutputValue() (uint64, error) {
+	sum := uint64(0)
+	for _, output := range t.Outputs {
+		if output.Value > MaxMoney || sum+output.Value > MaxMoney {
+			return 0, ErrFeeOverflow
+		}
+		sum += output.Value
+	}
+
+	return sum, nil
+}
+
+// Fee returns the input value minus the output value of the transaction
+func (t *Transaction) Fee(fetcher PrevoutFetcher) (uint64, error) {
+	in, err := t.InputValue(fetcher)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := t.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	if out > in {
+		return 0, ErrFeeNegative
+	}
+
+	return in - out, nil
+}
+
+// FeeRate returns the fee paid per virtual byte, in sat/vB
+func (t *Transaction) FeeRate(fetcher PrevoutFetcher) (float64, error) {
+	fee, err := t.Fee(fetcher)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(fee) / float64(t.VirtualSize()), nil
+}
+
+// blockPrevoutFetcher resolves outputs created earlier in the same block before asking its parent
+type blockPrevoutFetcher struct {
+	parent  PrevoutFetcher
+	outputs MapPrevoutFetcher
+}
+
+func (f *blockPrevoutFetcher) FetchPrevout(op *OutPoint) (*TransactionOutput, error) {
+	if output, err := f.outputs.FetchPrevout(op); err == nil {
+		return output, nil
+	}
+
+	return f.parent.FetchPrevout(op)
+}
+
+// Fees returns the sum of the fees of the non-coinbase transactions of the block,
+// failing when it exceeds MaxMoney. fetcher provides the outputs spent from previous
+// blocks, typically the UTXO set before connecting the block.
+func (b *Block) Fees(fetcher PrevoutFetcher) (uint64, error) {
+	f := &blockPrevoutFetcher{
+		parent:  fetcher,
+		outputs: NewMapPrevoutFetcher(),
+	}
+
+	sum := uint64(0)
+	for i, tx := range b.Transactions {
+		if i > 0 {
+			fee, err := tx.Fee(f)
+			if err != nil {
+				return 0, err
+			}
+
+			if sum+fee > MaxMoney {
+				return 0, ErrFeeOverflow
+			}
+			sum += fee
+		}
+
+		f.outputs.AddTransaction(tx)
+	}
+
+	return sum, nil
+}
@@ -0,0 +1,121 @@
+package bcore
+
+import (
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestTransactionFee(t *testing.T) {
+	// native P2WPKH example of BIP143
+	b := "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	tx, err := NewTransactionWitnessFromHexString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := NewMapPrevoutFetcher()
+	fetcher.Add(tx.Inputs[0].PrevOutput, &TransactionOutput{Value: 625000000})
+
+	if _, err := tx.Fee(fetcher); err != ErrPrevoutMissing {
+		t.Fatalf("expect missing prevout, got %v", err)
+	}
+
+	fetcher.Add(tx.Inputs[1].PrevOutput, &TransactionOutput{Value: 600000000})
+
+	in, err := tx.InputValue(fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if in != 1225000000 {
+		t.Fatalf("input value: expect 1225000000, got %d", in)
+	}
+
+	fee, err := tx.Fee(fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fee != 1225000000-112340000-223450000 {
+		t.Fatalf("fee: got %d", fee)
+	}
+
+	rate, err := tx.FeeRate(fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rate != float64(fee)/261 {
+		t.Fatalf("fee rate: got %f", rate)
+	}
+
+	fetcher.Add(tx.Inputs[0].PrevOutput, &TransactionOutput{Value: 1})
+	fetcher.Add(tx.Inputs[1].PrevOutput, &TransactionOutput{Value: 1})
+	if _, err := tx.Fee(fetcher); err != ErrFeeNegative {
+		t.Fatalf("expect negative fee, got %v", err)
+	}
+
+	fetcher.Add(tx.Inputs[1].PrevOutput, &TransactionOutput{Value: MaxMoney})
+	if _, err := tx.Fee(fetcher); err != ErrFeeOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+
+	// Outputs of 2^63 + 2^63 + 1000 sat would wrap to a 1000 sat total
+	fetcher.Add(tx.Inputs[1].PrevOutput, &TransactionOutput{Value: 1})
+	tx.Outputs = []*TransactionOutput{{Value: 1 << 63}, {Value: 1 << 63}, {Value: 1000}}
+	if _, err := tx.Fee(fetcher); err != ErrFeeOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+
+	tx.Outputs = []*TransactionOutput{{Value: MaxMoney}, {Value: 1}}
+	if _, err := tx.OutputValue(); err != ErrFeeOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+
+	if _, err := NewCoinbaseTransaction(1, nil, nil).Fee(fetcher); err != ErrFeeCoinbase {
+		t.Fatalf("expect coinbase error, got %v", err)
+	}
+}
+
+func TestBlockFees(t *testing.T) {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestChain(t, set, 101)
+
+	spend := newTestTransaction([]*OutPoint{NewOutPoint(blocks[0].Transactions[0].Hash(), 0)}, 49*Coin)
+	child := newTestTransaction([]*OutPoint{NewOutPoint(spend.Hash(), 0)}, 47*Coin)
+	block := newTestBlock(blocks[100].Hash(), 102, spend, child)
+
+	fees, err := block.Fees(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fees != 3*Coin {
+		t.Fatalf("fees: expect %d, got %d", 3*Coin, fees)
+	}
+
+	if _, err := set.ConnectBlock(block, 102); err != nil {
+		t.Fatal(err)
+	}
+
+	// once connected the spent outputs are gone from the set
+	if _, err := block.Fees(set); err != ErrPrevoutMissing {
+		t.Fatalf("expect missing prevout, got %v", err)
+	}
+
+	orphan := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, Coin)
+	if _, err := newTestBlock(block.Hash(), 103, orphan).Fees(set); err != ErrPrevoutMissing {
+		t.Fatalf("expect missing prevout, got %v", err)
+	}
+
+	// Fees within range each may add up beyond MaxMoney
+	fetcher := NewMapPrevoutFetcher()
+	fetcher.Add(NewOutPoint(Hash{1}, 0), &TransactionOutput{Value: MaxMoney})
+	fetcher.Add(NewOutPoint(Hash{2}, 0), &TransactionOutput{Value: MaxMoney})
+	a := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, 0)
+	b := newTestTransaction([]*OutPoint{NewOutPoint(Hash{2}, 0)}, Coin)
+	if _, err := newTestBlock(block.Hash(), 103, a, b).Fees(fetcher); err != ErrFeeOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+}
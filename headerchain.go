@@ -0,0 +1,295 @@
+package bcore
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrHeaderOrphan      = errors.New("headerchain: unknown previous block")
+	ErrHeaderBadBits     = errors.New("headerchain: unexpected difficulty bits")
+	ErrHeaderTimeTooOld  = errors.New("headerchain: time not after median time past")
+	ErrHeaderTimeTooNew  = errors.New("headerchain: time too far in the future")
+	ErrHeaderNotContinue = errors.New("headerchain: headers do not connect")
+	ErrHeaderBadVersion  = errors.New("headerchain: obsolete block version")
+)
+
+const (
+	// MaxFutureBlockTime is how far in the future a header time may be, in seconds
+	MaxFutureBlockTime = 2 * 60 * 60
+	// MedianTimeSpan is the number of blocks the median time past is computed over
+	MedianTimeSpan = 11
+)
+
+// HeaderNode is a header of the chain tree with its height and cumulative work
+type HeaderNode struct {
+	Header *BlockHeader
+	Hash   Hash
+	Height uint32
+	// Total work of the chain ending at this header
+	Work   *big.Int
+	Parent *HeaderNode
+}
+
+// Ancestor returns the ancestor of n at height, nil if height is above n
+func (n *HeaderNode) Ancestor(height uint32) *HeaderNode {
+	if height > n.Height {
+		return nil
+	}
+
+	for n != nil && n.Height > height {
+		n = n.Parent
+	}
+	return n
+}
+
+// MedianTimePast returns the median time of the last MedianTimeSpan blocks ending at n
+func (n *HeaderNode) MedianTimePast() uint32 {
+	times := make([]uint32, 0, MedianTimeSpan)
+	for i := 0; i < MedianTimeSpan && n != nil; i++ {
+		times = append(times, n.Header.Time)
+		n = n.Parent
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// HeaderChain is an in-memory tree of validated block headers rooted at the genesis
+// block, following the branch with the most work as the active chain.
+type HeaderChain struct {
+	params *ChainParams
+	// Returns the current time, headers may not be too far past it
+	now func() time.Time
+
+	mu     sync.RWMutex
+	nodes  map[Hash]*HeaderNode
+	active []*HeaderNode
+}
+
+func NewHeaderChain(params *ChainParams) *HeaderChain {
+	genesis := &HeaderNode{
+		Header: params.GenesisHeader,
+		Hash:   params.GenesisHeader.Hash(),
+		Work:   CalcWork(params.GenesisHeader.Bits),
+	}
+
+	return &HeaderChain{
+		params: params,
+		now:    time.Now,
+		nodes:  map[Hash]*HeaderNode{genesis.Hash: genesis},
+		active: []*HeaderNode{genesis},
+	}
+}
+
+func (c *HeaderChain) Params() *ChainParams { return c.params }
+
+// Tip returns the last header of the active chain
+func (c *HeaderChain) Tip() *HeaderNode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active[len(c.active)-1]
+}
+
+func (c *HeaderChain) Height() uint32 {
+	return c.Tip().Height
+}
+
+// Get returns the node of any known header, active or not
+func (c *HeaderChain) Get(hash Hash) (*HeaderNode, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n, ok := c.nodes[hash]
+	return n, ok
+}
+
+// AtHeight returns the header of the active chain at height
+func (c *HeaderChain) AtHeight(height uint32) (*HeaderNode, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if int(height) >= len(c.active) {
+		return nil, false
+	}
+	return c.active[height], true
+}
+
+// Contains reports whether n is part of the active chain
+func (c *HeaderChain) Contains(n *HeaderNode) bool {
+	a, ok := c.AtHeight(n.Height)
+	return ok && a == n
+}
+
+// Locator returns the block locator of the active chain
+func (c *HeaderChain) Locator() []Hash {
+	return BlockLocator(c.Tip())
+}
+
+// BlockLocator returns hashes from n back to the genesis block, the ten most recent
+// ones then exponentially fewer, like Bitcoin Core does.
+func BlockLocator(n *HeaderNode) []Hash {
+	var locator []Hash
+	step := uint32(1)
+	for n != nil {
+		locator = append(locator, n.Hash)
+		if n.Height == 0 {
+			break
+		}
+
+		height := uint32(0)
+		if n.Height > step {
+			height = n.Height - step
+		}
+		n = n.Ancestor(height)
+
+		if len(locator) > 10 {
+			step *= 2
+		}
+	}
+	return locator
+}
+
+// FindFork returns the last header of the active chain found in locator, the genesis block if none
+func (c *HeaderChain) FindFork(locator []Hash) *HeaderNode {
+	for _, hash := range locator {
+		if n, ok := c.Get(hash); ok && c.Contains(n) {
+			return n
+		}
+	}
+
+	n, _ := c.AtHeight(0)
+	return n
+}
+
+// nextBits returns the bits required for the header following parent at time
+func (c *HeaderChain) nextBits(parent *HeaderNode, time uint32) Compact {
+	params := c.params
+	interval := params.DifficultyAdjustmentInterval()
+
+	if params.PowNoRetargeting {
+		return parent.Header.Bits
+	}
+
+	if (parent.Height+1)%interval != 0 {
+		if !params.PowAllowMinDifficultyBlocks {
+			return parent.Header.Bits
+		}
+
+		// A block twice the spacing after its parent may be mined at the pow limit
+		if time > parent.Header.Time+params.PowTargetSpacing*2 {
+			return params.PowLimit
+		}
+
+		// Otherwise it uses the bits of the last block not mined at the pow limit
+		n := parent
+		for n.Parent != nil && n.Height%interval != 0 && n.Header.Bits == params.PowLimit {
+			n = n.Parent
+		}
+		return n.Header.Bits
+	}
+
+	first := parent.Ancestor(parent.Height + 1 - interval)
+	return CalcRetarget(parent.Header.Bits, first.Header.Time, parent.Header.Time, params)
+}
+
+// checkHeader validates header as the child of parent
+func (c *HeaderChain) checkHeader(header *BlockHeader, hash Hash, parent *HeaderNode) error {
+	if err := CheckProofOfWork(hash, header.Bits, c.params); err != nil {
+		return err
+	}
+
+	if header.Bits != c.nextBits(parent, header.Time) {
+		return ErrHeaderBadBits
+	}
+
+	if header.Time <= parent.MedianTimePast() {
+		return ErrHeaderTimeTooOld
+	}
+
+	if int64(header.Time) > c.now().Unix()+MaxFutureBlockTime {
+		return ErrHeaderTimeTooNew
+	}
+
+	// Each of BIP34, BIP66 and BIP65 obsoletes the versions below its own once active
+	version, height := int32(header.Version), parent.Height+1
+	if (version < 2 && height >= c.params.BIP34Height) ||
+		(version < 3 && height >= c.params.BIP66Height) ||
+		(version < 4 && height >= c.params.BIP65Height) {
+		return ErrHeaderBadVersion
+	}
+
+	return nil
+}
+
+// AddHeader validates and adds a header whose parent is known. The active chain
+// switches to its branch when it has more work. Known headers are returned as is.
+func (c *HeaderChain) AddHeader(header *BlockHeader) (*HeaderNode, error) {
+	hash := header.Hash()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, ok := c.nodes[hash]; ok {
+		return n, nil
+	}
+
+	parent, ok := c.nodes[header.PrevHash]
+	if !ok {
+		return nil, ErrHeaderOrphan
+	}
+
+	if err := c.checkHeader(header, hash, parent); err != nil {
+		return nil, err
+	}
+
+	n := &HeaderNode{
+		Header: header,
+		Hash:   hash,
+		Height: parent.Height + 1,
+		Work:   new(big.Int).Add(parent.Work, CalcWork(header.Bits)),
+		Parent: parent,
+	}
+	c.nodes[hash] = n
+
+	if n.Work.Cmp(c.active[len(c.active)-1].Work) > 0 {
+		c.setTip(n)
+	}
+
+	return n, nil
+}
+
+// setTip makes n the tip of the active chain
+func (c *HeaderChain) setTip(n *HeaderNode) {
+	if int(n.Height) < len(c.active) {
+		c.active = c.active[:n.Height+1]
+	} else {
+		c.active = append(c.active, make([]*HeaderNode, int(n.Height)+1-len(c.active))...)
+	}
+
+	for ; n != nil && c.active[n.Height] != n; n = n.Parent {
+		c.active[n.Height] = n
+	}
+}
+
+// AddHeaders adds headers which must each follow the previous one, as in a headers message.
+// It returns the node of the last header and stops at the first invalid one.
+func (c *HeaderChain) AddHeaders(headers []*BlockHeader) (*HeaderNode, error) {
+	var last *HeaderNode
+	for i, header := range headers {
+		if i > 0 && header.PrevHash != headers[i-1].Hash() {
+			return last, ErrHeaderNotContinue
+		}
+
+		n, err := c.AddHeader(header)
+		if err != nil {
+			return last, err
+		}
+		last = n
+	}
+
+	return last, nil
+}
@@ -0,0 +1,247 @@
+package bcore
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// mineTestHeader returns a header following parent that satisfies bits
+func mineTestHeader(parent *BlockHeader, t uint32, bits Compact, params *ChainParams) *BlockHeader {
+	header := &BlockHeader{
+		Version:  4,
+		PrevHash: parent.Hash(),
+		Time:     t,
+		Bits:     bits,
+	}
+	for CheckProofOfWork(header.Hash(), bits, params) != nil {
+		header.Nonce++
+	}
+	return header
+}
+
+// newTestHeaders mines n headers after parent, spaced by spacing seconds
+func newTestHeaders(parent *BlockHeader, n int, spacing uint32, params *ChainParams) []*BlockHeader {
+	headers := make([]*BlockHeader, 0, n)
+	for i := 0; i < n; i++ {
+		parent = mineTestHeader(parent, parent.Time+spacing, parent.Bits, params)
+		headers = append(headers, parent)
+	}
+	return headers
+}
+
+func TestHeaderChain(t *testing.T) {
+	chain := NewHeaderChain(RegTestParams)
+	chain.now = func() time.Time { return time.Unix(1296688602+100000, 0) }
+
+	headers := newTestHeaders(RegTestParams.GenesisHeader, 30, 600, RegTestParams)
+	tip, err := chain.AddHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip.Height != 30 || chain.Tip() != tip || chain.Height() != 30 {
+		t.Fatalf("tip: unexpected height %d", tip.Height)
+	}
+
+	for i, header := range headers {
+		n, ok := chain.AtHeight(uint32(i + 1))
+		if !ok || n.Hash != header.Hash() || !chain.Contains(n) {
+			t.Fatalf("height %d: unexpected header", i+1)
+		}
+	}
+
+	// Known headers are accepted again
+	if n, err := chain.AddHeader(headers[5]); err != nil || n.Height != 6 {
+		t.Fatalf("known header: %v %v", n, err)
+	}
+
+	locator := chain.Locator()
+	heights := []uint32{30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 17, 13, 5, 0}
+	if len(locator) != len(heights) {
+		t.Fatalf("locator: expect %d hashes, got %d", len(heights), len(locator))
+	}
+	for i, hash := range locator {
+		n, _ := chain.Get(hash)
+		if n.Height != heights[i] {
+			t.Fatalf("locator %d: expect height %d, got %d", i, heights[i], n.Height)
+		}
+	}
+
+	// A longer fork from height 20 becomes the active chain
+	fork := newTestHeaders(headers[19], 12, 601, RegTestParams)
+	if _, err := chain.AddHeaders(fork[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Tip() != tip {
+		t.Fatal("fork with as much work replaced the tip")
+	}
+
+	forkTip, err := chain.AddHeaders(fork[10:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.Tip() != forkTip || forkTip.Height != 32 || chain.Contains(tip) {
+		t.Fatal("fork with more work did not become the tip")
+	}
+
+	if n := chain.FindFork(BlockLocator(tip)); n.Hash != headers[19].Hash() {
+		t.Fatalf("fork point: expect height 20, got %d", n.Height)
+	}
+
+	if n := chain.FindFork([]Hash{{1}}); n.Height != 0 {
+		t.Fatalf("unknown locator: expect genesis, got %d", n.Height)
+	}
+
+	if mtp := forkTip.MedianTimePast(); mtp != fork[6].Time {
+		t.Fatalf("median time past: expect %d, got %d", fork[6].Time, mtp)
+	}
+}
+
+func TestHeaderChainErrors(t *testing.T) {
+	chain := NewHeaderChain(RegTestParams)
+	chain.now = func() time.Time { return time.Unix(1296688602+100000, 0) }
+
+	genesis := RegTestParams.GenesisHeader
+	headers := newTestHeaders(genesis, 11, 600, RegTestParams)
+	if _, err := chain.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	tip := headers[len(headers)-1]
+
+	orphan := mineTestHeader(&BlockHeader{Time: tip.Time}, tip.Time+600, genesis.Bits, RegTestParams)
+	if _, err := chain.AddHeader(orphan); err != ErrHeaderOrphan {
+		t.Fatalf("expect %v, got %v", ErrHeaderOrphan, err)
+	}
+
+	bits := mineTestHeader(tip, tip.Time+600, NewCompact(0x201fffff), RegTestParams)
+	if _, err := chain.AddHeader(bits); err != ErrHeaderBadBits {
+		t.Fatalf("expect %v, got %v", ErrHeaderBadBits, err)
+	}
+
+	old := mineTestHeader(tip, headers[5].Time, genesis.Bits, RegTestParams)
+	if _, err := chain.AddHeader(old); err != ErrHeaderTimeTooOld {
+		t.Fatalf("expect %v, got %v", ErrHeaderTimeTooOld, err)
+	}
+
+	future := mineTestHeader(tip, uint32(chain.now().Unix())+MaxFutureBlockTime+1, genesis.Bits, RegTestParams)
+	if _, err := chain.AddHeader(future); err != ErrHeaderTimeTooNew {
+		t.Fatalf("expect %v, got %v", ErrHeaderTimeTooNew, err)
+	}
+
+	pow := mineTestHeader(tip, tip.Time+600, genesis.Bits, RegTestParams)
+	for CheckProofOfWork(pow.Hash(), pow.Bits, RegTestParams) == nil {
+		pow.Nonce++
+	}
+	if _, err := chain.AddHeader(pow); err != ErrPowHighHash {
+		t.Fatalf("expect %v, got %v", ErrPowHighHash, err)
+	}
+
+	next := newTestHeaders(tip, 2, 600, RegTestParams)
+	if _, err := chain.AddHeaders([]*BlockHeader{next[1], next[0]}); err != ErrHeaderOrphan {
+		t.Fatalf("expect %v, got %v", ErrHeaderOrphan, err)
+	}
+	if _, err := chain.AddHeaders([]*BlockHeader{next[0], headers[3]}); err != ErrHeaderNotContinue {
+		t.Fatalf("expect %v, got %v", ErrHeaderNotContinue, err)
+	}
+}
+
+func TestHeaderChainVersion(t *testing.T) {
+	params := *RegTestParams
+	params.BIP34Height, params.BIP66Height, params.BIP65Height = 2, 3, 4
+
+	chain := NewHeaderChain(&params)
+	chain.now = func() time.Time { return time.Unix(1296688602+100000, 0) }
+
+	mine := func(parent *BlockHeader, version uint32) *BlockHeader {
+		header := &BlockHeader{Version: version, PrevHash: parent.Hash(), Time: parent.Time + 600, Bits: parent.Bits}
+		for CheckProofOfWork(header.Hash(), header.Bits, &params) != nil {
+			header.Nonce++
+		}
+		return header
+	}
+
+	// The version 1 header is accepted below BIP34, each next height obsoletes one more version
+	parent := params.GenesisHeader
+	for version := uint32(1); version <= 4; version++ {
+		if version > 1 {
+			if _, err := chain.AddHeader(mine(parent, version-1)); err != ErrHeaderBadVersion {
+				t.Fatalf("version %d: expect %v, got %v", version-1, ErrHeaderBadVersion, err)
+			}
+		}
+
+		header := mine(parent, version)
+		if _, err := chain.AddHeader(header); err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		parent = header
+	}
+
+	// Versions are signed, as in Bitcoin Core
+	if _, err := chain.AddHeader(mine(parent, 0x80000004)); err != ErrHeaderBadVersion {
+		t.Fatalf("expect %v, got %v", ErrHeaderBadVersion, err)
+	}
+	if _, err := chain.AddHeader(mine(parent, 0x20000000)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeaderChainRetarget(t *testing.T) {
+	// Difficulty periods of 10 blocks at the regtest pow limit
+	params := *RegTestParams
+	params.PowTargetTimespan = 10 * 600
+	params.PowNoRetargeting = false
+	params.PowAllowMinDifficultyBlocks = false
+
+	chain := NewHeaderChain(&params)
+	chain.now = func() time.Time { return time.Unix(1296688602+100000, 0) }
+
+	// Blocks come four times too fast
+	headers := newTestHeaders(params.GenesisHeader, 9, 150, &params)
+	if _, err := chain.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+
+	last := headers[8]
+	if bits := chain.nextBits(chain.Tip(), last.Time+150); uint32(bits) != 0x201fffff {
+		t.Fatalf("retarget: expect 201fffff, got %08x", uint32(bits))
+	}
+
+	easy := mineTestHeader(last, last.Time+150, params.PowLimit, &params)
+	if _, err := chain.AddHeader(easy); err != ErrHeaderBadBits && err != ErrPowHighHash {
+		t.Fatalf("expect %v, got %v", ErrHeaderBadBits, err)
+	}
+
+	hard := mineTestHeader(last, last.Time+150, NewCompact(0x201fffff), &params)
+	if _, err := chain.AddHeader(hard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeaderChainMinDifficulty(t *testing.T) {
+	params := *RegTestParams
+	params.PowTargetTimespan = 10 * 600
+	params.PowNoRetargeting = false
+
+	chain := NewHeaderChain(&params)
+	chain.now = func() time.Time { return time.Unix(1296688602+100000, 0) }
+
+	headers := newTestHeaders(params.GenesisHeader, 9, 150, &params)
+	if _, err := chain.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	hard := mineTestHeader(headers[8], headers[8].Time+150, NewCompact(0x201fffff), &params)
+	if _, err := chain.AddHeader(hard); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block 20 minutes after its parent may use the pow limit, the next ones go back to the period bits
+	slow := mineTestHeader(hard, hard.Time+1201, params.PowLimit, &params)
+	if _, err := chain.AddHeader(slow); err != nil {
+		t.Fatal(err)
+	}
+
+	if bits := chain.nextBits(chain.Tip(), slow.Time+600); uint32(bits) != 0x201fffff {
+		t.Fatalf("expect 201fffff, got %08x", uint32(bits))
+	}
+}
@@ -0,0 +1,121 @@
+// Package chacha20 implements the ChaCha20 stream cipher of RFC 8439.
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+var (
+	ErrKeySize   = errors.New("chacha20: wrong key size")
+	ErrNonceSize = errors.New("chacha20: wrong nonce size")
+)
+
+const (
+	KeySize   = 32
+	NonceSize = 12
+	BlockSize = 64
+)
+
+// Cipher is a ChaCha20 key stream positioned at a block counter
+type Cipher struct {
+	state [16]uint32
+	// Unused key stream of the last block
+	buf [BlockSize]byte
+	n   int
+}
+
+func New(key, nonce []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+
+	if len(nonce) != NonceSize {
+		return nil, ErrNonceSize
+	}
+
+	c := &Cipher{}
+	c.state[0] = 0x61707865
+	c.state[1] = 0x3320646e
+	c.state[2] = 0x79622d32
+	c.state[3] = 0x6b206574
+	for i := 0; i < 8; i++ {
+		c.state[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	for i := 0; i < 3; i++ {
+		c.state[13+i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+
+	return c, nil
+}
+
+// SetCounter moves the key stream to the start of block counter
+func (c *Cipher) SetCounter(counter uint32) {
+	c.state[12] = counter
+	c.n = 0
+}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d = bits.RotateLeft32(d^a, 16)
+	c += d
+	b = bits.RotateLeft32(b^c, 12)
+	a += b
+	d = bits.RotateLeft32(d^a, 8)
+	c += d
+	b = bits.RotateLeft32(b^c, 7)
+	return a, b, c, d
+}
+
+// block writes the key stream block of the current counter and advances it
+func (c *Cipher) block(out *[BlockSize]byte) {
+	x := c.state
+	for i := 0; i < 10; i++ {
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+c.state[i])
+	}
+	c.state[12]++
+}
+
+// XORKeyStream XORs src with the key stream into dst, which may overlap src exactly
+func (c *Cipher) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if c.n == 0 {
+			c.block(&c.buf)
+			c.n = BlockSize
+		}
+
+		used := BlockSize - c.n
+		n := len(src)
+		if n > c.n {
+			n = c.n
+		}
+
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ c.buf[used+i]
+		}
+
+		c.n -= n
+		dst = dst[n:]
+		src = src[n:]
+	}
+}
+
+// KeyStream fills dst with key stream
+func (c *Cipher) KeyStream(dst []byte) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	c.XORKeyStream(dst, dst)
+}
@@ -0,0 +1,68 @@
+package chacha20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// RFC 8439 section 2.4.2
+func TestXORKeyStream(t *testing.T) {
+	key := mustDecode("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce := mustDecode("000000000000004a00000000")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expect := mustDecode("6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0bf91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d807ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab77937365af90bbf74a35be6b40b8eedf2785e42874d")
+
+	c, err := New(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCounter(1)
+
+	got := make([]byte, len(plaintext))
+	c.XORKeyStream(got, plaintext)
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("ciphertext: got %x", got)
+	}
+
+	// the key stream is the same when consumed in pieces
+	c.SetCounter(1)
+	got = make([]byte, len(plaintext))
+	for i := 0; i < len(plaintext); i += 7 {
+		end := i + 7
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+		c.XORKeyStream(got[i:end], plaintext[i:end])
+	}
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("ciphertext in pieces: got %x", got)
+	}
+}
+
+// RFC 8439 appendix A.1, test vector 1
+func TestKeyStream(t *testing.T) {
+	c, err := New(make([]byte, KeySize), make([]byte, NonceSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, BlockSize)
+	c.KeyStream(got)
+	expect := mustDecode("76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586")
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("key stream: got %x", got)
+	}
+
+	if _, err := New(nil, make([]byte, NonceSize)); err != ErrKeySize {
+		t.Fatalf("expect key size error, got %v", err)
+	}
+}
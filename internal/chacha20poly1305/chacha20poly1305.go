@@ -0,0 +1,106 @@
+// Package chacha20poly1305 implements the ChaCha20-Poly1305 AEAD of RFC 8439.
+package chacha20poly1305
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"github.com/detailyang/go-bcore/internal/chacha20"
+	"github.com/detailyang/go-bcore/internal/poly1305"
+)
+
+var (
+	ErrKeySize   = errors.New("chacha20poly1305: wrong key size")
+	ErrNonceSize = errors.New("chacha20poly1305: wrong nonce size")
+	ErrOpen      = errors.New("chacha20poly1305: message authentication failed")
+)
+
+const (
+	KeySize   = chacha20.KeySize
+	NonceSize = chacha20.NonceSize
+	Overhead  = poly1305.TagSize
+)
+
+type AEAD struct {
+	key [KeySize]byte
+}
+
+func New(key []byte) (*AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+
+	a := &AEAD{}
+	copy(a.key[:], key)
+	return a, nil
+}
+
+// cipher returns the ChaCha20 stream of nonce at block 1 and the Poly1305 key from block 0
+func (a *AEAD) cipher(nonce []byte) (*chacha20.Cipher, *poly1305.MAC, error) {
+	if len(nonce) != NonceSize {
+		return nil, nil, ErrNonceSize
+	}
+
+	c, err := chacha20.New(a.key[:], nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var polyKey [chacha20.BlockSize]byte
+	c.KeyStream(polyKey[:])
+	c.SetCounter(1)
+
+	return c, poly1305.New((*[poly1305.KeySize]byte)(polyKey[:poly1305.KeySize])), nil
+}
+
+// tag returns the tag of aad and ciphertext, each zero padded to 16 bytes and followed by their lengths
+func tag(mac *poly1305.MAC, aad, ciphertext []byte) []byte {
+	var pad [poly1305.TagSize]byte
+	mac.Write(aad)
+	mac.Write(pad[:(poly1305.TagSize-len(aad)%poly1305.TagSize)%poly1305.TagSize])
+	mac.Write(ciphertext)
+	mac.Write(pad[:(poly1305.TagSize-len(ciphertext)%poly1305.TagSize)%poly1305.TagSize])
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(aad)))
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+
+	return mac.Sum(nil)
+}
+
+// Seal appends the encryption of plaintext followed by its tag to dst
+func (a *AEAD) Seal(dst, nonce, plaintext, aad []byte) ([]byte, error) {
+	c, mac, err := a.cipher(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(dst)
+	dst = append(dst, plaintext...)
+	c.XORKeyStream(dst[n:], dst[n:])
+	return append(dst, tag(mac, aad, dst[n:])...), nil
+}
+
+// Open authenticates ciphertext, tag included, and appends its decryption to dst
+func (a *AEAD) Open(dst, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < Overhead {
+		return nil, ErrOpen
+	}
+
+	c, mac, err := a.cipher(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(ciphertext) - Overhead
+	if subtle.ConstantTimeCompare(tag(mac, aad, ciphertext[:n]), ciphertext[n:]) != 1 {
+		return nil, ErrOpen
+	}
+
+	m := len(dst)
+	dst = append(dst, ciphertext[:n]...)
+	c.XORKeyStream(dst[m:], dst[m:])
+	return dst, nil
+}
@@ -0,0 +1,64 @@
+package chacha20poly1305
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// RFC 8439 section 2.8.2
+func TestSealOpen(t *testing.T) {
+	key := mustDecode("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := mustDecode("070000004041424344454647")
+	aad := mustDecode("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expect := "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116" +
+		"1ae10b594f09e26a7e902ecbd0600691"
+
+	a, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := a.Seal(nil, nonce, plaintext, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sealed) != expect {
+		t.Fatalf("expect %s, got %x", expect, sealed)
+	}
+
+	opened, err := a.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("expect %q, got %q", plaintext, opened)
+	}
+
+	sealed[0] ^= 1
+	if _, err := a.Open(nil, nonce, sealed, aad); err != ErrOpen {
+		t.Fatalf("expect %v, got %v", ErrOpen, err)
+	}
+	sealed[0] ^= 1
+
+	if _, err := a.Open(nil, nonce, sealed, aad[1:]); err != ErrOpen {
+		t.Fatalf("expect %v, got %v", ErrOpen, err)
+	}
+
+	if _, err := a.Open(nil, nonce, sealed[:Overhead-1], aad); err != ErrOpen {
+		t.Fatalf("expect %v, got %v", ErrOpen, err)
+	}
+
+	if _, err := a.Seal(nil, nonce[1:], plaintext, aad); err != ErrNonceSize {
+		t.Fatalf("expect %v, got %v", ErrNonceSize, err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package minisketch implements the PinSketch set reconciliation sketches of libminisketch
+// over GF(2^32): sketches of two sets merge into the sketch of their symmetric difference,
+// which decodes when it has at most the capacity of the sketches.
+package minisketch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrDecode     = errors.New("minisketch: too many differences to decode")
+	ErrSketchSize = errors.New("minisketch: bad sketch size")
+)
+
+const (
+	// Bits is the size of the elements
+	Bits = 32
+
+	// modulus is the low part of the field polynomial x^32 + x^7 + x^3 + x^2 + 1
+	modulus = 0x8d
+)
+
+// mul returns a*b in GF(2^32)
+func mul(a, b uint32) uint32 {
+	var r uint32
+	for b != 0 {
+		if b&1 != 0 {
+			r ^= a
+		}
+		b >>= 1
+
+		carry := a >> 31
+		a <<= 1
+		if carry != 0 {
+			a ^= modulus
+		}
+	}
+	return r
+}
+
+// inv returns the inverse of a non zero a, a^(2^32-2)
+func inv(a uint32) uint32 {
+	r := uint32(1)
+	for i := 0; i < Bits-1; i++ {
+		a = mul(a, a)
+		r = mul(r, a)
+	}
+	return r
+}
+
+// Sketch is the odd power sums of the elements of a set, as many as its capacity
+type Sketch struct {
+	syndromes []uint32
+}
+
+// New returns the sketch of the empty set, decoding up to capacity differences
+func New(capacity int) *Sketch {
+	return &Sketch{syndromes: make([]uint32, capacity)}
+}
+
+// NewSketchFromBytes decodes a serialized sketch, its capacity being its size in elements
+func NewSketchFromBytes(data []byte) (*Sketch, error) {
+	if len(data)%(Bits/8) != 0 {
+		return nil, ErrSketchSize
+	}
+
+	s := New(len(data) / (Bits / 8))
+	for i := range s.syndromes {
+		s.syndromes[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return s, nil
+}
+
+// Capacity is the number of differences the sketch can decode
+func (s *Sketch) Capacity() int { return len(s.syndromes) }
+
+// Add toggles the non zero element x in the set. Adding it twice removes it.
+func (s *Sketch) Add(x uint32) {
+	x2 := mul(x, x)
+	for i := range s.syndromes {
+		s.syndromes[i] ^= x
+		x = mul(x, x2)
+	}
+}
+
+// Merge makes s the sketch of the symmetric difference of both sets, with the
+// smallest capacity of both
+func (s *Sketch) Merge(o *Sketch) {
+	if len(o.syndromes) < len(s.syndromes) {
+		s.syndromes = s.syndromes[:len(o.syndromes)]
+	}
+	for i := range s.syndromes {
+		s.syndromes[i] ^= o.syndromes[i]
+	}
+}
+
+// Clone returns a copy of the sketch
+func (s *Sketch) Clone() *Sketch {
+	return &Sketch{syndromes: append([]uint32{}, s.syndromes...)}
+}
+
+// Bytes serializes the syndromes as little endian elements
+func (s *Sketch) Bytes() []byte {
+	data := make([]byte, len(s.syndromes)*4)
+	for i, syndrome := range s.syndromes {
+		binary.LittleEndian.PutUint32(data[i*4:], syndrome)
+	}
+	return data
+}
+
+// Decode returns the elements of the set, failing with ErrDecode when they are more
+// than max or than the capacity
+func (s *Sketch) Decode(max int) ([]uint32, error) {
+	// The even power sums are squares of the odd ones: S(2i) = S(i)^2
+	n := 2 * len(s.syndromes)
+	sums := make([]uint32, n+1)
+	for i := 1; i <= n; i++ {
+		if i%2 == 1 {
+			sums[i] = s.syndromes[i/2]
+		} else {
+			sums[i] = mul(sums[i/2], sums[i/2])
+		}
+	}
+
+	locator := berlekampMassey(sums[1:])
+	degree := len(locator) - 1
+	if degree > max || degree > s.Capacity() {
+		return nil, ErrDecode
+	}
+
+	// The roots of the reversed locator are the elements
+	poly := make([]uint32, degree+1)
+	for i := range poly {
+		poly[i] = locator[degree-i]
+	}
+
+	roots, ok := findRoots(poly)
+	if !ok {
+		return nil, ErrDecode
+	}
+	return roots, nil
+}
+
+// berlekampMassey returns the shortest connection polynomial generating sums, the
+// error locator with the inverses of the elements as roots
+func berlekampMassey(sums []uint32) []uint32 {
+	c := []uint32{1}
+	b := []uint32{1}
+	l, m := 0, 1
+	bInv := uint32(1)
+
+	for n := range sums {
+		d := sums[n]
+		for i := 1; i <= l && i < len(c); i++ {
+			d ^= mul(c[i], sums[n-i])
+		}
+
+		if d == 0 {
+			m++
+			continue
+		}
+
+		// c -= d/b x^m b
+		t := append([]uint32{}, c...)
+		coef := mul(d, bInv)
+		for len(c) < len(b)+m {
+			c = append(c, 0)
+		}
+		for i, bi := range b {
+			c[i+m] ^= mul(coef, bi)
+		}
+
+		if 2*l <= n {
+			l = n + 1 - l
+			b = t
+			bInv = inv(d)
+			m = 1
+		} else {
+			m++
+		}
+	}
+
+	// A degree below l gives a zero root of the reversed locator, failing to decode
+	c = trim(c)
+	return append(c, make([]uint32, l+1-len(c))...)
+}
@@ -0,0 +1,148 @@
+package minisketch
+
+import (
+	"math/bits"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// TestFieldModulus checks with Rabin's test that the field polynomial is irreducible:
+// x^(2^32) = x and gcd(x^(2^16) - x, f) = 1
+func TestFieldModulus(t *testing.T) {
+	x := uint32(2)
+	for i := 0; i < Bits; i++ {
+		x = mul(x, x)
+	}
+	if x != 2 {
+		t.Fatal("x^(2^32) != x")
+	}
+
+	x = 2
+	for i := 0; i < Bits/2; i++ {
+		x = mul(x, x)
+	}
+
+	// Binary polynomials gcd, f of degree 32
+	a, b := uint64(1)<<32|modulus, uint64(x^2)
+	for b != 0 {
+		for bits.Len64(a) >= bits.Len64(b) {
+			a ^= b << (bits.Len64(a) - bits.Len64(b))
+		}
+		a, b = b, a
+	}
+	if a != 1 {
+		t.Fatalf("gcd is %x", a)
+	}
+}
+
+func TestInv(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		a := r.Uint32() | 1
+		if mul(a, inv(a)) != 1 {
+			t.Fatalf("%x: bad inverse", a)
+		}
+	}
+}
+
+func sorted(elements []uint32) []uint32 {
+	s := append([]uint32{}, elements...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s
+}
+
+func TestSketch(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for _, test := range []struct{ common, onlyA, onlyB, capacity int }{
+		{0, 0, 0, 0},
+		{100, 0, 0, 10},
+		{100, 1, 0, 1},
+		{50, 3, 4, 7},
+		{500, 20, 30, 60},
+		{0, 100, 100, 200},
+	} {
+		a, b := New(test.capacity), New(test.capacity)
+		var diff []uint32
+		for i := 0; i < test.common; i++ {
+			x := r.Uint32() | 1
+			a.Add(x)
+			b.Add(x)
+		}
+		for i := 0; i < test.onlyA; i++ {
+			x := r.Uint32() | 1
+			a.Add(x)
+			diff = append(diff, x)
+		}
+		for i := 0; i < test.onlyB; i++ {
+			x := r.Uint32() | 1
+			b.Add(x)
+			diff = append(diff, x)
+		}
+
+		// Through serialization
+		decoded, err := NewSketchFromBytes(b.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Merge(decoded)
+
+		elements, err := a.Decode(test.capacity)
+		if err != nil {
+			t.Fatalf("%+v: %v", test, err)
+		}
+
+		got, expect := sorted(elements), sorted(diff)
+		if len(got) != len(expect) {
+			t.Fatalf("%+v: expect %d elements, got %d", test, len(expect), len(got))
+		}
+		for i := range got {
+			if got[i] != expect[i] {
+				t.Fatalf("%+v: expect %x, got %x", test, expect, got)
+			}
+		}
+	}
+}
+
+func TestSketchOverCapacity(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	s := New(10)
+	for i := 0; i < 11; i++ {
+		s.Add(r.Uint32() | 1)
+	}
+	if _, err := s.Decode(10); err != ErrDecode {
+		t.Fatalf("expect %v, got %v", ErrDecode, err)
+	}
+
+	// Within the capacity but more than asked for
+	s = New(10)
+	for i := 0; i < 5; i++ {
+		s.Add(r.Uint32() | 1)
+	}
+	if _, err := s.Decode(4); err != ErrDecode {
+		t.Fatalf("expect %v, got %v", ErrDecode, err)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b := New(8), New(4)
+	a.Add(5)
+	a.Add(7)
+	b.Add(7)
+
+	// The merged sketch has the smallest capacity, adding twice removes
+	a.Merge(b)
+	elements, err := a.Decode(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Capacity() != 4 || len(elements) != 1 || elements[0] != 5 {
+		t.Fatalf("unexpected %x of capacity %d", elements, a.Capacity())
+	}
+
+	if _, err := NewSketchFromBytes([]byte{1, 2, 3}); err != ErrSketchSize {
+		t.Fatalf("expect %v, got %v", ErrSketchSize, err)
+	}
+}
@@ -0,0 +1,164 @@
+package minisketch
+
+// Polynomials over GF(2^32) are their coefficients, lowest degree first
+
+// trim drops the zero coefficients of the highest degrees
+func trim(p []uint32) []uint32 {
+	for len(p) > 0 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// polyDivMod returns the quotient and remainder of a by the non zero m
+func polyDivMod(a, m []uint32) ([]uint32, []uint32) {
+	r := append([]uint32{}, a...)
+	dm := len(m) - 1
+	if len(r) <= dm {
+		return nil, trim(r)
+	}
+
+	q := make([]uint32, len(r)-dm)
+	lead := inv(m[dm])
+	for i := len(r) - 1; i >= dm; i-- {
+		if r[i] == 0 {
+			continue
+		}
+
+		f := mul(r[i], lead)
+		q[i-dm] = f
+		for j, mj := range m {
+			r[i-dm+j] ^= mul(f, mj)
+		}
+	}
+
+	return trim(q), trim(r[:dm])
+}
+
+func polyMod(a, m []uint32) []uint32 {
+	_, r := polyDivMod(a, m)
+	return r
+}
+
+// polySqrMod returns a^2 mod m, squaring being linear in characteristic 2
+func polySqrMod(a, m []uint32) []uint32 {
+	if len(a) == 0 {
+		return nil
+	}
+
+	sqr := make([]uint32, 2*len(a)-1)
+	for i, ai := range a {
+		sqr[2*i] = mul(ai, ai)
+	}
+	return polyMod(sqr, m)
+}
+
+// polyMonic divides p by its leading coefficient
+func polyMonic(p []uint32) []uint32 {
+	lead := inv(p[len(p)-1])
+	monic := make([]uint32, len(p))
+	for i, pi := range p {
+		monic[i] = mul(pi, lead)
+	}
+	return monic
+}
+
+func polyGCD(a, b []uint32) []uint32 {
+	a, b = trim(a), trim(b)
+	for len(b) > 0 {
+		a, b = b, polyMod(a, b)
+	}
+	return a
+}
+
+func polyEqual(a, b []uint32) bool {
+	a, b = trim(a), trim(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findRoots returns the roots of p, reporting false unless p is the product of
+// distinct degree one factors with non zero roots
+func findRoots(p []uint32) ([]uint32, bool) {
+	p = trim(p)
+	if len(p) == 0 {
+		return nil, false
+	}
+	if len(p) == 1 {
+		return nil, true
+	}
+	if p[0] == 0 {
+		return nil, false
+	}
+
+	// p divides x^(2^32) - x, the product of all degree one factors, when it splits
+	// into distinct ones
+	x := polyMod([]uint32{0, 1}, p)
+	t := x
+	for i := 0; i < Bits; i++ {
+		t = polySqrMod(t, p)
+	}
+	if !polyEqual(t, x) {
+		return nil, false
+	}
+
+	var roots []uint32
+	state := uint32(0x9e3779b9)
+	if !splitRoots(polyMonic(p), &state, &roots) {
+		return nil, false
+	}
+	return roots, true
+}
+
+// splitRoots appends the roots of the monic split polynomial p, splitting it with the
+// trace map: the roots r with Tr(beta*r) = 0 are those of gcd(p, Tr(beta*x)).
+func splitRoots(p []uint32, state *uint32, roots *[]uint32) bool {
+	if len(p) == 2 {
+		*roots = append(*roots, p[0])
+		return true
+	}
+
+	// Each random beta splits with probability at least 1/2
+	for attempt := 0; attempt < 4*Bits; attempt++ {
+		// xorshift32
+		*state ^= *state << 13
+		*state ^= *state >> 17
+		*state ^= *state << 5
+
+		t := polyMod([]uint32{0, *state}, p)
+		trace := t
+		for i := 1; i < Bits; i++ {
+			t = polySqrMod(t, p)
+			trace = polyAdd(trace, t)
+		}
+
+		g := polyGCD(p, trace)
+		if len(g) < 2 || len(g) == len(p) {
+			continue
+		}
+
+		g = polyMonic(g)
+		q, _ := polyDivMod(p, g)
+		return splitRoots(g, state, roots) && splitRoots(polyMonic(q), state, roots)
+	}
+
+	return false
+}
+
+func polyAdd(a, b []uint32) []uint32 {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	sum := append([]uint32{}, a...)
+	for i, bi := range b {
+		sum[i] ^= bi
+	}
+	return trim(sum)
+}
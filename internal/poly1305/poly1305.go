@@ -0,0 +1,135 @@
+// Package poly1305 implements the Poly1305 one-time authenticator of RFC 8439.
+package poly1305
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	KeySize = 32
+	TagSize = 16
+)
+
+// MAC computes the tag of the data written to it, a key must authenticate one message only
+type MAC struct {
+	// Accumulator h below 2^130 + some, h2 holds its top bits
+	h0, h1, h2 uint64
+	r0, r1     uint64
+	s0, s1     uint64
+
+	buf [TagSize]byte
+	n   int
+}
+
+func New(key *[KeySize]byte) *MAC {
+	return &MAC{
+		r0: binary.LittleEndian.Uint64(key[0:8]) & 0x0ffffffc0fffffff,
+		r1: binary.LittleEndian.Uint64(key[8:16]) & 0x0ffffffc0ffffffc,
+		s0: binary.LittleEndian.Uint64(key[16:24]),
+		s1: binary.LittleEndian.Uint64(key[24:32]),
+	}
+}
+
+// block adds a 16 byte block to h, with hibit set above it for full blocks, and multiplies h by r
+func (m *MAC) block(b []byte, hibit uint64) {
+	var c uint64
+	m.h0, c = bits.Add64(m.h0, binary.LittleEndian.Uint64(b[0:8]), 0)
+	m.h1, c = bits.Add64(m.h1, binary.LittleEndian.Uint64(b[8:16]), c)
+	m.h2 += c + hibit
+
+	// h * r, with h2 small and r clamped so that no product overflows 128 bits
+	h0r0hi, h0r0lo := bits.Mul64(m.h0, m.r0)
+	h1r0hi, h1r0lo := bits.Mul64(m.h1, m.r0)
+	h0r1hi, h0r1lo := bits.Mul64(m.h0, m.r1)
+	h1r1hi, h1r1lo := bits.Mul64(m.h1, m.r1)
+	h2r0 := m.h2 * m.r0
+	h2r1 := m.h2 * m.r1
+
+	m1lo, c := bits.Add64(h1r0lo, h0r1lo, 0)
+	m1hi, _ := bits.Add64(h1r0hi, h0r1hi, c)
+	m2lo, c := bits.Add64(h1r1lo, h2r0, 0)
+	m2hi, _ := bits.Add64(h1r1hi, 0, c)
+
+	t0 := h0r0lo
+	t1, c := bits.Add64(m1lo, h0r0hi, 0)
+	t2, c := bits.Add64(m2lo, m1hi, c)
+	t3, _ := bits.Add64(h2r1, m2hi, c)
+
+	// Reduce modulo 2^130 - 5: h = t mod 2^130 + 5 * (t >> 130), added as 4x + x
+	m.h0, m.h1, m.h2 = t0, t1, t2&3
+	cc0, cc1 := t2&^3, t3
+	m.h0, c = bits.Add64(m.h0, cc0, 0)
+	m.h1, c = bits.Add64(m.h1, cc1, c)
+	m.h2 += c
+
+	cc0, cc1 = cc0>>2|cc1<<62, cc1>>2
+	m.h0, c = bits.Add64(m.h0, cc0, 0)
+	m.h1, c = bits.Add64(m.h1, cc1, c)
+	m.h2 += c
+}
+
+func (m *MAC) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if m.n > 0 {
+		k := copy(m.buf[m.n:], p)
+		m.n += k
+		p = p[k:]
+		if m.n < TagSize {
+			return n, nil
+		}
+		m.block(m.buf[:], 1)
+		m.n = 0
+	}
+
+	for ; len(p) >= TagSize; p = p[TagSize:] {
+		m.block(p, 1)
+	}
+
+	m.n = copy(m.buf[:], p)
+	return n, nil
+}
+
+// Sum appends the tag of the data written so far to b
+func (m *MAC) Sum(b []byte) []byte {
+	h := *m
+	if h.n > 0 {
+		var last [TagSize]byte
+		copy(last[:], h.buf[:h.n])
+		last[h.n] = 1
+		h.block(last[:], 0)
+	}
+
+	// h - p = h + 5 - 2^130, kept when h >= p
+	g0, c := bits.Sub64(h.h0, 0xfffffffffffffffb, 0)
+	g1, c := bits.Sub64(h.h1, 0xffffffffffffffff, c)
+	_, c = bits.Sub64(h.h2, 3, c)
+	if c == 0 {
+		h.h0, h.h1 = g0, g1
+	}
+
+	h.h0, c = bits.Add64(h.h0, h.s0, 0)
+	h.h1, _ = bits.Add64(h.h1, h.s1, c)
+
+	var tag [TagSize]byte
+	binary.LittleEndian.PutUint64(tag[0:8], h.h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h.h1)
+	return append(b, tag[:]...)
+}
+
+// Sum returns the tag of msg
+func Sum(msg []byte, key *[KeySize]byte) [TagSize]byte {
+	var tag [TagSize]byte
+	m := New(key)
+	m.Write(msg)
+	copy(tag[:], m.Sum(nil))
+	return tag
+}
+
+// Verify reports in constant time whether tag authenticates msg
+func Verify(tag []byte, msg []byte, key *[KeySize]byte) bool {
+	sum := Sum(msg, key)
+	return subtle.ConstantTimeCompare(tag, sum[:]) == 1
+}
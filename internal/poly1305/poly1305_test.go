@@ -0,0 +1,72 @@
+package poly1305
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		key string
+		msg []byte
+		tag string
+	}{
+		// RFC 8439 section 2.5.2
+		{
+			"85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b",
+			[]byte("Cryptographic Forum Research Group"),
+			"a8061dc1305136c6c22b8baf0c0127a9",
+		},
+		// RFC 8439 appendix A.3 test vector 1
+		{
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			make([]byte, 64),
+			"00000000000000000000000000000000",
+		},
+		// RFC 8439 appendix A.3 test vector 6, h reaching p
+		{
+			"0200000000000000000000000000000000000000000000000000000000000000",
+			mustDecode("ffffffffffffffffffffffffffffffff"),
+			"03000000000000000000000000000000",
+		},
+		// RFC 8439 appendix A.3 test vector 7
+		{
+			"0100000000000000000000000000000000000000000000000000000000000000",
+			mustDecode("fffffffffffffffffffffffffffffffff0ffffffffffffffffffffffffffffff11000000000000000000000000000000"),
+			"05000000000000000000000000000000",
+		},
+	}
+
+	for i, test := range tests {
+		var key [KeySize]byte
+		copy(key[:], mustDecode(test.key))
+
+		tag := Sum(test.msg, &key)
+		if hex.EncodeToString(tag[:]) != test.tag {
+			t.Fatalf("vector %d: expect %s, got %x", i, test.tag, tag)
+		}
+
+		if !Verify(tag[:], test.msg, &key) {
+			t.Fatalf("vector %d: verify failed", i)
+		}
+
+		// Writes split at every position give the same tag
+		for j := 0; j <= len(test.msg); j++ {
+			m := New(&key)
+			m.Write(test.msg[:j])
+			m.Write(test.msg[j:])
+			if !bytes.Equal(m.Sum(nil), tag[:]) {
+				t.Fatalf("vector %d: split at %d mismatch", i, j)
+			}
+		}
+	}
+}
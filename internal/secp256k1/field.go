@@ -0,0 +1,234 @@
+// Package secp256k1 implements the field and group arithmetic of the secp256k1
+// curve over fixed width integers, in constant time for secret scalars.
+package secp256k1
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// fieldC is 2^256 - p, p being 2^256 - 2^32 - 977
+const fieldC = 0x1000003d1
+
+var (
+	fieldP = [4]uint64{0xfffffffefffffc2f, 0xffffffffffffffff, 0xffffffffffffffff, 0xffffffffffffffff}
+	// (p+1)/4 gives square roots as p is 3 mod 4, p-2 inverses
+	fieldSqrtExp = [4]uint64{0xffffffffbfffff0c, 0xffffffffffffffff, 0xffffffffffffffff, 0x3fffffffffffffff}
+	fieldInvExp  = [4]uint64{0xfffffffefffffc2d, 0xffffffffffffffff, 0xffffffffffffffff, 0xffffffffffffffff}
+)
+
+// FieldElement is an integer modulo p, as little endian 64 bit limbs always reduced below p.
+// The zero value is 0.
+type FieldElement struct {
+	n [4]uint64
+}
+
+// subP returns a - p and the borrow of the subtraction
+func subP(a [4]uint64) ([4]uint64, uint64) {
+	var r [4]uint64
+	var b uint64
+	r[0], b = bits.Sub64(a[0], fieldP[0], 0)
+	r[1], b = bits.Sub64(a[1], fieldP[1], b)
+	r[2], b = bits.Sub64(a[2], fieldP[2], b)
+	r[3], b = bits.Sub64(a[3], fieldP[3], b)
+	return r, b
+}
+
+// selectLimbs returns a when mask is all ones, b when it is zero
+func selectLimbs(a, b [4]uint64, mask uint64) [4]uint64 {
+	for i := range a {
+		a[i] = a[i]&mask | b[i]&^mask
+	}
+	return a
+}
+
+func (r *FieldElement) Set(a *FieldElement) *FieldElement {
+	r.n = a.n
+	return r
+}
+
+func (r *FieldElement) SetUint64(v uint64) *FieldElement {
+	r.n = [4]uint64{v, 0, 0, 0}
+	return r
+}
+
+// SetBytes sets r to the 32 bytes big endian b reduced modulo p, and reports whether b was below p
+func (r *FieldElement) SetBytes(b []byte) (*FieldElement, bool) {
+	var a [4]uint64
+	for i := range a {
+		a[i] = binary.BigEndian.Uint64(b[24-8*i:])
+	}
+
+	d, borrow := subP(a)
+	r.n = selectLimbs(a, d, -borrow)
+	return r, borrow == 1
+}
+
+// Bytes returns the 32 bytes big endian encoding of a
+func (a *FieldElement) Bytes() [32]byte {
+	var b [32]byte
+	for i, l := range a.n {
+		binary.BigEndian.PutUint64(b[24-8*i:], l)
+	}
+	return b
+}
+
+func (a *FieldElement) IsZero() bool {
+	return a.n[0]|a.n[1]|a.n[2]|a.n[3] == 0
+}
+
+func (a *FieldElement) Equal(b *FieldElement) bool {
+	return (a.n[0]^b.n[0])|(a.n[1]^b.n[1])|(a.n[2]^b.n[2])|(a.n[3]^b.n[3]) == 0
+}
+
+func (a *FieldElement) IsOdd() bool {
+	return a.n[0]&1 == 1
+}
+
+func (r *FieldElement) Add(a, b *FieldElement) *FieldElement {
+	var s [4]uint64
+	var c uint64
+	s[0], c = bits.Add64(a.n[0], b.n[0], 0)
+	s[1], c = bits.Add64(a.n[1], b.n[1], c)
+	s[2], c = bits.Add64(a.n[2], b.n[2], c)
+	s[3], c = bits.Add64(a.n[3], b.n[3], c)
+
+	// The sum is below 2p, p is subtracted when it carried or did not borrow
+	d, borrow := subP(s)
+	r.n = selectLimbs(d, s, -(c | (borrow ^ 1)))
+	return r
+}
+
+func (r *FieldElement) Sub(a, b *FieldElement) *FieldElement {
+	var d [4]uint64
+	var borrow uint64
+	d[0], borrow = bits.Sub64(a.n[0], b.n[0], 0)
+	d[1], borrow = bits.Sub64(a.n[1], b.n[1], borrow)
+	d[2], borrow = bits.Sub64(a.n[2], b.n[2], borrow)
+	d[3], borrow = bits.Sub64(a.n[3], b.n[3], borrow)
+
+	// p is added back when it borrowed
+	mask := -borrow
+	var c uint64
+	d[0], c = bits.Add64(d[0], fieldP[0]&mask, 0)
+	d[1], c = bits.Add64(d[1], fieldP[1]&mask, c)
+	d[2], c = bits.Add64(d[2], fieldP[2]&mask, c)
+	d[3], _ = bits.Add64(d[3], fieldP[3]&mask, c)
+	r.n = d
+	return r
+}
+
+func (r *FieldElement) Neg(a *FieldElement) *FieldElement {
+	return r.Sub(new(FieldElement), a)
+}
+
+func (r *FieldElement) Mul(a, b *FieldElement) *FieldElement {
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a.n[i], b.n[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j] = lo
+			carry = hi
+		}
+		t[i+4] = carry
+	}
+
+	r.n = reduce(t)
+	return r
+}
+
+func (r *FieldElement) Square(a *FieldElement) *FieldElement {
+	return r.Mul(a, a)
+}
+
+// reduce returns t modulo p, folding 2^256 into fieldC
+func reduce(t [8]uint64) [4]uint64 {
+	var n [4]uint64
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		hi, lo := bits.Mul64(t[4+i], fieldC)
+		var c uint64
+		lo, c = bits.Add64(lo, t[i], 0)
+		hi += c
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		n[i] = lo
+		carry = hi
+	}
+
+	hi, lo := bits.Mul64(carry, fieldC)
+	var c uint64
+	n[0], c = bits.Add64(n[0], lo, 0)
+	n[1], c = bits.Add64(n[1], hi, c)
+	n[2], c = bits.Add64(n[2], 0, c)
+	n[3], c = bits.Add64(n[3], 0, c)
+
+	// A last carry leaves a small value, adding fieldC cannot carry again
+	n[0], c = bits.Add64(n[0], fieldC&-c, 0)
+	n[1], c = bits.Add64(n[1], 0, c)
+	n[2], c = bits.Add64(n[2], 0, c)
+	n[3], _ = bits.Add64(n[3], 0, c)
+
+	d, borrow := subP(n)
+	return selectLimbs(n, d, -borrow)
+}
+
+// exp sets r to a^e, the exponent being public
+func (r *FieldElement) exp(a *FieldElement, e [4]uint64) *FieldElement {
+	base := *a
+	x := FieldElement{n: [4]uint64{1}}
+	for i := 255; i >= 0; i-- {
+		x.Square(&x)
+		if e[i/64]>>(i%64)&1 == 1 {
+			x.Mul(&x, &base)
+		}
+	}
+	r.n = x.n
+	return r
+}
+
+// Inv sets r to the inverse of a, 0 for 0
+func (r *FieldElement) Inv(a *FieldElement) *FieldElement {
+	return r.exp(a, fieldInvExp)
+}
+
+// Sqrt sets r to a square root of a and reports whether a has one
+func (r *FieldElement) Sqrt(a *FieldElement) (*FieldElement, bool) {
+	var x, check FieldElement
+	x.exp(a, fieldSqrtExp)
+	ok := check.Square(&x).Equal(a)
+	r.n = x.n
+	return r, ok
+}
+
+// swap exchanges a and b when bit is 1, in constant time
+func (a *FieldElement) swap(b *FieldElement, bit uint64) {
+	mask := -bit
+	for i := range a.n {
+		t := (a.n[i] ^ b.n[i]) & mask
+		a.n[i] ^= t
+		b.n[i] ^= t
+	}
+}
+
+// YSquared returns x^3 + 7, the square of the y coordinates of the points at x
+func YSquared(x *FieldElement) *FieldElement {
+	y2 := new(FieldElement).Square(x)
+	y2.Mul(y2, x)
+	return y2.Add(y2, new(FieldElement).SetUint64(7))
+}
+
+// LiftX returns the even y coordinate of the point at x, false when x is not on the curve
+func LiftX(x *FieldElement) (*FieldElement, bool) {
+	y, ok := new(FieldElement).Sqrt(YSquared(x))
+	if y.IsOdd() {
+		y.Neg(y)
+	}
+	return y, ok
+}
@@ -0,0 +1,155 @@
+package secp256k1
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+var (
+	// curveN is the order of the group, as little endian limbs
+	curveN = [4]uint64{0xbfd25e8cd0364141, 0xbaaedce6af48a03b, 0xfffffffffffffffe, 0xffffffffffffffff}
+
+	generator = func() *Point {
+		var x, y FieldElement
+		x.SetBytes([]byte{
+			0x79, 0xbe, 0x66, 0x7e, 0xf9, 0xdc, 0xbb, 0xac, 0x55, 0xa0, 0x62, 0x95, 0xce, 0x87, 0x0b, 0x07,
+			0x02, 0x9b, 0xfc, 0xdb, 0x2d, 0xce, 0x28, 0xd9, 0x59, 0xf2, 0x81, 0x5b, 0x16, 0xf8, 0x17, 0x98,
+		})
+		y.SetBytes([]byte{
+			0x48, 0x3a, 0xda, 0x77, 0x26, 0xa3, 0xc4, 0x65, 0x5d, 0xa4, 0xfb, 0xfc, 0x0e, 0x11, 0x08, 0xa8,
+			0xfd, 0x17, 0xb4, 0x48, 0xa6, 0x85, 0x54, 0x19, 0x9c, 0x47, 0xd0, 0x8f, 0xfb, 0x10, 0xd4, 0xb8,
+		})
+		return NewPoint(&x, &y)
+	}()
+)
+
+// Point is a curve point in projective coordinates (X/Z, Y/Z), the point at
+// infinity being (0, 1, 0). The zero value is not a point.
+type Point struct {
+	x, y, z FieldElement
+}
+
+// NewPoint returns the point of affine coordinates (x, y), which must be on the curve
+func NewPoint(x, y *FieldElement) *Point {
+	p := &Point{x: *x, y: *y}
+	p.z.SetUint64(1)
+	return p
+}
+
+// Generator returns the generator G of the group
+func Generator() *Point {
+	p := *generator
+	return &p
+}
+
+func infinity() *Point {
+	p := &Point{}
+	p.y.SetUint64(1)
+	return p
+}
+
+// Add sets r to p + q with the complete formulas of Renes, Costello and Batina
+// for a = 0 (algorithm 7 of eprint 2015/1060), which have no special case for
+// doubling or the point at infinity.
+func (r *Point) Add(p, q *Point) *Point {
+	var t0, t1, t2, t3, t4, x3, y3, z3 FieldElement
+	b3 := new(FieldElement).SetUint64(3 * 7)
+
+	t0.Mul(&p.x, &q.x)
+	t1.Mul(&p.y, &q.y)
+	t2.Mul(&p.z, &q.z)
+	t3.Add(&p.x, &p.y)
+	t4.Add(&q.x, &q.y)
+	t3.Mul(&t3, &t4)
+	t4.Add(&t0, &t1)
+	t3.Sub(&t3, &t4)
+	t4.Add(&p.y, &p.z)
+	x3.Add(&q.y, &q.z)
+	t4.Mul(&t4, &x3)
+	x3.Add(&t1, &t2)
+	t4.Sub(&t4, &x3)
+	x3.Add(&p.x, &p.z)
+	y3.Add(&q.x, &q.z)
+	x3.Mul(&x3, &y3)
+	y3.Add(&t0, &t2)
+	y3.Sub(&x3, &y3)
+	x3.Add(&t0, &t0)
+	t0.Add(&x3, &t0)
+	t2.Mul(b3, &t2)
+	z3.Add(&t1, &t2)
+	t1.Sub(&t1, &t2)
+	y3.Mul(b3, &y3)
+	x3.Mul(&t4, &y3)
+	t2.Mul(&t3, &t1)
+	x3.Sub(&t2, &x3)
+	y3.Mul(&y3, &t0)
+	t1.Mul(&t1, &z3)
+	y3.Add(&t1, &y3)
+	t0.Mul(&t0, &t3)
+	z3.Mul(&z3, &t4)
+	z3.Add(&z3, &t0)
+
+	r.x, r.y, r.z = x3, y3, z3
+	return r
+}
+
+// swap exchanges p and q when bit is 1, in constant time
+func (p *Point) swap(q *Point, bit uint64) {
+	p.x.swap(&q.x, bit)
+	p.y.swap(&q.y, bit)
+	p.z.swap(&q.z, bit)
+}
+
+// ScalarMult sets r to k times p, k being 32 bytes big endian. The Montgomery
+// ladder goes over the 256 bits of k whatever their values, with the same
+// additions and swaps, so that its timing does not depend on k.
+func (r *Point) ScalarMult(p *Point, k *[32]byte) *Point {
+	r0, r1 := infinity(), *p
+	for i := 0; i < 256; i++ {
+		bit := uint64(k[i/8]>>(7-i%8)) & 1
+		r0.swap(&r1, bit)
+		r1.Add(r0, &r1)
+		r0.Add(r0, r0)
+		r0.swap(&r1, bit)
+	}
+
+	*r = *r0
+	return r
+}
+
+// ScalarBaseMult sets r to k times G
+func (r *Point) ScalarBaseMult(k *[32]byte) *Point {
+	return r.ScalarMult(generator, k)
+}
+
+// IsInfinity reports whether p is the point at infinity
+func (p *Point) IsInfinity() bool {
+	return p.z.IsZero()
+}
+
+// AffineX returns the affine x coordinate of p, false at infinity
+func (p *Point) AffineX() (*FieldElement, bool) {
+	if p.IsInfinity() {
+		return nil, false
+	}
+
+	zinv := new(FieldElement).Inv(&p.z)
+	return zinv.Mul(&p.x, zinv), true
+}
+
+// IsValidScalar reports whether the 32 bytes big endian k is in [1, n), in constant time
+func IsValidScalar(k *[32]byte) bool {
+	var a [4]uint64
+	for i := range a {
+		a[i] = binary.BigEndian.Uint64(k[24-8*i:])
+	}
+
+	var borrow uint64
+	_, borrow = bits.Sub64(a[0], curveN[0], 0)
+	_, borrow = bits.Sub64(a[1], curveN[1], borrow)
+	_, borrow = bits.Sub64(a[2], curveN[2], borrow)
+	_, borrow = bits.Sub64(a[3], curveN[3], borrow)
+
+	nonzero := (a[0] | a[1] | a[2] | a[3] | -(a[0] | a[1] | a[2] | a[3])) >> 63
+	return borrow&nonzero == 1
+}
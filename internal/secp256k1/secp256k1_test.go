@@ -0,0 +1,155 @@
+package secp256k1
+
+import (
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+var bigP, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+
+func toBig(a *FieldElement) *big.Int {
+	b := a.Bytes()
+	return new(big.Int).SetBytes(b[:])
+}
+
+func fromBig(n *big.Int) *FieldElement {
+	var b [32]byte
+	n.FillBytes(b[:])
+	a, _ := new(FieldElement).SetBytes(b[:])
+	return a
+}
+
+func TestField(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := []*big.Int{big.NewInt(0), big.NewInt(1), new(big.Int).Sub(bigP, big.NewInt(1)), new(big.Int).Sub(bigP, big.NewInt(2))}
+	for i := 0; i < 200; i++ {
+		values = append(values, new(big.Int).Rand(rng, bigP))
+	}
+
+	mod := func(n *big.Int) *big.Int { return n.Mod(n, bigP) }
+	for i, x := range values {
+		y := values[(i*7+3)%len(values)]
+		a, b := fromBig(x), fromBig(y)
+
+		checks := []struct {
+			name   string
+			got    *FieldElement
+			expect *big.Int
+		}{
+			{"add", new(FieldElement).Add(a, b), mod(new(big.Int).Add(x, y))},
+			{"sub", new(FieldElement).Sub(a, b), mod(new(big.Int).Sub(x, y))},
+			{"neg", new(FieldElement).Neg(a), mod(new(big.Int).Neg(x))},
+			{"mul", new(FieldElement).Mul(a, b), mod(new(big.Int).Mul(x, y))},
+			{"square", new(FieldElement).Square(a), mod(new(big.Int).Mul(x, x))},
+		}
+		if x.Sign() != 0 {
+			checks = append(checks, struct {
+				name   string
+				got    *FieldElement
+				expect *big.Int
+			}{"inv", new(FieldElement).Inv(a), new(big.Int).ModInverse(x, bigP)})
+		}
+
+		for _, c := range checks {
+			if toBig(c.got).Cmp(c.expect) != 0 {
+				t.Fatalf("%s %x %x: expect %x, got %x", c.name, x, y, c.expect, toBig(c.got))
+			}
+		}
+
+		r, ok := new(FieldElement).Sqrt(a)
+		if expect := new(big.Int).ModSqrt(x, bigP) != nil; ok != expect {
+			t.Fatalf("sqrt %x: expect %v", x, expect)
+		}
+		if ok && toBig(new(FieldElement).Square(r)).Cmp(x) != 0 {
+			t.Fatalf("sqrt %x: bad root %x", x, toBig(r))
+		}
+	}
+
+	// Encodings at or above p are reduced
+	var max [32]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	if a, ok := new(FieldElement).SetBytes(max[:]); ok || toBig(a).Int64() != 0x1000003d0 {
+		t.Fatalf("set bytes: got %x %v", toBig(a), ok)
+	}
+	if _, ok := new(FieldElement).SetBytes(bigP.Bytes()); ok {
+		t.Fatal("set bytes: p accepted")
+	}
+}
+
+func scalar(s string) *[32]byte {
+	var k [32]byte
+	n, _ := new(big.Int).SetString(s, 16)
+	n.FillBytes(k[:])
+	return &k
+}
+
+func affineX(t *testing.T, p *Point) string {
+	t.Helper()
+	x, ok := p.AffineX()
+	if !ok {
+		t.Fatal("point at infinity")
+	}
+	b := x.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+func TestScalarMult(t *testing.T) {
+	tests := []struct {
+		k string
+		x string
+	}{
+		{"1", "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"2", "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"},
+		{"3", "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"},
+		{"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140", "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, test := range tests {
+		if got := affineX(t, new(Point).ScalarBaseMult(scalar(test.k))); got != test.x {
+			t.Fatalf("%s*G: expect %s, got %s", test.k, test.x, got)
+		}
+	}
+
+	if !new(Point).ScalarBaseMult(scalar("0")).IsInfinity() {
+		t.Fatal("0*G: expect infinity")
+	}
+	if !new(Point).ScalarBaseMult(scalar("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")).IsInfinity() {
+		t.Fatal("n*G: expect infinity")
+	}
+
+	// (a*b)*G = a*(b*G) and a*G + b*G = (a+b)*G
+	a, b := "5f3d8a7c1e9b2d4f6a8c0e2b4d6f8a1c3e5b7d9f1a3c5e7b9d1f3a5c7e9b1d3f", "1234567890abcdef1234567890abcdef"
+	bigA, _ := new(big.Int).SetString(a, 16)
+	bigB, _ := new(big.Int).SetString(b, 16)
+	n, _ := new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+
+	ab := new(big.Int).Mod(new(big.Int).Mul(bigA, bigB), n)
+	bG := new(Point).ScalarBaseMult(scalar(b))
+	if affineX(t, new(Point).ScalarMult(bG, scalar(a))) != affineX(t, new(Point).ScalarBaseMult(scalar(ab.Text(16)))) {
+		t.Fatal("scalar mult does not compose")
+	}
+
+	sum := new(big.Int).Mod(new(big.Int).Add(bigA, bigB), n)
+	aG := new(Point).ScalarBaseMult(scalar(a))
+	if affineX(t, new(Point).Add(aG, bG)) != affineX(t, new(Point).ScalarBaseMult(scalar(sum.Text(16)))) {
+		t.Fatal("addition does not match the scalars")
+	}
+}
+
+func TestIsValidScalar(t *testing.T) {
+	tests := map[string]bool{
+		"0": false,
+		"1": true,
+		"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140": true,
+		"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141": false,
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": false,
+	}
+	for k, expect := range tests {
+		if IsValidScalar(scalar(k)) != expect {
+			t.Fatalf("%s: expect %v", k, expect)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package sha3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum256(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		digest string
+	}{
+		{nil, "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{[]byte("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		// More than one block, from the NIST examples
+		{bytes.Repeat([]byte{0xa3}, 200), "79f38adec5c20307a98ef76e8324afbfd46cfd81b22e3973c65fa1bd9de31787"},
+	}
+
+	for _, test := range tests {
+		digest := Sum256(test.data)
+		if hex.EncodeToString(digest[:]) != test.digest {
+			t.Fatalf("sha3-256 of %d bytes: expect %s, got %x", len(test.data), test.digest, digest)
+		}
+	}
+}
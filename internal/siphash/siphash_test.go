@@ -0,0 +1,30 @@
+package siphash
+
+import "testing"
+
+func TestSum64(t *testing.T) {
+	// Key 000102...0f and messages 00 01 02 ... of every length, from the SipHash paper
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	data := make([]byte, 64)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	tests := []struct {
+		n    int
+		hash uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+		{16, 0x3f2acc7f57c29bdb},
+		{63, 0x958a324ceb064572},
+	}
+
+	for _, test := range tests {
+		if hash := Sum64(k0, k1, data[:test.n]); hash != test.hash {
+			t.Fatalf("length %d: expect %016x, got %016x", test.n, test.hash, hash)
+		}
+	}
+}
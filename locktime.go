@@ -0,0 +1,225 @@
+package bcore
+
+import (
+	"errors"
+)
+
+var (
+	ErrLocktimePrevoutsMismatch = errors.New("locktime: prevout heights and times do not match inputs")
+)
+
+const (
+	// LocktimeThreshold is the value below which a locktime is interpreted as a block height,
+	// at or above it as a unix timestamp.
+	LocktimeThreshold = 500000000
+
+	// If this flag is set the sequence number is not interpreted as a relative locktime (BIP68)
+	SequenceLocktimeDisableFlag = 1 << 31
+	// If this flag is set the relative locktime is in units of 512 seconds, otherwise in blocks
+	SequenceLocktimeTypeFlag = 1 << 22
+	// The mask extracting the relative locktime value from the sequence number
+	SequenceLocktimeMask = 0x0000ffff
+	// Time based relative locktimes are shifted by this many bits, a granularity of 512 seconds
+	SequenceLocktimeGranularity = 9
+
+	// MaxBIP125RBFSequence is the highest sequence number which still signals replaceability
+	MaxBIP125RBFSequence = 0xfffffffd
+)
+
+// LocktimeType describes the unit a locktime is expressed in
+type LocktimeType int
+
+const (
+	LocktimeTypeHeight LocktimeType = iota
+	LocktimeTypeTime
+)
+
+func (lt LocktimeType) String() string {
+	switch lt {
+	case LocktimeTypeHeight:
+		return "height"
+	case LocktimeTypeTime:
+		return "time"
+	}
+
+	return "unknown"
+}
+
+// RelativeLocktime is the BIP68 interpretation of a TransactionInput.Sequence
+type RelativeLocktime struct {
+	// The sequence number does not encode a relative locktime
+	Disabled bool
+	// The unit of Value
+	Type LocktimeType
+	// Number of blocks, or number of 512 seconds intervals
+	Value uint32
+}
+
+// Blocks returns the number of blocks the input is locked for, zero for time based locks.
+func (rl *RelativeLocktime) Blocks() uint32 {
+	if rl.Disabled || rl.Type != LocktimeTypeHeight {
+		return 0
+	}
+
+	return rl.Value
+}
+
+// Seconds returns the number of seconds the input is locked for, zero for height based locks.
+func (rl *RelativeLocktime) Seconds() uint32 {
+	if rl.Disabled || rl.Type != LocktimeTypeTime {
+		return 0
+	}
+
+	return rl.Value << SequenceLocktimeGranularity
+}
+
+// SequenceLock is the minimal height and median time past a transaction's inputs are locked to.
+// A value of -1 means that no constraint applies.
+type SequenceLock struct {
+	MinHeight int64
+	MinTime   int64
+}
+
+// Evaluate reports whether a block at height whose previous block has
+// median time past mtp satisfies the lock.
+func (sl *SequenceLock) Evaluate(height, mtp uint32) bool {
+	return sl.MinHeight < int64(height) && sl.MinTime < int64(mtp)
+}
+
+// LocktimeType returns whether Locktime is a block height or a unix timestamp.
+func (t *Transaction) LocktimeType() LocktimeType {
+	if t.Locktime < LocktimeThreshold {
+		return LocktimeTypeHeight
+	}
+
+	return LocktimeTypeTime
+}
+
+// LocktimeHeight returns the locktime as a block height, ok is false for timestamps.
+func (t *Transaction) LocktimeHeight() (height uint32, ok bool) {
+	if t.LocktimeType() != LocktimeTypeHeight {
+		return 0, false
+	}
+
+	return t.Locktime, true
+}
+
+// LocktimeTime returns the locktime as a unix timestamp, ok is false for block heights.
+func (t *Transaction) LocktimeTime() (time uint32, ok bool) {
+	if t.LocktimeType() != LocktimeTypeTime {
+		return 0, false
+	}
+
+	return t.Locktime, true
+}
+
+// IsFinal reports whether the transaction may be included in a block at height
+// whose median time past is mtp, following IsFinalTx in Bitcoin Core.
+func (t *Transaction) IsFinal(height, mtp uint32) bool {
+	if t.Locktime == 0 {
+		return true
+	}
+
+	limit := height
+	if t.LocktimeType() == LocktimeTypeTime {
+		limit = mtp
+	}
+
+	if t.Locktime < limit {
+		return true
+	}
+
+	for _, input := range t.Inputs {
+		if !input.IsFinal() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SignalsReplacement reports whether any input opts in to replace-by-fee (BIP125).
+func (t *Transaction) SignalsReplacement() bool {
+	for _, input := range t.Inputs {
+		if input.SignalsReplacement() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CalculateSequenceLocks computes the BIP68 lock of the transaction.
+// prevHeights holds the height of the block containing each spent output and prevMTPs
+// the median time past of the block preceding it, both indexed like Inputs.
+func (t *Transaction) CalculateSequenceLocks(prevHeights, prevMTPs []uint32) (*SequenceLock, error) {
+	if len(prevHeights) != len(t.Inputs) || len(prevMTPs) != len(t.Inputs) {
+		return nil, ErrLocktimePrevoutsMismatch
+	}
+
+	lock := &SequenceLock{
+		MinHeight: -1,
+		MinTime:   -1,
+	}
+
+	// BIP68 is only enforced for version 2 transactions and above
+	if t.Version < 2 {
+		return lock, nil
+	}
+
+	for i, input := range t.Inputs {
+		rl := input.RelativeLocktime()
+		if rl.Disabled {
+			continue
+		}
+
+		switch rl.Type {
+		case LocktimeTypeTime:
+			minTime := int64(prevMTPs[i]) + int64(rl.Seconds()) - 1
+			if minTime > lock.MinTime {
+				lock.MinTime = minTime
+			}
+
+		case LocktimeTypeHeight:
+			minHeight := int64(prevHeights[i]) + int64(rl.Blocks()) - 1
+			if minHeight > lock.MinHeight {
+				lock.MinHeight = minHeight
+			}
+		}
+	}
+
+	return lock, nil
+}
+
+// CheckSequenceLocks reports whether the transaction's relative locktimes are satisfied
+// in a block at height whose previous block has median time past mtp.
+func (t *Transaction) CheckSequenceLocks(prevHeights, prevMTPs []uint32, height, mtp uint32) (bool, error) {
+	lock, err := t.CalculateSequenceLocks(prevHeights, prevMTPs)
+	if err != nil {
+		return false, err
+	}
+
+	return lock.Evaluate(height, mtp), nil
+}
+
+// RelativeLocktime interprets the sequence number as a BIP68 relative locktime.
+func (ti *TransactionInput) RelativeLocktime() *RelativeLocktime {
+	if ti.Sequence&SequenceLocktimeDisableFlag != 0 {
+		return &RelativeLocktime{Disabled: true}
+	}
+
+	lt := LocktimeTypeHeight
+	if ti.Sequence&SequenceLocktimeTypeFlag != 0 {
+		lt = LocktimeTypeTime
+	}
+
+	return &RelativeLocktime{
+		Type:  lt,
+		Value: ti.Sequence & SequenceLocktimeMask,
+	}
+}
+
+// SignalsReplacement reports whether the sequence number opts in to replace-by-fee (BIP125).
+func (ti *TransactionInput) SignalsReplacement() bool {
+	return ti.Sequence <= MaxBIP125RBFSequence
+}
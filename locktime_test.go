@@ -0,0 +1,141 @@
+package bcore
+
+import (
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func newLocktimeTransaction(version, locktime uint32, sequences ...uint32) *Transaction {
+	inputs := make([]*TransactionInput, len(sequences))
+	for i, sequence := range sequences {
+		inputs[i] = &TransactionInput{
+			PrevOutput: NewOutPoint(HashZero, uint32(i)),
+			Sequence:   sequence,
+		}
+	}
+
+	return &Transaction{
+		Version:  version,
+		Inputs:   inputs,
+		Locktime: locktime,
+	}
+}
+
+func TestTransactionLocktimeType(t *testing.T) {
+	tx := newLocktimeTransaction(1, 499999999)
+	if height, ok := tx.LocktimeHeight(); !ok || height != 499999999 {
+		t.Fatalf("locktime height: got %d %v", height, ok)
+	}
+
+	if _, ok := tx.LocktimeTime(); ok {
+		t.Fatalf("locktime should not be a time")
+	}
+
+	tx.Locktime = LocktimeThreshold
+	if tx.LocktimeType() != LocktimeTypeTime {
+		t.Fatalf("locktime type: got %s", tx.LocktimeType())
+	}
+
+	if time, ok := tx.LocktimeTime(); !ok || time != LocktimeThreshold {
+		t.Fatalf("locktime time: got %d %v", time, ok)
+	}
+}
+
+func TestTransactionIsFinal(t *testing.T) {
+	tests := []struct {
+		locktime uint32
+		sequence uint32
+		height   uint32
+		mtp      uint32
+		expect   bool
+	}{
+		{0, 0, 100, 1600000000, true},
+		{99, 0, 100, 1600000000, true},
+		{100, 0, 100, 1600000000, false},
+		{100, TransactionFinalSequence, 100, 1600000000, true},
+		{1599999999, 0, 100, 1600000000, true},
+		{1600000000, 0, 100, 1600000000, false},
+	}
+
+	for i, test := range tests {
+		tx := newLocktimeTransaction(1, test.locktime, test.sequence)
+		if got := tx.IsFinal(test.height, test.mtp); got != test.expect {
+			t.Fatalf("test %d: expect %v, got %v", i, test.expect, got)
+		}
+	}
+}
+
+func TestTransactionInputRelativeLocktime(t *testing.T) {
+	in := &TransactionInput{Sequence: SequenceLocktimeDisableFlag | 10}
+	if !in.RelativeLocktime().Disabled {
+		t.Fatalf("relative locktime should be disabled")
+	}
+
+	in.Sequence = 10
+	rl := in.RelativeLocktime()
+	if rl.Disabled || rl.Type != LocktimeTypeHeight || rl.Blocks() != 10 || rl.Seconds() != 0 {
+		t.Fatalf("relative locktime: got %+v", rl)
+	}
+
+	in.Sequence = SequenceLocktimeTypeFlag | 0x00ff0003
+	rl = in.RelativeLocktime()
+	if rl.Type != LocktimeTypeTime || rl.Value != 3 || rl.Seconds() != 1536 || rl.Blocks() != 0 {
+		t.Fatalf("relative locktime: got %+v", rl)
+	}
+}
+
+func TestTransactionSignalsReplacement(t *testing.T) {
+	if newLocktimeTransaction(2, 0, TransactionFinalSequence, TransactionFinalSequence-1).SignalsReplacement() {
+		t.Fatalf("should not signal replacement")
+	}
+
+	if !newLocktimeTransaction(2, 0, TransactionFinalSequence, MaxBIP125RBFSequence).SignalsReplacement() {
+		t.Fatalf("should signal replacement")
+	}
+}
+
+func TestTransactionCheckSequenceLocks(t *testing.T) {
+	tx := newLocktimeTransaction(2, 0, 10, SequenceLocktimeTypeFlag|2, SequenceLocktimeDisableFlag|0xffff)
+	prevHeights := []uint32{100, 105, 200}
+	prevMTPs := []uint32{1600000000, 1600001000, 1600002000}
+
+	lock, err := tx.CalculateSequenceLocks(prevHeights, prevMTPs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lock.MinHeight != 109 || lock.MinTime != 1600001000+1024-1 {
+		t.Fatalf("sequence lock: got %+v", lock)
+	}
+
+	tests := []struct {
+		height uint32
+		mtp    uint32
+		expect bool
+	}{
+		{109, 1600002024, false},
+		{110, 1600002023, false},
+		{110, 1600002024, true},
+	}
+
+	for _, test := range tests {
+		ok, err := tx.CheckSequenceLocks(prevHeights, prevMTPs, test.height, test.mtp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != test.expect {
+			t.Fatalf("height %d mtp %d: expect %v, got %v", test.height, test.mtp, test.expect, ok)
+		}
+	}
+
+	tx.Version = 1
+	if ok, _ := tx.CheckSequenceLocks(prevHeights, prevMTPs, 0, 0); !ok {
+		t.Fatalf("version 1 transaction should not be sequence locked")
+	}
+
+	if _, err := tx.CheckSequenceLocks(prevHeights[:1], prevMTPs, 0, 0); err != ErrLocktimePrevoutsMismatch {
+		t.Fatalf("expect mismatch error, got %v", err)
+	}
+}
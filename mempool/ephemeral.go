@@ -0,0 +1,118 @@
+package mempool
+
+import (
+	"bytes"
+	"errors"
+
+	bcore "github.com/detailyang/go-bcore"
+)
+
+var (
+	ErrTxDust                 = errors.New("mempool: too many dust outputs")
+	ErrEphemeralDustFee       = errors.New("mempool: transaction with dust output pays a fee")
+	ErrMissingEphemeralSpends = errors.New("mempool: dust output of parent not spent")
+	ErrAnchorSpendNotEmpty    = errors.New("mempool: pay to anchor spent with script or witness")
+)
+
+const (
+	// DefaultDustRelayFeeRate is the default feerate at which outputs worth less than
+	// their spending are dust, in sat/vB
+	DefaultDustRelayFeeRate = 3.0
+	// MaxDustOutputs is the maximal number of dust outputs of a transaction, which may
+	// only be ephemeral: in a transaction paying no fee, spent by its child
+	MaxDustOutputs = 1
+
+	// Sizes of an input spending an output besides the output itself, with a
+	// witness program or not
+	witnessInputSize = 32 + 4 + 1 + 107/bcore.WitnessScaleFactor + 4
+	inputSize        = 32 + 4 + 1 + 107 + 4
+)
+
+// PayToAnchorScript is the keyless witness program of version 1 spent without witness, P2A
+var PayToAnchorScript = []byte{0x51, 0x02, 0x4e, 0x73}
+
+// IsPayToAnchor reports whether script is PayToAnchorScript
+func IsPayToAnchor(script []byte) bool {
+	return bytes.Equal(script, PayToAnchorScript)
+}
+
+// isWitnessProgram reports whether script is a version byte and a push of 2 to 40 bytes, BIP141
+func isWitnessProgram(script []byte) bool {
+	if len(script) < 4 || len(script) > 42 {
+		return false
+	}
+	if script[0] != 0 && (script[0] < 0x51 || script[0] > 0x60) {
+		return false
+	}
+	return int(script[1])+2 == len(script)
+}
+
+// DustThreshold returns the value below which output is dust, spending it costing
+// more than its value at rate
+func DustThreshold(output *bcore.TransactionOutput, rate float64) uint64 {
+	if output.IsUnspendable() {
+		return 0
+	}
+
+	size := len(output.Bytes())
+	if isWitnessProgram(output.ScriptPubkey) {
+		size += witnessInputSize
+	} else {
+		size += inputSize
+	}
+	return Fee(rate, size)
+}
+
+// dustOutputs returns the indexes of the dust outputs of tx
+func (m *Mempool) dustOutputs(tx *bcore.Transaction) []uint32 {
+	var dust []uint32
+	for i, output := range tx.Outputs {
+		if output.Value < DustThreshold(output, m.cfg.DustRelayFeeRate) {
+			dust = append(dust, uint32(i))
+		}
+	}
+	return dust
+}
+
+func (m *Mempool) checkDust(e *Entry) error {
+	dust := m.dustOutputs(e.Tx)
+	if len(dust) > MaxDustOutputs {
+		return ErrTxDust
+	}
+	if len(dust) > 0 && e.Fee != 0 {
+		return ErrEphemeralDustFee
+	}
+	return nil
+}
+
+// checkAnchorSpends requires the inputs of e spending anchors to be empty
+func (m *Mempool) checkAnchorSpends(e *Entry) error {
+	for _, input := range e.Tx.Inputs {
+		prevout, err := poolView{m: m}.FetchPrevout(input.PrevOutput)
+		if err != nil {
+			return err
+		}
+
+		if IsPayToAnchor(prevout.ScriptPubkey) && (len(input.ScriptSig) > 0 || len(input.ScriptWitness) > 0) {
+			return ErrAnchorSpendNotEmpty
+		}
+	}
+	return nil
+}
+
+// checkEphemeralSpends requires e to spend the dust outputs of its in-mempool parents
+func (m *Mempool) checkEphemeralSpends(e *Entry) error {
+	spent := make(map[bcore.OutPoint]bool)
+	for _, input := range e.Tx.Inputs {
+		spent[*input.PrevOutput] = true
+	}
+
+	for _, parent := range e.parents {
+		for _, i := range m.dustOutputs(parent.Tx) {
+			if !spent[bcore.OutPoint{Hash: parent.Txid, Index: i}] {
+				return ErrMissingEphemeralSpends
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package mempool
+
+import (
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+)
+
+func TestDustThreshold(t *testing.T) {
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, make([]byte, 20)...), 0x88, 0xac)
+
+	tests := []struct {
+		script    []byte
+		threshold uint64
+	}{
+		{p2wpkh, 294},
+		{p2pkh, 546},
+		{PayToAnchorScript, 240},
+		{[]byte{0x6a, 0x01, 0x01}, 0},
+	}
+	for _, test := range tests {
+		output := &bcore.TransactionOutput{ScriptPubkey: test.script}
+		if threshold := DustThreshold(output, DefaultDustRelayFeeRate); threshold != test.threshold {
+			t.Fatalf("%x: expect %d, got %d", test.script, test.threshold, threshold)
+		}
+	}
+
+	if !IsPayToAnchor(PayToAnchorScript) || IsPayToAnchor(p2wpkh) {
+		t.Fatal("unexpected pay to anchor")
+	}
+}
+
+// newTestAnchorTx is a TRUC transaction paying no fee with an ephemeral anchor
+func newTestAnchorTx(input *bcore.OutPoint) *bcore.Transaction {
+	tx := newTestTRUCTx([]*bcore.OutPoint{input}, testCoinValue)
+	tx.Outputs = append(tx.Outputs, &bcore.TransactionOutput{ScriptPubkey: PayToAnchorScript})
+	return tx
+}
+
+// newTestAnchorSpend spends the anchor of parent and input into one output
+func newTestAnchorSpend(parent *bcore.Transaction, input *bcore.OutPoint, value uint64) *bcore.Transaction {
+	tx := newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 1), input}, value)
+	tx.Inputs[0].ScriptSig = nil
+	return tx
+}
+
+func TestEphemeralDust(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	tests := []struct {
+		tx  *bcore.Transaction
+		err error
+	}{
+		{newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000, 0, 0), ErrTxDust},
+		{newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000, 0), ErrEphemeralDustFee},
+		{newTestAnchorTx(testCoin(1)), ErrTxFeeTooLow},
+	}
+	for i, test := range tests {
+		if _, err := m.Add(test.tx); err != test.err {
+			t.Fatalf("%d: expect %v, got %v", i, test.err, err)
+		}
+	}
+
+	// The child has to spend the dust of its parent
+	parent := newTestAnchorTx(testCoin(1))
+	if _, err := m.AddPackage(parent, newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 0)}, testCoinValue-5000)); err != ErrMissingEphemeralSpends {
+		t.Fatalf("expect %v, got %v", ErrMissingEphemeralSpends, err)
+	}
+	if m.Len() != 0 {
+		t.Fatal("parent left in the mempool")
+	}
+
+	// Anchors are spent with an empty input
+	child := newTestAnchorSpend(parent, testCoin(2), testCoinValue-5000)
+	child.Inputs[0].ScriptWitness = [][]byte{{1}}
+	if _, err := m.AddPackage(parent, child); err != ErrAnchorSpendNotEmpty {
+		t.Fatalf("expect %v, got %v", ErrAnchorSpendNotEmpty, err)
+	}
+
+	entries, err := m.AddPackage(parent, newTestAnchorSpend(parent, testCoin(2), testCoinValue-5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Fee != 0 || entries[1].Fee != 5000 || m.Len() != 2 {
+		t.Fatalf("unexpected package %v", entries)
+	}
+
+	// A replacement of the child spends the dust too
+	if _, err := m.Add(newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 0)}, testCoinValue-50000)); err != ErrMissingEphemeralSpends {
+		t.Fatalf("expect %v, got %v", ErrMissingEphemeralSpends, err)
+	}
+	mustAdd(t, m, newTestAnchorSpend(parent, testCoin(3), testCoinValue-20000))
+	if m.Len() != 2 {
+		t.Fatalf("expect 2 transactions, got %d", m.Len())
+	}
+}
@@ -0,0 +1,826 @@
+// Package mempool keeps unconfirmed transactions spending the UTXO set or each other,
+// tracking for each one the aggregates of its in-mempool ancestors and descendants
+// as Bitcoin Core does, to enforce package limits and evict by feerate.
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrTxDuplicate         = errors.New("mempool: transaction already in mempool")
+	ErrTxCoinbase          = errors.New("mempool: coinbase transaction")
+	ErrTxEmpty             = errors.New("mempool: no inputs or no outputs")
+	ErrTxNullPrevout       = errors.New("mempool: null prevout")
+	ErrTxDuplicateInputs   = errors.New("mempool: duplicate inputs")
+	ErrTxOutputRange       = errors.New("mempool: output value out of range")
+	ErrTxOutputsRange      = errors.New("mempool: total output value out of range")
+	ErrTxTooLarge          = errors.New("mempool: transaction weight too large")
+	ErrTxMissingInputs     = errors.New("mempool: missing inputs")
+	ErrTxFeeTooLow         = errors.New("mempool: feerate below minimum")
+	ErrTooManyAncestors    = errors.New("mempool: too many unconfirmed ancestors")
+	ErrAncestorsTooLarge   = errors.New("mempool: unconfirmed ancestors too large")
+	ErrTooManyDescendants  = errors.New("mempool: too many unconfirmed descendants")
+	ErrDescendantsTooLarge = errors.New("mempool: unconfirmed descendants too large")
+	ErrMempoolFull         = errors.New("mempool: full")
+	ErrPackageNotChild     = errors.New("mempool: package child does not spend its parent")
+	ErrPackageConflict     = errors.New("mempool: package parent conflicts with mempool transactions")
+)
+
+const (
+	// MaxStandardTxWeight is the maximal weight of a relayed transaction
+	MaxStandardTxWeight = 400000
+
+	// DefaultMaxSize is the default memory budget of a Mempool
+	DefaultMaxSize = 300 << 20
+	// DefaultAncestorLimit is the default maximal number of in-mempool ancestors of a
+	// transaction, itself included
+	DefaultAncestorLimit = 25
+	// DefaultAncestorSizeLimit is the default maximal virtual size of a transaction
+	// with its in-mempool ancestors
+	DefaultAncestorSizeLimit = 101000
+	// DefaultDescendantLimit is the default maximal number of in-mempool descendants
+	// of a transaction, itself included
+	DefaultDescendantLimit = 25
+	// DefaultDescendantSizeLimit is the default maximal virtual size of a transaction
+	// with its in-mempool descendants
+	DefaultDescendantSizeLimit = 101000
+	// DefaultMinRelayFeeRate is the default minimal feerate of accepted transactions, in sat/vB
+	DefaultMinRelayFeeRate = 1.0
+	// DefaultIncrementalRelayFeeRate is the default feerate added to the minimal
+	// feerate when evicting, in sat/vB
+	DefaultIncrementalRelayFeeRate = 1.0
+
+	// RollingFeeHalfLife is the time the minimal feerate raised by evictions takes to halve
+	RollingFeeHalfLife = 12 * time.Hour
+
+	// Estimated memory used by an entry besides its transaction
+	entryOverhead = 400
+)
+
+// Config holds the settings of a Mempool, zero fields take their default
+type Config struct {
+	// Memory budget, in bytes
+	MaxSize             int
+	AncestorLimit       int
+	AncestorSizeLimit   int
+	DescendantLimit     int
+	DescendantSizeLimit int
+	// In sat/vB
+	MinRelayFeeRate         float64
+	IncrementalRelayFeeRate float64
+	// Feerate below which spending an output costs more than its value
+	DustRelayFeeRate float64
+	// Whether transactions not signaling BIP125 may be replaced
+	FullRBF bool
+}
+
+func (cfg Config) withDefaults() *Config {
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	if cfg.AncestorLimit == 0 {
+		cfg.AncestorLimit = DefaultAncestorLimit
+	}
+	if cfg.AncestorSizeLimit == 0 {
+		cfg.AncestorSizeLimit = DefaultAncestorSizeLimit
+	}
+	if cfg.DescendantLimit == 0 {
+		cfg.DescendantLimit = DefaultDescendantLimit
+	}
+	if cfg.DescendantSizeLimit == 0 {
+		cfg.DescendantSizeLimit = DefaultDescendantSizeLimit
+	}
+	if cfg.MinRelayFeeRate == 0 {
+		cfg.MinRelayFeeRate = DefaultMinRelayFeeRate
+	}
+	if cfg.IncrementalRelayFeeRate == 0 {
+		cfg.IncrementalRelayFeeRate = DefaultIncrementalRelayFeeRate
+	}
+	if cfg.DustRelayFeeRate == 0 {
+		cfg.DustRelayFeeRate = DefaultDustRelayFeeRate
+	}
+	return &cfg
+}
+
+// FeeRate returns fee per virtual byte of size, in sat/vB
+func FeeRate(fee uint64, size int) float64 {
+	return float64(fee) / float64(size)
+}
+
+// Fee returns the fee paying rate for size, rounded up
+func Fee(rate float64, size int) uint64 {
+	return uint64(math.Ceil(rate * float64(size)))
+}
+
+// Aggregate sums a set of transactions
+type Aggregate struct {
+	Count int
+	// Virtual size
+	Size int
+	Fees uint64
+}
+
+// FeeRate returns the feerate of the set, in sat/vB
+func (a Aggregate) FeeRate() float64 { return FeeRate(a.Fees, a.Size) }
+
+func (a *Aggregate) add(e *Entry) {
+	a.Count++
+	a.Size += e.Size
+	a.Fees += e.Fee
+}
+
+func (a *Aggregate) sub(e *Entry) {
+	a.Count--
+	a.Size -= e.Size
+	a.Fees -= e.Fee
+}
+
+// Entry is a transaction of the mempool
+type Entry struct {
+	Tx    *bcore.Transaction
+	Txid  Hash
+	Wtxid Hash
+	Fee   uint64
+	// Virtual size
+	Size int
+	// Time the transaction entered the mempool
+	Time time.Time
+
+	pool     *Mempool
+	parents  map[Hash]*Entry
+	children map[Hash]*Entry
+	// Both include the entry itself
+	ancestors   Aggregate
+	descendants Aggregate
+}
+
+// FeeRate returns the feerate of the transaction alone, in sat/vB
+func (e *Entry) FeeRate() float64 { return FeeRate(e.Fee, e.Size) }
+
+// Ancestors returns the aggregate of the transaction with its in-mempool ancestors
+func (e *Entry) Ancestors() Aggregate {
+	e.pool.mu.RLock()
+	defer e.pool.mu.RUnlock()
+	return e.ancestors
+}
+
+// Descendants returns the aggregate of the transaction with its in-mempool descendants
+func (e *Entry) Descendants() Aggregate {
+	e.pool.mu.RLock()
+	defer e.pool.mu.RUnlock()
+	return e.descendants
+}
+
+// Parents returns the in-mempool transactions the transaction spends
+func (e *Entry) Parents() []*Entry {
+	e.pool.mu.RLock()
+	defer e.pool.mu.RUnlock()
+	return entryList(e.parents)
+}
+
+// Children returns the in-mempool transactions spending the transaction
+func (e *Entry) Children() []*Entry {
+	e.pool.mu.RLock()
+	defer e.pool.mu.RUnlock()
+	return entryList(e.children)
+}
+
+// descendantScore orders evictions, the lowest of the feerates of the transaction
+// alone and with its descendants going first
+func (e *Entry) descendantScore() float64 {
+	return math.Max(e.FeeRate(), e.descendants.FeeRate())
+}
+
+func (e *Entry) usage() int {
+	return entryOverhead + len(e.Tx.BytesWithWitness())
+}
+
+func entryList(entries map[Hash]*Entry) []*Entry {
+	list := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	return list
+}
+
+// Mempool holds unconfirmed transactions spending outputs of the view, typically the
+// UTXO set at the chain tip, or of each other. When it uses more than its memory
+// budget, the transactions with the lowest descendant score are evicted with their
+// descendants, and the minimal feerate is raised above theirs, decaying afterwards.
+//
+// Heights are not tracked: the view only holds outputs the next block may spend, so
+// immature coinbase outputs are left out of it, and callers check finality and the
+// BIP68 sequence locks of transactions against the chain before adding them.
+type Mempool struct {
+	mu      sync.RWMutex
+	cfg     *Config
+	view    bcore.PrevoutFetcher
+	now     func() time.Time
+	usage   int
+	entries map[Hash]*Entry
+	wtxids  map[Hash]*Entry
+	// The mempool transaction spending each outpoint
+	spends map[bcore.OutPoint]*Entry
+
+	// Minimal feerate raised by evictions, and when it last decayed
+	rollingMinFeeRate float64
+	lastRollingUpdate time.Time
+	// Whether a block came since the last eviction, the rolling feerate decaying only then
+	blockSinceBump bool
+}
+
+func NewMempool(view bcore.PrevoutFetcher, cfg *Config) *Mempool {
+	return &Mempool{
+		cfg:     cfg.withDefaults(),
+		view:    view,
+		now:     time.Now,
+		entries: make(map[Hash]*Entry),
+		wtxids:  make(map[Hash]*Entry),
+		spends:  make(map[bcore.OutPoint]*Entry),
+	}
+}
+
+// Len returns the number of transactions
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// Usage returns the estimated memory used by the transactions
+func (m *Mempool) Usage() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usage
+}
+
+// Get returns the entry of txid, nil if it is not in the mempool
+func (m *Mempool) Get(txid Hash) *Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.entries[txid]
+}
+
+// GetByWtxid returns the entry of wtxid, nil if it is not in the mempool
+func (m *Mempool) GetByWtxid(wtxid Hash) *Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.wtxids[wtxid]
+}
+
+// Spender returns the mempool transaction spending op, nil if there is none
+func (m *Mempool) Spender(op *bcore.OutPoint) *Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.spends[*op]
+}
+
+// Entries returns every transaction, in no particular order
+func (m *Mempool) Entries() []*Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return entryList(m.entries)
+}
+
+// MinFeeRate returns the minimal feerate of accepted transactions, in sat/vB
+func (m *Mempool) MinFeeRate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.minFeeRate()
+}
+
+// minFeeRate decays the rolling feerate, faster when the mempool is mostly empty
+func (m *Mempool) minFeeRate() float64 {
+	if m.blockSinceBump && m.rollingMinFeeRate > 0 {
+		now := m.now()
+		halfLife := RollingFeeHalfLife
+		if m.usage < m.cfg.MaxSize/4 {
+			halfLife /= 4
+		} else if m.usage < m.cfg.MaxSize/2 {
+			halfLife /= 2
+		}
+
+		m.rollingMinFeeRate /= math.Pow(2, float64(now.Sub(m.lastRollingUpdate))/float64(halfLife))
+		m.lastRollingUpdate = now
+		if m.rollingMinFeeRate < m.cfg.IncrementalRelayFeeRate/2 {
+			m.rollingMinFeeRate = 0
+		}
+	}
+
+	return math.Max(m.rollingMinFeeRate, m.cfg.MinRelayFeeRate)
+}
+
+// poolView resolves outputs of mempool transactions, and of a package parent not
+// inserted yet, before asking the view
+type poolView struct {
+	m       *Mempool
+	outputs bcore.MapPrevoutFetcher
+}
+
+func (v poolView) FetchPrevout(op *bcore.OutPoint) (*bcore.TransactionOutput, error) {
+	if output, err := v.outputs.FetchPrevout(op); err == nil {
+		return output, nil
+	}
+
+	if e, ok := v.m.entries[op.Hash]; ok {
+		if int(op.Index) >= len(e.Tx.Outputs) {
+			return nil, bcore.ErrPrevoutMissing
+		}
+		return e.Tx.Outputs[op.Index], nil
+	}
+
+	return v.m.view.FetchPrevout(op)
+}
+
+// Add accepts tx, spending outputs of the view or of mempool transactions, and
+// returns its entry. Transactions spending the same outputs are replaced when the
+// replacement rules allow it, the failed rule being returned otherwise.
+func (m *Mempool) Add(tx *bcore.Transaction) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.add(tx)
+}
+
+func (m *Mempool) add(tx *bcore.Transaction) (*Entry, error) {
+	e, err := m.newEntry(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.checkFeeRate(e); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.accept(e); err != nil {
+		return nil, err
+	}
+
+	m.trim()
+	if _, ok := m.entries[e.Txid]; !ok {
+		return nil, ErrMempoolFull
+	}
+	return e, nil
+}
+
+// AddPackage accepts child with its parent, which may pay less than the minimal
+// feerate when the package pays it, even less than the minimal relay feerate when
+// TRUC. A parent in the mempool already leaves the child to pay for itself. The
+// parent may not replace mempool transactions besides its TRUC sibling, and is
+// removed again when the child is refused, the sibling coming back.
+func (m *Mempool) AddPackage(parent, child *bcore.Transaction) ([]*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	txid := parent.Hash()
+	spends := false
+	for _, input := range child.Inputs {
+		spends = spends || input.PrevOutput.Hash == txid
+	}
+	if !spends {
+		return nil, ErrPackageNotChild
+	}
+
+	if p, ok := m.entries[txid]; ok {
+		c, err := m.add(child)
+		if err != nil {
+			return nil, err
+		}
+		return []*Entry{p, c}, nil
+	}
+
+	p, err := m.newEntry(parent, nil)
+	if err != nil {
+		return nil, err
+	}
+	outputs := bcore.NewMapPrevoutFetcher()
+	outputs.AddTransaction(parent)
+	c, err := m.newEntry(child, outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Fee < Fee(m.cfg.MinRelayFeeRate, p.Size) && parent.Version != TRUCVersion {
+		return nil, ErrTxFeeTooLow
+	}
+	if p.Fee+c.Fee < Fee(m.minFeeRate(), p.Size+c.Size) {
+		return nil, ErrTxFeeTooLow
+	}
+	for _, input := range parent.Inputs {
+		if _, ok := m.spends[*input.PrevOutput]; ok {
+			return nil, ErrPackageConflict
+		}
+	}
+
+	evicted, err := m.accept(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// The child entry again, linked to its parent
+	c, err = m.newEntry(child, nil)
+	if err == nil {
+		_, err = m.accept(c)
+	}
+	if err != nil {
+		m.removeSet(map[Hash]*Entry{p.Txid: p})
+		m.restore(evicted)
+		return nil, err
+	}
+
+	m.trim()
+	if m.entries[p.Txid] == nil || m.entries[c.Txid] == nil {
+		return nil, ErrMempoolFull
+	}
+	return []*Entry{p, c}, nil
+}
+
+// accept checks the policy rules of e, its feerate aside, and inserts it in place
+// of the transactions it replaces, which it returns
+func (m *Mempool) accept(e *Entry) ([]*Entry, error) {
+	if err := m.checkDust(e); err != nil {
+		return nil, err
+	}
+	if err := m.checkAnchorSpends(e); err != nil {
+		return nil, err
+	}
+
+	r, err := m.checkReplacement(e)
+	if err != nil {
+		return nil, err
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	ancestors, err := m.checkLimits(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkEphemeralSpends(e); err != nil {
+		return nil, err
+	}
+
+	evicted := make(map[Hash]*Entry)
+	for _, ev := range r.Evicted {
+		evicted[ev.Txid] = ev
+	}
+	m.removeSet(evicted)
+	m.insert(e, ancestors)
+	return r.Evicted, nil
+}
+
+// restore inserts again the entries a removed transaction replaced, ancestors first
+func (m *Mempool) restore(evicted []*Entry) {
+	sort.Slice(evicted, func(i, j int) bool {
+		return evicted[i].ancestors.Count < evicted[j].ancestors.Count
+	})
+
+	for _, e := range evicted {
+		e.parents = make(map[Hash]*Entry)
+		e.children = make(map[Hash]*Entry)
+		e.ancestors, e.descendants = Aggregate{}, Aggregate{}
+		for _, input := range e.Tx.Inputs {
+			if parent, ok := m.entries[input.PrevOutput.Hash]; ok {
+				e.parents[parent.Txid] = parent
+			}
+		}
+		m.insert(e, m.ancestorsOf(e.parents))
+	}
+}
+
+// checkTransaction fails transactions invalid whatever outputs they spend, as
+// CheckTransaction of Bitcoin Core does
+func checkTransaction(tx *bcore.Transaction) error {
+	if tx.IsEmpty() {
+		return ErrTxEmpty
+	}
+	if tx.IsNull() {
+		return ErrTxNullPrevout
+	}
+
+	spent := make(map[bcore.OutPoint]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		if spent[*input.PrevOutput] {
+			return ErrTxDuplicateInputs
+		}
+		spent[*input.PrevOutput] = true
+	}
+
+	sum := uint64(0)
+	for _, output := range tx.Outputs {
+		if output.Value > bcore.MaxMoney {
+			return ErrTxOutputRange
+		}
+		if sum+output.Value > bcore.MaxMoney {
+			return ErrTxOutputsRange
+		}
+		sum += output.Value
+	}
+	return nil
+}
+
+// newEntry checks tx alone and returns its entry, linked to its in-mempool parents.
+// outputs are those of a package parent not in the mempool yet, nil otherwise.
+func (m *Mempool) newEntry(tx *bcore.Transaction, outputs bcore.MapPrevoutFetcher) (*Entry, error) {
+	if tx.IsCoinbase() {
+		return nil, ErrTxCoinbase
+	}
+	if err := checkTransaction(tx); err != nil {
+		return nil, err
+	}
+	if tx.Weight() > MaxStandardTxWeight {
+		return nil, ErrTxTooLarge
+	}
+
+	txid := tx.Hash()
+	if _, ok := m.entries[txid]; ok {
+		return nil, ErrTxDuplicate
+	}
+
+	fee, err := tx.Fee(poolView{m, outputs})
+	if err == bcore.ErrPrevoutMissing {
+		return nil, ErrTxMissingInputs
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		Tx:       tx,
+		Txid:     txid,
+		Wtxid:    tx.WitnessHash(),
+		Fee:      fee,
+		Size:     tx.VirtualSize(),
+		Time:     m.now(),
+		pool:     m,
+		parents:  make(map[Hash]*Entry),
+		children: make(map[Hash]*Entry),
+	}
+	for _, input := range tx.Inputs {
+		if parent, ok := m.entries[input.PrevOutput.Hash]; ok {
+			e.parents[parent.Txid] = parent
+		}
+	}
+	return e, nil
+}
+
+func (m *Mempool) checkFeeRate(e *Entry) error {
+	if e.Fee < Fee(m.minFeeRate(), e.Size) {
+		return ErrTxFeeTooLow
+	}
+	return nil
+}
+
+// checkLimits returns the in-mempool ancestors of the new entry e, failing when e
+// or one of them would exceed the package limits
+func (m *Mempool) checkLimits(e *Entry) (map[Hash]*Entry, error) {
+	ancestors := m.ancestorsOf(e.parents)
+
+	a := Aggregate{}
+	a.add(e)
+	for _, ancestor := range ancestors {
+		a.add(ancestor)
+	}
+	if a.Count > m.cfg.AncestorLimit {
+		return nil, ErrTooManyAncestors
+	}
+	if a.Size > m.cfg.AncestorSizeLimit {
+		return nil, ErrAncestorsTooLarge
+	}
+
+	for _, ancestor := range ancestors {
+		if ancestor.descendants.Count+1 > m.cfg.DescendantLimit {
+			return nil, ErrTooManyDescendants
+		}
+		if ancestor.descendants.Size+e.Size > m.cfg.DescendantSizeLimit {
+			return nil, ErrDescendantsTooLarge
+		}
+	}
+
+	return ancestors, nil
+}
+
+// ancestorsOf returns the given parents with all their ancestors
+func (m *Mempool) ancestorsOf(parents map[Hash]*Entry) map[Hash]*Entry {
+	ancestors := make(map[Hash]*Entry)
+	stack := entryList(parents)
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := ancestors[e.Txid]; ok {
+			continue
+		}
+
+		ancestors[e.Txid] = e
+		for _, parent := range e.parents {
+			stack = append(stack, parent)
+		}
+	}
+	return ancestors
+}
+
+// descendantsOf returns e with all its descendants
+func (m *Mempool) descendantsOf(e *Entry) map[Hash]*Entry {
+	descendants := make(map[Hash]*Entry)
+	stack := []*Entry{e}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := descendants[e.Txid]; ok {
+			continue
+		}
+
+		descendants[e.Txid] = e
+		for _, child := range e.children {
+			stack = append(stack, child)
+		}
+	}
+	return descendants
+}
+
+// insert adds e with ancestors, its in-mempool ancestors
+func (m *Mempool) insert(e *Entry, ancestors map[Hash]*Entry) {
+	for _, parent := range e.parents {
+		parent.children[e.Txid] = e
+	}
+
+	e.ancestors.add(e)
+	e.descendants.add(e)
+	for _, ancestor := range ancestors {
+		e.ancestors.add(ancestor)
+		ancestor.descendants.add(e)
+	}
+
+	m.entries[e.Txid] = e
+	m.wtxids[e.Wtxid] = e
+	for _, input := range e.Tx.Inputs {
+		m.spends[*input.PrevOutput] = e
+	}
+	m.usage += e.usage()
+}
+
+// removeSet removes the entries of set, updating the aggregates of the remaining
+// ancestors and descendants
+func (m *Mempool) removeSet(set map[Hash]*Entry) {
+	for _, e := range set {
+		for _, ancestor := range m.ancestorsOf(e.parents) {
+			if _, ok := set[ancestor.Txid]; !ok {
+				ancestor.descendants.sub(e)
+			}
+		}
+		for _, descendant := range m.descendantsOf(e) {
+			if _, ok := set[descendant.Txid]; !ok {
+				descendant.ancestors.sub(e)
+			}
+		}
+	}
+
+	for _, e := range set {
+		for _, parent := range e.parents {
+			delete(parent.children, e.Txid)
+		}
+		for _, child := range e.children {
+			delete(child.parents, e.Txid)
+		}
+
+		delete(m.entries, e.Txid)
+		delete(m.wtxids, e.Wtxid)
+		for _, input := range e.Tx.Inputs {
+			delete(m.spends, *input.PrevOutput)
+		}
+		m.usage -= e.usage()
+	}
+}
+
+// Remove removes txid with its descendants and returns them
+func (m *Mempool) Remove(txid Hash) []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[txid]
+	if !ok {
+		return nil
+	}
+
+	set := m.descendantsOf(e)
+	m.removeSet(set)
+	return entryList(set)
+}
+
+// RemoveForBlock removes the transactions of a block connected to the chain of the
+// view, and the mempool transactions spending the same outputs with their descendants,
+// which it returns
+func (m *Mempool) RemoveForBlock(block *bcore.Block) []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conflicts := make(map[Hash]*Entry)
+	for _, tx := range block.Transactions {
+		if e, ok := m.entries[tx.Hash()]; ok {
+			m.removeSet(map[Hash]*Entry{e.Txid: e})
+			continue
+		}
+
+		for _, input := range tx.Inputs {
+			if spender, ok := m.spends[*input.PrevOutput]; ok {
+				set := m.descendantsOf(spender)
+				m.removeSet(set)
+				for txid, e := range set {
+					conflicts[txid] = e
+				}
+			}
+		}
+	}
+
+	m.blockSinceBump = true
+	return entryList(conflicts)
+}
+
+// scoreHeap orders the entries by descendant score for trim, the lowest first
+type scoreHeap struct {
+	entries []*Entry
+	index   map[Hash]int
+}
+
+func newScoreHeap(entries map[Hash]*Entry) *scoreHeap {
+	h := &scoreHeap{entries: entryList(entries), index: make(map[Hash]int, len(entries))}
+	for i, e := range h.entries {
+		h.index[e.Txid] = i
+	}
+	heap.Init(h)
+	return h
+}
+
+func (h *scoreHeap) Len() int { return len(h.entries) }
+
+func (h *scoreHeap) Less(i, j int) bool {
+	return h.entries[i].descendantScore() < h.entries[j].descendantScore()
+}
+
+func (h *scoreHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.index[h.entries[i].Txid] = i
+	h.index[h.entries[j].Txid] = j
+}
+
+func (h *scoreHeap) Push(x interface{}) {
+	e := x.(*Entry)
+	h.index[e.Txid] = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *scoreHeap) Pop() interface{} {
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	delete(h.index, e.Txid)
+	return e
+}
+
+// trim evicts transactions with their descendants, lowest descendant score first,
+// until the mempool fits its memory budget. The entries are ordered once, only the
+// ancestors of each evicted set changing their score afterwards.
+func (m *Mempool) trim() {
+	if m.usage <= m.cfg.MaxSize {
+		return
+	}
+
+	h := newScoreHeap(m.entries)
+	for m.usage > m.cfg.MaxSize {
+		worst := h.entries[0]
+		rate := worst.descendants.FeeRate() + m.cfg.IncrementalRelayFeeRate
+		if rate > m.rollingMinFeeRate {
+			m.rollingMinFeeRate = rate
+			m.lastRollingUpdate = m.now()
+			m.blockSinceBump = false
+		}
+
+		// The descendants of worst may have other ancestors than its own
+		set := m.descendantsOf(worst)
+		ancestors := make(map[Hash]*Entry)
+		for _, e := range set {
+			for txid, ancestor := range m.ancestorsOf(e.parents) {
+				if _, ok := set[txid]; !ok {
+					ancestors[txid] = ancestor
+				}
+			}
+		}
+		m.removeSet(set)
+
+		for txid := range set {
+			heap.Remove(h, h.index[txid])
+		}
+		for txid := range ancestors {
+			heap.Fix(h, h.index[txid])
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+// testCoinValue is the value of the confirmed outputs of the test view
+const testCoinValue = 1000000
+
+// newTestMempool returns a mempool over a view of confirmed outputs Hash{i}:0
+func newTestMempool(t *testing.T, cfg *Config) *Mempool {
+	view := bcore.NewMapPrevoutFetcher()
+	for i := 1; i < 256; i++ {
+		view.Add(testCoin(i), &bcore.TransactionOutput{Value: testCoinValue, ScriptPubkey: make([]byte, 22)})
+	}
+
+	m := NewMempool(view, cfg)
+	m.now = func() time.Time { return testNow }
+	return m
+}
+
+func testCoin(i int) *bcore.OutPoint {
+	return bcore.NewOutPoint(Hash{byte(i)}, 0)
+}
+
+// newTestTx spends inputs into outputs of the given values
+func newTestTx(inputs []*bcore.OutPoint, values ...uint64) *bcore.Transaction {
+	tx := &bcore.Transaction{Version: 2}
+	for _, op := range inputs {
+		tx.Inputs = append(tx.Inputs, &bcore.TransactionInput{PrevOutput: op, ScriptSig: make([]byte, 20), Sequence: 0xffffffff})
+	}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, &bcore.TransactionOutput{Value: value, ScriptPubkey: make([]byte, 22)})
+	}
+	return tx
+}
+
+func outpoint(tx *bcore.Transaction, index uint32) *bcore.OutPoint {
+	return bcore.NewOutPoint(tx.Hash(), index)
+}
+
+func mustAdd(t *testing.T, m *Mempool, tx *bcore.Transaction) *Entry {
+	e, err := m.Add(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func expectAggregate(t *testing.T, what string, got, expect Aggregate) {
+	if got != expect {
+		t.Fatalf("%s: expect %+v, got %+v", what, expect, got)
+	}
+}
+
+func TestMempoolAdd(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	tx := newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000)
+	e := mustAdd(t, m, tx)
+	if e.Fee != 1000 || e.Size != tx.VirtualSize() || !e.Time.Equal(testNow) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if m.Len() != 1 || m.Get(tx.Hash()) != e || m.GetByWtxid(tx.WitnessHash()) != e || m.Spender(testCoin(1)) != e {
+		t.Fatal("entry not indexed")
+	}
+	if m.Usage() != e.usage() {
+		t.Fatalf("expect usage %d, got %d", e.usage(), m.Usage())
+	}
+
+	tests := []struct {
+		tx  *bcore.Transaction
+		err error
+	}{
+		{tx, ErrTxDuplicate},
+		{newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-2000), ErrReplacementNotSignaling},
+		{newTestTx([]*bcore.OutPoint{bcore.NewOutPoint(Hash{1}, 1)}, 1000), ErrTxMissingInputs},
+		{newTestTx([]*bcore.OutPoint{outpoint(tx, 1)}, 1000), ErrTxMissingInputs},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-10), ErrTxFeeTooLow},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue+1), bcore.ErrFeeNegative},
+		{bcore.NewCoinbaseTransaction(1, nil, nil), ErrTxCoinbase},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, make([]uint64, 4000)...), ErrTxTooLarge},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}), ErrTxEmpty},
+		{newTestTx([]*bcore.OutPoint{testCoin(2), bcore.NewDefaultOutPoint()}, 1000), ErrTxNullPrevout},
+		{newTestTx([]*bcore.OutPoint{testCoin(2), testCoin(2)}, 1000), ErrTxDuplicateInputs},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, bcore.MaxMoney+1), ErrTxOutputRange},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, 1<<63, 1<<63, 1000), ErrTxOutputRange},
+		{newTestTx([]*bcore.OutPoint{testCoin(2)}, bcore.MaxMoney, 1), ErrTxOutputsRange},
+	}
+	for i, test := range tests {
+		if _, err := m.Add(test.tx); err != test.err {
+			t.Fatalf("%d: expect %v, got %v", i, test.err, err)
+		}
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expect 1 transaction, got %d", m.Len())
+	}
+}
+
+func TestMempoolAncestors(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	// a and b both fund c, which funds d
+	a := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000))
+	b := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-3000, 1000))
+	c := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(a.Tx, 0), outpoint(b.Tx, 0)}, 2*testCoinValue-9000))
+	d := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(c.Tx, 0)}, 2*testCoinValue-14000))
+
+	if len(c.Parents()) != 2 || len(a.Children()) != 1 || len(d.Children()) != 0 {
+		t.Fatal("unexpected links")
+	}
+
+	expectAggregate(t, "a descendants", a.Descendants(), Aggregate{3, a.Size + c.Size + d.Size, 1000 + 5000 + 5000})
+	expectAggregate(t, "b descendants", b.Descendants(), Aggregate{3, b.Size + c.Size + d.Size, 2000 + 5000 + 5000})
+	expectAggregate(t, "c ancestors", c.Ancestors(), Aggregate{3, a.Size + b.Size + c.Size, 1000 + 2000 + 5000})
+	expectAggregate(t, "d ancestors", d.Ancestors(), Aggregate{4, a.Size + b.Size + c.Size + d.Size, 13000})
+
+	// Confirming a leaves c with b as only ancestor
+	block := &bcore.Block{Transactions: []*bcore.Transaction{bcore.NewCoinbaseTransaction(1, nil, nil), a.Tx}}
+	if conflicts := m.RemoveForBlock(block); len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	if m.Len() != 3 || len(c.Parents()) != 1 {
+		t.Fatal("a not removed")
+	}
+	expectAggregate(t, "c ancestors", c.Ancestors(), Aggregate{2, b.Size + c.Size, 7000})
+	expectAggregate(t, "d ancestors", d.Ancestors(), Aggregate{3, b.Size + c.Size + d.Size, 12000})
+	expectAggregate(t, "b descendants", b.Descendants(), Aggregate{3, b.Size + c.Size + d.Size, 12000})
+
+	// Removing c removes d, b being left alone
+	if removed := m.Remove(c.Txid); len(removed) != 2 {
+		t.Fatalf("expect 2 transactions removed, got %d", len(removed))
+	}
+	expectAggregate(t, "b descendants", b.Descendants(), Aggregate{1, b.Size, 2000})
+	if m.Spender(outpoint(b.Tx, 0)) != nil || len(b.Children()) != 0 {
+		t.Fatal("c still spends b")
+	}
+
+	// A block spending the output of b conflicts with its spender
+	e := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(b.Tx, 1)}, 600))
+	block = &bcore.Block{Transactions: []*bcore.Transaction{
+		bcore.NewCoinbaseTransaction(2, nil, nil),
+		newTestTx([]*bcore.OutPoint{outpoint(b.Tx, 1)}, 900),
+	}}
+	if conflicts := m.RemoveForBlock(block); len(conflicts) != 1 || conflicts[0] != e {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	if m.Len() != 1 || m.Usage() != b.usage() {
+		t.Fatalf("expect b only, got %d transactions", m.Len())
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	// A chain of DefaultAncestorLimit transactions
+	tx := newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000)
+	mustAdd(t, m, tx)
+	for i := 1; i < DefaultAncestorLimit; i++ {
+		tx = newTestTx([]*bcore.OutPoint{outpoint(tx, 0)}, tx.Outputs[0].Value-1000)
+		mustAdd(t, m, tx)
+	}
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{outpoint(tx, 0)}, tx.Outputs[0].Value-1000)); err != ErrTooManyAncestors {
+		t.Fatalf("expect %v, got %v", ErrTooManyAncestors, err)
+	}
+
+	// DefaultDescendantLimit - 1 children of a parent
+	values := make([]uint64, DefaultDescendantLimit)
+	for i := range values {
+		values[i] = 10000
+	}
+	parent := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(2)}, values...)).Tx
+	for i := 0; i < DefaultDescendantLimit-1; i++ {
+		mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent, uint32(i))}, 9000))
+	}
+	last := newTestTx([]*bcore.OutPoint{outpoint(parent, DefaultDescendantLimit-1)}, 9000)
+	if _, err := m.Add(last); err != ErrTooManyDescendants {
+		t.Fatalf("expect %v, got %v", ErrTooManyDescendants, err)
+	}
+
+	// Size limits
+	m = newTestMempool(t, &Config{AncestorSizeLimit: 350, DescendantSizeLimit: 300})
+	a := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(1)}, 10000, 10000, 10000))
+	b := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(a.Tx, 0)}, 9000))
+	if a.Size+b.Size > 300 {
+		t.Fatalf("unexpected sizes %d and %d", a.Size, b.Size)
+	}
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{outpoint(a.Tx, 1)}, 9000)); err != ErrDescendantsTooLarge {
+		t.Fatalf("expect %v, got %v", ErrDescendantsTooLarge, err)
+	}
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{outpoint(b.Tx, 0), outpoint(a.Tx, 2)}, 8000, 600, 600, 600)); err != ErrAncestorsTooLarge {
+		t.Fatalf("expect %v, got %v", ErrAncestorsTooLarge, err)
+	}
+}
+
+func TestMempoolTrim(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	var entries []*Entry
+	for i := 1; i <= 10; i++ {
+		entries = append(entries, mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(i)}, testCoinValue-uint64(1000*i))))
+	}
+
+	// A low feerate parent with a high feerate child is evicted after a low feerate transaction
+	parent := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(11)}, testCoinValue-500))
+	m.cfg.MaxSize = m.Usage()
+	child := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-500-20000))
+	if m.Get(entries[0].Txid) != nil || m.Get(parent.Txid) == nil || m.Get(child.Txid) == nil {
+		t.Fatal("lowest descendant score not evicted")
+	}
+
+	rate := entries[0].FeeRate() + DefaultIncrementalRelayFeeRate
+	if got := m.MinFeeRate(); got != rate {
+		t.Fatalf("expect minimal feerate %f, got %f", rate, got)
+	}
+
+	// A transaction below the rolling minimal feerate is refused, above it is evicted
+	// when the lowest
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{testCoin(20)}, testCoinValue-Fee(rate, entries[0].Size)+1)); err != ErrTxFeeTooLow {
+		t.Fatalf("expect %v, got %v", ErrTxFeeTooLow, err)
+	}
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{testCoin(20)}, testCoinValue-Fee(rate, entries[0].Size))); err != ErrMempoolFull {
+		t.Fatalf("expect %v, got %v", ErrMempoolFull, err)
+	}
+	if got := m.MinFeeRate(); got <= rate {
+		t.Fatalf("expect minimal feerate above %f, got %f", rate, got)
+	}
+	rate = m.MinFeeRate()
+
+	// The rolling feerate decays only after a block, with the half life
+	m.now = func() time.Time { return testNow.Add(RollingFeeHalfLife) }
+	if got := m.MinFeeRate(); got != rate {
+		t.Fatalf("expect minimal feerate %f, got %f", rate, got)
+	}
+	m.RemoveForBlock(&bcore.Block{})
+	if got := m.MinFeeRate(); got != rate/2 {
+		t.Fatalf("expect minimal feerate %f, got %f", rate/2, got)
+	}
+
+	// Down to the minimal relay feerate
+	m.now = func() time.Time { return testNow.Add(10 * RollingFeeHalfLife) }
+	if got := m.MinFeeRate(); got != DefaultMinRelayFeeRate {
+		t.Fatalf("expect minimal feerate %f, got %f", DefaultMinRelayFeeRate, got)
+	}
+}
+
+func TestMempoolTrimMany(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	var singles []*Entry
+	for i := 1; i <= 5; i++ {
+		singles = append(singles, mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(i)}, testCoinValue-uint64(1000*i))))
+	}
+
+	// The low feerate child goes first, then the parent scores with its high feerate child
+	parent := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(10)}, testCoinValue/2, testCoinValue/2-500))
+	high := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue/2-40000))
+	low := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 1)}, testCoinValue/2-500-300))
+
+	m.cfg.MaxSize = m.Usage() - low.usage() - singles[0].usage() - singles[1].usage()
+	m.trim()
+
+	for _, e := range []*Entry{low, singles[0], singles[1]} {
+		if m.Get(e.Txid) != nil {
+			t.Fatalf("%s not evicted", e.Txid)
+		}
+	}
+	for _, e := range []*Entry{parent, high, singles[2], singles[3], singles[4]} {
+		if m.Get(e.Txid) == nil {
+			t.Fatalf("%s evicted", e.Txid)
+		}
+	}
+	if got := parent.Descendants().Count; got != 2 {
+		t.Fatalf("expect 2 descendants, got %d", got)
+	}
+}
+
+func TestMempoolAddPackage(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	// A parent below the minimal feerate is paid for by its child
+	parent := newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-10)
+	child := newTestTx([]*bcore.OutPoint{outpoint(parent, 0)}, testCoinValue-5000)
+	if _, err := m.Add(parent); err != ErrTxFeeTooLow {
+		t.Fatalf("expect %v, got %v", ErrTxFeeTooLow, err)
+	}
+	if _, err := m.AddPackage(parent, newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-5000)); err != ErrPackageNotChild {
+		t.Fatalf("expect %v, got %v", ErrPackageNotChild, err)
+	}
+	if _, err := m.AddPackage(parent, child); err != ErrTxFeeTooLow {
+		t.Fatalf("expect %v, got %v", ErrTxFeeTooLow, err)
+	}
+
+	// Below the minimal relay feerate, only TRUC parents
+	parent.Version, child.Version = TRUCVersion, TRUCVersion
+	child.Inputs[0].PrevOutput = outpoint(parent, 0)
+	if _, err := m.AddPackage(parent, newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 0)}, testCoinValue-50)); err != ErrTxFeeTooLow {
+		t.Fatalf("expect %v, got %v", ErrTxFeeTooLow, err)
+	}
+	entries, err := m.AddPackage(parent, child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Ancestors().Count != 2 || entries[0].Descendants().Fees != 5000 {
+		t.Fatalf("unexpected package %v", entries)
+	}
+
+	// A parent in the mempool leaves the child alone, here replacing the previous one
+	low := newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 0)}, testCoinValue-5010)
+	if _, err := m.AddPackage(parent, low); err != ErrReplacementIncrementalFeeLow {
+		t.Fatalf("expect %v, got %v", ErrReplacementIncrementalFeeLow, err)
+	}
+
+	// The parent may not replace mempool transactions
+	conflict := newTestTRUCTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-20)
+	if _, err := m.AddPackage(conflict, newTestTRUCTx([]*bcore.OutPoint{outpoint(conflict, 0)}, testCoinValue-50000)); err != ErrPackageConflict {
+		t.Fatalf("expect %v, got %v", ErrPackageConflict, err)
+	}
+}
@@ -0,0 +1,172 @@
+package mempool
+
+import (
+	"errors"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrReplacementNotSignaling      = errors.New("mempool: replaced transaction does not signal replaceability")
+	ErrReplacementFeeRateTooLow     = errors.New("mempool: replacement feerate not above replaced transaction")
+	ErrReplacementTooManyEvictions  = errors.New("mempool: replacement evicts too many transactions")
+	ErrReplacementNewUnconfirmed    = errors.New("mempool: replacement adds unconfirmed inputs")
+	ErrReplacementSpendsConflict    = errors.New("mempool: replacement spends a replaced transaction")
+	ErrReplacementFeeTooLow         = errors.New("mempool: replacement fee below replaced transactions")
+	ErrReplacementIncrementalFeeLow = errors.New("mempool: replacement does not pay the incremental relay fee")
+)
+
+const (
+	// MaxReplacementEvictions is the maximal number of transactions a replacement evicts
+	MaxReplacementEvictions = 100
+	// MaxBIP125Sequence is the largest input sequence signaling replaceability
+	MaxBIP125Sequence = 0xfffffffd
+)
+
+// SignalsReplacement reports whether tx opts in to replacement, BIP125
+func SignalsReplacement(tx *bcore.Transaction) bool {
+	for _, input := range tx.Inputs {
+		if input.Sequence <= MaxBIP125Sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// Replacement is the evaluation of a transaction replacing the mempool transactions
+// it conflicts with, under the rules of BIP125 as Bitcoin Core applies them
+type Replacement struct {
+	Tx   *bcore.Transaction
+	Fee  uint64
+	Size int
+	// Mempool transactions spending outputs Tx spends, and its TRUC sibling
+	Conflicts []*Entry
+	// Conflicts with their descendants, removed by the replacement
+	Evicted     []*Entry
+	EvictedFees uint64
+	EvictedSize int
+	// Rule the replacement fails, nil when allowed
+	Err error
+}
+
+// EvaluateReplacement evaluates tx as a replacement of the mempool transactions it
+// conflicts with. Errors are those making tx invalid regardless of its conflicts, a
+// failed replacement rule being reported in the Err of the replacement.
+func (m *Mempool) EvaluateReplacement(tx *bcore.Transaction) (*Replacement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.newEntry(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return m.checkReplacement(e)
+}
+
+// checkReplacement evaluates e as a replacement of its conflicts, and of its sibling
+// under the TRUC rules. Errors are TRUC rules e fails.
+func (m *Mempool) checkReplacement(e *Entry) (*Replacement, error) {
+	sibling, err := m.checkTRUC(e)
+	if err != nil {
+		return nil, err
+	}
+	return m.evaluateReplacement(e, sibling), nil
+}
+
+// conflictsOf returns the mempool transactions spending outputs e spends
+func (m *Mempool) conflictsOf(e *Entry) map[Hash]*Entry {
+	conflicts := make(map[Hash]*Entry)
+	for _, input := range e.Tx.Inputs {
+		if spender, ok := m.spends[*input.PrevOutput]; ok {
+			conflicts[spender.Txid] = spender
+		}
+	}
+	return conflicts
+}
+
+// replaceable reports whether e is TRUC or signals replaceability itself, unless any
+// transaction is. Like Bitcoin Core, the signaling of its ancestors is not inherited,
+// although BIP125 describes it.
+func (m *Mempool) replaceable(e *Entry) bool {
+	return m.cfg.FullRBF || e.Tx.Version == TRUCVersion || SignalsReplacement(e.Tx)
+}
+
+// evaluateReplacement evaluates e as a replacement of its conflicts and of sibling,
+// when not nil
+func (m *Mempool) evaluateReplacement(e *Entry, sibling *Entry) *Replacement {
+	r := &Replacement{Tx: e.Tx, Fee: e.Fee, Size: e.Size}
+
+	conflicts := m.conflictsOf(e)
+	if sibling != nil {
+		conflicts[sibling.Txid] = sibling
+	}
+	r.Conflicts = entryList(conflicts)
+	if len(conflicts) == 0 {
+		return r
+	}
+
+	for _, conflict := range conflicts {
+		if !m.replaceable(conflict) {
+			r.Err = ErrReplacementNotSignaling
+			return r
+		}
+	}
+
+	// The replacement pays a higher feerate than each transaction it directly replaces
+	for _, conflict := range conflicts {
+		if e.FeeRate() <= conflict.FeeRate() {
+			r.Err = ErrReplacementFeeRateTooLow
+			return r
+		}
+	}
+
+	evicted := make(map[Hash]*Entry)
+	for _, conflict := range conflicts {
+		for txid, descendant := range m.descendantsOf(conflict) {
+			evicted[txid] = descendant
+		}
+	}
+	r.Evicted = entryList(evicted)
+	for _, ev := range evicted {
+		r.EvictedFees += ev.Fee
+		r.EvictedSize += ev.Size
+	}
+	if len(evicted) > MaxReplacementEvictions {
+		r.Err = ErrReplacementTooManyEvictions
+		return r
+	}
+
+	// Unconfirmed inputs are only those the replaced transactions already spent
+	spent := make(map[Hash]bool)
+	for _, conflict := range conflicts {
+		for _, input := range conflict.Tx.Inputs {
+			spent[input.PrevOutput.Hash] = true
+		}
+	}
+	for _, input := range e.Tx.Inputs {
+		if _, ok := m.entries[input.PrevOutput.Hash]; ok && !spent[input.PrevOutput.Hash] {
+			r.Err = ErrReplacementNewUnconfirmed
+			return r
+		}
+	}
+
+	for txid := range m.ancestorsOf(e.parents) {
+		if _, ok := evicted[txid]; ok {
+			r.Err = ErrReplacementSpendsConflict
+			return r
+		}
+	}
+
+	// The replacement pays for the evicted transactions and for its own relay
+	if r.Fee < r.EvictedFees {
+		r.Err = ErrReplacementFeeTooLow
+		return r
+	}
+	if r.Fee-r.EvictedFees < Fee(m.cfg.IncrementalRelayFeeRate, r.Size) {
+		r.Err = ErrReplacementIncrementalFeeLow
+		return r
+	}
+
+	return r
+}
@@ -0,0 +1,126 @@
+package mempool
+
+import (
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+)
+
+// signaling makes tx opt in to replacement
+func signaling(tx *bcore.Transaction) *bcore.Transaction {
+	tx.Inputs[0].Sequence = MaxBIP125Sequence
+	return tx
+}
+
+func expectReplacement(t *testing.T, m *Mempool, tx *bcore.Transaction, expect error) *Replacement {
+	r, err := m.EvaluateReplacement(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Err != expect {
+		t.Fatalf("expect %v, got %v", expect, r.Err)
+	}
+	return r
+}
+
+func TestSignalsReplacement(t *testing.T) {
+	tx := newTestTx([]*bcore.OutPoint{testCoin(1), testCoin(2)}, 1000)
+	if SignalsReplacement(tx) {
+		t.Fatal("final sequences signal")
+	}
+
+	tx.Inputs[1].Sequence = MaxBIP125Sequence + 1
+	if SignalsReplacement(tx) {
+		t.Fatal("sequence 0xfffffffe signals")
+	}
+
+	tx.Inputs[1].Sequence = 0
+	if !SignalsReplacement(tx) {
+		t.Fatal("sequence 0 does not signal")
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	original := mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-1000)))
+	child := mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(original.Tx, 0)}, testCoinValue-3000))
+
+	replacement := newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-3000-uint64(original.Size))
+	r := expectReplacement(t, m, replacement, nil)
+	if len(r.Conflicts) != 1 || r.Conflicts[0] != original || len(r.Evicted) != 2 {
+		t.Fatalf("unexpected conflicts %v and evictions %v", r.Conflicts, r.Evicted)
+	}
+	if r.EvictedFees != 3000 || r.EvictedSize != original.Size+child.Size || r.Fee != 3000+uint64(original.Size) {
+		t.Fatalf("unexpected replacement %+v", r)
+	}
+
+	e := mustAdd(t, m, replacement)
+	if m.Len() != 1 || m.Get(original.Txid) != nil || m.Get(child.Txid) != nil || m.Spender(testCoin(1)) != e {
+		t.Fatal("replaced transactions not evicted")
+	}
+}
+
+func TestReplacementRules(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	// Rule 1: the replaced transaction signals, signaling of its ancestors is not inherited
+	final := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-11000, 10000))
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-100000), ErrReplacementNotSignaling)
+	if _, err := m.Add(newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-100000)); err != ErrReplacementNotSignaling {
+		t.Fatalf("expect %v, got %v", ErrReplacementNotSignaling, err)
+	}
+
+	signaled := mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{testCoin(5)}, testCoinValue-1000)))
+	mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(signaled.Tx, 0)}, testCoinValue-2000))
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(signaled.Tx, 0)}, testCoinValue-100000), ErrReplacementNotSignaling)
+
+	parent := mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-1000)))
+	child := mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-2000)))
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-100000), nil)
+
+	// Rule 6: a higher feerate than each replaced transaction
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-1990), ErrReplacementFeeRateTooLow)
+
+	// Rule 3: a higher fee than the evicted transactions, rule 4: and the incremental relay fee
+	mustAdd(t, m, newTestTx([]*bcore.OutPoint{outpoint(child.Tx, 0)}, testCoinValue-52000))
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-1000-50000), ErrReplacementFeeTooLow)
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-1000-51000), ErrReplacementIncrementalFeeLow)
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-1000-51000-uint64(child.Size)), nil)
+
+	// Rule 2: no unconfirmed input besides those of the replaced transactions
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0), outpoint(final.Tx, 1)}, testCoinValue-100000), ErrReplacementNewUnconfirmed)
+
+	// The replacement may not spend a transaction it evicts
+	a := mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{testCoin(3)}, testCoinValue-11000, 10000)))
+	mustAdd(t, m, signaling(newTestTx([]*bcore.OutPoint{outpoint(a.Tx, 0), testCoin(4)}, 2*testCoinValue-21000)))
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{testCoin(4), outpoint(a.Tx, 1), testCoin(3)}, 1000), ErrReplacementSpendsConflict)
+
+	// Full RBF replaces transactions not signaling
+	m.cfg.FullRBF = true
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-100000), nil)
+}
+
+func TestReplacementTooManyEvictions(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	// 5 conflicts with 20 descendants each
+	var inputs []*bcore.OutPoint
+	for i := 1; i <= 5; i++ {
+		tx := signaling(newTestTx([]*bcore.OutPoint{testCoin(i)}, testCoinValue-1000))
+		mustAdd(t, m, tx)
+		for j := 0; j < 20; j++ {
+			tx = newTestTx([]*bcore.OutPoint{outpoint(tx, 0)}, tx.Outputs[0].Value-1000)
+			mustAdd(t, m, tx)
+		}
+		inputs = append(inputs, testCoin(i))
+	}
+
+	r := expectReplacement(t, m, newTestTx(inputs, 10000), ErrReplacementTooManyEvictions)
+	if len(r.Evicted) != 105 {
+		t.Fatalf("expect 105 evictions, got %d", len(r.Evicted))
+	}
+
+	// 4 conflicts evict 84 transactions
+	expectReplacement(t, m, newTestTx(inputs[1:], 10000), nil)
+}
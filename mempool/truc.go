@@ -0,0 +1,68 @@
+package mempool
+
+import (
+	"errors"
+)
+
+var (
+	ErrTRUCTooLarge         = errors.New("mempool: TRUC transaction too large")
+	ErrTRUCChildTooLarge    = errors.New("mempool: TRUC child transaction too large")
+	ErrTRUCSpendsNonTRUC    = errors.New("mempool: TRUC transaction spends an unconfirmed non TRUC transaction")
+	ErrNonTRUCSpendsTRUC    = errors.New("mempool: non TRUC transaction spends an unconfirmed TRUC transaction")
+	ErrTRUCTooManyAncestors = errors.New("mempool: TRUC transaction has too many unconfirmed ancestors")
+)
+
+const (
+	// TRUCVersion is the version of topologically restricted until confirmation
+	// transactions, BIP431
+	TRUCVersion = 3
+	// TRUCMaxSize is the maximal virtual size of a TRUC transaction
+	TRUCMaxSize = 10000
+	// TRUCChildMaxSize is the maximal virtual size of a TRUC transaction with an
+	// unconfirmed parent
+	TRUCChildMaxSize = 1000
+)
+
+// checkTRUC checks the topology of e when TRUC or spending a TRUC transaction: a
+// cluster of at most a parent and a child, both TRUC. When the parent has a child
+// already, that sibling is returned, e replacing it as under BIP125.
+func (m *Mempool) checkTRUC(e *Entry) (*Entry, error) {
+	truc := e.Tx.Version == TRUCVersion
+	for _, parent := range e.parents {
+		if parentTRUC := parent.Tx.Version == TRUCVersion; parentTRUC != truc {
+			if truc {
+				return nil, ErrTRUCSpendsNonTRUC
+			}
+			return nil, ErrNonTRUCSpendsTRUC
+		}
+	}
+	if !truc {
+		return nil, nil
+	}
+
+	if e.Size > TRUCMaxSize {
+		return nil, ErrTRUCTooLarge
+	}
+	if len(e.parents) == 0 {
+		return nil, nil
+	}
+	if len(m.ancestorsOf(e.parents)) > 1 {
+		return nil, ErrTRUCTooManyAncestors
+	}
+	if e.Size > TRUCChildMaxSize {
+		return nil, ErrTRUCChildTooLarge
+	}
+
+	var parent *Entry
+	for _, p := range e.parents {
+		parent = p
+	}
+
+	conflicts := m.conflictsOf(e)
+	for txid, sibling := range parent.children {
+		if _, ok := conflicts[txid]; !ok {
+			return sibling, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,111 @@
+package mempool
+
+import (
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+)
+
+// newTestTRUCTx is newTestTx with TRUCVersion
+func newTestTRUCTx(inputs []*bcore.OutPoint, values ...uint64) *bcore.Transaction {
+	tx := newTestTx(inputs, values...)
+	tx.Version = TRUCVersion
+	return tx
+}
+
+func repeat(value uint64, n int) []uint64 {
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+func TestTRUC(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	parent := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-11000, 10000))
+	nonTRUC := mustAdd(t, m, newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-1000))
+	other := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{testCoin(3)}, testCoinValue-1000))
+
+	tests := []struct {
+		tx  *bcore.Transaction
+		err error
+	}{
+		{newTestTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-20000), ErrNonTRUCSpendsTRUC},
+		{newTestTRUCTx([]*bcore.OutPoint{outpoint(nonTRUC.Tx, 0)}, testCoinValue-2000), ErrTRUCSpendsNonTRUC},
+		{newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 0), outpoint(other.Tx, 0)}, 2*testCoinValue-20000), ErrTRUCTooManyAncestors},
+		{newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, repeat(20000, 40)...), ErrTRUCChildTooLarge},
+		{newTestTRUCTx([]*bcore.OutPoint{testCoin(4)}, repeat(2000, 330)...), ErrTRUCTooLarge},
+	}
+	for i, test := range tests {
+		if _, err := m.Add(test.tx); err != test.err {
+			t.Fatalf("%d: expect %v, got %v", i, test.err, err)
+		}
+	}
+
+	child := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-20000))
+	if _, err := m.Add(newTestTRUCTx([]*bcore.OutPoint{outpoint(child.Tx, 0)}, testCoinValue-30000)); err != ErrTRUCTooManyAncestors {
+		t.Fatalf("expect %v, got %v", ErrTRUCTooManyAncestors, err)
+	}
+
+	// TRUC transactions are replaceable without signaling
+	expectReplacement(t, m, newTestTRUCTx([]*bcore.OutPoint{testCoin(3)}, testCoinValue-2000), nil)
+	expectReplacement(t, m, newTestTx([]*bcore.OutPoint{testCoin(2)}, testCoinValue-2000), ErrReplacementNotSignaling)
+}
+
+func TestTRUCSiblingEviction(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	parent := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-21000, 10000, 10000))
+	child := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 0)}, testCoinValue-22000))
+
+	// The sibling replaces the child under the replacement rules
+	sibling := newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 1)}, 9500)
+	r := expectReplacement(t, m, sibling, ErrReplacementFeeRateTooLow)
+	if len(r.Conflicts) != 1 || r.Conflicts[0] != child {
+		t.Fatalf("unexpected conflicts %v", r.Conflicts)
+	}
+	if _, err := m.Add(sibling); err != ErrReplacementFeeRateTooLow {
+		t.Fatalf("expect %v, got %v", ErrReplacementFeeRateTooLow, err)
+	}
+
+	sibling = newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 1)}, 8000)
+	e := mustAdd(t, m, sibling)
+	if m.Get(child.Txid) != nil || len(parent.Children()) != 1 || parent.Children()[0] != e {
+		t.Fatal("sibling not evicted")
+	}
+
+	// A child conflicting with the existing one replaces it directly
+	mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{outpoint(parent.Tx, 1), outpoint(parent.Tx, 2)}, 15000))
+	if m.Get(e.Txid) != nil || m.Len() != 2 {
+		t.Fatal("child not replaced")
+	}
+}
+
+func TestTRUCPackageSiblingRestored(t *testing.T) {
+	m := newTestMempool(t, &Config{})
+
+	grandparent := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{testCoin(1)}, testCoinValue-21000, 10000, 10000))
+	sibling := mustAdd(t, m, newTestTRUCTx([]*bcore.OutPoint{outpoint(grandparent.Tx, 0)}, testCoinValue-22000))
+	usage := m.Usage()
+
+	// The parent evicts the sibling, its child then has too many TRUC ancestors
+	parent := newTestTRUCTx([]*bcore.OutPoint{outpoint(grandparent.Tx, 1)}, 7000)
+	child := newTestTRUCTx([]*bcore.OutPoint{outpoint(parent, 0)}, 6000)
+	if _, err := m.AddPackage(parent, child); err != ErrTRUCTooManyAncestors {
+		t.Fatalf("expect %v, got %v", ErrTRUCTooManyAncestors, err)
+	}
+
+	if m.Len() != 2 || m.Get(sibling.Txid) != sibling || m.Spender(outpoint(grandparent.Tx, 0)) != sibling || m.Get(parent.Hash()) != nil {
+		t.Fatal("sibling not restored")
+	}
+	if children := grandparent.Children(); len(children) != 1 || children[0] != sibling {
+		t.Fatalf("unexpected children %v", children)
+	}
+	expectAggregate(t, "grandparent descendants", grandparent.Descendants(), Aggregate{Count: 2, Size: grandparent.Size + sibling.Size, Fees: 2000})
+	expectAggregate(t, "sibling ancestors", sibling.Ancestors(), Aggregate{Count: 2, Size: grandparent.Size + sibling.Size, Fees: 2000})
+	if m.Usage() != usage {
+		t.Fatalf("expect usage %d, got %d", usage, m.Usage())
+	}
+}
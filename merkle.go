@@ -0,0 +1,182 @@
+package bcore
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrWitnessCommitmentMismatch = errors.New("witness commitment: mismatch")
+	ErrWitnessReservedValue      = errors.New("witness commitment: bad reserved value")
+	ErrWitnessUnexpected         = errors.New("witness commitment: witness data without commitment")
+)
+
+const (
+	// WitnessCommitmentSize is the minimal size of a coinbase output script committing
+	// to the witness merkle root: OP_RETURN, a 36 bytes push, the header and the hash
+	WitnessCommitmentSize = 38
+)
+
+// witnessCommitmentHeader starts the scripts of witness commitments (BIP141)
+var witnessCommitmentHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+func merkleParent(left, right Hash) Hash {
+	return DHash256(NewBuffer().PutHash(left).PutHash(right).Bytes())
+}
+
+// merkleLevel hashes pairs of hashes, the last one is paired with itself on odd levels
+func merkleLevel(hashes []Hash) []Hash {
+	next := make([]Hash, 0, (len(hashes)+1)/2)
+	for i := 0; i < len(hashes); i += 2 {
+		j := i + 1
+		if j == len(hashes) {
+			j = i
+		}
+		next = append(next, merkleParent(hashes[i], hashes[j]))
+	}
+	return next
+}
+
+// MerkleRoot returns the merkle root of hashes, HashZero if there is none
+func MerkleRoot(hashes []Hash) Hash {
+	if len(hashes) == 0 {
+		return HashZero
+	}
+
+	for len(hashes) > 1 {
+		hashes = merkleLevel(hashes)
+	}
+	return hashes[0]
+}
+
+// MerkleRootMutated returns the merkle root of hashes and whether a pair of equal
+// hashes of a level makes another list, without the duplicates, share the root
+// (CVE-2012-2459)
+func MerkleRootMutated(hashes []Hash) (Hash, bool) {
+	if len(hashes) == 0 {
+		return HashZero, false
+	}
+
+	mutated := false
+	for len(hashes) > 1 {
+		for i := 0; i+1 < len(hashes); i += 2 {
+			mutated = mutated || hashes[i] == hashes[i+1]
+		}
+		hashes = merkleLevel(hashes)
+	}
+	return hashes[0], mutated
+}
+
+// MerkleBranch returns the hashes proving the inclusion of hashes[pos], from the bottom up
+func MerkleBranch(hashes []Hash, pos int) []Hash {
+	var branch []Hash
+	for len(hashes) > 1 {
+		sibling := pos ^ 1
+		if sibling == len(hashes) {
+			sibling = pos
+		}
+		branch = append(branch, hashes[sibling])
+
+		hashes = merkleLevel(hashes)
+		pos >>= 1
+	}
+	return branch
+}
+
+// MerkleRootFromBranch returns the root a merkle branch of the leaf at pos proves
+func MerkleRootFromBranch(leaf Hash, branch []Hash, pos int) Hash {
+	for _, h := range branch {
+		if pos&1 == 1 {
+			leaf = merkleParent(h, leaf)
+		} else {
+			leaf = merkleParent(leaf, h)
+		}
+		pos >>= 1
+	}
+	return leaf
+}
+
+func (b *Block) txids() []Hash {
+	hashes := make([]Hash, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
+// MerkleRoot computes the merkle root of the transactions of the block
+func (b *Block) MerkleRoot() Hash {
+	return MerkleRoot(b.txids())
+}
+
+// MerkleBranch returns the merkle branch of the transaction at pos
+func (b *Block) MerkleBranch(pos int) []Hash {
+	return MerkleBranch(b.txids(), pos)
+}
+
+// MerkleRootMutated computes the merkle root of the transactions of the block, and
+// whether duplicated transactions give the same root
+func (b *Block) MerkleRootMutated() (Hash, bool) {
+	return MerkleRootMutated(b.txids())
+}
+
+// WitnessMerkleRoot computes the merkle root of the witness hashes of the transactions,
+// the coinbase one being zero (BIP141)
+func (b *Block) WitnessMerkleRoot() Hash {
+	hashes := make([]Hash, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		if i > 0 {
+			hashes[i] = tx.WitnessHash()
+		}
+	}
+	return MerkleRoot(hashes)
+}
+
+// WitnessCommitment returns the witness commitment of the last coinbase output
+// holding one, false when there is none
+func (b *Block) WitnessCommitment() (Hash, bool) {
+	if len(b.Transactions) == 0 {
+		return HashZero, false
+	}
+
+	outputs := b.Transactions[0].Outputs
+	for i := len(outputs) - 1; i >= 0; i-- {
+		script := outputs[i].ScriptPubkey
+		if len(script) >= WitnessCommitmentSize && bytes.HasPrefix(script, witnessCommitmentHeader) {
+			commitment, err := NewReadBuffer(script[len(witnessCommitmentHeader):WitnessCommitmentSize]).GetHash()
+			return commitment, err == nil
+		}
+	}
+	return HashZero, false
+}
+
+// CheckWitnessCommitment checks the witness data of the block against the commitment
+// of its coinbase, the coinbase witness holding the 32 bytes reserved value. Blocks
+// without commitment have no witness data.
+func (b *Block) CheckWitnessCommitment() error {
+	commitment, ok := b.WitnessCommitment()
+	if !ok {
+		for _, tx := range b.Transactions {
+			if tx.HasWitness() {
+				return ErrWitnessUnexpected
+			}
+		}
+		return nil
+	}
+
+	var witness ScriptWitness
+	if inputs := b.Transactions[0].Inputs; len(inputs) > 0 {
+		witness = inputs[0].ScriptWitness
+	}
+	if len(witness) != 1 || len(witness[0]) != HashSize {
+		return ErrWitnessReservedValue
+	}
+
+	root := b.WitnessMerkleRoot()
+	if DHash256(NewBuffer().PutHash(root).PutBytes(witness[0]).Bytes()) != commitment {
+		return ErrWitnessCommitmentMismatch
+	}
+	return nil
+}
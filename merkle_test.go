@@ -0,0 +1,92 @@
+package bcore
+
+import (
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestMerkleBranch(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		hashes := make([]Hash, n)
+		for i := range hashes {
+			hashes[i] = Hash{byte(i + 1)}
+		}
+
+		root := MerkleRoot(hashes)
+		for pos := range hashes {
+			branch := MerkleBranch(hashes, pos)
+			if got := MerkleRootFromBranch(hashes[pos], branch, pos); got != root {
+				t.Fatalf("%d leaves, pos %d: expect root %s, got %s", n, pos, root, got)
+			}
+		}
+	}
+
+	one := Hash{1}
+	if MerkleRoot([]Hash{one}) != one || len(MerkleBranch([]Hash{one}, 0)) != 0 {
+		t.Fatal("merkle root of a single hash is the hash itself")
+	}
+
+	three := MerkleRoot([]Hash{{1}, {2}, {3}})
+	expect := merkleParent(merkleParent(Hash{1}, Hash{2}), merkleParent(Hash{3}, Hash{3}))
+	if three != expect {
+		t.Fatalf("odd level: expect %s, got %s", expect, three)
+	}
+}
+
+func TestMerkleRootMutated(t *testing.T) {
+	root, mutated := MerkleRootMutated([]Hash{{1}, {2}, {3}})
+	if mutated || root != MerkleRoot([]Hash{{1}, {2}, {3}}) {
+		t.Fatal("distinct hashes mutated")
+	}
+
+	// The last hash duplicated gives the same root
+	if dup, mutated := MerkleRootMutated([]Hash{{1}, {2}, {3}, {3}}); !mutated || dup != root {
+		t.Fatalf("duplicated hash: root %s, mutated %v", dup, mutated)
+	}
+	if _, mutated := MerkleRootMutated([]Hash{{1}, {2}, {1}, {2}}); !mutated {
+		t.Fatal("duplicated subtree not mutated")
+	}
+
+	a := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, 1000)
+	b := newTestTransaction([]*OutPoint{NewOutPoint(Hash{2}, 0)}, 1000)
+	block := newTestBlock(HashZero, 1, a, b)
+	mutatedBlock := NewBlock(block.Header, append(block.Transactions, b))
+	if root, mutated := mutatedBlock.MerkleRootMutated(); !mutated || root != block.MerkleRoot() {
+		t.Fatalf("mutated block: root %s, mutated %v", root, mutated)
+	}
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	tx := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, 1000)
+	block := newTestBlock(HashZero, 1, tx)
+	if _, ok := block.WitnessCommitment(); ok || block.CheckWitnessCommitment() != nil {
+		t.Fatal("block without witness data")
+	}
+
+	tx.Inputs[0].ScriptWitness = NewScriptWitness([][]byte{{1, 2, 3}})
+	if err := block.CheckWitnessCommitment(); err != ErrWitnessUnexpected {
+		t.Fatalf("expect %v, got %v", ErrWitnessUnexpected, err)
+	}
+
+	reserved := make([]byte, HashSize)
+	commitment := DHash256(NewBuffer().PutHash(block.WitnessMerkleRoot()).PutBytes(reserved).Bytes())
+	coinbase := block.Transactions[0]
+	coinbase.Outputs = append(coinbase.Outputs, &TransactionOutput{ScriptPubkey: NewBuffer().PutBytes(witnessCommitmentHeader).PutHash(commitment).Bytes()})
+	if err := block.CheckWitnessCommitment(); err != ErrWitnessReservedValue {
+		t.Fatalf("expect %v, got %v", ErrWitnessReservedValue, err)
+	}
+
+	coinbase.Inputs[0].ScriptWitness = NewScriptWitness([][]byte{reserved})
+	if got, ok := block.WitnessCommitment(); !ok || got != commitment {
+		t.Fatalf("expect commitment %s, got %s", commitment, got)
+	}
+	if err := block.CheckWitnessCommitment(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Inputs[0].ScriptWitness[0][0] ^= 1
+	if err := block.CheckWitnessCommitment(); err != ErrWitnessCommitmentMismatch {
+		t.Fatalf("expect %v, got %v", ErrWitnessCommitmentMismatch, err)
+	}
+}
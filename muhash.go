@@ -0,0 +1,145 @@
+package bcore
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/detailyang/go-bcore/internal/chacha20"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// MuHash3072Size is the size of a serialized MuHash3072 state element
+	MuHash3072Size = 384
+)
+
+// muhash3072P is the prime 2^3072 - 1103717 MuHash3072 works modulo
+var muhash3072P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+// MuHash3072 is the incremental multiset hash of Bitcoin Core, used by the muhash
+// of gettxoutsetinfo and the coinstatsindex. Elements can be inserted and removed in
+// any order and the result only depends on the final multiset.
+type MuHash3072 struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+func NewMuHash3072() *MuHash3072 {
+	return &MuHash3072{
+		numerator:   big.NewInt(1),
+		denominator: big.NewInt(1),
+	}
+}
+
+// muhash3072Element maps data to a number modulo p: the 384 bytes ChaCha20 key stream
+// keyed by SHA256(data), read as a little-endian integer.
+func muhash3072Element(data []byte) *big.Int {
+	key := sha256.Sum256(data)
+	c, _ := chacha20.New(key[:], make([]byte, chacha20.NonceSize))
+
+	stream := make([]byte, MuHash3072Size)
+	c.KeyStream(stream)
+
+	return new(big.Int).SetBytes(reverseBytes(stream))
+}
+
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func (m *MuHash3072) Insert(data []byte) *MuHash3072 {
+	m.numerator.Mul(m.numerator, muhash3072Element(data))
+	m.numerator.Mod(m.numerator, muhash3072P)
+	return m
+}
+
+func (m *MuHash3072) Remove(data []byte) *MuHash3072 {
+	m.denominator.Mul(m.denominator, muhash3072Element(data))
+	m.denominator.Mod(m.denominator, muhash3072P)
+	return m
+}
+
+// Combine adds the elements of another MuHash3072 to m
+func (m *MuHash3072) Combine(o *MuHash3072) *MuHash3072 {
+	m.numerator.Mul(m.numerator, o.numerator)
+	m.numerator.Mod(m.numerator, muhash3072P)
+	m.denominator.Mul(m.denominator, o.denominator)
+	m.denominator.Mod(m.denominator, muhash3072P)
+	return m
+}
+
+func (m *MuHash3072) Clone() *MuHash3072 {
+	return &MuHash3072{
+		numerator:   new(big.Int).Set(m.numerator),
+		denominator: new(big.Int).Set(m.denominator),
+	}
+}
+
+// Finalize returns the SHA256 of the 384 bytes little-endian numerator / denominator
+func (m *MuHash3072) Finalize() Hash {
+	inverse := new(big.Int).ModInverse(m.denominator, muhash3072P)
+	n := inverse.Mul(inverse, m.numerator)
+	n.Mod(n, muhash3072P)
+
+	data := make([]byte, MuHash3072Size)
+	n.FillBytes(data)
+
+	return Hash(sha256.Sum256(reverseBytes(data)))
+}
+
+// InsertUtxo adds a coin in the serialization shared with hash_serialized_3
+func (m *MuHash3072) InsertUtxo(op *OutPoint, e *UtxoEntry) *MuHash3072 {
+	return m.Insert(hashSerializedCoinBytes(op, e))
+}
+
+// RemoveUtxo removes a coin added with InsertUtxo
+func (m *MuHash3072) RemoveUtxo(op *OutPoint, e *UtxoEntry) *MuHash3072 {
+	return m.Remove(hashSerializedCoinBytes(op, e))
+}
+
+// ApplyBlock updates the hash for a block connected at height with its undo data,
+// the way the coinstatsindex of Bitcoin Core does.
+func (m *MuHash3072) ApplyBlock(block *Block, height uint32, undo *BlockUndo) error {
+	return m.applyBlock(block, height, undo, false)
+}
+
+// RevertBlock undoes ApplyBlock for a disconnected block
+func (m *MuHash3072) RevertBlock(block *Block, height uint32, undo *BlockUndo) error {
+	return m.applyBlock(block, height, undo, true)
+}
+
+func (m *MuHash3072) applyBlock(block *Block, height uint32, undo *BlockUndo, revert bool) error {
+	if !undo.Matches(block) {
+		return ErrUtxoUndoMismatch
+	}
+
+	created, spent := m.InsertUtxo, m.RemoveUtxo
+	if revert {
+		created, spent = m.RemoveUtxo, m.InsertUtxo
+	}
+
+	for i, tx := range block.Transactions {
+		txid := tx.Hash()
+		coinbase := tx.IsCoinbase()
+		for j, output := range tx.Outputs {
+			if output.IsUnspendable() {
+				continue
+			}
+			created(NewOutPoint(txid, uint32(j)), NewUtxoEntry(output, height, coinbase))
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		for j, input := range tx.Inputs {
+			spent(input.PrevOutput, undo.Transactions[i-1].Spent[j])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package bcore
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestMuHash3072(t *testing.T) {
+	fromInt := func(i byte) []byte {
+		data := make([]byte, 32)
+		data[0] = i
+		return data
+	}
+
+	// Test vector of the Bitcoin Core muhash unit test
+	m := NewMuHash3072().Insert(fromInt(0)).Insert(fromInt(1)).Remove(fromInt(2))
+	expect := "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863"
+	if got := m.Finalize().RString(); got != expect {
+		t.Fatalf("muhash: expect %s, got %s", expect, got)
+	}
+
+	// Order of insertions and removals does not matter
+	o := NewMuHash3072().Remove(fromInt(2)).Insert(fromInt(1)).Insert(fromInt(0))
+	if m.Finalize() != o.Finalize() {
+		t.Fatal("muhash depends on insertion order")
+	}
+
+	c := NewMuHash3072().Insert(fromInt(0)).Combine(NewMuHash3072().Insert(fromInt(1)).Remove(fromInt(2)))
+	if m.Finalize() != c.Finalize() {
+		t.Fatal("combined muhash mismatch")
+	}
+
+	if NewMuHash3072().Insert(fromInt(3)).Remove(fromInt(3)).Finalize() != NewMuHash3072().Finalize() {
+		t.Fatal("removed element still in muhash")
+	}
+}
+
+func TestMuHash3072ApplyBlock(t *testing.T) {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestSpendChain(110)
+
+	m := NewMuHash3072()
+	undos := make([]*BlockUndo, len(blocks))
+	for i, block := range blocks {
+		undo, err := set.ConnectBlock(block, uint32(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		undos[i] = undo
+
+		if err := m.ApplyBlock(block, uint32(i+1), undo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := NewMuHash3072()
+	set.ForEach(func(op *OutPoint, e *UtxoEntry) bool {
+		expect.InsertUtxo(op, e)
+		return true
+	})
+	if m.Finalize() != expect.Finalize() {
+		t.Fatal("incremental muhash does not match utxo set")
+	}
+
+	tip := m.Clone()
+	for i := len(blocks) - 1; i >= 100; i-- {
+		if err := m.RevertBlock(blocks[i], uint32(i+1), undos[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.DisconnectBlock(blocks[i], undos[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect = NewMuHash3072()
+	set.ForEach(func(op *OutPoint, e *UtxoEntry) bool {
+		expect.InsertUtxo(op, e)
+		return true
+	})
+	if m.Finalize() != expect.Finalize() || m.Finalize() == tip.Finalize() {
+		t.Fatal("reverted muhash does not match utxo set")
+	}
+
+	if err := m.ApplyBlock(blocks[len(blocks)-1], uint32(len(blocks)), &BlockUndo{}); err != ErrUtxoUndoMismatch {
+		t.Fatalf("expect %v, got %v", ErrUtxoUndoMismatch, err)
+	}
+}
+
+func TestComputeUtxoStatsCommitments(t *testing.T) {
+	store := NewMemoryUtxoStore()
+	set := NewUtxoSet(RegTestParams)
+	for i, block := range newTestSpendChain(105) {
+		if _, err := set.ConnectBlock(block, uint32(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := make(map[OutPoint]*UtxoEntry)
+	muhash := NewMuHash3072()
+	set.ForEach(func(op *OutPoint, e *UtxoEntry) bool {
+		changes[*op] = e
+		muhash.InsertUtxo(op, e)
+		return true
+	})
+	if err := store.WriteUtxos(changes, HashZero); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := ComputeUtxoStats(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.MuHash != muhash.Finalize() {
+		t.Fatal("utxo stats muhash mismatch")
+	}
+
+	var buf bytes.Buffer
+	if _, hash, err := WriteSnapshot(&buf, store, RegTestParams); err != nil {
+		t.Fatal(err)
+	} else if stats.HashSerialized != hash {
+		t.Fatal("utxo stats hash_serialized mismatch")
+	}
+}
@@ -0,0 +1,486 @@
+// Package netsync downloads the chain from peers, headers first: the headers are
+// fetched and validated from block locators, then the blocks are downloaded in
+// parallel from several peers.
+package netsync
+
+import (
+	"errors"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/peer"
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrNoPeers        = errors.New("netsync: no peer left to download from")
+	ErrHeadersTimeout = errors.New("netsync: headers timeout")
+	ErrBlockNotFound  = errors.New("netsync: block not found on any peer")
+	ErrBadMerkleRoot  = errors.New("netsync: block merkle root mismatch")
+	ErrMutatedBlock   = errors.New("netsync: block with duplicated transactions")
+	ErrStartAboveTip  = errors.New("netsync: start height above the header chain tip")
+)
+
+const (
+	// DefaultWindow is how far past the next block to process blocks are requested
+	DefaultWindow = 1024
+	// DefaultMaxInFlightPerPeer is the maximal number of blocks requested from a peer at once
+	DefaultMaxInFlightPerPeer = 16
+	// DefaultStallTimeout is how long the next block to process may hold back a full window
+	DefaultStallTimeout   = 2 * time.Second
+	DefaultHeadersTimeout = 2 * time.Minute
+	DefaultBlockTimeout   = 10 * time.Minute
+)
+
+// Config holds the settings of a Syncer, zero fields take their default
+type Config struct {
+	Chain *bcore.HeaderChain
+	// ProcessBlock is called with the blocks of the active chain in height order
+	ProcessBlock func(block *bcore.Block, height uint32) error
+	// Height of the last block already processed, blocks are downloaded from the next one
+	StartHeight        uint32
+	Window             int
+	MaxInFlightPerPeer int
+	StallTimeout       time.Duration
+	HeadersTimeout     time.Duration
+	// How long a requested block may stay unanswered before its peer is disconnected
+	BlockTimeout time.Duration
+}
+
+func (cfg Config) withDefaults() *Config {
+	if cfg.Window == 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.MaxInFlightPerPeer == 0 {
+		cfg.MaxInFlightPerPeer = DefaultMaxInFlightPerPeer
+	}
+	if cfg.StallTimeout == 0 {
+		cfg.StallTimeout = DefaultStallTimeout
+	}
+	if cfg.HeadersTimeout == 0 {
+		cfg.HeadersTimeout = DefaultHeadersTimeout
+	}
+	if cfg.BlockTimeout == 0 {
+		cfg.BlockTimeout = DefaultBlockTimeout
+	}
+	return &cfg
+}
+
+// Syncer brings the header chain and the processed blocks up to date with peers
+type Syncer struct {
+	cfg *Config
+}
+
+func NewSyncer(cfg *Config) *Syncer {
+	return &Syncer{cfg: cfg.withDefaults()}
+}
+
+// Height returns the height of the last processed block
+func (s *Syncer) Height() uint32 {
+	return s.cfg.StartHeight
+}
+
+// Sync downloads the headers from every peer in turn, then the blocks of the active
+// chain from all of them. Peers failing the header sync are disconnected.
+func (s *Syncer) Sync(peers []*peer.Peer) error {
+	synced := false
+	for _, p := range peers {
+		if !isFullNode(p) {
+			continue
+		}
+
+		if err := s.SyncHeaders(p); err != nil {
+			p.Disconnect()
+			continue
+		}
+		synced = true
+	}
+
+	if !synced {
+		return ErrNoPeers
+	}
+
+	return s.SyncBlocks(peers)
+}
+
+// SyncHeaders sends getheaders from the locator of the chain until p has no more
+// headers to give. A peer sending invalid headers is banned.
+func (s *Syncer) SyncHeaders(p *peer.Peer) error {
+	for {
+		err := p.Send(&wire.MsgGetHeaders{BlockLocator: wire.BlockLocator{
+			Version: wire.ProtocolVersion,
+			Hashes:  s.cfg.Chain.Locator(),
+		}})
+		if err != nil {
+			return err
+		}
+
+		headers, err := s.waitHeaders(p)
+		if err != nil {
+			return err
+		}
+
+		if len(headers) == 0 {
+			return nil
+		}
+
+		if _, err := s.cfg.Chain.AddHeaders(headers); err != nil {
+			p.Misbehaving(100, err)
+			return err
+		}
+
+		if len(headers) < wire.MaxHeadersResults {
+			return nil
+		}
+	}
+}
+
+// waitHeaders returns the next headers message of p, dropping anything else meanwhile
+func (s *Syncer) waitHeaders(p *peer.Peer) ([]*bcore.BlockHeader, error) {
+	timer := time.NewTimer(s.cfg.HeadersTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case headers := <-p.Headers():
+			return headers, nil
+		case <-p.Blocks():
+		case <-p.Txs():
+		case <-p.Messages():
+		case <-p.Done():
+			return nil, p.Err()
+		case <-timer.C:
+			p.Disconnect()
+			return nil, ErrHeadersTimeout
+		}
+	}
+}
+
+// isFullNode reports whether p serves the blocks with their witnesses, peers
+// without NODE_WITNESS are not synced from as in Bitcoin Core
+func isFullNode(p *peer.Peer) bool {
+	v := p.Version()
+	return v != nil && v.Services&wire.SFNodeNetwork != 0 && v.Services&wire.SFNodeWitness != 0
+}
+
+// blockRequest is a block asked to a peer
+type blockRequest struct {
+	node *bcore.HeaderNode
+	peer *syncPeer
+	sent time.Time
+}
+
+type syncPeer struct {
+	*peer.Peer
+	inFlight map[Hash]*blockRequest
+	// Blocks the peer answered notfound for
+	missing map[Hash]bool
+}
+
+// peerEvent is a block or notfound message of a peer, or its disconnection
+type peerEvent struct {
+	peer         *syncPeer
+	block        *bcore.Block
+	notFound     []*wire.InvVect
+	disconnected bool
+}
+
+// readPeer forwards the blocks and notfound messages of p until it disconnects
+func readPeer(p *syncPeer, events chan<- *peerEvent, quit <-chan struct{}) {
+	for {
+		e := &peerEvent{peer: p}
+		select {
+		case e.block = <-p.Blocks():
+		case msg := <-p.Messages():
+			m, ok := msg.(*wire.MsgNotFound)
+			if !ok {
+				continue
+			}
+			e.notFound = m.Inventory
+		case <-p.Headers():
+			continue
+		case <-p.Txs():
+			continue
+		case <-p.Done():
+			e.disconnected = true
+		case <-quit:
+			return
+		}
+
+		select {
+		case events <- e:
+		case <-quit:
+			return
+		}
+
+		if e.disconnected {
+			return
+		}
+	}
+}
+
+// blockDownload is the state of SyncBlocks
+type blockDownload struct {
+	cfg   *Config
+	peers map[*syncPeer]bool
+	// Nodes of the active chain to download, nodes[0] being at height first
+	nodes    []*bcore.HeaderNode
+	first    uint32
+	next     uint32
+	inFlight map[Hash]*blockRequest
+	received map[uint32]*bcore.Block
+}
+
+// SyncBlocks downloads the blocks of the active chain past the last processed
+// one from peers and processes them in order. Blocks are requested in a window
+// following the next block to process, spread over the peers. A peer holding
+// back the window for StallTimeout, or not answering a request in BlockTimeout,
+// is disconnected and its requests go to the others.
+func (s *Syncer) SyncBlocks(peers []*peer.Peer) error {
+	d := &blockDownload{
+		cfg:      s.cfg,
+		peers:    make(map[*syncPeer]bool),
+		first:    s.cfg.StartHeight + 1,
+		next:     s.cfg.StartHeight + 1,
+		inFlight: make(map[Hash]*blockRequest),
+		received: make(map[uint32]*bcore.Block),
+	}
+
+	tip := s.cfg.Chain.Tip()
+	if tip.Height < s.cfg.StartHeight {
+		return ErrStartAboveTip
+	}
+	d.nodes = make([]*bcore.HeaderNode, tip.Height-s.cfg.StartHeight)
+	for n := tip; n.Height > s.cfg.StartHeight; n = n.Parent {
+		d.nodes[n.Height-d.first] = n
+	}
+	if len(d.nodes) == 0 {
+		return nil
+	}
+
+	events := make(chan *peerEvent)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	for _, p := range peers {
+		if !isFullNode(p) {
+			continue
+		}
+		sp := &syncPeer{
+			Peer:     p,
+			inFlight: make(map[Hash]*blockRequest),
+			missing:  make(map[Hash]bool),
+		}
+		d.peers[sp] = true
+		go readPeer(sp, events, quit)
+	}
+
+	ticker := time.NewTicker(s.cfg.StallTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		if err := d.schedule(); err != nil {
+			return err
+		}
+
+		select {
+		case e := <-events:
+			if err := d.handle(e); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			d.checkTimeouts()
+		}
+
+		if int(d.next-d.first) == len(d.nodes) {
+			return nil
+		}
+	}
+}
+
+func (d *blockDownload) node(height uint32) *bcore.HeaderNode {
+	return d.nodes[height-d.first]
+}
+
+// windowEnd returns the height following the last block of the window
+func (d *blockDownload) windowEnd() uint32 {
+	end := d.next + uint32(d.cfg.Window)
+	if last := d.first + uint32(len(d.nodes)); end > last {
+		end = last
+	}
+	return end
+}
+
+// schedule requests the blocks of the window neither received nor in flight from
+// the peers with the fewest blocks in flight.
+func (d *blockDownload) schedule() error {
+	if len(d.peers) == 0 {
+		return ErrNoPeers
+	}
+
+	requests := make(map[*syncPeer][]*wire.InvVect)
+	now := time.Now()
+
+	for height := d.next; height < d.windowEnd(); height++ {
+		n := d.node(height)
+		if d.received[height] != nil || d.inFlight[n.Hash] != nil {
+			continue
+		}
+
+		var best *syncPeer
+		missing := true
+		for sp := range d.peers {
+			if sp.missing[n.Hash] {
+				continue
+			}
+			missing = false
+
+			if len(sp.inFlight) < d.cfg.MaxInFlightPerPeer && (best == nil || len(sp.inFlight) < len(best.inFlight)) {
+				best = sp
+			}
+		}
+
+		if missing {
+			return ErrBlockNotFound
+		}
+
+		if best == nil {
+			continue
+		}
+
+		req := &blockRequest{node: n, peer: best, sent: now}
+		best.inFlight[n.Hash] = req
+		d.inFlight[n.Hash] = req
+
+		requests[best] = append(requests[best], wire.NewInvVect(wire.InvTypeWitnessBlock, n.Hash))
+	}
+
+	for sp, inv := range requests {
+		if err := sp.Send(&wire.MsgGetData{Inventory: inv}); err != nil {
+			d.removePeer(sp)
+		}
+	}
+
+	return nil
+}
+
+// removePeer forgets a peer, its requests go back to the schedule
+func (d *blockDownload) removePeer(sp *syncPeer) {
+	if !d.peers[sp] {
+		return
+	}
+
+	for hash := range sp.inFlight {
+		delete(d.inFlight, hash)
+	}
+	delete(d.peers, sp)
+	sp.Disconnect()
+}
+
+func (d *blockDownload) handle(e *peerEvent) error {
+	sp := e.peer
+	if !d.peers[sp] {
+		return nil
+	}
+
+	switch {
+	case e.disconnected:
+		d.removePeer(sp)
+	case e.block != nil:
+		return d.handleBlock(sp, e.block)
+	default:
+		for _, inv := range e.notFound {
+			if req := sp.inFlight[inv.Hash]; req != nil {
+				delete(sp.inFlight, inv.Hash)
+				delete(d.inFlight, inv.Hash)
+				sp.missing[inv.Hash] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// handleBlock checks a block against its header and processes the blocks now in order.
+// Blocks whose transactions or witness data were mutated ban the peer.
+func (d *blockDownload) handleBlock(sp *syncPeer, block *bcore.Block) error {
+	hash := block.Hash()
+
+	// Unrequested blocks are ignored
+	req := sp.inFlight[hash]
+	if req == nil {
+		return nil
+	}
+
+	// Transactions or witnesses not matching the header are the peer's doing, the
+	// block itself may still be valid
+	root, mutated := block.MerkleRootMutated()
+	err := block.CheckWitnessCommitment()
+	switch {
+	case root != req.node.Header.MerkleRoot:
+		err = ErrBadMerkleRoot
+	case mutated:
+		err = ErrMutatedBlock
+	}
+	if err != nil {
+		sp.Misbehaving(100, err)
+		d.removePeer(sp)
+		return nil
+	}
+
+	delete(sp.inFlight, hash)
+	delete(d.inFlight, hash)
+	d.received[req.node.Height] = block
+
+	for {
+		block := d.received[d.next]
+		if block == nil {
+			return nil
+		}
+
+		if err := d.cfg.ProcessBlock(block, d.next); err != nil {
+			return err
+		}
+		delete(d.received, d.next)
+		d.cfg.StartHeight = d.next
+		d.next++
+	}
+}
+
+// checkTimeouts disconnects the peer stalling the window, and peers with a request
+// older than BlockTimeout.
+func (d *blockDownload) checkTimeouts() {
+	now := time.Now()
+
+	if d.next < d.windowEnd() && d.windowFull() {
+		if req := d.inFlight[d.node(d.next).Hash]; req != nil && now.Sub(req.sent) > d.cfg.StallTimeout {
+			d.removePeer(req.peer)
+		}
+	}
+
+	for sp := range d.peers {
+		for _, req := range sp.inFlight {
+			if now.Sub(req.sent) > d.cfg.BlockTimeout {
+				d.removePeer(sp)
+				break
+			}
+		}
+	}
+}
+
+// windowFull reports whether every block of the window is received or in flight
+// while more blocks remain past it, so downloading waits on the next block.
+func (d *blockDownload) windowFull() bool {
+	end := d.windowEnd()
+	if int(end-d.first) == len(d.nodes) {
+		return false
+	}
+
+	for height := d.next; height < end; height++ {
+		if d.received[height] == nil && d.inFlight[d.node(height).Hash] == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,377 @@
+package netsync
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/peer"
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// newTestChain mines n regtest blocks on the genesis block, blocks[0] being the genesis header only
+func newTestChain(n int) []*bcore.Block {
+	params := bcore.RegTestParams
+	blocks := []*bcore.Block{bcore.NewBlock(params.GenesisHeader, nil)}
+
+	for height := uint32(1); height <= uint32(n); height++ {
+		prev := blocks[height-1].Header
+		coinbase := bcore.NewCoinbaseTransaction(height, nil, []*bcore.TransactionOutput{
+			{Value: bcore.Subsidy(height, params), ScriptPubkey: []byte{0x51}},
+		})
+
+		block := bcore.NewBlock(&bcore.BlockHeader{
+			Version:  4,
+			PrevHash: prev.Hash(),
+			Time:     prev.Time + 600,
+			Bits:     prev.Bits,
+		}, []*bcore.Transaction{coinbase})
+		block.Header.MerkleRoot = block.MerkleRoot()
+
+		for bcore.CheckProofOfWork(block.Hash(), block.Header.Bits, params) != nil {
+			block.Header.Nonce++
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// testServer is a node serving blocks, up to height have
+type testServer struct {
+	*peer.Peer
+	blocks []*bcore.Block
+	have   uint32
+	// Never answer getdata
+	stall bool
+	// Send blocks with the transactions of another block
+	corrupt bool
+	// Send blocks with a witness not committed to
+	mutateWitness bool
+	// Headers sent in answer to getheaders, from the blocks if nil
+	headers []*bcore.BlockHeader
+	// Services advertised, network and witness if zero
+	services uint64
+}
+
+func (s *testServer) serve() {
+	chain := bcore.NewHeaderChain(bcore.RegTestParams)
+	for _, block := range s.blocks[1:] {
+		if _, err := chain.AddHeader(block.Header); err != nil {
+			panic(err)
+		}
+	}
+
+	for {
+		var msg wire.Message
+		select {
+		case msg = <-s.Messages():
+		case <-s.Done():
+			return
+		}
+
+		switch m := msg.(type) {
+		case *wire.MsgGetHeaders:
+			if s.headers != nil {
+				s.Send(&wire.MsgHeaders{Headers: s.headers})
+				break
+			}
+
+			fork := chain.FindFork(m.Hashes)
+			var headers []*bcore.BlockHeader
+			for height := fork.Height + 1; len(headers) < wire.MaxHeadersResults; height++ {
+				n, ok := chain.AtHeight(height)
+				if !ok {
+					break
+				}
+				headers = append(headers, n.Header)
+			}
+			s.Send(&wire.MsgHeaders{Headers: headers})
+		case *wire.MsgGetData:
+			if s.stall {
+				break
+			}
+
+			var notFound []*wire.InvVect
+			for _, inv := range m.Inventory {
+				n, ok := chain.Get(inv.Hash)
+				if !ok || n.Height > s.have {
+					notFound = append(notFound, inv)
+					continue
+				}
+
+				block := s.blocks[n.Height]
+				if s.corrupt {
+					block = bcore.NewBlock(block.Header, s.blocks[n.Height-1].Transactions)
+				}
+				if s.mutateWitness {
+					block = mutateWitness(block)
+				}
+				s.Send(&wire.MsgBlock{Block: block})
+			}
+
+			if len(notFound) > 0 {
+				s.Send(&wire.MsgNotFound{Inventory: notFound})
+			}
+		}
+	}
+}
+
+// mutateWitness returns a copy of block with a coinbase witness, which leaves the
+// merkle root unchanged
+func mutateWitness(block *bcore.Block) *bcore.Block {
+	coinbase := *block.Transactions[0]
+	input := *coinbase.Inputs[0]
+	input.ScriptWitness = bcore.NewScriptWitness([][]byte{make([]byte, HashSize)})
+	coinbase.Inputs = []*bcore.TransactionInput{&input}
+	return bcore.NewBlock(block.Header, append([]*bcore.Transaction{&coinbase}, block.Transactions[1:]...))
+}
+
+// newTestServers connects a client peer to each server and starts serving
+func newTestServers(t *testing.T, servers ...*testServer) []*peer.Peer {
+	var clients []*peer.Peer
+	for _, s := range servers {
+		if s.services == 0 {
+			s.services = wire.SFNodeNetwork | wire.SFNodeWitness
+		}
+
+		a, b := net.Pipe()
+		client := peer.NewOutboundPeer(a, &peer.Config{Params: bcore.RegTestParams})
+		s.Peer = peer.NewInboundPeer(b, &peer.Config{
+			Params:   bcore.RegTestParams,
+			Services: s.services,
+		})
+		t.Cleanup(func() {
+			client.Disconnect()
+			s.Disconnect()
+		})
+
+		errs := make(chan error, 2)
+		go func() { errs <- client.Start() }()
+		go func() { errs <- s.Start() }()
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		go s.serve()
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+// newTestSyncer returns a syncer checking blocks are processed in order
+func newTestSyncer(t *testing.T, blocks []*bcore.Block, cfg *Config) *Syncer {
+	cfg.Chain = bcore.NewHeaderChain(bcore.RegTestParams)
+	next := cfg.StartHeight + 1
+	cfg.ProcessBlock = func(block *bcore.Block, height uint32) error {
+		if height != next || block.Hash() != blocks[height].Hash() {
+			t.Fatalf("process: unexpected block at height %d", height)
+		}
+		next++
+		return nil
+	}
+
+	return NewSyncer(cfg)
+}
+
+func TestSync(t *testing.T) {
+	blocks := newTestChain(2100)
+	peers := newTestServers(t,
+		&testServer{blocks: blocks, have: 2100},
+		&testServer{blocks: blocks, have: 1000},
+		&testServer{blocks: blocks, have: 2100},
+	)
+
+	s := newTestSyncer(t, blocks, &Config{Window: 64})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	if tip := s.cfg.Chain.Tip(); tip.Height != 2100 || tip.Hash != blocks[2100].Hash() {
+		t.Fatalf("header chain: unexpected tip at height %d", tip.Height)
+	}
+
+	if s.Height() != 2100 {
+		t.Fatalf("expect height 2100, got %d", s.Height())
+	}
+
+	// Nothing left to do
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncFromHeight(t *testing.T) {
+	blocks := newTestChain(50)
+	peers := newTestServers(t, &testServer{blocks: blocks, have: 50})
+
+	s := newTestSyncer(t, blocks, &Config{StartHeight: 30})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Height() != 50 {
+		t.Fatalf("expect height 50, got %d", s.Height())
+	}
+}
+
+func TestSyncWitnessPeers(t *testing.T) {
+	blocks := newTestChain(50)
+	// Blocks asked to the peer without witness would fail and get it banned
+	peers := newTestServers(t,
+		&testServer{blocks: blocks, have: 50, corrupt: true, services: wire.SFNodeNetwork},
+		&testServer{blocks: blocks, have: 50},
+	)
+
+	if err := newTestSyncer(t, blocks, &Config{}).Sync(peers[:1]); err != ErrNoPeers {
+		t.Fatalf("expect %v, got %v", ErrNoPeers, err)
+	}
+
+	s := newTestSyncer(t, blocks, &Config{})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Height() != 50 {
+		t.Fatalf("expect height 50, got %d", s.Height())
+	}
+
+	select {
+	case <-peers[0].Done():
+		t.Fatalf("peer without witness disconnected: %v", peers[0].Err())
+	default:
+	}
+}
+
+func TestSyncStalling(t *testing.T) {
+	blocks := newTestChain(200)
+	servers := []*testServer{
+		{blocks: blocks, have: 200, stall: true},
+		{blocks: blocks, have: 200},
+	}
+	peers := newTestServers(t, servers...)
+
+	s := newTestSyncer(t, blocks, &Config{Window: 32, MaxInFlightPerPeer: 8, StallTimeout: 100 * time.Millisecond})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Height() != 200 {
+		t.Fatalf("expect height 200, got %d", s.Height())
+	}
+
+	select {
+	case <-peers[0].Done():
+	default:
+		t.Fatal("stalling peer still connected")
+	}
+}
+
+func TestSyncBlockTimeout(t *testing.T) {
+	blocks := newTestChain(10)
+	peers := newTestServers(t, &testServer{blocks: blocks, have: 10, stall: true})
+
+	s := newTestSyncer(t, blocks, &Config{StallTimeout: 40 * time.Millisecond, BlockTimeout: 100 * time.Millisecond})
+	if err := s.Sync(peers); err != ErrNoPeers {
+		t.Fatalf("expect %v, got %v", ErrNoPeers, err)
+	}
+}
+
+func TestSyncBadBlocks(t *testing.T) {
+	blocks := newTestChain(100)
+	peers := newTestServers(t,
+		&testServer{blocks: blocks, have: 100, corrupt: true},
+		&testServer{blocks: blocks, have: 100},
+	)
+
+	s := newTestSyncer(t, blocks, &Config{Window: 16, MaxInFlightPerPeer: 4})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	<-peers[0].Done()
+	if err := peers[0].Err(); !errors.Is(err, peer.ErrPeerMisbehaving) {
+		t.Fatalf("expect %v, got %v", peer.ErrPeerMisbehaving, err)
+	}
+}
+
+func TestSyncMutatedWitness(t *testing.T) {
+	blocks := newTestChain(100)
+	peers := newTestServers(t,
+		&testServer{blocks: blocks, have: 100, mutateWitness: true},
+		&testServer{blocks: blocks, have: 100},
+	)
+
+	s := newTestSyncer(t, blocks, &Config{Window: 16, MaxInFlightPerPeer: 4})
+	if err := s.Sync(peers); err != nil {
+		t.Fatal(err)
+	}
+
+	<-peers[0].Done()
+	if err := peers[0].Err(); !errors.Is(err, peer.ErrPeerMisbehaving) {
+		t.Fatalf("expect %v, got %v", peer.ErrPeerMisbehaving, err)
+	}
+}
+
+func TestSyncBlockNotFound(t *testing.T) {
+	blocks := newTestChain(20)
+	peers := newTestServers(t, &testServer{blocks: blocks, have: 10})
+
+	s := newTestSyncer(t, blocks, &Config{})
+	if err := s.Sync(peers); err != ErrBlockNotFound {
+		t.Fatalf("expect %v, got %v", ErrBlockNotFound, err)
+	}
+}
+
+func TestSyncBadHeaders(t *testing.T) {
+	blocks := newTestChain(20)
+
+	// Headers skipping one block do not connect
+	headers := []*bcore.BlockHeader{blocks[1].Header, blocks[3].Header}
+	peers := newTestServers(t, &testServer{blocks: blocks, have: 20, headers: headers})
+
+	s := newTestSyncer(t, blocks, &Config{})
+	if err := s.SyncHeaders(peers[0]); err != bcore.ErrHeaderNotContinue {
+		t.Fatalf("expect %v, got %v", bcore.ErrHeaderNotContinue, err)
+	}
+
+	<-peers[0].Done()
+	if err := peers[0].Err(); !errors.Is(err, peer.ErrPeerMisbehaving) {
+		t.Fatalf("expect %v, got %v", peer.ErrPeerMisbehaving, err)
+	}
+
+	if err := s.Sync(peers); err != ErrNoPeers {
+		t.Fatalf("expect %v, got %v", ErrNoPeers, err)
+	}
+}
+
+func TestBlockLocatorMessage(t *testing.T) {
+	chain := bcore.NewHeaderChain(bcore.RegTestParams)
+	blocks := newTestChain(300)
+	for _, block := range blocks[1:] {
+		if _, err := chain.AddHeader(block.Header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locator := chain.Locator()
+	if len(locator) > wire.MaxLocatorSize || locator[0] != blocks[300].Hash() || locator[len(locator)-1] != blocks[0].Hash() {
+		t.Fatalf("unexpected locator of %d hashes", len(locator))
+	}
+
+	msg := &wire.MsgGetHeaders{BlockLocator: wire.BlockLocator{Version: wire.ProtocolVersion, Hashes: locator}}
+	decoded, err := wire.NewMessageFromBytes(msg.Command(), msg.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m := decoded.(*wire.MsgGetHeaders); len(m.Hashes) != len(locator) || m.HashStop != HashZero {
+		t.Fatal("getheaders: locator mismatch")
+	}
+}
@@ -0,0 +1,138 @@
+package bcore
+
+import (
+	"encoding/hex"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// Coin is the number of satoshis in one bitcoin.
+	Coin = 100000000
+	// MaxMoney is the maximum amount of satoshis that can ever exist.
+	MaxMoney = 21000000 * Coin
+)
+
+// ChainParams defines the consensus parameters of a bitcoin network
+type ChainParams struct {
+	// Human-readable name of the network
+	Name string
+	// The network magic which prefixes every message and block file record.
+	// It is stored as the little-endian uint32 of the 4 magic bytes.
+	Magic uint32
+	// Number of blocks between block subsidy halvings
+	SubsidyHalvingInterval uint32
+	// Number of confirmations before a coinbase output can be spent
+	CoinbaseMaturity uint32
+	// The height from which the coinbase must start with the block height (BIP34)
+	BIP34Height uint32
+	// The heights from which signatures must be strict DER (BIP66) and
+	// OP_CHECKLOCKTIMEVERIFY is enforced (BIP65)
+	BIP66Height uint32
+	BIP65Height uint32
+	// Header of the first block of the chain
+	GenesisHeader *BlockHeader
+	// The easiest target blocks can have
+	PowLimit Compact
+	// Expected time of a difficulty period, in seconds
+	PowTargetTimespan uint32
+	// Expected time between blocks, in seconds
+	PowTargetSpacing uint32
+	// Whether a block more than twice the target spacing after its parent may use the pow limit (testnet)
+	PowAllowMinDifficultyBlocks bool
+	// Whether the difficulty never changes (regtest)
+	PowNoRetargeting bool
+}
+
+// DifficultyAdjustmentInterval returns the number of blocks of a difficulty period
+func (p *ChainParams) DifficultyAdjustmentInterval() uint32 {
+	return p.PowTargetTimespan / p.PowTargetSpacing
+}
+
+// genesisMerkleRoot is the merkle root shared by the genesis blocks of every network
+var genesisMerkleRoot = mustParseRHash("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+
+// mustParseRHash parses a hash displayed in reversed byte order
+func mustParseRHash(s string) Hash {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != HashSize {
+		panic("bcore: bad hash " + s)
+	}
+
+	var h Hash
+	for i := range b {
+		h[HashSize-1-i] = b[i]
+	}
+	return h
+}
+
+func newGenesisHeader(time uint32, bits uint32, nonce uint32) *BlockHeader {
+	return &BlockHeader{
+		Version:    1,
+		MerkleRoot: genesisMerkleRoot,
+		Time:       time,
+		Bits:       NewCompact(bits),
+		Nonce:      nonce,
+	}
+}
+
+var (
+	MainNetParams = &ChainParams{
+		Name:                   "main",
+		Magic:                  0xd9b4bef9,
+		SubsidyHalvingInterval: 210000,
+		CoinbaseMaturity:       100,
+		BIP34Height:            227931,
+		BIP66Height:            363725,
+		BIP65Height:            388381,
+		GenesisHeader:          newGenesisHeader(1231006505, 0x1d00ffff, 2083236893),
+		PowLimit:               NewCompact(0x1d00ffff),
+		PowTargetTimespan:      14 * 24 * 60 * 60,
+		PowTargetSpacing:       10 * 60,
+	}
+
+	TestNet3Params = &ChainParams{
+		Name:                        "test",
+		Magic:                       0x0709110b,
+		SubsidyHalvingInterval:      210000,
+		CoinbaseMaturity:            100,
+		BIP34Height:                 21111,
+		BIP66Height:                 330776,
+		BIP65Height:                 581885,
+		GenesisHeader:               newGenesisHeader(1296688602, 0x1d00ffff, 414098458),
+		PowLimit:                    NewCompact(0x1d00ffff),
+		PowTargetTimespan:           14 * 24 * 60 * 60,
+		PowTargetSpacing:            10 * 60,
+		PowAllowMinDifficultyBlocks: true,
+	}
+
+	SigNetParams = &ChainParams{
+		Name:                   "signet",
+		Magic:                  0x40cf030a,
+		SubsidyHalvingInterval: 210000,
+		CoinbaseMaturity:       100,
+		BIP34Height:            1,
+		BIP66Height:            1,
+		BIP65Height:            1,
+		GenesisHeader:          newGenesisHeader(1598918400, 0x1e0377ae, 52613770),
+		PowLimit:               NewCompact(0x1e0377ae),
+		PowTargetTimespan:      14 * 24 * 60 * 60,
+		PowTargetSpacing:       10 * 60,
+	}
+
+	RegTestParams = &ChainParams{
+		Name:                        "regtest",
+		Magic:                       0xdab5bffa,
+		SubsidyHalvingInterval:      150,
+		CoinbaseMaturity:            100,
+		BIP34Height:                 1,
+		BIP66Height:                 1,
+		BIP65Height:                 1,
+		GenesisHeader:               newGenesisHeader(1296688602, 0x207fffff, 2),
+		PowLimit:                    NewCompact(0x207fffff),
+		PowTargetTimespan:           14 * 24 * 60 * 60,
+		PowTargetSpacing:            10 * 60,
+		PowAllowMinDifficultyBlocks: true,
+		PowNoRetargeting:            true,
+	}
+)
@@ -0,0 +1,645 @@
+// Package peer manages a connection to a bitcoin node speaking the P2P protocol.
+package peer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/wire"
+)
+
+var (
+	ErrPeerDisconnected     = errors.New("peer: disconnected")
+	ErrPeerHandshake        = errors.New("peer: unexpected message during handshake")
+	ErrPeerHandshakeTimeout = errors.New("peer: handshake timeout")
+	ErrPeerSelfConnection   = errors.New("peer: connected to self")
+	ErrPeerObsoleteVersion  = errors.New("peer: obsolete protocol version")
+	ErrPeerPingTimeout      = errors.New("peer: ping timeout")
+	ErrPeerMisbehaving      = errors.New("peer: misbehaving")
+)
+
+const (
+	// MinProtocolVersion is the oldest protocol version peers may use
+	MinProtocolVersion = 31800
+
+	DefaultUserAgent        = "/bcore:0.1.0/"
+	DefaultHandshakeTimeout = 60 * time.Second
+	DefaultPingInterval     = 2 * time.Minute
+	DefaultPingTimeout      = 20 * time.Minute
+	// DefaultBanThreshold is the misbehavior score at which a peer is disconnected
+	DefaultBanThreshold = 100
+	// DefaultQueueSize is the capacity of the outgoing queue and of the received channels
+	DefaultQueueSize = 64
+)
+
+// Config holds the settings of a Peer, zero fields take their default
+type Config struct {
+	Params          *bcore.ChainParams
+	ProtocolVersion int32
+	Services        uint64
+	UserAgent       string
+	// Height of our best block, sent in our version message
+	StartHeight int32
+	// Whether we want transactions relayed
+	Relay bool
+	// Whether we offer transaction reconciliation, BIP330
+	TxReconciliation bool
+	// Maximal payload size of received messages, wire.MaxMessagePayload by default
+	MaxMessageSize   uint32
+	HandshakeTimeout time.Duration
+	PingInterval     time.Duration
+	PingTimeout      time.Duration
+	BanThreshold     int
+	QueueSize        int
+}
+
+func (cfg Config) withDefaults() *Config {
+	if cfg.ProtocolVersion == 0 {
+		cfg.ProtocolVersion = wire.ProtocolVersion
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+	if cfg.MaxMessageSize == 0 {
+		cfg.MaxMessageSize = wire.MaxMessagePayload
+	}
+	if cfg.HandshakeTimeout == 0 {
+		cfg.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = DefaultPingInterval
+	}
+	if cfg.PingTimeout == 0 {
+		cfg.PingTimeout = DefaultPingTimeout
+	}
+	if cfg.BanThreshold == 0 {
+		cfg.BanThreshold = DefaultBanThreshold
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	return &cfg
+}
+
+// Peer is a connection to a node. Start performs the version handshake, after which
+// pings are answered and sent, and blocks, headers and transactions are delivered
+// on their channels. Other messages are delivered on Messages.
+type Peer struct {
+	cfg     *Config
+	conn    net.Conn
+	inbound bool
+	nonce   uint64
+
+	out      chan wire.Message
+	blocks   chan *bcore.Block
+	headers  chan []*bcore.BlockHeader
+	txs      chan *bcore.Transaction
+	messages chan wire.Message
+
+	handshake chan struct{}
+	quit      chan struct{}
+	once      sync.Once
+
+	mu         sync.Mutex
+	err        error
+	version    *wire.MsgVersion
+	wtxidRelay bool
+	sendAddrV2 bool
+	sendHeader bool
+	feeFilter  int64
+	score      int
+	pingNonce  uint64
+	pingSent   time.Time
+	latency    time.Duration
+
+	// Salts of the short transaction IDs of reconciliation, once both peers offered it
+	reconSalt       uint64
+	remoteReconSalt uint64
+	txRecon         bool
+}
+
+func newPeer(conn net.Conn, cfg *Config, inbound bool) *Peer {
+	cfg = cfg.withDefaults()
+
+	return &Peer{
+		cfg:       cfg,
+		conn:      conn,
+		inbound:   inbound,
+		nonce:     randomNonce(),
+		reconSalt: randomNonce(),
+		out:       make(chan wire.Message, cfg.QueueSize),
+		blocks:    make(chan *bcore.Block, cfg.QueueSize),
+		headers:   make(chan []*bcore.BlockHeader, cfg.QueueSize),
+		txs:       make(chan *bcore.Transaction, cfg.QueueSize),
+		messages:  make(chan wire.Message, cfg.QueueSize),
+		handshake: make(chan struct{}),
+		quit:      make(chan struct{}),
+	}
+}
+
+// NewOutboundPeer wraps a connection we opened, we send our version first
+func NewOutboundPeer(conn net.Conn, cfg *Config) *Peer {
+	return newPeer(conn, cfg, false)
+}
+
+// NewInboundPeer wraps a connection accepted from a node, which sends its version first
+func NewInboundPeer(conn net.Conn, cfg *Config) *Peer {
+	return newPeer(conn, cfg, true)
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// Start runs the connection and returns once the handshake completed or failed
+func (p *Peer) Start() error {
+	go p.writeLoop()
+	go p.readLoop()
+
+	if !p.inbound {
+		p.queue(p.localVersion())
+	}
+
+	timer := time.NewTimer(p.cfg.HandshakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.handshake:
+		go p.pingLoop()
+		return nil
+	case <-p.quit:
+		return p.Err()
+	case <-timer.C:
+		p.disconnect(ErrPeerHandshakeTimeout)
+		return ErrPeerHandshakeTimeout
+	}
+}
+
+func (p *Peer) localVersion() *wire.MsgVersion {
+	addr := &wire.NetAddress{IP: net.IPv6zero}
+	if tcp, ok := p.conn.RemoteAddr().(*net.TCPAddr); ok {
+		addr = wire.NewNetAddress(tcp, 0)
+	}
+
+	return &wire.MsgVersion{
+		Version:     p.cfg.ProtocolVersion,
+		Services:    p.cfg.Services,
+		Timestamp:   time.Now().Unix(),
+		AddrRecv:    addr,
+		AddrFrom:    &wire.NetAddress{Services: p.cfg.Services, IP: net.IPv6zero},
+		Nonce:       p.nonce,
+		UserAgent:   p.cfg.UserAgent,
+		StartHeight: p.cfg.StartHeight,
+		Relay:       p.cfg.Relay,
+	}
+}
+
+// queue adds msg to the outgoing queue, without waiting for the handshake
+func (p *Peer) queue(msg wire.Message) error {
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+}
+
+// Send queues a message once the handshake completed
+func (p *Peer) Send(msg wire.Message) error {
+	select {
+	case <-p.handshake:
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+
+	return p.queue(msg)
+}
+
+// SendAddresses relays addrs with addrv2 when the peer asked for it, with addr otherwise
+// dropping the addresses addr cannot carry.
+func (p *Peer) SendAddresses(addrs []*wire.NetAddressV2) error {
+	var msgs []wire.Message
+	if p.SendAddrV2() {
+		var batch []*wire.NetAddressV2
+		for _, na := range addrs {
+			if !na.Supported() {
+				continue
+			}
+			if batch = append(batch, na); len(batch) == wire.MaxAddrToSend {
+				msgs = append(msgs, &wire.MsgAddrV2{Addresses: batch})
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			msgs = append(msgs, &wire.MsgAddrV2{Addresses: batch})
+		}
+	} else {
+		var batch []*wire.NetAddress
+		for _, na := range addrs {
+			v1, err := na.ToV1()
+			if err != nil {
+				continue
+			}
+			if batch = append(batch, v1); len(batch) == wire.MaxAddrToSend {
+				msgs = append(msgs, &wire.MsgAddr{Addresses: batch})
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			msgs = append(msgs, &wire.MsgAddr{Addresses: batch})
+		}
+	}
+
+	for _, msg := range msgs {
+		if err := p.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case msg := <-p.out:
+			if err := wire.WriteMessage(p.conn, p.cfg.Params.Magic, msg); err != nil {
+				p.disconnect(err)
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) readLoop() {
+	for {
+		msg, err := wire.ReadMessageMax(p.conn, p.cfg.Params.Magic, p.cfg.MaxMessageSize)
+		if merr, ok := err.(*wire.MessageError); ok {
+			// Malformed payloads are scored, the stream is still in sync
+			p.Misbehaving(20, merr)
+			continue
+		}
+
+		if err != nil {
+			p.disconnect(err)
+			return
+		}
+
+		if err := p.handle(msg); err != nil {
+			p.disconnect(err)
+			return
+		}
+	}
+}
+
+func (p *Peer) handshaking() bool {
+	select {
+	case <-p.handshake:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *Peer) handle(msg wire.Message) error {
+	if p.handshaking() {
+		return p.handleHandshake(msg)
+	}
+
+	switch m := msg.(type) {
+	case *wire.MsgVersion, *wire.MsgVerack, *wire.MsgWtxidRelay, *wire.MsgSendAddrV2, *wire.MsgSendTxRcncl:
+		// Only valid during the handshake
+		p.Misbehaving(1, ErrPeerHandshake)
+	case *wire.MsgPing:
+		return p.queue(&wire.MsgPong{Nonce: m.Nonce})
+	case *wire.MsgPong:
+		p.handlePong(m)
+	case *wire.MsgSendHeaders:
+		p.mu.Lock()
+		p.sendHeader = true
+		p.mu.Unlock()
+	case *wire.MsgFeeFilter:
+		if m.FeeRate < 0 || uint64(m.FeeRate) > bcore.MaxMoney {
+			p.Misbehaving(1, errors.New("peer: invalid fee filter"))
+			break
+		}
+		p.mu.Lock()
+		p.feeFilter = m.FeeRate
+		p.mu.Unlock()
+	case *wire.MsgBlock:
+		return p.deliverBlock(m.Block)
+	case *wire.MsgHeaders:
+		return p.deliverHeaders(m.Headers)
+	case *wire.MsgTx:
+		return p.deliverTx(m.Tx)
+	default:
+		select {
+		case p.messages <- msg:
+		case <-p.quit:
+			return ErrPeerDisconnected
+		}
+	}
+
+	return nil
+}
+
+// handleHandshake processes the messages before verack: the version of the
+// peer, followed by the feature negotiation messages and its verack.
+func (p *Peer) handleHandshake(msg wire.Message) error {
+	p.mu.Lock()
+	version := p.version
+	p.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *wire.MsgVersion:
+		if version != nil {
+			return ErrPeerHandshake
+		}
+
+		if m.Nonce == p.nonce && !p.inbound {
+			return ErrPeerSelfConnection
+		}
+
+		if m.Version < MinProtocolVersion {
+			return ErrPeerObsoleteVersion
+		}
+
+		p.mu.Lock()
+		p.version = m
+		p.mu.Unlock()
+
+		if p.inbound {
+			if err := p.queue(p.localVersion()); err != nil {
+				return err
+			}
+		}
+
+		// Feature negotiation goes between version and verack
+		if m.Version >= wire.WtxidRelayVersion && p.cfg.ProtocolVersion >= wire.WtxidRelayVersion {
+			if err := p.queue(&wire.MsgWtxidRelay{}); err != nil {
+				return err
+			}
+		}
+		if err := p.queue(&wire.MsgSendAddrV2{}); err != nil {
+			return err
+		}
+		if p.offersTxReconciliation(m) {
+			if err := p.queue(&wire.MsgSendTxRcncl{Version: wire.TxReconciliationVersion, Salt: p.reconSalt}); err != nil {
+				return err
+			}
+		}
+
+		return p.queue(&wire.MsgVerack{})
+	case *wire.MsgWtxidRelay:
+		if version == nil {
+			return ErrPeerHandshake
+		}
+		if version.Version >= wire.WtxidRelayVersion && p.cfg.ProtocolVersion >= wire.WtxidRelayVersion {
+			p.mu.Lock()
+			p.wtxidRelay = true
+			p.mu.Unlock()
+		}
+	case *wire.MsgSendAddrV2:
+		if version == nil {
+			return ErrPeerHandshake
+		}
+		p.mu.Lock()
+		p.sendAddrV2 = true
+		p.mu.Unlock()
+	case *wire.MsgSendTxRcncl:
+		if version == nil || m.Version < 1 {
+			return ErrPeerHandshake
+		}
+
+		// Only after wtxidrelay, and when we offered it too
+		p.mu.Lock()
+		if p.wtxidRelay && p.offersTxReconciliation(version) {
+			p.txRecon = true
+			p.remoteReconSalt = m.Salt
+		}
+		p.mu.Unlock()
+	case *wire.MsgVerack:
+		if version == nil {
+			return ErrPeerHandshake
+		}
+		close(p.handshake)
+	default:
+		if version == nil {
+			return ErrPeerHandshake
+		}
+		// Other messages before verack are ignored, like Bitcoin Core does
+	}
+
+	return nil
+}
+
+func (p *Peer) deliverBlock(block *bcore.Block) error {
+	select {
+	case p.blocks <- block:
+		return nil
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+}
+
+func (p *Peer) deliverHeaders(headers []*bcore.BlockHeader) error {
+	select {
+	case p.headers <- headers:
+		return nil
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+}
+
+func (p *Peer) deliverTx(tx *bcore.Transaction) error {
+	select {
+	case p.txs <- tx:
+		return nil
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+}
+
+// pingLoop pings the peer every PingInterval and disconnects it when a ping
+// stays unanswered for PingTimeout.
+func (p *Peer) pingLoop() {
+	ticker := time.NewTicker(p.cfg.PingInterval)
+	defer ticker.Stop()
+
+	p.ping()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			waiting := p.pingNonce != 0
+			expired := waiting && time.Since(p.pingSent) > p.cfg.PingTimeout
+			p.mu.Unlock()
+
+			if expired {
+				p.disconnect(ErrPeerPingTimeout)
+				return
+			}
+
+			if !waiting {
+				p.ping()
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) ping() {
+	nonce := randomNonce()
+
+	p.mu.Lock()
+	p.pingNonce = nonce
+	p.pingSent = time.Now()
+	p.mu.Unlock()
+
+	p.queue(&wire.MsgPing{Nonce: nonce})
+}
+
+func (p *Peer) handlePong(m *wire.MsgPong) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Pongs to older pings are ignored
+	if p.pingNonce == 0 || m.Nonce != p.pingNonce {
+		return
+	}
+
+	p.latency = time.Since(p.pingSent)
+	p.pingNonce = 0
+}
+
+// Misbehaving adds howmuch to the misbehavior score of the peer and disconnects
+// it once the score reaches the ban threshold.
+func (p *Peer) Misbehaving(howmuch int, reason error) {
+	p.mu.Lock()
+	p.score += howmuch
+	banned := p.score >= p.cfg.BanThreshold
+	p.mu.Unlock()
+
+	if banned {
+		p.disconnect(fmt.Errorf("%w: %v", ErrPeerMisbehaving, reason))
+	}
+}
+
+func (p *Peer) disconnect(err error) {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// Disconnect closes the connection
+func (p *Peer) Disconnect() {
+	p.disconnect(ErrPeerDisconnected)
+}
+
+// Done is closed once the peer is disconnected
+func (p *Peer) Done() <-chan struct{} { return p.quit }
+
+// Err returns why the peer disconnected
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Peer) Blocks() <-chan *bcore.Block          { return p.blocks }
+func (p *Peer) Headers() <-chan []*bcore.BlockHeader { return p.headers }
+func (p *Peer) Txs() <-chan *bcore.Transaction       { return p.txs }
+func (p *Peer) Messages() <-chan wire.Message        { return p.messages }
+
+func (p *Peer) Addr() net.Addr { return p.conn.RemoteAddr() }
+func (p *Peer) Inbound() bool  { return p.inbound }
+
+// Version returns the version message of the peer, nil before it was received
+func (p *Peer) Version() *wire.MsgVersion {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.version
+}
+
+// ProtocolVersion returns the protocol version both sides use
+func (p *Peer) ProtocolVersion() int32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.version == nil || p.version.Version > p.cfg.ProtocolVersion {
+		return p.cfg.ProtocolVersion
+	}
+	return p.version.Version
+}
+
+// WtxidRelay reports whether transactions are announced by wtxid, BIP339
+func (p *Peer) WtxidRelay() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.wtxidRelay
+}
+
+// SendAddrV2 reports whether the peer wants addresses relayed with addrv2, BIP155
+func (p *Peer) SendAddrV2() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sendAddrV2
+}
+
+// offersTxReconciliation reports whether we send sendtxrcncl to a peer of version:
+// both relay transactions and announce them by wtxid
+func (p *Peer) offersTxReconciliation(version *wire.MsgVersion) bool {
+	return p.cfg.TxReconciliation && p.cfg.Relay && version.Relay &&
+		version.Version >= wire.WtxidRelayVersion && p.cfg.ProtocolVersion >= wire.WtxidRelayVersion
+}
+
+// TxReconciliation returns our salt and the salt of the peer of short transaction IDs,
+// ok when both peers offered transaction reconciliation, BIP330. The peer which opened
+// the connection initiates the reconciliation rounds.
+func (p *Peer) TxReconciliation() (salt, remoteSalt uint64, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reconSalt, p.remoteReconSalt, p.txRecon
+}
+
+// SendHeaders reports whether the peer wants new blocks announced with headers, BIP130
+func (p *Peer) SendHeaders() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sendHeader
+}
+
+// FeeFilter returns the minimal fee rate in sat/kvB of the transactions the peer wants announced
+func (p *Peer) FeeFilter() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.feeFilter
+}
+
+func (p *Peer) Score() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.score
+}
+
+// Latency returns the round trip time of the last answered ping
+func (p *Peer) Latency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latency
+}
@@ -0,0 +1,309 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func newTestPeers(t *testing.T, outCfg, inCfg *Config) (*Peer, *Peer) {
+	a, b := net.Pipe()
+	out := NewOutboundPeer(a, outCfg)
+	in := NewInboundPeer(b, inCfg)
+	t.Cleanup(func() {
+		out.Disconnect()
+		in.Disconnect()
+	})
+
+	errs := make(chan error, 2)
+	go func() { errs <- out.Start() }()
+	go func() { errs <- in.Start() }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return out, in
+}
+
+func waitDisconnect(t *testing.T, p *Peer) error {
+	select {
+	case <-p.Done():
+		return p.Err()
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer still connected")
+		return nil
+	}
+}
+
+func TestPeerHandshake(t *testing.T) {
+	out, in := newTestPeers(t,
+		&Config{Params: bcore.RegTestParams, Services: wire.SFNodeNetwork | wire.SFNodeWitness, StartHeight: 120, Relay: true},
+		&Config{Params: bcore.RegTestParams, ProtocolVersion: 70015, UserAgent: "/old:1.0/"},
+	)
+
+	v := in.Version()
+	if v == nil || v.Services != wire.SFNodeNetwork|wire.SFNodeWitness || v.StartHeight != 120 || !v.Relay || v.Version != wire.ProtocolVersion {
+		t.Fatalf("inbound: unexpected version %+v", v)
+	}
+
+	if v := out.Version(); v == nil || v.UserAgent != "/old:1.0/" || v.Relay {
+		t.Fatalf("outbound: unexpected version %+v", v)
+	}
+
+	if out.ProtocolVersion() != 70015 || in.ProtocolVersion() != 70015 {
+		t.Fatalf("protocol version: got %d and %d", out.ProtocolVersion(), in.ProtocolVersion())
+	}
+
+	// The inbound peer is too old for wtxidrelay, both support addrv2
+	if out.WtxidRelay() || in.WtxidRelay() || !out.SendAddrV2() || !in.SendAddrV2() {
+		t.Fatal("unexpected feature negotiation")
+	}
+
+	if !in.Inbound() || out.Inbound() {
+		t.Fatal("unexpected direction")
+	}
+}
+
+func TestPeerTxReconciliation(t *testing.T) {
+	cfg := &Config{Params: bcore.RegTestParams, Relay: true, TxReconciliation: true}
+	out, in := newTestPeers(t, cfg, cfg)
+
+	outSalt, outRemote, outOk := out.TxReconciliation()
+	inSalt, inRemote, inOk := in.TxReconciliation()
+	if !outOk || !inOk || outRemote != inSalt || inRemote != outSalt {
+		t.Fatal("transaction reconciliation not negotiated")
+	}
+
+	// Both peers must offer it
+	out, in = newTestPeers(t, cfg, &Config{Params: bcore.RegTestParams, Relay: true})
+	if _, _, ok := out.TxReconciliation(); ok {
+		t.Fatal("outbound: unexpected transaction reconciliation")
+	}
+	if _, _, ok := in.TxReconciliation(); ok {
+		t.Fatal("inbound: unexpected transaction reconciliation")
+	}
+}
+
+func TestPeerMessages(t *testing.T) {
+	cfg := &Config{Params: bcore.RegTestParams, PingInterval: 10 * time.Millisecond}
+	out, in := newTestPeers(t, cfg, cfg)
+
+	if !out.WtxidRelay() || !in.WtxidRelay() {
+		t.Fatal("wtxidrelay not negotiated")
+	}
+
+	header := &bcore.BlockHeader{Version: 4, PrevHash: Hash{1}, Bits: NewCompact(0x207fffff)}
+	tx := &bcore.Transaction{
+		Version: 2,
+		Inputs:  []*bcore.TransactionInput{{PrevOutput: bcore.NewOutPoint(Hash{2}, 0), ScriptSig: []byte{}, Sequence: 0xffffffff}},
+		Outputs: []*bcore.TransactionOutput{{Value: 1000, ScriptPubkey: []byte{0x51}}},
+	}
+
+	for _, msg := range []wire.Message{
+		&wire.MsgSendHeaders{},
+		&wire.MsgFeeFilter{FeeRate: 1000},
+		&wire.MsgHeaders{Headers: []*bcore.BlockHeader{header}},
+		&wire.MsgBlock{Block: bcore.NewBlock(header, []*bcore.Transaction{tx})},
+		&wire.MsgTx{Tx: tx},
+		&wire.MsgInv{Inventory: []*wire.InvVect{wire.NewInvVect(wire.InvTypeWTx, tx.WitnessHash())}},
+	} {
+		if err := out.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeout := time.After(5 * time.Second)
+	select {
+	case headers := <-in.Headers():
+		if len(headers) != 1 || headers[0].Hash() != header.Hash() {
+			t.Fatal("unexpected headers")
+		}
+	case <-timeout:
+		t.Fatal("headers not received")
+	}
+
+	select {
+	case block := <-in.Blocks():
+		if block.Hash() != header.Hash() || len(block.Transactions) != 1 {
+			t.Fatal("unexpected block")
+		}
+	case <-timeout:
+		t.Fatal("block not received")
+	}
+
+	select {
+	case got := <-in.Txs():
+		if got.Hash() != tx.Hash() {
+			t.Fatal("unexpected transaction")
+		}
+	case <-timeout:
+		t.Fatal("transaction not received")
+	}
+
+	select {
+	case msg := <-in.Messages():
+		if inv, ok := msg.(*wire.MsgInv); !ok || len(inv.Inventory) != 1 {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-timeout:
+		t.Fatal("inv not received")
+	}
+
+	if !in.SendHeaders() || in.FeeFilter() != 1000 {
+		t.Fatal("sendheaders or feefilter not recorded")
+	}
+
+	// Pings are answered
+	deadline := time.Now().Add(5 * time.Second)
+	for out.Latency() == 0 || in.Latency() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pings not answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPeerSendAddresses(t *testing.T) {
+	cfg := &Config{Params: bcore.RegTestParams}
+	out, in := newTestPeers(t, cfg, cfg)
+
+	tor, err := wire.NewNetAddressV2("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", 8333, wire.SFNodeNetwork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := []*wire.NetAddressV2{
+		tor,
+		{Network: wire.NetIPv4, Addr: []byte{1, 2, 3, 4}, Port: 8333},
+		// Unknown networks are not relayed
+		{Network: 42, Addr: []byte{1}},
+	}
+	if err := out.SendAddresses(addrs); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-in.Messages():
+		m, ok := msg.(*wire.MsgAddrV2)
+		if !ok || len(m.Addresses) != 2 || m.Addresses[0].Host() != tor.Host() {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("addrv2 not received")
+	}
+}
+
+func TestPeerMisbehaving(t *testing.T) {
+	out, in := newTestPeers(t, &Config{Params: bcore.RegTestParams}, &Config{Params: bcore.RegTestParams})
+
+	// A ping payload is 8 bytes, each malformed message scores 20
+	for i := 0; i < 3; i++ {
+		if err := out.Send(&wire.MsgUnknown{Cmd: wire.CmdPing, Payload: []byte{1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Item counts no payload could hold
+	tx := NewBuffer().PutUint32(2).PutVarInt(1 << 62).Bytes()
+	block := NewBuffer().PutBytes(make([]byte, bcore.BlockHeaderSize)).PutVarInt(1 << 62).Bytes()
+	for _, msg := range []wire.Message{&wire.MsgUnknown{Cmd: wire.CmdTx, Payload: tx}, &wire.MsgUnknown{Cmd: wire.CmdBlock, Payload: block}} {
+		if err := out.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := waitDisconnect(t, in); !errors.Is(err, ErrPeerMisbehaving) {
+		t.Fatalf("expect %v, got %v", ErrPeerMisbehaving, err)
+	}
+	if in.Score() != 100 {
+		t.Fatalf("score: expect 100, got %d", in.Score())
+	}
+	waitDisconnect(t, out)
+}
+
+func TestPeerMessageSize(t *testing.T) {
+	out, in := newTestPeers(t, &Config{Params: bcore.RegTestParams}, &Config{Params: bcore.RegTestParams, MaxMessageSize: 100})
+
+	if err := out.Send(&wire.MsgUnknown{Cmd: "large", Payload: make([]byte, 101)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitDisconnect(t, in); err != wire.ErrMessageTooLarge {
+		t.Fatalf("expect %v, got %v", wire.ErrMessageTooLarge, err)
+	}
+}
+
+// rawPeer plays the remote side of a connection with raw messages
+func rawPeer(t *testing.T, conn net.Conn, msgs ...wire.Message) {
+	go func() {
+		for _, msg := range msgs {
+			if err := wire.WriteMessage(conn, bcore.RegTestParams.Magic, msg); err != nil {
+				return
+			}
+		}
+		// Drain what the peer sends
+		for {
+			if _, err := wire.ReadMessage(conn, bcore.RegTestParams.Magic); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func testVersion(version int32) *wire.MsgVersion {
+	return &wire.MsgVersion{
+		Version:  version,
+		AddrRecv: &wire.NetAddress{IP: net.IPv6zero},
+		AddrFrom: &wire.NetAddress{IP: net.IPv6zero},
+		Nonce:    1,
+	}
+}
+
+func TestPeerHandshakeErrors(t *testing.T) {
+	for _, test := range []struct {
+		msgs []wire.Message
+		err  error
+	}{
+		{[]wire.Message{testVersion(30000)}, ErrPeerObsoleteVersion},
+		{[]wire.Message{&wire.MsgPing{Nonce: 1}}, ErrPeerHandshake},
+		{[]wire.Message{&wire.MsgVerack{}}, ErrPeerHandshake},
+		{[]wire.Message{testVersion(wire.ProtocolVersion), testVersion(wire.ProtocolVersion)}, ErrPeerHandshake},
+		{nil, ErrPeerHandshakeTimeout},
+	} {
+		a, b := net.Pipe()
+		rawPeer(t, b, test.msgs...)
+
+		p := NewInboundPeer(a, &Config{Params: bcore.RegTestParams, HandshakeTimeout: 100 * time.Millisecond})
+		if err := p.Start(); err != test.err {
+			t.Fatalf("expect %v, got %v", test.err, err)
+		}
+		b.Close()
+	}
+}
+
+func TestPeerPingTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	// The remote side completes the handshake but never answers pings
+	rawPeer(t, b, testVersion(wire.ProtocolVersion), &wire.MsgVerack{})
+
+	p := NewOutboundPeer(a, &Config{
+		Params:       bcore.RegTestParams,
+		PingInterval: 10 * time.Millisecond,
+		PingTimeout:  50 * time.Millisecond,
+	})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitDisconnect(t, p); err != ErrPeerPingTimeout {
+		t.Fatalf("expect %v, got %v", ErrPeerPingTimeout, err)
+	}
+}
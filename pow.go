@@ -0,0 +1,118 @@
+package bcore
+
+import (
+	"errors"
+	"math/big"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrPowBadTarget = errors.New("pow: target out of range")
+	ErrPowHighHash  = errors.New("pow: hash above target")
+)
+
+var bigOne = big.NewInt(1)
+
+// CompactToBig decodes the compact representation of a target. It also reports whether
+// the encoding is negative or overflows 256 bits, both invalid for a target.
+func CompactToBig(bits Compact) (target *big.Int, negative bool, overflow bool) {
+	compact := uint32(bits)
+	exponent := compact >> 24
+	mantissa := compact & 0x007fffff
+
+	target = new(big.Int)
+	if exponent <= 3 {
+		target.SetUint64(uint64(mantissa >> (8 * (3 - exponent))))
+	} else {
+		target.SetUint64(uint64(mantissa))
+		target.Lsh(target, uint(8*(exponent-3)))
+	}
+
+	negative = mantissa != 0 && compact&0x00800000 != 0
+	overflow = mantissa != 0 && (exponent > 34 ||
+		(mantissa > 0xff && exponent > 33) ||
+		(mantissa > 0xffff && exponent > 32))
+
+	return target, negative, overflow
+}
+
+// BigToCompact encodes a non-negative target in its compact representation
+func BigToCompact(target *big.Int) Compact {
+	size := uint32(len(target.Bytes()))
+
+	var compact uint32
+	if size <= 3 {
+		compact = uint32(target.Uint64()) << (8 * (3 - size))
+	} else {
+		compact = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+
+	// The sign bit is set, shift the mantissa to an extra byte
+	if compact&0x00800000 != 0 {
+		compact >>= 8
+		size++
+	}
+
+	return NewCompact(compact | size<<24)
+}
+
+// HashToBig returns a hash as the little-endian number proof of work compares to the target
+func HashToBig(hash Hash) *big.Int {
+	b := make([]byte, HashSize)
+	for i := range hash {
+		b[HashSize-1-i] = hash[i]
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+// CalcWork returns the expected number of hashes to find a block with bits: 2^256 / (target+1)
+func CalcWork(bits Compact) *big.Int {
+	target, negative, overflow := CompactToBig(bits)
+	if negative || overflow || target.Sign() == 0 {
+		return new(big.Int)
+	}
+
+	target.Add(target, bigOne)
+	return new(big.Int).Div(new(big.Int).Lsh(bigOne, 256), target)
+}
+
+// CheckProofOfWork checks that hash satisfies the target of bits, which must be within the pow limit
+func CheckProofOfWork(hash Hash, bits Compact, params *ChainParams) error {
+	target, negative, overflow := CompactToBig(bits)
+	limit, _, _ := CompactToBig(params.PowLimit)
+	if negative || overflow || target.Sign() == 0 || target.Cmp(limit) > 0 {
+		return ErrPowBadTarget
+	}
+
+	if HashToBig(hash).Cmp(target) > 0 {
+		return ErrPowHighHash
+	}
+
+	return nil
+}
+
+// CalcRetarget returns the bits of the first block of a difficulty period from the bits of
+// the last block of the previous period and the time elapsed over it.
+func CalcRetarget(bits Compact, firstTime, lastTime uint32, params *ChainParams) Compact {
+	timespan := int64(lastTime) - int64(firstTime)
+	min := int64(params.PowTargetTimespan / 4)
+	max := int64(params.PowTargetTimespan * 4)
+	if timespan < min {
+		timespan = min
+	}
+	if timespan > max {
+		timespan = max
+	}
+
+	target, _, _ := CompactToBig(bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(int64(params.PowTargetTimespan)))
+
+	limit, _, _ := CompactToBig(params.PowLimit)
+	if target.Cmp(limit) > 0 {
+		target = limit
+	}
+
+	return BigToCompact(target)
+}
@@ -0,0 +1,99 @@
+package bcore
+
+import (
+	"math/big"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func compactTarget(bits Compact) *big.Int {
+	target, _, _ := CompactToBig(bits)
+	return target
+}
+
+func TestCompactToBig(t *testing.T) {
+	for _, test := range []struct {
+		bits     uint32
+		target   string
+		negative bool
+		overflow bool
+	}{
+		{0x00000000, "0", false, false},
+		{0x01003456, "0", false, false},
+		{0x01123456, "12", false, false},
+		{0x02008000, "80", false, false},
+		{0x05009234, "92340000", false, false},
+		{0x04923456, "12345600", true, false},
+		{0x04123456, "12345600", false, false},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000", false, false},
+		{0x207fffff, "7fffff0000000000000000000000000000000000000000000000000000000000", false, false},
+		{0xff123456, "", false, true},
+	} {
+		target, negative, overflow := CompactToBig(NewCompact(test.bits))
+		if negative != test.negative || overflow != test.overflow {
+			t.Fatalf("%08x: expect negative %v overflow %v", test.bits, test.negative, test.overflow)
+		}
+		if test.overflow {
+			continue
+		}
+
+		if target.Text(16) != test.target {
+			t.Fatalf("%08x: expect %s, got %s", test.bits, test.target, target.Text(16))
+		}
+
+		if !test.negative && target.Sign() > 0 && compactTarget(BigToCompact(target)).Cmp(target) != 0 {
+			t.Fatalf("%08x: roundtrip gives %08x", test.bits, uint32(BigToCompact(target)))
+		}
+	}
+
+	if bits := BigToCompact(big.NewInt(0x80)); uint32(bits) != 0x02008000 {
+		t.Fatalf("expect 02008000, got %08x", uint32(bits))
+	}
+}
+
+func TestCalcRetarget(t *testing.T) {
+	// Test vectors of the Bitcoin Core pow unit tests
+	for _, test := range []struct {
+		bits      uint32
+		firstTime uint32
+		lastTime  uint32
+		expect    uint32
+	}{
+		{0x1d00ffff, 1261130161, 1262152739, 0x1d00d86a},
+		{0x1d00ffff, 1231006505, 1233061996, 0x1d00ffff},
+		{0x1c05a3f4, 1279008237, 1279297671, 0x1c0168fd},
+		{0x1c387f6f, 1263163443, 1269211443, 0x1d00e1fd},
+	} {
+		got := CalcRetarget(NewCompact(test.bits), test.firstTime, test.lastTime, MainNetParams)
+		if uint32(got) != test.expect {
+			t.Fatalf("%08x: expect %08x, got %08x", test.bits, test.expect, uint32(got))
+		}
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	var low Hash
+	low[HashSize-1] = 0x7f
+	if err := CheckProofOfWork(low, NewCompact(0x207fffff), RegTestParams); err != nil {
+		t.Fatal(err)
+	}
+
+	var high Hash
+	high[HashSize-1] = 0x80
+	if err := CheckProofOfWork(high, NewCompact(0x207fffff), RegTestParams); err != ErrPowHighHash {
+		t.Fatalf("expect %v, got %v", ErrPowHighHash, err)
+	}
+
+	if err := CheckProofOfWork(HashZero, NewCompact(0x207fffff), MainNetParams); err != ErrPowBadTarget {
+		t.Fatalf("expect %v, got %v", ErrPowBadTarget, err)
+	}
+
+	if err := CheckProofOfWork(HashZero, NewCompact(0x04923456), RegTestParams); err != ErrPowBadTarget {
+		t.Fatalf("expect %v, got %v", ErrPowBadTarget, err)
+	}
+
+	if work := CalcWork(NewCompact(0x207fffff)); work.Int64() != 2 {
+		t.Fatalf("regtest work: expect 2, got %s", work)
+	}
+}
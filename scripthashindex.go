@@ -0,0 +1,361 @@
+package bcore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	ErrScriptHashIndexNotTip = errors.New("scripthashindex: block does not extend the index tip")
+	ErrScriptHashIndexBadKey = errors.New("scripthashindex: bad key")
+)
+
+const (
+	scriptHashIndexEventPrefix     = 'h'
+	scriptHashIndexUnspentPrefix   = 'u'
+	scriptHashIndexBestBlockPrefix = 'B'
+)
+
+// NewScriptHash returns the Electrum script hash of a script: its single SHA256.
+// Electrum clients display it reversed, as RString does.
+func NewScriptHash(script []byte) Hash {
+	return Hash(sha256.Sum256(script))
+}
+
+// ScriptHashEvent is an output paying to a script hash being funded or spent
+type ScriptHashEvent struct {
+	Txid   Hash
+	Height uint32
+	// Position of the transaction in its block
+	TxPos uint32
+	Spend bool
+	// Output index for a funding, input index for a spend
+	Index uint32
+	// Output funded or spent
+	OutPoint *OutPoint
+	Value    uint64
+}
+
+// ScriptHashHistoryItem is a transaction touching a script hash, as listed by blockchain.scripthash.get_history
+type ScriptHashHistoryItem struct {
+	Txid   Hash
+	Height uint32
+}
+
+// ScriptHashUnspent is an unspent output paying to a script hash
+type ScriptHashUnspent struct {
+	OutPoint *OutPoint
+	Height   uint32
+	Value    uint64
+}
+
+// ScriptHashIndex keeps the funding and spending history and the unspent outputs of
+// every script hash, like an Electrum server. Blocks are connected and disconnected
+// in chain order with their undo data, starting from the genesis block.
+type ScriptHashIndex struct {
+	db *leveldb.DB
+}
+
+func NewScriptHashIndex(path string) (*ScriptHashIndex, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		Compression: opt.NoCompression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScriptHashIndex{db: db}, nil
+}
+
+// Events are keyed by script hash, height, position in block, kind and index so that
+// iterating a script hash returns them in chain order.
+func scriptHashEventKey(sh Hash, e *ScriptHashEvent) []byte {
+	key := make([]byte, 1+HashSize+4+4+1+4)
+	key[0] = scriptHashIndexEventPrefix
+	copy(key[1:], sh[:])
+	binary.BigEndian.PutUint32(key[1+HashSize:], e.Height)
+	binary.BigEndian.PutUint32(key[1+HashSize+4:], e.TxPos)
+	if e.Spend {
+		key[1+HashSize+8] = 1
+	}
+	binary.BigEndian.PutUint32(key[1+HashSize+9:], e.Index)
+	return key
+}
+
+func scriptHashUnspentKey(sh Hash, op *OutPoint) []byte {
+	return NewBuffer().PutUint8(scriptHashIndexUnspentPrefix).PutHash(sh).PutBytes(op.Bytes()).Bytes()
+}
+
+func scriptHashKeyPrefix(prefix byte, sh Hash) []byte {
+	return NewBuffer().PutUint8(prefix).PutHash(sh).Bytes()
+}
+
+func (e *ScriptHashEvent) valueBytes() []byte {
+	return NewBuffer().PutHash(e.Txid).PutBytes(e.OutPoint.Bytes()).PutUint64(e.Value).Bytes()
+}
+
+func newScriptHashEventFromKeyValue(key, value []byte) (*ScriptHashEvent, error) {
+	if len(key) != 1+HashSize+4+4+1+4 {
+		return nil, ErrScriptHashIndexBadKey
+	}
+
+	e := &ScriptHashEvent{
+		Height: binary.BigEndian.Uint32(key[1+HashSize:]),
+		TxPos:  binary.BigEndian.Uint32(key[1+HashSize+4:]),
+		Spend:  key[1+HashSize+8] == 1,
+		Index:  binary.BigEndian.Uint32(key[1+HashSize+9:]),
+	}
+
+	buffer := NewReadBuffer(value)
+
+	var err error
+	if e.Txid, err = buffer.GetHash(); err != nil {
+		return nil, err
+	}
+
+	hash, err := buffer.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	e.OutPoint = NewOutPoint(hash, index)
+
+	if e.Value, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (si *ScriptHashIndex) BestBlock() (Hash, error) {
+	data, err := si.db.Get([]byte{scriptHashIndexBestBlockPrefix}, nil)
+	if err == leveldb.ErrNotFound {
+		return HashZero, nil
+	}
+
+	if err != nil {
+		return HashZero, err
+	}
+
+	return NewReadBuffer(data).GetHash()
+}
+
+// blockScriptHashEvents calls fn for every event of a block in block order, with its
+// script hash and the spent entry for spends.
+func blockScriptHashEvents(block *Block, height uint32, undo *BlockUndo, fn func(sh Hash, e *ScriptHashEvent, spent *UtxoEntry)) error {
+	if !undo.Matches(block) {
+		return ErrUtxoUndoMismatch
+	}
+
+	for i, tx := range block.Transactions {
+		txid := tx.Hash()
+
+		if i > 0 {
+			for j, input := range tx.Inputs {
+				spent := undo.Transactions[i-1].Spent[j]
+				fn(NewScriptHash(spent.Output.ScriptPubkey), &ScriptHashEvent{
+					Txid:     txid,
+					Height:   height,
+					TxPos:    uint32(i),
+					Spend:    true,
+					Index:    uint32(j),
+					OutPoint: input.PrevOutput,
+					Value:    spent.Output.Value,
+				}, spent)
+			}
+		}
+
+		for j, output := range tx.Outputs {
+			if output.IsUnspendable() {
+				continue
+			}
+
+			fn(NewScriptHash(output.ScriptPubkey), &ScriptHashEvent{
+				Txid:     txid,
+				Height:   height,
+				TxPos:    uint32(i),
+				Index:    uint32(j),
+				OutPoint: NewOutPoint(txid, uint32(j)),
+				Value:    output.Value,
+			}, nil)
+		}
+	}
+
+	return nil
+}
+
+func scriptHashUnspentValue(height uint32, value uint64) []byte {
+	return NewBuffer().PutUint32(height).PutUint64(value).Bytes()
+}
+
+// ConnectBlock indexes the block at height, which must extend the best block.
+// undo holds the outputs spent by the block, as returned by UtxoSet.ConnectBlock or read from rev*.dat files.
+func (si *ScriptHashIndex) ConnectBlock(block *Block, height uint32, undo *BlockUndo) error {
+	best, err := si.BestBlock()
+	if err != nil {
+		return err
+	}
+
+	if block.Header.PrevHash != best {
+		return ErrScriptHashIndexNotTip
+	}
+
+	batch := new(leveldb.Batch)
+	err = blockScriptHashEvents(block, height, undo, func(sh Hash, e *ScriptHashEvent, spent *UtxoEntry) {
+		batch.Put(scriptHashEventKey(sh, e), e.valueBytes())
+		if e.Spend {
+			batch.Delete(scriptHashUnspentKey(sh, e.OutPoint))
+		} else {
+			batch.Put(scriptHashUnspentKey(sh, e.OutPoint), scriptHashUnspentValue(height, e.Value))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte{scriptHashIndexBestBlockPrefix}, NewBuffer().PutHash(block.Hash()).Bytes())
+
+	return si.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// DisconnectBlock reverts the best block during a reorg
+func (si *ScriptHashIndex) DisconnectBlock(block *Block, height uint32, undo *BlockUndo) error {
+	best, err := si.BestBlock()
+	if err != nil {
+		return err
+	}
+
+	if block.Hash() != best {
+		return ErrScriptHashIndexNotTip
+	}
+
+	// Outputs created by the block are not restored when the block also spent them
+	batch := new(leveldb.Batch)
+	err = blockScriptHashEvents(block, height, undo, func(sh Hash, e *ScriptHashEvent, spent *UtxoEntry) {
+		batch.Delete(scriptHashEventKey(sh, e))
+		if e.Spend {
+			if spent.Height < height {
+				batch.Put(scriptHashUnspentKey(sh, e.OutPoint), scriptHashUnspentValue(spent.Height, e.Value))
+			}
+		} else {
+			batch.Delete(scriptHashUnspentKey(sh, e.OutPoint))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte{scriptHashIndexBestBlockPrefix}, NewBuffer().PutHash(block.Header.PrevHash).Bytes())
+
+	return si.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// Events returns the funding and spending events of sh in chain order
+func (si *ScriptHashIndex) Events(sh Hash) ([]*ScriptHashEvent, error) {
+	iter := si.db.NewIterator(util.BytesPrefix(scriptHashKeyPrefix(scriptHashIndexEventPrefix, sh)), nil)
+	defer iter.Release()
+
+	var events []*ScriptHashEvent
+	for iter.Next() {
+		e, err := newScriptHashEventFromKeyValue(iter.Key(), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, iter.Error()
+}
+
+// History returns the transactions touching sh in chain order
+func (si *ScriptHashIndex) History(sh Hash) ([]*ScriptHashHistoryItem, error) {
+	events, err := si.Events(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*ScriptHashHistoryItem
+	for _, e := range events {
+		n := len(history)
+		if n > 0 && history[n-1].Txid == e.Txid && history[n-1].Height == e.Height {
+			continue
+		}
+		history = append(history, &ScriptHashHistoryItem{Txid: e.Txid, Height: e.Height})
+	}
+
+	return history, nil
+}
+
+// Unspent returns the unspent outputs paying to sh, in outpoint order
+func (si *ScriptHashIndex) Unspent(sh Hash) ([]*ScriptHashUnspent, error) {
+	iter := si.db.NewIterator(util.BytesPrefix(scriptHashKeyPrefix(scriptHashIndexUnspentPrefix, sh)), nil)
+	defer iter.Release()
+
+	var unspent []*ScriptHashUnspent
+	for iter.Next() {
+		op, err := NewOutPointFromBytes(iter.Key()[1+HashSize:])
+		if err != nil {
+			return nil, err
+		}
+
+		buffer := NewReadBuffer(iter.Value())
+		height, err := buffer.GetUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := buffer.GetUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		unspent = append(unspent, &ScriptHashUnspent{OutPoint: op, Height: height, Value: value})
+	}
+
+	return unspent, iter.Error()
+}
+
+// Balance returns the sum of the unspent outputs paying to sh
+func (si *ScriptHashIndex) Balance(sh Hash) (uint64, error) {
+	unspent, err := si.Unspent(sh)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := uint64(0)
+	for _, u := range unspent {
+		sum += u.Value
+	}
+	return sum, nil
+}
+
+// Status returns the Electrum status of sh: the SHA256 of the concatenated "txid:height:"
+// of its history. It returns false when sh has no history, for which the status is null.
+func (si *ScriptHashIndex) Status(sh Hash) (Hash, bool, error) {
+	history, err := si.History(sh)
+	if err != nil || len(history) == 0 {
+		return HashZero, false, err
+	}
+
+	h := sha256.New()
+	for _, item := range history {
+		fmt.Fprintf(h, "%s:%d:", item.Txid.RString(), item.Height)
+	}
+
+	var status Hash
+	copy(status[:], h.Sum(nil))
+	return status, true, nil
+}
+
+func (si *ScriptHashIndex) Close() error {
+	return si.db.Close()
+}
@@ -0,0 +1,116 @@
+package bcore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptHashIndex(t *testing.T) {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestSpendChain(105)
+	sh := NewScriptHash(testScriptPubkey)
+
+	si, err := NewScriptHashIndex(filepath.Join(t.TempDir(), "scripthash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer si.Close()
+
+	if _, ok, err := si.Status(sh); err != nil || ok {
+		t.Fatalf("status of empty history: %v %v", ok, err)
+	}
+
+	undos := make([]*BlockUndo, len(blocks))
+	for i, block := range blocks {
+		undo, err := set.ConnectBlock(block, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		undos[i] = undo
+
+		if err := si.ConnectBlock(block, uint32(i), undo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := si.ConnectBlock(blocks[3], 3, undos[3]); err != ErrScriptHashIndexNotTip {
+		t.Fatalf("expect %v, got %v", ErrScriptHashIndexNotTip, err)
+	}
+
+	checkScriptHashIndex(t, si, set, blocks)
+
+	for i := len(blocks) - 1; i >= 102; i-- {
+		if err := si.DisconnectBlock(blocks[i], uint32(i), undos[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.DisconnectBlock(blocks[i], undos[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkScriptHashIndex(t, si, set, blocks[:102])
+}
+
+// checkScriptHashIndex compares the index with the utxo set and the blocks, which all pay to testScriptPubkey
+func checkScriptHashIndex(t *testing.T, si *ScriptHashIndex, set *UtxoSet, blocks []*Block) {
+	sh := NewScriptHash(testScriptPubkey)
+
+	unspent, err := si.Unspent(sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent) != set.Len() {
+		t.Fatalf("unspent: expect %d, got %d", set.Len(), len(unspent))
+	}
+	for _, u := range unspent {
+		e, ok := set.Get(u.OutPoint)
+		if !ok || e.Height != u.Height || e.Output.Value != u.Value {
+			t.Fatalf("unspent %s:%d: unexpected %+v", u.OutPoint.Hash, u.OutPoint.Index, u)
+		}
+	}
+
+	if balance, err := si.Balance(sh); err != nil || balance != set.TotalAmount() {
+		t.Fatalf("balance: expect %d, got %d %v", set.TotalAmount(), balance, err)
+	}
+
+	history, err := si.History(sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	n := 0
+	for height, block := range blocks {
+		for _, tx := range block.Transactions {
+			if n >= len(history) || history[n].Txid != tx.Hash() || history[n].Height != uint32(height) {
+				t.Fatalf("history %d: expect %s at %d", n, tx.Hash(), height)
+			}
+			fmt.Fprintf(h, "%s:%d:", tx.Hash().RString(), height)
+			n++
+		}
+	}
+	if n != len(history) {
+		t.Fatalf("history: expect %d items, got %d", n, len(history))
+	}
+
+	status, ok, err := si.Status(sh)
+	if err != nil || !ok || string(status[:]) != string(h.Sum(nil)) {
+		t.Fatalf("status mismatch: %v %v", ok, err)
+	}
+
+	events, err := si.Events(sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spends := 0
+	for _, e := range events {
+		if e.Spend {
+			spends++
+		}
+	}
+	if len(events)-spends-spends != set.Len() {
+		t.Fatalf("events: %d fundings and %d spends for %d unspent", len(events)-spends, spends, set.Len())
+	}
+}
@@ -0,0 +1,78 @@
+package bcore
+
+import (
+	"errors"
+)
+
+var (
+	ErrScriptNumOverflow   = errors.New("scriptnum: overflow")
+	ErrScriptNumNotMinimal = errors.New("scriptnum: non-minimally encoded")
+)
+
+const (
+	// ScriptNumDefaultSize is the maximum size of a number operand accepted by the interpreter
+	ScriptNumDefaultSize = 4
+)
+
+// EncodeScriptNum serializes n as a CScriptNum: little-endian magnitude with
+// the sign carried in the most significant bit of the last byte.
+func EncodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// If the most significant byte already uses the sign bit an extra byte is
+	// needed to carry it, otherwise the sign bit is set in place.
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// DecodeScriptNum parses a CScriptNum of at most maxSize bytes.
+// When minimal is true, encodings with superfluous zero bytes are rejected.
+func DecodeScriptNum(data []byte, minimal bool, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, ErrScriptNumOverflow
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	if minimal && data[len(data)-1]&0x7f == 0 {
+		if len(data) == 1 || data[len(data)-2]&0x80 == 0 {
+			return 0, ErrScriptNumNotMinimal
+		}
+	}
+
+	var result int64
+	for i, b := range data {
+		result |= int64(b) << uint(8*i)
+	}
+
+	if data[len(data)-1]&0x80 != 0 {
+		return -(result &^ (int64(0x80) << uint(8*(len(data)-1)))), nil
+	}
+
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package bcore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScriptNum(t *testing.T) {
+	tests := []struct {
+		n      int64
+		expect []byte
+	}{
+		{0, []byte{}},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-256, []byte{0x00, 0x81}},
+		{227931, []byte{0x5b, 0x7a, 0x03}},
+		{2147483647, []byte{0xff, 0xff, 0xff, 0x7f}},
+		{-2147483647, []byte{0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		b := EncodeScriptNum(test.n)
+		if !bytes.Equal(b, test.expect) {
+			t.Fatalf("encode %d: expect %x, got %x", test.n, test.expect, b)
+		}
+
+		n, err := DecodeScriptNum(b, true, ScriptNumDefaultSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != test.n {
+			t.Fatalf("decode %x: expect %d, got %d", b, test.n, n)
+		}
+	}
+}
+
+func TestScriptNumDecodeErrors(t *testing.T) {
+	if _, err := DecodeScriptNum([]byte{0x01, 0x00}, true, ScriptNumDefaultSize); err != ErrScriptNumNotMinimal {
+		t.Fatalf("expect not minimal, got %v", err)
+	}
+
+	if _, err := DecodeScriptNum([]byte{0x80}, true, ScriptNumDefaultSize); err != ErrScriptNumNotMinimal {
+		t.Fatalf("expect not minimal, got %v", err)
+	}
+
+	if n, err := DecodeScriptNum([]byte{0x01, 0x00}, false, ScriptNumDefaultSize); err != nil || n != 1 {
+		t.Fatalf("expect 1, got %d %v", n, err)
+	}
+
+	if _, err := DecodeScriptNum([]byte{1, 2, 3, 4, 5}, true, ScriptNumDefaultSize); err != ErrScriptNumOverflow {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package bcore
+
+import (
+	"github.com/detailyang/go-bcore/internal/secp256k1"
+)
+
+// isValidPubkey reports whether pubkey is a serialized point on the curve
+func isValidPubkey(pubkey []byte) bool {
+	var x, y secp256k1.FieldElement
+	switch {
+	case len(pubkey) == 33 && (pubkey[0] == 0x02 || pubkey[0] == 0x03):
+		if _, ok := x.SetBytes(pubkey[1:]); !ok {
+			return false
+		}
+		_, ok := secp256k1.LiftX(&x)
+		return ok
+
+	case len(pubkey) == 65 && pubkey[0] == 0x04:
+		_, xok := x.SetBytes(pubkey[1:33])
+		_, yok := y.SetBytes(pubkey[33:])
+		if !xok || !yok {
+			return false
+		}
+		return y.Square(&y).Equal(secp256k1.YSquared(&x))
+	}
+
+	return false
+}
+
+// decompressPubkey turns a 33 bytes compressed public key into its 65 bytes form
+func decompressPubkey(pubkey []byte) ([]byte, bool) {
+	if len(pubkey) != 33 || (pubkey[0] != 0x02 && pubkey[0] != 0x03) {
+		return nil, false
+	}
+
+	var x secp256k1.FieldElement
+	if _, ok := x.SetBytes(pubkey[1:]); !ok {
+		return nil, false
+	}
+
+	y, ok := secp256k1.LiftX(&x)
+	if !ok {
+		return nil, false
+	}
+
+	if pubkey[0] == 0x03 {
+		y.Neg(y)
+	}
+
+	result := make([]byte, 65)
+	result[0] = 0x04
+	xb, yb := x.Bytes(), y.Bytes()
+	copy(result[1:33], xb[:])
+	copy(result[33:], yb[:])
+	return result, true
+}
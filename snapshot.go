@@ -0,0 +1,452 @@
+package bcore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrSnapshotBadMagic       = errors.New("snapshot: bad magic")
+	ErrSnapshotBadVersion     = errors.New("snapshot: unsupported version")
+	ErrSnapshotBadNetwork     = errors.New("snapshot: network mismatch")
+	ErrSnapshotBadCoinCount   = errors.New("snapshot: coin count mismatch")
+	ErrSnapshotBadIndex       = errors.New("snapshot: bad output index")
+	ErrSnapshotUnordered      = errors.New("snapshot: coins out of order")
+	ErrSnapshotTrailingData   = errors.New("snapshot: coins left over")
+	ErrSnapshotHashMismatch   = errors.New("snapshot: hash_serialized mismatch")
+	ErrSnapshotCompactSizeBig = errors.New("snapshot: compact size too large")
+)
+
+const (
+	// SnapshotVersion is the version of the UTXO snapshot format written by dumptxoutset
+	SnapshotVersion = 2
+	// SnapshotMetadataSize is the size of the metadata preceding the coins
+	SnapshotMetadataSize = 5 + 2 + 4 + HashSize + 8
+
+	// Largest output index accepted, as many outputs as fit in a block
+	snapshotMaxIndex = MaxBlockSerializedSize / 9
+	// Number of coins written to a store per batch while loading a snapshot
+	snapshotLoadBatchSize = 100000
+)
+
+// SnapshotMagic starts every UTXO snapshot file
+var SnapshotMagic = []byte{'u', 't', 'x', 'o', 0xff}
+
+// SnapshotMetadata is the header of a UTXO snapshot created by the dumptxoutset RPC of Bitcoin Core
+type SnapshotMetadata struct {
+	// Network magic of the chain the snapshot belongs to
+	Magic uint32
+	// Hash of the block the UTXO set is taken at
+	BaseHash Hash
+	// Number of coins in the snapshot
+	CoinsCount uint64
+}
+
+func NewSnapshotMetadataFromBytes(data []byte) (*SnapshotMetadata, error) {
+	if len(data) != SnapshotMetadataSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if !bytes.Equal(data[:len(SnapshotMagic)], SnapshotMagic) {
+		return nil, ErrSnapshotBadMagic
+	}
+
+	buffer := NewReadBuffer(data[len(SnapshotMagic):])
+
+	version, err := buffer.GetBytes(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint16(version) != SnapshotVersion {
+		return nil, ErrSnapshotBadVersion
+	}
+
+	var m SnapshotMetadata
+	if m.Magic, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	if m.BaseHash, err = buffer.GetHash(); err != nil {
+		return nil, err
+	}
+
+	if m.CoinsCount, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (m *SnapshotMetadata) Bytes() []byte {
+	version := make([]byte, 2)
+	binary.LittleEndian.PutUint16(version, SnapshotVersion)
+
+	return NewBuffer().
+		PutBytes(SnapshotMagic).
+		PutBytes(version).
+		PutUint32(m.Magic).
+		PutHash(m.BaseHash).
+		PutUint64(m.CoinsCount).
+		Bytes()
+}
+
+// compareOutPoint orders outpoints like the keys of the chainstate database: by txid
+// and then by VARINT encoded output index, which is not numerical order past 16511.
+func compareOutPoint(a, b *OutPoint) int {
+	if c := bytes.Compare(a.Hash[:], b.Hash[:]); c != 0 {
+		return c
+	}
+
+	return bytes.Compare(EncodeVarInt128(uint64(a.Index)), EncodeVarInt128(uint64(b.Index)))
+}
+
+func readCompactSize(r io.ByteReader) (uint64, error) {
+	d, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	switch d {
+	case 0xfd:
+		n = 2
+	case 0xfe:
+		n = 4
+	case 0xff:
+		n = 8
+	default:
+		return uint64(d), nil
+	}
+
+	v := uint64(0)
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b) << uint(8*i)
+	}
+
+	return v, nil
+}
+
+// streamBuffer reads a stream with the methods of a Buffer, for the coin decoders of compress.go
+type streamBuffer struct {
+	r *bufio.Reader
+}
+
+func (b streamBuffer) GetUint8() (uint8, error) {
+	return b.r.ReadByte()
+}
+
+func (b streamBuffer) GetBytes(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(b.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SnapshotReader streams the coins of a UTXO snapshot, computing its hash_serialized_3 on the way
+type SnapshotReader struct {
+	r        *bufio.Reader
+	metadata *SnapshotMetadata
+	hasher   *HashSerialized
+	left     uint64
+	txid     Hash
+	group    uint64
+	last     *OutPoint
+}
+
+// NewSnapshotReader reads the metadata of a snapshot, the coins are read with Next
+func NewSnapshotReader(r io.Reader) (*SnapshotReader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+
+	data := make([]byte, SnapshotMetadataSize)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+
+	metadata, err := NewSnapshotMetadataFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotReader{
+		r:        br,
+		metadata: metadata,
+		hasher:   NewHashSerialized(),
+		left:     metadata.CoinsCount,
+	}, nil
+}
+
+func (sr *SnapshotReader) Metadata() *SnapshotMetadata { return sr.metadata }
+
+// Next returns the next coin, io.EOF once CoinsCount coins were read
+func (sr *SnapshotReader) Next() (*OutPoint, *UtxoEntry, error) {
+	if sr.left == 0 {
+		return nil, nil, io.EOF
+	}
+
+	if sr.group == 0 {
+		if _, err := io.ReadFull(sr.r, sr.txid[:]); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+
+		n, err := readCompactSize(sr.r)
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+
+		if n == 0 || n > sr.left {
+			return nil, nil, ErrSnapshotBadCoinCount
+		}
+		sr.group = n
+	}
+
+	index, err := readCompactSize(sr.r)
+	if err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+
+	if index > snapshotMaxIndex {
+		return nil, nil, ErrSnapshotBadIndex
+	}
+
+	e, err := newUtxoEntryFromCompressed(streamBuffer{sr.r})
+	if err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+
+	op := NewOutPoint(sr.txid, uint32(index))
+	if sr.last != nil && compareOutPoint(sr.last, op) >= 0 {
+		return nil, nil, ErrSnapshotUnordered
+	}
+	sr.last = op
+
+	sr.group--
+	sr.left--
+	sr.hasher.Add(op, e)
+
+	return op, e, nil
+}
+
+// HashSerialized returns the hash_serialized_3 of the coins read so far
+func (sr *SnapshotReader) HashSerialized() Hash {
+	return sr.hasher.Sum()
+}
+
+// Verify reads the remaining coins and checks that nothing follows them and that
+// the snapshot commits to expected
+func (sr *SnapshotReader) Verify(expected Hash) error {
+	for {
+		if _, _, err := sr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if _, err := sr.r.ReadByte(); err != io.EOF {
+		return ErrSnapshotTrailingData
+	}
+
+	if sr.HashSerialized() != expected {
+		return ErrSnapshotHashMismatch
+	}
+
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// LoadSnapshot writes the coins of a snapshot into store and sets its best block to the
+// snapshot base once the snapshot is verified against expected. Until then the store
+// reports HashZero as its best block, a store with a failed load must be discarded.
+func LoadSnapshot(r io.Reader, store UtxoStore, params *ChainParams, expected Hash) (*SnapshotMetadata, error) {
+	sr, err := NewSnapshotReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if sr.Metadata().Magic != params.Magic {
+		return nil, ErrSnapshotBadNetwork
+	}
+
+	batch := make(map[OutPoint]*UtxoEntry)
+	for {
+		op, e, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch[*op] = e
+		if len(batch) >= snapshotLoadBatchSize {
+			if err := store.WriteUtxos(batch, HashZero); err != nil {
+				return nil, err
+			}
+			batch = make(map[OutPoint]*UtxoEntry)
+		}
+	}
+
+	if err := sr.Verify(expected); err != nil {
+		return nil, err
+	}
+
+	if err := store.WriteUtxos(batch, sr.Metadata().BaseHash); err != nil {
+		return nil, err
+	}
+
+	return sr.Metadata(), nil
+}
+
+// SnapshotWriter writes a UTXO snapshot. Coins must be written in the order of the
+// chainstate keys, by txid bytes then VARINT encoded output index, and their number
+// must match the metadata.
+type SnapshotWriter struct {
+	w        *bufio.Writer
+	metadata *SnapshotMetadata
+	hasher   *HashSerialized
+	written  uint64
+	last     *OutPoint
+	group    []byte
+	ngroup   uint64
+}
+
+func NewSnapshotWriter(w io.Writer, metadata *SnapshotMetadata) (*SnapshotWriter, error) {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if _, err := bw.Write(metadata.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotWriter{
+		w:        bw,
+		metadata: metadata,
+		hasher:   NewHashSerialized(),
+	}, nil
+}
+
+// flushGroup writes the buffered coins of the current txid
+func (sw *SnapshotWriter) flushGroup() error {
+	if sw.ngroup == 0 {
+		return nil
+	}
+
+	buffer := NewBuffer().PutHash(sw.last.Hash).PutVarInt(sw.ngroup).PutBytes(sw.group)
+	if _, err := sw.w.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+
+	sw.group = sw.group[:0]
+	sw.ngroup = 0
+	return nil
+}
+
+func (sw *SnapshotWriter) Write(op *OutPoint, e *UtxoEntry) error {
+	if sw.written >= sw.metadata.CoinsCount {
+		return ErrSnapshotBadCoinCount
+	}
+
+	if sw.last != nil {
+		if compareOutPoint(sw.last, op) >= 0 {
+			return ErrSnapshotUnordered
+		}
+
+		if sw.last.Hash != op.Hash {
+			if err := sw.flushGroup(); err != nil {
+				return err
+			}
+		}
+	}
+
+	sw.group = append(sw.group, NewBuffer().PutVarInt(uint64(op.Index)).PutBytes(e.CompressedBytes()).Bytes()...)
+	sw.ngroup++
+	sw.last = op.Clone()
+	sw.written++
+	sw.hasher.Add(op, e)
+
+	return nil
+}
+
+// HashSerialized returns the hash_serialized_3 of the coins written so far
+func (sw *SnapshotWriter) HashSerialized() Hash {
+	return sw.hasher.Sum()
+}
+
+// Close writes the buffered coins, it fails if fewer coins than announced were written
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.flushGroup(); err != nil {
+		return err
+	}
+
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+
+	if sw.written != sw.metadata.CoinsCount {
+		return ErrSnapshotBadCoinCount
+	}
+
+	return nil
+}
+
+// WriteSnapshot dumps every coin of store as a snapshot at its best block.
+// The store must iterate in chainstate key order, as LevelDBUtxoStore and MemoryUtxoStore do.
+func WriteSnapshot(w io.Writer, store UtxoStore, params *ChainParams) (*SnapshotMetadata, Hash, error) {
+	best, err := store.BestBlock()
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	count := uint64(0)
+	if err := store.ForEachUtxo(func(*OutPoint, *UtxoEntry) bool {
+		count++
+		return true
+	}); err != nil {
+		return nil, HashZero, err
+	}
+
+	metadata := &SnapshotMetadata{
+		Magic:      params.Magic,
+		BaseHash:   best,
+		CoinsCount: count,
+	}
+
+	sw, err := NewSnapshotWriter(w, metadata)
+	if err != nil {
+		return nil, HashZero, err
+	}
+
+	var werr error
+	if err := store.ForEachUtxo(func(op *OutPoint, e *UtxoEntry) bool {
+		werr = sw.Write(op, e)
+		return werr == nil
+	}); err != nil {
+		return nil, HashZero, err
+	}
+
+	if werr != nil {
+		return nil, HashZero, werr
+	}
+
+	if err := sw.Close(); err != nil {
+		return nil, HashZero, err
+	}
+
+	return metadata, sw.HashSerialized(), nil
+}
@@ -0,0 +1,201 @@
+package bcore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func newTestSnapshotStore(t *testing.T) *MemoryUtxoStore {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestSpendChain(110)
+	for i, block := range blocks {
+		if _, err := set.ConnectBlock(block, uint32(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := utxoSnapshotChanges(set)
+	// an output index whose VARINT sorts before smaller indexes
+	changes[OutPoint{Hash: Hash{0xff}, Index: 16512}] = NewUtxoEntry(&TransactionOutput{Value: 1, ScriptPubkey: []byte{0x51}}, 3, false)
+	changes[OutPoint{Hash: Hash{0xff}, Index: 16511}] = NewUtxoEntry(&TransactionOutput{Value: 2, ScriptPubkey: []byte{0x51}}, 3, false)
+
+	store := NewMemoryUtxoStore()
+	if err := store.WriteUtxos(changes, blocks[109].Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func utxoSnapshotChanges(set *UtxoSet) map[OutPoint]*UtxoEntry {
+	changes := make(map[OutPoint]*UtxoEntry)
+	set.ForEach(func(op *OutPoint, e *UtxoEntry) bool {
+		changes[*op] = e
+		return true
+	})
+	return changes
+}
+
+func TestSnapshotMetadata(t *testing.T) {
+	m := &SnapshotMetadata{
+		Magic:      MainNetParams.Magic,
+		BaseHash:   Hash{1, 2, 3},
+		CoinsCount: 176948713,
+	}
+
+	expect := "7574786fff" + "0200" + "f9beb4d9" + "0102030000000000000000000000000000000000000000000000000000000000" + "e9058c0a00000000"
+	if hex.EncodeToString(m.Bytes()) != expect {
+		t.Fatalf("metadata: expect %s, got %x", expect, m.Bytes())
+	}
+
+	got, err := NewSnapshotMetadataFromBytes(m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got != *m {
+		t.Fatalf("metadata: expect %+v, got %+v", m, got)
+	}
+
+	b := m.Bytes()
+	b[5] = 1
+	if _, err := NewSnapshotMetadataFromBytes(b); err != ErrSnapshotBadVersion {
+		t.Fatalf("expect bad version, got %v", err)
+	}
+
+	b[0] = 'U'
+	if _, err := NewSnapshotMetadataFromBytes(b); err != ErrSnapshotBadMagic {
+		t.Fatalf("expect bad magic, got %v", err)
+	}
+}
+
+func TestSnapshotRoundtrip(t *testing.T) {
+	store := newTestSnapshotStore(t)
+
+	var buf bytes.Buffer
+	metadata, hash, err := WriteSnapshot(&buf, store, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	best, _ := store.BestBlock()
+	stats, err := ComputeUtxoStats(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.BaseHash != best || metadata.CoinsCount != stats.Count {
+		t.Fatalf("metadata: got %+v", metadata)
+	}
+
+	// the commitment does not depend on how the coins are stored
+	hasher := NewHashSerialized()
+	store.ForEachUtxo(func(op *OutPoint, e *UtxoEntry) bool {
+		hasher.Add(op, e)
+		return true
+	})
+
+	if hasher.Sum() != hash {
+		t.Fatalf("hash_serialized: expect %s, got %s", hasher.Sum(), hash)
+	}
+
+	sr, err := NewSnapshotReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for {
+		op, e, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect, err := store.GetUtxo(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Height != expect.Height || e.Coinbase != expect.Coinbase || e.Output.Value != expect.Output.Value {
+			t.Fatalf("coin %s:%d: expect %+v, got %+v", op.Hash, op.Index, expect, e)
+		}
+		n++
+	}
+
+	if uint64(n) != stats.Count || sr.HashSerialized() != hash {
+		t.Fatalf("read %d coins with hash %s", n, sr.HashSerialized())
+	}
+
+	loaded := NewMemoryUtxoStore()
+	if _, err := LoadSnapshot(bytes.NewReader(buf.Bytes()), loaded, RegTestParams, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedStats, err := ComputeUtxoStats(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *loadedStats != *stats {
+		t.Fatalf("loaded snapshot: expect %+v, got %+v", stats, loadedStats)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	store := newTestSnapshotStore(t)
+
+	var buf bytes.Buffer
+	_, hash, err := WriteSnapshot(&buf, store, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSnapshot(bytes.NewReader(buf.Bytes()), NewMemoryUtxoStore(), MainNetParams, hash); err != ErrSnapshotBadNetwork {
+		t.Fatalf("expect bad network, got %v", err)
+	}
+
+	loaded := NewMemoryUtxoStore()
+	if _, err := LoadSnapshot(bytes.NewReader(buf.Bytes()), loaded, RegTestParams, HashZero); err != ErrSnapshotHashMismatch {
+		t.Fatalf("expect hash mismatch, got %v", err)
+	}
+
+	if best, _ := loaded.BestBlock(); best != HashZero {
+		t.Fatalf("failed load should not set the best block")
+	}
+
+	sr, _ := NewSnapshotReader(bytes.NewReader(append(buf.Bytes(), 0)))
+	if err := sr.Verify(hash); err != ErrSnapshotTrailingData {
+		t.Fatalf("expect trailing data, got %v", err)
+	}
+
+	sr, _ = NewSnapshotReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err := sr.Verify(hash); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect unexpected EOF, got %v", err)
+	}
+
+	sw, err := NewSnapshotWriter(io.Discard, &SnapshotMetadata{CoinsCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewUtxoEntry(&TransactionOutput{Value: 1}, 1, false)
+	if err := sw.Write(NewOutPoint(Hash{2}, 0), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.Write(NewOutPoint(Hash{1}, 0), e); err != ErrSnapshotUnordered {
+		t.Fatalf("expect unordered, got %v", err)
+	}
+
+	if err := sw.Close(); err != ErrSnapshotBadCoinCount {
+		t.Fatalf("expect bad count, got %v", err)
+	}
+}
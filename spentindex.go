@@ -0,0 +1,174 @@
+package bcore
+
+import (
+	"errors"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrSpentIndexMissing = errors.New("spentindex: output not spent")
+	ErrSpentIndexNotTip  = errors.New("spentindex: block does not extend the index tip")
+)
+
+const (
+	spentIndexSpenderPrefix   = 's'
+	spentIndexBestBlockPrefix = 'B'
+)
+
+// SpentIndexEntry locates the input spending an output
+type SpentIndexEntry struct {
+	Txid  Hash
+	Input uint32
+	// Block containing the spending transaction
+	BlockHash Hash
+	Height    uint32
+}
+
+func (e *SpentIndexEntry) Bytes() []byte {
+	return NewBuffer().
+		PutHash(e.Txid).
+		PutUint32(e.Input).
+		PutHash(e.BlockHash).
+		PutUint32(e.Height).
+		Bytes()
+}
+
+func newSpentIndexEntryFromBytes(data []byte) (*SpentIndexEntry, error) {
+	buffer := NewReadBuffer(data)
+	e := &SpentIndexEntry{}
+
+	var err error
+	if e.Txid, err = buffer.GetHash(); err != nil {
+		return nil, err
+	}
+
+	if e.Input, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	if e.BlockHash, err = buffer.GetHash(); err != nil {
+		return nil, err
+	}
+
+	if e.Height, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// SpentIndex maps every spent output to the input spending it. Blocks are connected
+// and disconnected in chain order, starting from the genesis block.
+type SpentIndex struct {
+	db *leveldb.DB
+}
+
+func NewSpentIndex(path string) (*SpentIndex, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		Compression: opt.NoCompression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpentIndex{db: db}, nil
+}
+
+func spentIndexKey(op *OutPoint) []byte {
+	return NewBuffer().PutUint8(spentIndexSpenderPrefix).PutBytes(op.Bytes()).Bytes()
+}
+
+// BestBlock returns the hash of the last connected block, HashZero if none
+func (si *SpentIndex) BestBlock() (Hash, error) {
+	data, err := si.db.Get([]byte{spentIndexBestBlockPrefix}, nil)
+	if err == leveldb.ErrNotFound {
+		return HashZero, nil
+	}
+
+	if err != nil {
+		return HashZero, err
+	}
+
+	return NewReadBuffer(data).GetHash()
+}
+
+// GetSpender returns the input spending op, ErrSpentIndexMissing if it is unspent or unknown
+func (si *SpentIndex) GetSpender(op *OutPoint) (*SpentIndexEntry, error) {
+	data, err := si.db.Get(spentIndexKey(op), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrSpentIndexMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSpentIndexEntryFromBytes(data)
+}
+
+// ConnectBlock records the spends of the block at height, which must extend the best block
+func (si *SpentIndex) ConnectBlock(block *Block, height uint32) error {
+	best, err := si.BestBlock()
+	if err != nil {
+		return err
+	}
+
+	if block.Header.PrevHash != best {
+		return ErrSpentIndexNotTip
+	}
+
+	hash := block.Hash()
+	batch := new(leveldb.Batch)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		txid := tx.Hash()
+		for i, input := range tx.Inputs {
+			e := &SpentIndexEntry{
+				Txid:      txid,
+				Input:     uint32(i),
+				BlockHash: hash,
+				Height:    height,
+			}
+			batch.Put(spentIndexKey(input.PrevOutput), e.Bytes())
+		}
+	}
+	batch.Put([]byte{spentIndexBestBlockPrefix}, NewBuffer().PutHash(hash).Bytes())
+
+	return si.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// DisconnectBlock forgets the spends of the best block
+func (si *SpentIndex) DisconnectBlock(block *Block) error {
+	best, err := si.BestBlock()
+	if err != nil {
+		return err
+	}
+
+	if block.Hash() != best {
+		return ErrSpentIndexNotTip
+	}
+
+	batch := new(leveldb.Batch)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, input := range tx.Inputs {
+			batch.Delete(spentIndexKey(input.PrevOutput))
+		}
+	}
+	batch.Put([]byte{spentIndexBestBlockPrefix}, NewBuffer().PutHash(block.Header.PrevHash).Bytes())
+
+	return si.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (si *SpentIndex) Close() error {
+	return si.db.Close()
+}
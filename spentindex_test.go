@@ -0,0 +1,66 @@
+package bcore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSpentIndex(t *testing.T) {
+	blocks := newTestSpendChain(105)
+
+	si, err := NewSpentIndex(filepath.Join(t.TempDir(), "spent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer si.Close()
+
+	for i, block := range blocks {
+		if err := si.ConnectBlock(block, uint32(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := si.ConnectBlock(blocks[3], 3); err != ErrSpentIndexNotTip {
+		t.Fatalf("expect %v, got %v", ErrSpentIndexNotTip, err)
+	}
+
+	for i, block := range blocks[100:] {
+		tx := block.Transactions[1]
+		for j, input := range tx.Inputs {
+			e, err := si.GetSpender(input.PrevOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Txid != tx.Hash() || e.Input != uint32(j) || e.BlockHash != block.Hash() || e.Height != uint32(100+i) {
+				t.Fatalf("spender of %s:%d: unexpected %+v", input.PrevOutput.Hash, input.PrevOutput.Index, e)
+			}
+		}
+	}
+
+	unspent := NewOutPoint(blocks[104].Transactions[1].Hash(), 0)
+	if _, err := si.GetSpender(unspent); err != ErrSpentIndexMissing {
+		t.Fatalf("expect %v, got %v", ErrSpentIndexMissing, err)
+	}
+
+	if err := si.DisconnectBlock(blocks[103]); err != ErrSpentIndexNotTip {
+		t.Fatalf("expect %v, got %v", ErrSpentIndexNotTip, err)
+	}
+
+	if err := si.DisconnectBlock(blocks[104]); err != nil {
+		t.Fatal(err)
+	}
+
+	if best, err := si.BestBlock(); err != nil || best != blocks[103].Hash() {
+		t.Fatalf("best block: expect %s, got %s %v", blocks[103].Hash(), best, err)
+	}
+
+	for _, input := range blocks[104].Transactions[1].Inputs {
+		if _, err := si.GetSpender(input.PrevOutput); err != ErrSpentIndexMissing {
+			t.Fatalf("expect %v, got %v", ErrSpentIndexMissing, err)
+		}
+	}
+
+	if _, err := si.GetSpender(blocks[103].Transactions[1].Inputs[0].PrevOutput); err != nil {
+		t.Fatal(err)
+	}
+}
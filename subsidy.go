@@ -0,0 +1,18 @@
+package bcore
+
+const (
+	// InitialSubsidy is the block subsidy before the first halving.
+	InitialSubsidy = 50 * Coin
+)
+
+// Subsidy returns the amount of new satoshis a block at height may create.
+// The subsidy is halved every params.SubsidyHalvingInterval blocks and drops to zero
+// once it would be shifted right by 64 bits or more.
+func Subsidy(height uint32, params *ChainParams) uint64 {
+	halvings := height / params.SubsidyHalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+
+	return InitialSubsidy >> halvings
+}
@@ -0,0 +1,41 @@
+package bcore
+
+import (
+	"testing"
+)
+
+func TestSubsidy(t *testing.T) {
+	tests := []struct {
+		height uint32
+		params *ChainParams
+		expect uint64
+	}{
+		{0, MainNetParams, 5000000000},
+		{209999, MainNetParams, 5000000000},
+		{210000, MainNetParams, 2500000000},
+		{420000, MainNetParams, 1250000000},
+		{840000, MainNetParams, 312500000},
+		{6929999, MainNetParams, 1},
+		{6930000, MainNetParams, 0},
+		{64 * 210000, MainNetParams, 0},
+		{149, RegTestParams, 5000000000},
+		{150, RegTestParams, 2500000000},
+	}
+
+	for _, test := range tests {
+		if got := Subsidy(test.height, test.params); got != test.expect {
+			t.Fatalf("subsidy at %d on %s: expect %d, got %d", test.height, test.params.Name, test.expect, got)
+		}
+	}
+}
+
+func TestSubsidyTotal(t *testing.T) {
+	total := uint64(0)
+	for height := uint32(0); height < 64*MainNetParams.SubsidyHalvingInterval; height += MainNetParams.SubsidyHalvingInterval {
+		total += Subsidy(height, MainNetParams) * uint64(MainNetParams.SubsidyHalvingInterval)
+	}
+
+	if total != 2099999997690000 || total > MaxMoney {
+		t.Fatalf("total subsidy: got %d", total)
+	}
+}
@@ -14,6 +14,7 @@ var (
 	ErrTransactionInputOutPointWrongSize = errors.New("transaction outpoint: wrong size")
 	ErrTransactionNoWitnessMarker        = errors.New("transaction: no witness marker")
 	ErrTransactionNoWitnessFlag          = errors.New("transaction: no witness flag")
+	ErrTransactionTooManyItems           = errors.New("transaction: too many items")
 )
 
 const (
@@ -23,6 +24,16 @@ const (
 	TransactionWitnessFlag     = 0x01
 
 	TransactionOutPointSize = HashSize + 4
+
+	// WitnessScaleFactor is the weight of a non-witness byte (BIP141)
+	WitnessScaleFactor = 4
+
+	// Smallest serialized input, output and transaction, bounding decoded counts
+	transactionInputMinSize  = TransactionOutPointSize + 1 + 4
+	transactionOutputMinSize = 8 + 1
+	transactionMinSize       = 4 + 1 + 1 + 4
+	// Items preallocated for a decoded count, the rest growing as they are read
+	maxPreallocItems = 1024
 )
 
 type Transaction struct {
@@ -66,19 +77,53 @@ func NewScriptWitness(b [][]byte) ScriptWitness {
 	return witness
 }
 
-func NewScriptWitnessFromBuffer(buffer *Buffer) (ScriptWitness, error) {
+// getCount reads the CompactSize count of items serialized in at least size bytes,
+// failing when a block could not hold them
+func getCount(buffer *Buffer, size int) (int, error) {
 	n, err := buffer.GetVarInt()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > MaxBlockSerializedSize/uint64(size) {
+		return 0, ErrTransactionTooManyItems
+	}
+
+	return int(n), nil
+}
+
+// getVarBytes reads CompactSize prefixed bytes, failing when a block could not hold them
+func getVarBytes(buffer *Buffer) ([]byte, error) {
+	n, err := getCount(buffer, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.GetBytes(n)
+}
+
+// preallocate returns the capacity to allocate for n decoded items, so that a
+// forged count does not allocate more than the payload carries
+func preallocate(n int) int {
+	if n > maxPreallocItems {
+		return maxPreallocItems
+	}
+	return n
+}
+
+func NewScriptWitnessFromBuffer(buffer *Buffer) (ScriptWitness, error) {
+	n, err := getCount(buffer, 1)
 	if err != nil {
 		return nil, err
 	}
 
-	witness := make([][]byte, n)
-	for i := 0; i < int(n); i++ {
-		b, err := buffer.GetVarBytes()
+	witness := make([][]byte, 0, preallocate(n))
+	for i := 0; i < n; i++ {
+		b, err := getVarBytes(buffer)
 		if err != nil {
 			return nil, err
 		}
-		witness[i] = b
+		witness = append(witness, b)
 	}
 
 	return NewScriptWitness(witness), nil
@@ -168,7 +213,7 @@ func NewTransactionInputFromBuffer(buffer *Buffer) (*TransactionInput, error) {
 		return nil, err
 	}
 
-	scriptSig, err := buffer.GetVarBytes()
+	scriptSig, err := getVarBytes(buffer)
 	if err != nil {
 		return nil, err
 	}
@@ -196,7 +241,7 @@ func (ti *TransactionInput) IsFinal() bool {
 }
 
 func (ti *TransactionInput) HasWitness() bool {
-	return false
+	return ti.ScriptWitness.Size() > 0
 }
 
 func (ti *TransactionInput) String() string {
@@ -228,7 +273,7 @@ func NewTransactionOutputFromBuffer(buffer *Buffer) (*TransactionOutput, error)
 		return nil, err
 	}
 
-	scriptPubkey, err := buffer.GetVarBytes()
+	scriptPubkey, err := getVarBytes(buffer)
 	if err != nil {
 		return nil, err
 	}
@@ -290,32 +335,61 @@ func NewTransactionFromBuffer(buffer *Buffer) (*Transaction, error) {
 		return nil, err
 	}
 
-	ninputs, err := buffer.GetVarInt()
+	ninputs, err := getCount(buffer, transactionInputMinSize)
 	if err != nil {
 		return nil, err
 	}
 
-	inputs := make([]*TransactionInput, ninputs)
-	for i := 0; i < int(ninputs); i++ {
+	// An empty input list is the witness marker, as in blocks and the p2p protocol
+	witness := false
+	if ninputs == TransactionWitnessMarker {
+		flag, err := buffer.GetUint8()
+		if err != nil {
+			return nil, err
+		}
+
+		if flag != TransactionWitnessFlag {
+			return nil, ErrTransactionNoWitnessFlag
+		}
+
+		witness = true
+		ninputs, err = getCount(buffer, transactionInputMinSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inputs := make([]*TransactionInput, 0, preallocate(ninputs))
+	for i := 0; i < ninputs; i++ {
 		input, err := NewTransactionInputFromBuffer(buffer)
 		if err != nil {
 			return nil, err
 		}
-		inputs[i] = input
+		inputs = append(inputs, input)
 	}
 
-	noutputs, err := buffer.GetVarInt()
+	noutputs, err := getCount(buffer, transactionOutputMinSize)
 	if err != nil {
 		return nil, err
 	}
 
-	outputs := make([]*TransactionOutput, noutputs)
-	for i := 0; i < int(noutputs); i++ {
+	outputs := make([]*TransactionOutput, 0, preallocate(noutputs))
+	for i := 0; i < noutputs; i++ {
 		output, err := NewTransactionOutputFromBuffer(buffer)
 		if err != nil {
 			return nil, err
 		}
-		outputs[i] = output
+		outputs = append(outputs, output)
+	}
+
+	if witness {
+		for i := 0; i < ninputs; i++ {
+			witness, err := NewScriptWitnessFromBuffer(buffer)
+			if err != nil {
+				return nil, err
+			}
+			inputs[i].ScriptWitness = witness
+		}
 	}
 
 	locktime, err := buffer.GetUint32()
@@ -357,35 +431,35 @@ func NewTransactionWitnessFromBytes(data []byte) (*Transaction, error) {
 		return nil, ErrTransactionNoWitnessFlag
 	}
 
-	ninputs, err := buffer.GetVarInt()
+	ninputs, err := getCount(buffer, transactionInputMinSize)
 	if err != nil {
 		return nil, err
 	}
 
-	inputs := make([]*TransactionInput, ninputs)
-	for i := 0; i < int(ninputs); i++ {
+	inputs := make([]*TransactionInput, 0, preallocate(ninputs))
+	for i := 0; i < ninputs; i++ {
 		input, err := NewTransactionInputFromBuffer(buffer)
 		if err != nil {
 			return nil, err
 		}
-		inputs[i] = input
+		inputs = append(inputs, input)
 	}
 
-	noutputs, err := buffer.GetVarInt()
+	noutputs, err := getCount(buffer, transactionOutputMinSize)
 	if err != nil {
 		return nil, err
 	}
 
-	outputs := make([]*TransactionOutput, noutputs)
-	for i := 0; i < int(noutputs); i++ {
+	outputs := make([]*TransactionOutput, 0, preallocate(noutputs))
+	for i := 0; i < noutputs; i++ {
 		output, err := NewTransactionOutputFromBuffer(buffer)
 		if err != nil {
 			return nil, err
 		}
-		outputs[i] = output
+		outputs = append(outputs, output)
 	}
 
-	for i := 0; i < int(ninputs); i++ {
+	for i := 0; i < ninputs; i++ {
 		witness, err := NewScriptWitnessFromBuffer(buffer)
 		if err != nil {
 			return nil, err
@@ -490,6 +564,16 @@ func (t *Transaction) BytesWithWitness() []byte {
 	return buffer.Bytes()
 }
 
+// Weight returns the BIP141 weight: the size without witness counts four times, witness data once.
+func (t *Transaction) Weight() int {
+	return len(t.Bytes())*(WitnessScaleFactor-1) + len(t.BytesWithWitness())
+}
+
+// VirtualSize returns the weight divided by four, rounded up.
+func (t *Transaction) VirtualSize() int {
+	return (t.Weight() + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
 func (t *Transaction) String() string {
 	inputs := make([]fmt.Stringer, len(t.Inputs))
 	for i, s := range t.Inputs {
@@ -0,0 +1,256 @@
+package bcore
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrUndoBadChecksum = errors.New("undo: bad checksum")
+	ErrUndoMismatch    = errors.New("undo: does not match block")
+)
+
+const (
+	// MaxUndoSerializedSize bounds the size of an undo record, the deserialization limit of Bitcoin Core
+	MaxUndoSerializedSize = 0x02000000
+	// UndoChecksumSize is the size of the checksum following every undo record
+	UndoChecksumSize = HashSize
+)
+
+// UndoFileName returns the name of the n-th undo file
+func UndoFileName(n int) string {
+	return fmt.Sprintf("rev%05d.dat", n)
+}
+
+// newUtxoEntryFromUndoBuffer reads a spent coin of a CTxUndo:
+// VARINT(height*2 + coinbase), a legacy version VARINT for non-zero heights and the compressed output.
+func newUtxoEntryFromUndoBuffer(buffer *Buffer) (*UtxoEntry, error) {
+	code, err := DecodeVarInt128(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	height := uint32(code >> 1)
+	if height > 0 {
+		if _, err := DecodeVarInt128(buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	output, err := NewTransactionOutputFromCompressedBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUtxoEntry(output, height, code&1 == 1), nil
+}
+
+func (e *UtxoEntry) undoBytes() []byte {
+	code := uint64(e.Height) * 2
+	if e.Coinbase {
+		code |= 1
+	}
+
+	buffer := NewBuffer().PutBytes(EncodeVarInt128(code))
+	if e.Height > 0 {
+		buffer.PutUint8(0)
+	}
+
+	return buffer.PutBytes(e.Output.CompressedBytes()).Bytes()
+}
+
+func NewTransactionUndoFromBuffer(buffer *Buffer) (*TransactionUndo, error) {
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return nil, err
+	}
+
+	var spent []*UtxoEntry
+	for i := uint64(0); i < n; i++ {
+		e, err := newUtxoEntryFromUndoBuffer(buffer)
+		if err != nil {
+			return nil, err
+		}
+		spent = append(spent, e)
+	}
+
+	return &TransactionUndo{Spent: spent}, nil
+}
+
+// Bytes serializes the undo data as a CTxUndo of Bitcoin Core
+func (tu *TransactionUndo) Bytes() []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(tu.Spent)))
+	for _, e := range tu.Spent {
+		buffer.PutBytes(e.undoBytes())
+	}
+
+	return buffer.Bytes()
+}
+
+func NewBlockUndoFromBytes(data []byte) (*BlockUndo, error) {
+	return NewBlockUndoFromBuffer(NewReadBuffer(data))
+}
+
+func NewBlockUndoFromBuffer(buffer *Buffer) (*BlockUndo, error) {
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*TransactionUndo
+	for i := uint64(0); i < n; i++ {
+		tu, err := NewTransactionUndoFromBuffer(buffer)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tu)
+	}
+
+	return &BlockUndo{Transactions: txs}, nil
+}
+
+// Bytes serializes the undo data as a CBlockUndo of Bitcoin Core
+func (u *BlockUndo) Bytes() []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(u.Transactions)))
+	for _, tu := range u.Transactions {
+		buffer.PutBytes(tu.Bytes())
+	}
+
+	return buffer.Bytes()
+}
+
+// Checksum returns the checksum stored after the undo record of a block whose previous block is prevHash
+func (u *BlockUndo) Checksum(prevHash Hash) Hash {
+	return undoChecksum(prevHash, u.Bytes())
+}
+
+func undoChecksum(prevHash Hash, data []byte) Hash {
+	return DHash256(NewBuffer().PutHash(prevHash).PutBytes(data).Bytes())
+}
+
+// Matches reports whether the undo data has one entry per input of every non-coinbase transaction of block
+func (u *BlockUndo) Matches(block *Block) bool {
+	if len(block.Transactions) == 0 || len(u.Transactions) != len(block.Transactions)-1 {
+		return false
+	}
+
+	for i, tu := range u.Transactions {
+		if len(tu.Spent) != len(block.Transactions[i+1].Inputs) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Prevouts returns the outputs spent by block, which lets fees be computed without a UTXO set.
+func (u *BlockUndo) Prevouts(block *Block) (MapPrevoutFetcher, error) {
+	if !u.Matches(block) {
+		return nil, ErrUndoMismatch
+	}
+
+	fetcher := NewMapPrevoutFetcher()
+	for i, tu := range u.Transactions {
+		for j, input := range block.Transactions[i+1].Inputs {
+			fetcher.Add(input.PrevOutput, tu.Spent[j].Output)
+		}
+	}
+
+	return fetcher, nil
+}
+
+// UndoRecord is an undo record read from a rev*.dat file
+type UndoRecord struct {
+	Undo     *BlockUndo
+	Checksum Hash
+	Position BlockFilePosition
+
+	data []byte
+}
+
+// Verify reports whether the record belongs to a block whose previous block is prevHash
+func (ur *UndoRecord) Verify(prevHash Hash) bool {
+	return undoChecksum(prevHash, ur.data) == ur.Checksum
+}
+
+// UndoFileReader iterates the undo records stored in the rev*.dat files of a
+// Bitcoin Core blocks directory. The undo data of a block is in the rev file with the
+// number of its blk file, and records are written in the order blocks were connected.
+type UndoFileReader struct {
+	r *flatFileReader
+}
+
+func NewUndoFileReader(dir string, params *ChainParams) (*UndoFileReader, error) {
+	r, err := newFlatFileReader(dir, "rev", params, MaxUndoSerializedSize, UndoChecksumSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UndoFileReader{r: r}, nil
+}
+
+// Seek moves the reader so that Next returns the record stored at pos.
+// A zero offset starts at the beginning of the file.
+func (r *UndoFileReader) Seek(pos BlockFilePosition) error {
+	return r.r.seek(pos)
+}
+
+func newUndoRecord(data []byte, pos BlockFilePosition) (*UndoRecord, error) {
+	n := len(data) - UndoChecksumSize
+	undo, err := NewBlockUndoFromBytes(data[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	checksum, err := NewReadBuffer(data[n:]).GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &UndoRecord{
+		Undo:     undo,
+		Checksum: checksum,
+		Position: pos,
+		data:     data[:n],
+	}, nil
+}
+
+// Next returns the next undo record, io.EOF after the last one.
+func (r *UndoFileReader) Next() (*UndoRecord, error) {
+	data, pos, err := r.r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return newUndoRecord(data, pos)
+}
+
+// ReadUndoAt reads the undo record stored at pos and checks that it belongs to block.
+func (r *UndoFileReader) ReadUndoAt(pos BlockFilePosition, block *Block) (*BlockUndo, error) {
+	data, err := r.r.readAt(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := newUndoRecord(data, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if !record.Verify(block.Header.PrevHash) {
+		return nil, ErrUndoBadChecksum
+	}
+
+	if !record.Undo.Matches(block) {
+		return nil, ErrUndoMismatch
+	}
+
+	return record.Undo, nil
+}
+
+func (r *UndoFileReader) Close() error {
+	r.r.close()
+	return nil
+}
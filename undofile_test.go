@@ -0,0 +1,158 @@
+package bcore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func writeTestUndoFile(t *testing.T, dir string, n int, key []byte, blocks []*Block, undos []*BlockUndo) []BlockFilePosition {
+	var data []byte
+	var positions []BlockFilePosition
+	for i, undo := range undos {
+		b := undo.Bytes()
+		header := make([]byte, BlockFileHeaderSize)
+		binary.LittleEndian.PutUint32(header, RegTestParams.Magic)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(b)))
+		data = append(data, header...)
+		positions = append(positions, BlockFilePosition{File: n, Offset: int64(len(data))})
+		data = append(data, b...)
+		checksum := undo.Checksum(blocks[i].Header.PrevHash)
+		data = append(data, checksum[:]...)
+	}
+	xorBlockFile(data, key, 0)
+
+	if err := os.WriteFile(filepath.Join(dir, UndoFileName(n)), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return positions
+}
+
+func TestBlockUndoBytes(t *testing.T) {
+	p2pkh, _ := hex.DecodeString("76a914404371705fa9bd789a2fcd52d2c580b65d35549d88ac")
+	undo := &BlockUndo{
+		Transactions: []*TransactionUndo{
+			{Spent: []*UtxoEntry{
+				NewUtxoEntry(&TransactionOutput{Value: 50 * Coin, ScriptPubkey: p2pkh}, 1, true),
+				NewUtxoEntry(&TransactionOutput{Value: 1, ScriptPubkey: []byte{0x51}}, 0, false),
+			}},
+			{},
+		},
+	}
+
+	expect := "02" + "02" + "03" + "00" + "3200404371705fa9bd789a2fcd52d2c580b65d35549d" + "00" + "01" + "0751" + "00"
+	if hex.EncodeToString(undo.Bytes()) != expect {
+		t.Fatalf("undo bytes: expect %s, got %x", expect, undo.Bytes())
+	}
+
+	got, err := NewBlockUndoFromBytes(undo.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Transactions) != 2 || len(got.Transactions[0].Spent) != 2 || len(got.Transactions[1].Spent) != 0 {
+		t.Fatalf("undo: got %+v", got)
+	}
+
+	e := got.Transactions[0].Spent[0]
+	if e.Height != 1 || !e.Coinbase || e.Output.Value != 50*Coin || hex.EncodeToString(e.Output.ScriptPubkey) != hex.EncodeToString(p2pkh) {
+		t.Fatalf("spent coin: got %+v", e)
+	}
+}
+
+func TestUndoFileReader(t *testing.T) {
+	key := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xor.dat"), key, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestSpendChain(110)
+	undos := make([]*BlockUndo, len(blocks))
+	fees := make([]uint64, len(blocks))
+	for i, block := range blocks {
+		fee, err := block.Fees(set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fees[i] = fee
+
+		undos[i], err = set.ConnectBlock(block, uint32(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	positions := writeTestUndoFile(t, dir, 0, key, blocks[:105], undos[:105])
+	positions = append(positions, writeTestUndoFile(t, dir, 1, key, blocks[105:], undos[105:])...)
+
+	r, err := NewUndoFileReader(dir, RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; ; i++ {
+		record, err := r.Next()
+		if err == io.EOF {
+			if i != len(blocks) {
+				t.Fatalf("undo records: expect %d, got %d", len(blocks), i)
+			}
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Position != positions[i] {
+			t.Fatalf("record %d position: expect %s, got %s", i, positions[i], record.Position)
+		}
+
+		if !record.Verify(blocks[i].Header.PrevHash) || record.Verify(blocks[i].Hash()) {
+			t.Fatalf("record %d: bad checksum", i)
+		}
+
+		prevouts, err := record.Undo.Prevouts(blocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fee, err := blocks[i].Fees(prevouts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fee != fees[i] {
+			t.Fatalf("block %d fees: expect %d, got %d", i, fees[i], fee)
+		}
+	}
+
+	undo, err := r.ReadUndoAt(positions[107], blocks[107])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !undo.Matches(blocks[107]) || undo.Transactions[0].Spent[0].Height != 8 {
+		t.Fatalf("undo: got %+v", undo.Transactions[0].Spent[0])
+	}
+
+	if _, err := r.ReadUndoAt(positions[107], blocks[106]); err != ErrUndoBadChecksum {
+		t.Fatalf("expect bad checksum, got %v", err)
+	}
+
+	if _, err := undos[107].Prevouts(blocks[0]); err != ErrUndoMismatch {
+		t.Fatalf("expect mismatch, got %v", err)
+	}
+
+	if undos[107].Checksum(HashZero) == undos[107].Checksum(blocks[106].Hash()) {
+		t.Fatalf("checksum should commit to the previous block")
+	}
+}
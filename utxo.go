@@ -0,0 +1,287 @@
+package bcore
+
+import (
+	"errors"
+)
+
+var (
+	ErrUtxoMissing          = errors.New("utxo: missing or spent output")
+	ErrUtxoOverwrite        = errors.New("utxo: output already exists")
+	ErrUtxoImmatureCoinbase = errors.New("utxo: immature coinbase spend")
+	ErrUtxoUndoMismatch     = errors.New("utxo: undo data does not match block")
+	ErrUtxoNoCoinbase       = errors.New("utxo: block has no coinbase")
+)
+
+const (
+	// MaxScriptSize is the maximal size of a script, larger output scripts are unspendable
+	MaxScriptSize = 10000
+
+	opcodeReturn = 0x6a
+)
+
+// IsUnspendable reports whether the output can provably never be spent,
+// in which case it is not added to the UTXO set.
+func (to *TransactionOutput) IsUnspendable() bool {
+	return (len(to.ScriptPubkey) > 0 && to.ScriptPubkey[0] == opcodeReturn) || len(to.ScriptPubkey) > MaxScriptSize
+}
+
+// UtxoEntry is an unspent transaction output together with the metadata needed to validate its spend
+type UtxoEntry struct {
+	Output *TransactionOutput
+	// Height of the block containing the transaction
+	Height uint32
+	// Whether the output was created by a coinbase transaction
+	Coinbase bool
+}
+
+func NewUtxoEntry(output *TransactionOutput, height uint32, coinbase bool) *UtxoEntry {
+	return &UtxoEntry{
+		Output:   output,
+		Height:   height,
+		Coinbase: coinbase,
+	}
+}
+
+func (e *UtxoEntry) Clone() *UtxoEntry {
+	return &UtxoEntry{
+		Output:   e.Output.Clone(),
+		Height:   e.Height,
+		Coinbase: e.Coinbase,
+	}
+}
+
+// IsMature reports whether the output may be spent in a block at height, never
+// below the height of its own block
+func (e *UtxoEntry) IsMature(height uint32, params *ChainParams) bool {
+	if height < e.Height {
+		return false
+	}
+	return !e.Coinbase || height-e.Height >= params.CoinbaseMaturity
+}
+
+// TransactionUndo holds the outputs spent by a transaction, in input order
+type TransactionUndo struct {
+	Spent []*UtxoEntry
+}
+
+// BlockUndo holds the undo data of every non-coinbase transaction of a block, in block order
+type BlockUndo struct {
+	Transactions []*TransactionUndo
+}
+
+// UtxoSet is an in-memory set of unspent transaction outputs
+type UtxoSet struct {
+	params  *ChainParams
+	entries map[OutPoint]*UtxoEntry
+}
+
+func NewUtxoSet(params *ChainParams) *UtxoSet {
+	return &UtxoSet{
+		params:  params,
+		entries: make(map[OutPoint]*UtxoEntry),
+	}
+}
+
+func (s *UtxoSet) Len() int { return len(s.entries) }
+
+func (s *UtxoSet) Get(op *OutPoint) (*UtxoEntry, bool) {
+	e, ok := s.entries[*op]
+	return e, ok
+}
+
+func (s *UtxoSet) Has(op *OutPoint) bool {
+	_, ok := s.entries[*op]
+	return ok
+}
+
+// Add inserts an entry, it fails if the outpoint is already unspent unless overwrite is set
+func (s *UtxoSet) Add(op *OutPoint, entry *UtxoEntry, overwrite bool) error {
+	if _, ok := s.entries[*op]; ok && !overwrite {
+		return ErrUtxoOverwrite
+	}
+
+	s.entries[*op] = entry
+	return nil
+}
+
+// Spend removes the entry of op and returns it
+func (s *UtxoSet) Spend(op *OutPoint) (*UtxoEntry, error) {
+	e, ok := s.entries[*op]
+	if !ok {
+		return nil, ErrUtxoMissing
+	}
+
+	delete(s.entries, *op)
+	return e, nil
+}
+
+// ForEach calls fn for every unspent output until it returns false, in no particular order
+func (s *UtxoSet) ForEach(fn func(op *OutPoint, entry *UtxoEntry) bool) {
+	for op, e := range s.entries {
+		op := op
+		if !fn(&op, e) {
+			return
+		}
+	}
+}
+
+// TotalAmount returns the sum of all unspent output values
+func (s *UtxoSet) TotalAmount() uint64 {
+	sum := uint64(0)
+	for _, e := range s.entries {
+		sum += e.Output.Value
+	}
+	return sum
+}
+
+// ConnectBlock applies the block at height: every input is spent and every spendable
+// output is added. The returned undo data restores the set with DisconnectBlock.
+// On error the set is left unchanged.
+func (s *UtxoSet) ConnectBlock(block *Block, height uint32) (*BlockUndo, error) {
+	return connectBlock(s, s.params, block, height)
+}
+
+// DisconnectBlock reverts a block previously applied with ConnectBlock using its undo data.
+func (s *UtxoSet) DisconnectBlock(block *Block, undo *BlockUndo) error {
+	return disconnectBlock(s, block, undo)
+}
+
+func (s *UtxoSet) fetchUtxo(op *OutPoint) (*UtxoEntry, error) {
+	e, ok := s.entries[*op]
+	if !ok {
+		return nil, ErrUtxoMissing
+	}
+	return e, nil
+}
+
+func (s *UtxoSet) addUtxo(op *OutPoint, entry *UtxoEntry, overwrite bool) error {
+	return s.Add(op, entry, overwrite)
+}
+
+func (s *UtxoSet) spendUtxo(op *OutPoint) {
+	delete(s.entries, *op)
+}
+
+// utxoView is the set of unspent outputs blocks are connected to.
+// Only fetchUtxo may fail for reasons other than the state of the set.
+type utxoView interface {
+	// fetchUtxo returns ErrUtxoMissing if op is not unspent
+	fetchUtxo(op *OutPoint) (*UtxoEntry, error)
+	// addUtxo fails with ErrUtxoOverwrite if op is known to be unspent and overwrite is not set
+	addUtxo(op *OutPoint, entry *UtxoEntry, overwrite bool) error
+	spendUtxo(op *OutPoint)
+}
+
+func connectBlock(view utxoView, params *ChainParams, block *Block, height uint32) (*BlockUndo, error) {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return nil, ErrUtxoNoCoinbase
+	}
+
+	undo := &BlockUndo{
+		Transactions: make([]*TransactionUndo, 0, len(block.Transactions)-1),
+	}
+
+	for i, tx := range block.Transactions {
+		if err := connectTransaction(view, params, tx, height, undo); err != nil {
+			// The failed transaction rolled back its own changes, unwind the ones before it
+			disconnectTransactions(view, block.Transactions[:i], undo.Transactions)
+			return nil, err
+		}
+	}
+
+	return undo, nil
+}
+
+func connectTransaction(view utxoView, params *ChainParams, tx *Transaction, height uint32, undo *BlockUndo) error {
+	coinbase := tx.IsCoinbase()
+
+	if !coinbase {
+		txundo := &TransactionUndo{
+			Spent: make([]*UtxoEntry, 0, len(tx.Inputs)),
+		}
+
+		for _, input := range tx.Inputs {
+			e, err := view.fetchUtxo(input.PrevOutput)
+			if err == nil && !e.IsMature(height, params) {
+				err = ErrUtxoImmatureCoinbase
+			}
+
+			if err != nil {
+				restoreInputs(view, tx, txundo)
+				return err
+			}
+
+			view.spendUtxo(input.PrevOutput)
+			txundo.Spent = append(txundo.Spent, e)
+		}
+
+		undo.Transactions = append(undo.Transactions, txundo)
+	}
+
+	txid := tx.Hash()
+	for i, output := range tx.Outputs {
+		if output.IsUnspendable() {
+			continue
+		}
+
+		// BIP30: historical duplicate coinbases overwrite the earlier outputs
+		op := NewOutPoint(txid, uint32(i))
+		if err := view.addUtxo(op, NewUtxoEntry(output, height, coinbase), coinbase); err != nil {
+			removeOutputs(view, tx, i)
+			n := len(undo.Transactions) - 1
+			restoreInputs(view, tx, undo.Transactions[n])
+			undo.Transactions = undo.Transactions[:n]
+			return err
+		}
+	}
+
+	return nil
+}
+
+func disconnectBlock(view utxoView, block *Block, undo *BlockUndo) error {
+	if len(block.Transactions) == 0 || len(undo.Transactions) != len(block.Transactions)-1 {
+		return ErrUtxoUndoMismatch
+	}
+
+	for i := 1; i < len(block.Transactions); i++ {
+		if len(undo.Transactions[i-1].Spent) != len(block.Transactions[i].Inputs) {
+			return ErrUtxoUndoMismatch
+		}
+	}
+
+	disconnectTransactions(view, block.Transactions, undo.Transactions)
+	return nil
+}
+
+// disconnectTransactions reverts txs in reverse order, txundos holds the undo data of their non-coinbase transactions
+func disconnectTransactions(view utxoView, txs []*Transaction, txundos []*TransactionUndo) {
+	n := len(txundos)
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+		removeOutputs(view, tx, len(tx.Outputs))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		n--
+		restoreInputs(view, tx, txundos[n])
+	}
+}
+
+// removeOutputs removes the spendable outputs among the first n outputs of tx
+func removeOutputs(view utxoView, tx *Transaction, n int) {
+	txid := tx.Hash()
+	for i := 0; i < n; i++ {
+		if !tx.Outputs[i].IsUnspendable() {
+			view.spendUtxo(NewOutPoint(txid, uint32(i)))
+		}
+	}
+}
+
+// restoreInputs puts back the outputs spent by tx recorded in txundo
+func restoreInputs(view utxoView, tx *Transaction, txundo *TransactionUndo) {
+	for i := len(txundo.Spent) - 1; i >= 0; i-- {
+		view.addUtxo(tx.Inputs[i].PrevOutput, txundo.Spent[i], true)
+	}
+}
@@ -0,0 +1,196 @@
+package bcore
+
+import (
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var testScriptPubkey = []byte{0x51}
+
+func newTestTransaction(prevs []*OutPoint, values ...uint64) *Transaction {
+	inputs := make([]*TransactionInput, len(prevs))
+	for i, prev := range prevs {
+		inputs[i] = &TransactionInput{
+			PrevOutput:    prev,
+			ScriptSig:     []byte{},
+			Sequence:      TransactionFinalSequence,
+			ScriptWitness: NewScriptWitness([][]byte{}),
+		}
+	}
+
+	outputs := make([]*TransactionOutput, len(values))
+	for i, value := range values {
+		outputs[i] = &TransactionOutput{
+			Value:        value,
+			ScriptPubkey: testScriptPubkey,
+		}
+	}
+
+	return &Transaction{
+		Version: 2,
+		Inputs:  inputs,
+		Outputs: outputs,
+	}
+}
+
+// newTestBlock builds a block at height on top of prev whose coinbase claims the subsidy
+func newTestBlock(prev Hash, height uint32, txs ...*Transaction) *Block {
+	coinbase := NewCoinbaseTransaction(height, nil, []*TransactionOutput{
+		{Value: Subsidy(height, RegTestParams), ScriptPubkey: testScriptPubkey},
+	})
+
+	return NewBlock(&BlockHeader{
+		Version:  4,
+		PrevHash: prev,
+		Time:     1600000000 + height*600,
+		Bits:     NewCompact(0x207fffff),
+	}, append([]*Transaction{coinbase}, txs...))
+}
+
+// newTestChain connects n blocks with only a coinbase on top of an empty set
+func newTestChain(t *testing.T, set *UtxoSet, n int) []*Block {
+	blocks := make([]*Block, 0, n)
+	prev := HashZero
+	for height := 1; height <= n; height++ {
+		block := newTestBlock(prev, uint32(height))
+		if _, err := set.ConnectBlock(block, uint32(height)); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		prev = block.Hash()
+	}
+
+	return blocks
+}
+
+func utxoSnapshot(set *UtxoSet) map[OutPoint]UtxoEntry {
+	m := make(map[OutPoint]UtxoEntry)
+	set.ForEach(func(op *OutPoint, e *UtxoEntry) bool {
+		m[*op] = *e
+		return true
+	})
+	return m
+}
+
+func checkUtxoSnapshot(t *testing.T, set *UtxoSet, expect map[OutPoint]UtxoEntry) {
+	got := utxoSnapshot(set)
+	if len(got) != len(expect) {
+		t.Fatalf("utxo set size: expect %d, got %d", len(expect), len(got))
+	}
+
+	for op, e := range expect {
+		g, ok := got[op]
+		if !ok || g.Height != e.Height || g.Coinbase != e.Coinbase || g.Output.Value != e.Output.Value {
+			t.Fatalf("utxo %s:%d: expect %+v, got %+v", op.Hash, op.Index, e, g)
+		}
+	}
+}
+
+func TestUtxoSetConnectDisconnect(t *testing.T) {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestChain(t, set, 99)
+
+	// heights 1 to 99 are all before the first regtest halving
+	if set.Len() != 99 || set.TotalAmount() != 99*50*Coin {
+		t.Fatalf("utxo set: got %d entries of %d", set.Len(), set.TotalAmount())
+	}
+
+	coinbase := NewOutPoint(blocks[0].Transactions[0].Hash(), 0)
+	spend := newTestTransaction([]*OutPoint{coinbase}, 30*Coin, 19*Coin)
+	spend.Outputs = append(spend.Outputs, &TransactionOutput{Value: 0, ScriptPubkey: []byte{opcodeReturn}})
+	child := newTestTransaction([]*OutPoint{NewOutPoint(spend.Hash(), 0)}, 29*Coin)
+
+	before := utxoSnapshot(set)
+
+	// height 100 spends the coinbase of height 1 only 99 blocks later
+	if _, err := set.ConnectBlock(newTestBlock(blocks[98].Hash(), 100, spend, child), 100); err != ErrUtxoImmatureCoinbase {
+		t.Fatalf("expect immature coinbase, got %v", err)
+	}
+	checkUtxoSnapshot(t, set, before)
+
+	blocks = append(blocks, newTestBlock(blocks[98].Hash(), 100))
+	if _, err := set.ConnectBlock(blocks[99], 100); err != nil {
+		t.Fatal(err)
+	}
+	before = utxoSnapshot(set)
+
+	block := newTestBlock(blocks[99].Hash(), 101, spend, child)
+	undo, err := set.ConnectBlock(block, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(undo.Transactions) != 2 || undo.Transactions[0].Spent[0].Output.Value != 50*Coin || !undo.Transactions[0].Spent[0].Coinbase {
+		t.Fatalf("undo: got %+v", undo.Transactions)
+	}
+
+	if set.Has(coinbase) || set.Has(NewOutPoint(spend.Hash(), 0)) || set.Has(NewOutPoint(spend.Hash(), 2)) {
+		t.Fatalf("spent and unspendable outputs should not be in the set")
+	}
+
+	e, ok := set.Get(NewOutPoint(child.Hash(), 0))
+	if !ok || e.Height != 101 || e.Coinbase || e.Output.Value != 29*Coin {
+		t.Fatalf("child output: got %+v", e)
+	}
+
+	if set.Len() != 100-1+1+1+1 {
+		t.Fatalf("utxo set size: got %d", set.Len())
+	}
+
+	if err := set.DisconnectBlock(block, undo); err != nil {
+		t.Fatal(err)
+	}
+	checkUtxoSnapshot(t, set, before)
+}
+
+func TestUtxoSetConnectBlockRollback(t *testing.T) {
+	set := NewUtxoSet(RegTestParams)
+	blocks := newTestChain(t, set, 101)
+	before := utxoSnapshot(set)
+
+	spend := newTestTransaction([]*OutPoint{NewOutPoint(blocks[0].Transactions[0].Hash(), 0)}, 50*Coin)
+	missing := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, Coin)
+	if _, err := set.ConnectBlock(newTestBlock(blocks[100].Hash(), 102, spend, missing), 102); err != ErrUtxoMissing {
+		t.Fatalf("expect missing, got %v", err)
+	}
+	checkUtxoSnapshot(t, set, before)
+
+	double := newTestTransaction([]*OutPoint{NewOutPoint(blocks[0].Transactions[0].Hash(), 0)}, 49*Coin)
+	if _, err := set.ConnectBlock(newTestBlock(blocks[100].Hash(), 102, spend, double), 102); err != ErrUtxoMissing {
+		t.Fatalf("expect double spend to be missing, got %v", err)
+	}
+	checkUtxoSnapshot(t, set, before)
+
+	if _, err := set.ConnectBlock(NewBlock(&BlockHeader{}, []*Transaction{spend}), 102); err != ErrUtxoNoCoinbase {
+		t.Fatalf("expect no coinbase, got %v", err)
+	}
+
+	if err := set.DisconnectBlock(blocks[0], &BlockUndo{Transactions: []*TransactionUndo{{}}}); err != ErrUtxoUndoMismatch {
+		t.Fatalf("expect undo mismatch, got %v", err)
+	}
+}
+
+func TestUtxoEntryIsMature(t *testing.T) {
+	maturity := RegTestParams.CoinbaseMaturity
+	coinbase := &UtxoEntry{Height: 10, Coinbase: true}
+	regular := &UtxoEntry{Height: 10}
+
+	tests := []struct {
+		e      *UtxoEntry
+		height uint32
+		expect bool
+	}{
+		{coinbase, 10 + maturity, true},
+		{coinbase, 10 + maturity - 1, false},
+		{coinbase, 9, false},
+		{coinbase, 0, false},
+		{regular, 10, true},
+		{regular, 9, false},
+	}
+	for _, test := range tests {
+		if got := test.e.IsMature(test.height, RegTestParams); got != test.expect {
+			t.Fatalf("%+v at %d: expect %v, got %v", test.e, test.height, test.expect, got)
+		}
+	}
+}
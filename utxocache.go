@@ -0,0 +1,197 @@
+package bcore
+
+import (
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// Estimated memory used by a cached entry besides its script
+	utxoCacheEntryOverhead = 160
+
+	// DefaultUtxoCacheSize is the default memory budget of a UtxoCache
+	DefaultUtxoCacheSize = 450 << 20
+)
+
+const (
+	// The entry differs from the store
+	utxoCacheDirty = 1 << iota
+	// The store has no unspent entry for the outpoint, so spending it needs no write
+	utxoCacheFresh
+)
+
+type utxoCacheEntry struct {
+	// nil once spent
+	entry *UtxoEntry
+	flags uint8
+}
+
+func (ce *utxoCacheEntry) usage() int {
+	if ce.entry == nil {
+		return utxoCacheEntryOverhead
+	}
+
+	return utxoCacheEntryOverhead + len(ce.entry.Output.ScriptPubkey)
+}
+
+// UtxoCache is a write-back cache of unspent outputs in front of a UtxoStore.
+// Changes are kept in memory and written to the store by Flush, which happens
+// automatically after a connected or disconnected block once the cache uses more
+// than its memory budget. A flush always ends at a block boundary.
+type UtxoCache struct {
+	store   UtxoStore
+	params  *ChainParams
+	maxSize int
+	size    int
+	best    Hash
+	entries map[OutPoint]*utxoCacheEntry
+}
+
+// NewUtxoCache creates a cache over store using about maxSize bytes of memory
+func NewUtxoCache(store UtxoStore, params *ChainParams, maxSize int) (*UtxoCache, error) {
+	best, err := store.BestBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &UtxoCache{
+		store:   store,
+		params:  params,
+		maxSize: maxSize,
+		best:    best,
+		entries: make(map[OutPoint]*utxoCacheEntry),
+	}, nil
+}
+
+// BestBlock returns the hash of the last connected block, including unflushed ones
+func (c *UtxoCache) BestBlock() Hash { return c.best }
+
+// Usage returns the estimated memory used by the cached entries
+func (c *UtxoCache) Usage() int { return c.size }
+
+// Len returns the number of cached entries, spent ones included
+func (c *UtxoCache) Len() int { return len(c.entries) }
+
+// Get returns the unspent output of op, ErrUtxoMissing if there is none
+func (c *UtxoCache) Get(op *OutPoint) (*UtxoEntry, error) {
+	return c.fetchUtxo(op)
+}
+
+// ConnectBlock applies the block at height like UtxoSet.ConnectBlock and flushes
+// the cache if it is over budget.
+func (c *UtxoCache) ConnectBlock(block *Block, height uint32) (*BlockUndo, error) {
+	undo, err := connectBlock(c, c.params, block, height)
+	if err != nil {
+		return nil, err
+	}
+
+	c.best = block.Hash()
+	if err := c.flushIfNeeded(); err != nil {
+		return nil, err
+	}
+
+	return undo, nil
+}
+
+// DisconnectBlock reverts a block like UtxoSet.DisconnectBlock and flushes
+// the cache if it is over budget.
+func (c *UtxoCache) DisconnectBlock(block *Block, undo *BlockUndo) error {
+	if err := disconnectBlock(c, block, undo); err != nil {
+		return err
+	}
+
+	c.best = block.Header.PrevHash
+	return c.flushIfNeeded()
+}
+
+func (c *UtxoCache) flushIfNeeded() error {
+	if c.size <= c.maxSize {
+		return nil
+	}
+
+	return c.Flush()
+}
+
+// Flush writes every change to the store together with the best block and empties the cache
+func (c *UtxoCache) Flush() error {
+	changes := make(map[OutPoint]*UtxoEntry)
+	for op, ce := range c.entries {
+		if ce.flags&utxoCacheDirty == 0 {
+			continue
+		}
+
+		// A fresh entry spent before the flush never reached the store
+		if ce.entry == nil && ce.flags&utxoCacheFresh != 0 {
+			continue
+		}
+
+		changes[op] = ce.entry
+	}
+
+	if err := c.store.WriteUtxos(changes, c.best); err != nil {
+		return err
+	}
+
+	c.entries = make(map[OutPoint]*utxoCacheEntry)
+	c.size = 0
+
+	return nil
+}
+
+func (c *UtxoCache) fetchUtxo(op *OutPoint) (*UtxoEntry, error) {
+	if ce, ok := c.entries[*op]; ok {
+		if ce.entry == nil {
+			return nil, ErrUtxoMissing
+		}
+		return ce.entry, nil
+	}
+
+	e, err := c.store.GetUtxo(op)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(op, &utxoCacheEntry{entry: e})
+	return e, nil
+}
+
+func (c *UtxoCache) addUtxo(op *OutPoint, entry *UtxoEntry, overwrite bool) error {
+	fresh := false
+	ce, ok := c.entries[*op]
+
+	if !overwrite {
+		if ok && ce.entry != nil {
+			return ErrUtxoOverwrite
+		}
+
+		// A dirty spent entry has not reached the store yet, which still has the output
+		fresh = !ok || ce.flags&utxoCacheDirty == 0
+	}
+
+	flags := uint8(utxoCacheDirty)
+	if fresh || (ok && ce.flags&utxoCacheFresh != 0) {
+		flags |= utxoCacheFresh
+	}
+
+	c.put(op, &utxoCacheEntry{entry: entry, flags: flags})
+	return nil
+}
+
+func (c *UtxoCache) spendUtxo(op *OutPoint) {
+	ce, ok := c.entries[*op]
+	if ok && ce.flags&utxoCacheFresh != 0 {
+		c.size -= ce.usage()
+		delete(c.entries, *op)
+		return
+	}
+
+	c.put(op, &utxoCacheEntry{flags: utxoCacheDirty})
+}
+
+func (c *UtxoCache) put(op *OutPoint, ce *utxoCacheEntry) {
+	if old, ok := c.entries[*op]; ok {
+		c.size -= old.usage()
+	}
+
+	c.entries[*op] = ce
+	c.size += ce.usage()
+}
@@ -0,0 +1,252 @@
+package bcore
+
+import (
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// newTestSpendChain builds n blocks where, past coinbase maturity, every block spends
+// the coinbase matured at its height and an output created by the previous block.
+func newTestSpendChain(n int) []*Block {
+	blocks := make([]*Block, 0, n)
+	prev := HashZero
+	var last *Transaction
+	for height := 1; height <= n; height++ {
+		var txs []*Transaction
+		if height > 100 {
+			prevs := []*OutPoint{NewOutPoint(blocks[height-101].Transactions[0].Hash(), 0)}
+			if last != nil {
+				prevs = append(prevs, NewOutPoint(last.Hash(), 1))
+			}
+			last = newTestTransaction(prevs, 10*Coin, 20*Coin, 15*Coin)
+			txs = append(txs, last)
+		}
+
+		block := newTestBlock(prev, uint32(height), txs...)
+		blocks = append(blocks, block)
+		prev = block.Hash()
+	}
+
+	return blocks
+}
+
+func checkUtxoStore(t *testing.T, store UtxoStore, expect map[OutPoint]UtxoEntry) {
+	got := make(map[OutPoint]UtxoEntry)
+	if err := store.ForEachUtxo(func(op *OutPoint, e *UtxoEntry) bool {
+		got[*op] = *e
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(expect) {
+		t.Fatalf("utxo store size: expect %d, got %d", len(expect), len(got))
+	}
+
+	for op, e := range expect {
+		g, ok := got[op]
+		if !ok || g.Height != e.Height || g.Coinbase != e.Coinbase || g.Output.Value != e.Output.Value {
+			t.Fatalf("utxo %s:%d: expect %+v, got %+v", op.Hash, op.Index, e, g)
+		}
+	}
+}
+
+func TestUtxoCacheConnectDisconnect(t *testing.T) {
+	blocks := newTestSpendChain(120)
+
+	for _, maxSize := range []int{0, 4 * utxoCacheEntryOverhead, DefaultUtxoCacheSize} {
+		set := NewUtxoSet(RegTestParams)
+		store := NewMemoryUtxoStore()
+		cache, err := NewUtxoCache(store, RegTestParams, maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		undos := make([]*BlockUndo, len(blocks))
+		for i, block := range blocks {
+			if _, err := set.ConnectBlock(block, uint32(i+1)); err != nil {
+				t.Fatal(err)
+			}
+
+			undos[i], err = cache.ConnectBlock(block, uint32(i+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if maxSize == 0 && cache.Len() != 0 {
+				t.Fatalf("cache should be flushed after every block, got %d entries", cache.Len())
+			}
+		}
+
+		if cache.BestBlock() != blocks[119].Hash() {
+			t.Fatalf("best block: got %s", cache.BestBlock())
+		}
+
+		for i := len(blocks) - 1; i >= 110; i-- {
+			if err := set.DisconnectBlock(blocks[i], undos[i]); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cache.DisconnectBlock(blocks[i], undos[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := cache.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		best, err := store.BestBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if best != blocks[109].Hash() {
+			t.Fatalf("store best block: expect %s, got %s", blocks[109].Hash(), best)
+		}
+
+		checkUtxoStore(t, store, utxoSnapshot(set))
+	}
+}
+
+func TestUtxoCacheFreshSpend(t *testing.T) {
+	store := NewMemoryUtxoStore()
+	cache, err := NewUtxoCache(store, RegTestParams, DefaultUtxoCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := newTestSpendChain(102)
+	for i, block := range blocks {
+		if _, err := cache.ConnectBlock(block, uint32(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// outputs created and spent without a flush in between never reach the store
+	op := NewOutPoint(blocks[100].Transactions[1].Hash(), 1)
+	if _, err := cache.Get(op); err != ErrUtxoMissing {
+		t.Fatalf("expect missing, got %v", err)
+	}
+
+	if _, ok := cache.entries[*op]; ok {
+		t.Fatalf("fresh spent entry should be dropped from the cache")
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetUtxo(op); err != ErrUtxoMissing {
+		t.Fatalf("expect missing, got %v", err)
+	}
+
+	e, err := cache.Get(NewOutPoint(blocks[101].Transactions[1].Hash(), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Height != 102 || e.Output.Value != 20*Coin || cache.Usage() == 0 {
+		t.Fatalf("entry: got %+v", e)
+	}
+}
+
+func TestUtxoCacheRecovery(t *testing.T) {
+	for _, store := range []func() (UtxoStore, error){
+		func() (UtxoStore, error) { return NewMemoryUtxoStore(), nil },
+		func() (UtxoStore, error) { return NewLevelDBUtxoStore(t.TempDir()) },
+	} {
+		store, err := store()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks := newTestSpendChain(110)
+		cache, err := NewUtxoCache(store, RegTestParams, DefaultUtxoCacheSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, block := range blocks[:105] {
+			if _, err := cache.ConnectBlock(block, uint32(i+1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := cache.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// blocks connected after the last flush are lost in a crash
+		for i, block := range blocks[105:] {
+			if _, err := cache.ConnectBlock(block, uint32(106+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cache, err = NewUtxoCache(store, RegTestParams, DefaultUtxoCacheSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cache.BestBlock() != blocks[104].Hash() {
+			t.Fatalf("recovered best block: got %s", cache.BestBlock())
+		}
+
+		for i, block := range blocks[105:] {
+			if _, err := cache.ConnectBlock(block, uint32(106+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := cache.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		set := NewUtxoSet(RegTestParams)
+		for i, block := range blocks {
+			if _, err := set.ConnectBlock(block, uint32(i+1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkUtxoStore(t, store, utxoSnapshot(set))
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUtxoCacheConnectBlockError(t *testing.T) {
+	store := NewMemoryUtxoStore()
+	cache, err := NewUtxoCache(store, RegTestParams, DefaultUtxoCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := newTestSpendChain(101)
+	for i, block := range blocks[:100] {
+		if _, err := cache.ConnectBlock(block, uint32(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := newTestTransaction([]*OutPoint{NewOutPoint(Hash{1}, 0)}, Coin)
+	block := newTestBlock(blocks[99].Hash(), 101, blocks[100].Transactions[1], missing)
+	if _, err := cache.ConnectBlock(block, 101); err != ErrUtxoMissing {
+		t.Fatalf("expect missing, got %v", err)
+	}
+
+	if cache.BestBlock() != blocks[99].Hash() {
+		t.Fatalf("best block should not move")
+	}
+
+	if _, err := cache.Get(NewOutPoint(blocks[0].Transactions[0].Hash(), 0)); err != nil {
+		t.Fatalf("rolled back input should be unspent, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package bcore
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrUtxoStoreClosed = errors.New("utxo store: closed")
+)
+
+// UtxoStore is a persistent backend of unspent outputs.
+// The best block marker is written atomically with the outputs, so after a crash the
+// store holds the UTXO set as of BestBlock and blocks are replayed from there.
+type UtxoStore interface {
+	// GetUtxo returns ErrUtxoMissing if op is not unspent
+	GetUtxo(op *OutPoint) (*UtxoEntry, error)
+	// BestBlock returns the hash of the block the store is synced to, HashZero if empty
+	BestBlock() (Hash, error)
+	// WriteUtxos atomically applies changes, where a nil entry deletes the outpoint, and sets the best block
+	WriteUtxos(changes map[OutPoint]*UtxoEntry, best Hash) error
+	// ForEachUtxo calls fn for every unspent output until it returns false
+	ForEachUtxo(fn func(op *OutPoint, entry *UtxoEntry) bool) error
+	Close() error
+}
+
+// MemoryUtxoStore is a UtxoStore kept in memory, entries are stored compressed
+type MemoryUtxoStore struct {
+	mu      sync.RWMutex
+	closed  bool
+	best    Hash
+	entries map[OutPoint][]byte
+}
+
+func NewMemoryUtxoStore() *MemoryUtxoStore {
+	return &MemoryUtxoStore{
+		entries: make(map[OutPoint][]byte),
+	}
+}
+
+func (s *MemoryUtxoStore) GetUtxo(op *OutPoint) (*UtxoEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrUtxoStoreClosed
+	}
+
+	data, ok := s.entries[*op]
+	if !ok {
+		return nil, ErrUtxoMissing
+	}
+
+	return NewUtxoEntryFromCompressedBytes(data)
+}
+
+func (s *MemoryUtxoStore) BestBlock() (Hash, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return HashZero, ErrUtxoStoreClosed
+	}
+
+	return s.best, nil
+}
+
+func (s *MemoryUtxoStore) WriteUtxos(changes map[OutPoint]*UtxoEntry, best Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrUtxoStoreClosed
+	}
+
+	for op, e := range changes {
+		if e == nil {
+			delete(s.entries, op)
+		} else {
+			s.entries[op] = e.CompressedBytes()
+		}
+	}
+	s.best = best
+
+	return nil
+}
+
+func (s *MemoryUtxoStore) ForEachUtxo(fn func(op *OutPoint, entry *UtxoEntry) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrUtxoStoreClosed
+	}
+
+	// Iterate in the key order of the chainstate database, like LevelDBUtxoStore
+	ops := make([]OutPoint, 0, len(s.entries))
+	for op := range s.entries {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return compareOutPoint(&ops[i], &ops[j]) < 0
+	})
+
+	for i := range ops {
+		e, err := NewUtxoEntryFromCompressedBytes(s.entries[ops[i]])
+		if err != nil {
+			return err
+		}
+
+		if !fn(&ops[i], e) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryUtxoStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
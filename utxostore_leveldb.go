@@ -0,0 +1,164 @@
+package bcore
+
+import (
+	"errors"
+
+	. "github.com/detailyang/go-bprimitives"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	ErrUtxoStoreInvalidKey = errors.New("utxo store: invalid key")
+)
+
+const (
+	// Key prefixes follow the chainstate database of Bitcoin Core
+	utxoStoreCoinPrefix      = 'C'
+	utxoStoreBestBlockPrefix = 'B'
+)
+
+// LevelDBUtxoStore is a UtxoStore backed by an embedded LevelDB database.
+// Coins are stored under 'C' + txid + VARINT(index) in their compressed encoding.
+type LevelDBUtxoStore struct {
+	db *leveldb.DB
+	// Values are XORed with this key when set, see OpenChainstate
+	key []byte
+}
+
+func NewLevelDBUtxoStore(path string) (*LevelDBUtxoStore, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		Compression: opt.NoCompression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LevelDBUtxoStore{db: db}, nil
+}
+
+func utxoStoreKey(op *OutPoint) []byte {
+	return NewBuffer().
+		PutUint8(utxoStoreCoinPrefix).
+		PutHash(op.Hash).
+		PutBytes(EncodeVarInt128(uint64(op.Index))).
+		Bytes()
+}
+
+func newOutPointFromUtxoStoreKey(key []byte) (*OutPoint, error) {
+	buffer := NewReadBuffer(key)
+
+	prefix, err := buffer.GetUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != utxoStoreCoinPrefix {
+		return nil, ErrUtxoStoreInvalidKey
+	}
+
+	hash, err := buffer.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := DecodeVarInt128(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOutPoint(hash, uint32(index)), nil
+}
+
+// xor (de)obfuscates a value in place
+func (s *LevelDBUtxoStore) xor(value []byte) []byte {
+	if len(s.key) == 0 {
+		return value
+	}
+
+	for i := range value {
+		value[i] ^= s.key[i%len(s.key)]
+	}
+	return value
+}
+
+func (s *LevelDBUtxoStore) get(key []byte) ([]byte, error) {
+	data, err := s.db.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.xor(data), nil
+}
+
+func (s *LevelDBUtxoStore) GetUtxo(op *OutPoint) (*UtxoEntry, error) {
+	data, err := s.get(utxoStoreKey(op))
+	if err == leveldb.ErrNotFound {
+		return nil, ErrUtxoMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUtxoEntryFromCompressedBytes(data)
+}
+
+func (s *LevelDBUtxoStore) BestBlock() (Hash, error) {
+	data, err := s.get([]byte{utxoStoreBestBlockPrefix})
+	if err == leveldb.ErrNotFound {
+		return HashZero, nil
+	}
+
+	if err != nil {
+		return HashZero, err
+	}
+
+	return NewReadBuffer(data).GetHash()
+}
+
+func (s *LevelDBUtxoStore) WriteUtxos(changes map[OutPoint]*UtxoEntry, best Hash) error {
+	batch := new(leveldb.Batch)
+	for op, e := range changes {
+		op := op
+		if e == nil {
+			batch.Delete(utxoStoreKey(&op))
+		} else {
+			batch.Put(utxoStoreKey(&op), s.xor(e.CompressedBytes()))
+		}
+	}
+	batch.Put([]byte{utxoStoreBestBlockPrefix}, s.xor(NewBuffer().PutHash(best).Bytes()))
+
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (s *LevelDBUtxoStore) ForEachUtxo(fn func(op *OutPoint, entry *UtxoEntry) bool) error {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte{utxoStoreCoinPrefix}), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		op, err := newOutPointFromUtxoStoreKey(iter.Key())
+		if err != nil {
+			return err
+		}
+
+		value := make([]byte, len(iter.Value()))
+		copy(value, iter.Value())
+
+		e, err := NewUtxoEntryFromCompressedBytes(s.xor(value))
+		if err != nil {
+			return err
+		}
+
+		if !fn(op, e) {
+			break
+		}
+	}
+
+	return iter.Error()
+}
+
+func (s *LevelDBUtxoStore) Close() error {
+	return s.db.Close()
+}
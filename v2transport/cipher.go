@@ -0,0 +1,210 @@
+package v2transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/detailyang/go-bcore/internal/chacha20"
+	"github.com/detailyang/go-bcore/internal/chacha20poly1305"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrPacketAuthentication = errors.New("v2transport: packet authentication failed")
+)
+
+const (
+	// RekeyInterval is the number of packets after which the ciphers change keys
+	RekeyInterval = 224
+	// LengthSize is the size of the encrypted contents length preceding each packet
+	LengthSize = 3
+	// HeaderSize is the size of the encrypted header byte preceding the contents
+	HeaderSize = 1
+	// Expansion is the size a packet adds to its contents
+	Expansion = LengthSize + HeaderSize + chacha20poly1305.Overhead
+	// GarbageTerminatorSize is the size of the garbage terminators
+	GarbageTerminatorSize = 16
+
+	// ignoreBit is set in the header of decoy packets
+	ignoreBit = 0x80
+)
+
+// fsChaCha20 is the forward secure stream cipher of the packet lengths: one
+// ChaCha20 stream, rekeyed with its own key stream every RekeyInterval chunks.
+type fsChaCha20 struct {
+	cipher *chacha20.Cipher
+	chunks uint32
+	rekeys uint64
+}
+
+func newFSChaCha20(key []byte) *fsChaCha20 {
+	f := &fsChaCha20{}
+	f.setKey(key)
+	return f
+}
+
+func (f *fsChaCha20) setKey(key []byte) {
+	nonce := make([]byte, chacha20.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeys)
+
+	// The key and nonce sizes are right
+	f.cipher, _ = chacha20.New(key, nonce)
+}
+
+func (f *fsChaCha20) crypt(dst, src []byte) {
+	f.cipher.XORKeyStream(dst, src)
+
+	f.chunks++
+	if f.chunks == RekeyInterval {
+		key := make([]byte, chacha20.KeySize)
+		f.cipher.KeyStream(key)
+		f.chunks = 0
+		f.rekeys++
+		f.setKey(key)
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure AEAD of the packet contents, the nonce
+// counting packets and rekeys, rekeyed every RekeyInterval packets.
+type fsChaCha20Poly1305 struct {
+	aead    *chacha20poly1305.AEAD
+	packets uint32
+	rekeys  uint64
+}
+
+func newFSChaCha20Poly1305(key []byte) *fsChaCha20Poly1305 {
+	aead, _ := chacha20poly1305.New(key)
+	return &fsChaCha20Poly1305{aead: aead}
+}
+
+func (f *fsChaCha20Poly1305) nonce(packets uint32) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint32(nonce, packets)
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeys)
+	return nonce
+}
+
+// next moves to the next packet, the new key being the encryption of zeros with
+// the reserved packet number 0xffffffff
+func (f *fsChaCha20Poly1305) next() {
+	f.packets++
+	if f.packets == RekeyInterval {
+		key, _ := f.aead.Seal(nil, f.nonce(0xffffffff), make([]byte, chacha20poly1305.KeySize), nil)
+		f.aead, _ = chacha20poly1305.New(key[:chacha20poly1305.KeySize])
+		f.packets = 0
+		f.rekeys++
+	}
+}
+
+func (f *fsChaCha20Poly1305) seal(dst, plaintext, aad []byte) []byte {
+	dst, _ = f.aead.Seal(dst, f.nonce(f.packets), plaintext, aad)
+	f.next()
+	return dst
+}
+
+func (f *fsChaCha20Poly1305) open(dst, ciphertext, aad []byte) ([]byte, error) {
+	dst, err := f.aead.Open(dst, f.nonce(f.packets), ciphertext, aad)
+	f.next()
+	return dst, err
+}
+
+// hkdfExpand32 returns the first 32 bytes of the HKDF-SHA256 expansion of prk with info
+func hkdfExpand32(prk []byte, info string) []byte {
+	mac := hmac.New(sha256.New, prk)
+	mac.Write([]byte(info))
+	mac.Write([]byte{1})
+	return mac.Sum(nil)
+}
+
+// Cipher encrypts and decrypts the packets of a v2 connection once the keys are exchanged
+type Cipher struct {
+	sendL *fsChaCha20
+	sendP *fsChaCha20Poly1305
+	recvL *fsChaCha20
+	recvP *fsChaCha20Poly1305
+
+	sendGarbageTerminator [GarbageTerminatorSize]byte
+	recvGarbageTerminator [GarbageTerminatorSize]byte
+	sessionID             Hash
+}
+
+// NewCipher derives the keys of a connection from our key and the encoded public
+// key of the peer with HKDF-SHA256, salted with the network magic.
+func NewCipher(key *PrivateKey, theirs [EllSwiftSize]byte, initiating bool, magic uint32) *Cipher {
+	secret := key.ECDH(theirs, initiating)
+
+	salt := make([]byte, 28)
+	copy(salt, "bitcoin_v2_shared_secret")
+	binary.LittleEndian.PutUint32(salt[24:], magic)
+
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret[:])
+	prk := extract.Sum(nil)
+
+	c := &Cipher{}
+	initiatorL := newFSChaCha20(hkdfExpand32(prk, "initiator_L"))
+	initiatorP := newFSChaCha20Poly1305(hkdfExpand32(prk, "initiator_P"))
+	responderL := newFSChaCha20(hkdfExpand32(prk, "responder_L"))
+	responderP := newFSChaCha20Poly1305(hkdfExpand32(prk, "responder_P"))
+	terminators := hkdfExpand32(prk, "garbage_terminators")
+	copy(c.sessionID[:], hkdfExpand32(prk, "session_id"))
+
+	if initiating {
+		c.sendL, c.sendP, c.recvL, c.recvP = initiatorL, initiatorP, responderL, responderP
+		copy(c.sendGarbageTerminator[:], terminators[:GarbageTerminatorSize])
+		copy(c.recvGarbageTerminator[:], terminators[GarbageTerminatorSize:])
+	} else {
+		c.sendL, c.sendP, c.recvL, c.recvP = responderL, responderP, initiatorL, initiatorP
+		copy(c.sendGarbageTerminator[:], terminators[GarbageTerminatorSize:])
+		copy(c.recvGarbageTerminator[:], terminators[:GarbageTerminatorSize])
+	}
+
+	return c
+}
+
+// SessionID identifies the connection, both ends compute the same
+func (c *Cipher) SessionID() Hash { return c.sessionID }
+
+// SendGarbageTerminator follows the garbage we send
+func (c *Cipher) SendGarbageTerminator() []byte { return c.sendGarbageTerminator[:] }
+
+// RecvGarbageTerminator follows the garbage of the peer
+func (c *Cipher) RecvGarbageTerminator() []byte { return c.recvGarbageTerminator[:] }
+
+// Encrypt returns the packet of contents: its encrypted length then the encrypted
+// header and contents with their tag. Decoy packets have ignore set.
+func (c *Cipher) Encrypt(contents, aad []byte, ignore bool) []byte {
+	packet := make([]byte, LengthSize, Expansion+len(contents))
+	packet[0] = byte(len(contents))
+	packet[1] = byte(len(contents) >> 8)
+	packet[2] = byte(len(contents) >> 16)
+	c.sendL.crypt(packet, packet)
+
+	plaintext := make([]byte, HeaderSize, HeaderSize+len(contents))
+	if ignore {
+		plaintext[0] = ignoreBit
+	}
+	plaintext = append(plaintext, contents...)
+
+	return c.sendP.seal(packet, plaintext, aad)
+}
+
+// DecryptLength returns the contents length of the next packet from its LengthSize first bytes
+func (c *Cipher) DecryptLength(data []byte) uint32 {
+	var length [LengthSize]byte
+	c.recvL.crypt(length[:], data[:LengthSize])
+	return uint32(length[0]) | uint32(length[1])<<8 | uint32(length[2])<<16
+}
+
+// Decrypt authenticates and decrypts the rest of a packet, the header, contents
+// and tag following its length. It reports whether the packet is a decoy.
+func (c *Cipher) Decrypt(data, aad []byte) ([]byte, bool, error) {
+	plaintext, err := c.recvP.open(nil, data, aad)
+	if err != nil || len(plaintext) < HeaderSize {
+		return nil, false, ErrPacketAuthentication
+	}
+
+	return plaintext[HeaderSize:], plaintext[0]&ignoreBit != 0, nil
+}
@@ -0,0 +1,151 @@
+package v2transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func newTestCiphers(t *testing.T) (*Cipher, *Cipher) {
+	a, err := GeneratePrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GeneratePrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCipher(a, b.EllSwift, true, 0xd9b4bef9), NewCipher(b, a.EllSwift, false, 0xd9b4bef9)
+}
+
+// decryptPacket decrypts the packet at the start of data, returning the rest
+func decryptPacket(t *testing.T, c *Cipher, data, aad []byte) ([]byte, bool, []byte) {
+	n := c.DecryptLength(data)
+	end := int(n) + Expansion
+	contents, ignore, err := c.Decrypt(data[LengthSize:end], aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents, ignore, data[end:]
+}
+
+func TestCipher(t *testing.T) {
+	initiator, responder := newTestCiphers(t)
+
+	if initiator.SessionID() != responder.SessionID() {
+		t.Fatal("cipher: session ids mismatch")
+	}
+	if !bytes.Equal(initiator.SendGarbageTerminator(), responder.RecvGarbageTerminator()) ||
+		!bytes.Equal(initiator.RecvGarbageTerminator(), responder.SendGarbageTerminator()) ||
+		bytes.Equal(initiator.SendGarbageTerminator(), initiator.RecvGarbageTerminator()) {
+		t.Fatal("cipher: garbage terminators mismatch")
+	}
+
+	// Enough packets for a few rekeys, both ways
+	garbage := []byte("garbage")
+	for i := 0; i < 3*RekeyInterval+5; i++ {
+		contents := bytes.Repeat([]byte{byte(i)}, i)
+		aad := []byte(nil)
+		if i == 0 {
+			aad = garbage
+		}
+
+		packet := initiator.Encrypt(contents, aad, i%7 == 0)
+		if len(packet) != len(contents)+Expansion {
+			t.Fatalf("packet %d: unexpected size %d", i, len(packet))
+		}
+
+		got, ignore, rest := decryptPacket(t, responder, packet, aad)
+		if !bytes.Equal(got, contents) || ignore != (i%7 == 0) || len(rest) != 0 {
+			t.Fatalf("packet %d: contents mismatch", i)
+		}
+
+		packet = responder.Encrypt(contents, nil, false)
+		if got, _, _ := decryptPacket(t, initiator, packet, nil); !bytes.Equal(got, contents) {
+			t.Fatalf("packet %d: reply mismatch", i)
+		}
+	}
+}
+
+func TestCipherVectors(t *testing.T) {
+	// BIP324 packet encoding test vectors, the packet at index after as many empty ones
+	tests := []struct {
+		secret     string
+		ours       string
+		theirs     string
+		initiating bool
+		index      int
+		contents   string
+		ignore     bool
+		// The end of the packet for long contents
+		ciphertext string
+	}{
+		{
+			"61062ea5071d800bbfd59e2e8b53d47d194b095ae5a4df04936b49772ef0d4d7",
+			"ec0adff257bbfe500c188c80b4fdd640f6b45a482bbc15fc7cef5931deff0aa186f6eb9bba7b85dc4dcc28b28722de1e3d9108b985e2967045668f66098e475b",
+			"a4a94dfce69b4a2a0a099313d10f9f7e7d649d60501c9e1d274c300e0d89aafaffffffffffffffffffffffffffffffffffffffffffffffffffffffff8faf88d5",
+			true, 1, "8e", false,
+			"7530d2a18720162ac09c25329a60d75adf36eda3c3",
+		},
+		{
+			"1f9c581b35231838f0f17cf0c979835baccb7f3abbbb96ffcc318ab71e6e126f",
+			"a1855e10e94e00baa23041d916e259f7044e491da6171269694763f018c7e63693d29575dcb464ac816baa1be353ba12e3876cba7628bd0bd8e755e721eb0140",
+			"fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f0000000000000000000000000000000000000000000000000000000000000000",
+			false, 999, "3eb1d4e98035cfd8eeb29bac969ed3824a", false,
+			"1da1bcf589f9b61872f45b7fa5371dd3f8bdf5d515b0c5f9fe9f0044afb8dc0aa1cd39a8c4",
+		},
+		{
+			"6c77432d1fda31e9f942f8af44607e10f3ad38a65f8a4bddae823e5eff90dc38",
+			"d2685070c1e6376e633e825296634fd461fa9e5bdf2109bcebd735e5a91f3e587c5cb782abb797fbf6bb5074fd1542a474f2a45b673763ec2db7fb99b737bbb9",
+			"56bd0c06f10352c3a1a9f4b4c92f6fa2b26df124b57878353c1fc691c51abea77c8817daeeb9fa546b77c8daf79d89b22b0e1b87574ece42371f00237aa9d83a",
+			false, 223, "7e0e78eb6990b059e6cf0ded66ea93ef82e72aa2f18ac24f2fc6ebab561ae557420729da103f64cecfa20527e15f9fb669a49bbbf274ef0389b3e43c8c44e5f60bf2ac38e2b55e7ec4273dba15ba41d21f8f5b3ee1688b3c29951218caf847a97fb50d75a86515d445699497d968164bf740012679b8962de573be941c62b7ef", true,
+			"729847a3e9eba7a5bff454b5de3b393431ee360736b6c030d7a5bd01d1203d2e98f528543fd2bf886ccaa1ada5e215a730a36b3f4abfc4e252c89eb01d9512f94916dae8a76bf16e4da28986ffe159090fe5267ee3394300b7ccf4dfad389a26321b3a3423e4594a82ccfbad16d6561ecb8772b0cb040280ff999a29e3d9d4fd",
+		},
+	}
+
+	for _, test := range tests {
+		key := newVectorKey(t, test.secret, test.ours)
+		c := NewCipher(key, decodeVectorEllSwift(t, test.theirs), test.initiating, 0xd9b4bef9)
+		for i := 0; i < test.index; i++ {
+			c.Encrypt(nil, nil, false)
+		}
+
+		contents, _ := hex.DecodeString(test.contents)
+		expect, _ := hex.DecodeString(test.ciphertext)
+		if packet := c.Encrypt(contents, nil, test.ignore); !bytes.HasSuffix(packet, expect) {
+			t.Fatalf("packet %d: expect %s, got %x", test.index, test.ciphertext, packet)
+		}
+	}
+
+	// Keys derived for the first vector
+	c := NewCipher(newVectorKey(t, tests[0].secret, tests[0].ours), decodeVectorEllSwift(t, tests[0].theirs), true, 0xd9b4bef9)
+	sessionID := c.SessionID()
+	if hex.EncodeToString(sessionID[:]) != "ce72dffb015da62b0d0f5474cab8bc72605225b0cee3f62312ec680ec5f41ba5" {
+		t.Fatalf("session id: got %x", sessionID[:])
+	}
+	if hex.EncodeToString(c.SendGarbageTerminator()) != "faef555dfcdb936425d84aba524758f3" ||
+		hex.EncodeToString(c.RecvGarbageTerminator()) != "02cb8ff24307a6e27de3b4e7ea3fa65b" {
+		t.Fatalf("garbage terminators: got %x and %x", c.SendGarbageTerminator(), c.RecvGarbageTerminator())
+	}
+}
+
+func TestCipherAuthentication(t *testing.T) {
+	initiator, responder := newTestCiphers(t)
+
+	packet := initiator.Encrypt([]byte("contents"), []byte("garbage"), false)
+	packet[LengthSize+2] ^= 1
+	responder.DecryptLength(packet)
+	if _, _, err := responder.Decrypt(packet[LengthSize:], []byte("garbage")); err != ErrPacketAuthentication {
+		t.Fatalf("expect %v, got %v", ErrPacketAuthentication, err)
+	}
+
+	// The aad is authenticated
+	initiator, responder = newTestCiphers(t)
+	packet = initiator.Encrypt([]byte("contents"), []byte("garbage"), false)
+	responder.DecryptLength(packet)
+	if _, _, err := responder.Decrypt(packet[LengthSize:], []byte("garbagf")); err != ErrPacketAuthentication {
+		t.Fatalf("expect %v, got %v", ErrPacketAuthentication, err)
+	}
+}
@@ -0,0 +1,244 @@
+package v2transport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/detailyang/go-bcore/internal/secp256k1"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrPrivateKey = errors.New("v2transport: invalid private key")
+)
+
+const (
+	// EllSwiftSize is the size of an ElligatorSwift encoded public key
+	EllSwiftSize = 64
+)
+
+// mustParseFieldElement parses a field element of 32 bytes in hex
+func mustParseFieldElement(s string) *secp256k1.FieldElement {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		panic("v2transport: bad constant " + s)
+	}
+	a, _ := new(secp256k1.FieldElement).SetBytes(b)
+	return a
+}
+
+func fe(n uint64) *secp256k1.FieldElement { return new(secp256k1.FieldElement).SetUint64(n) }
+
+var (
+	// ellSwiftC is the square root of -3 used by XSwiftEC, as (-3)^((p+1)/4)
+	ellSwiftC = mustParseFieldElement("0a2d2ba93507f1df233770c2a797962cc61f6d15da14ecd47d8d27ae1cd5f852")
+
+	// fieldHalf is the inverse of 2
+	fieldHalf = new(secp256k1.FieldElement).Inv(fe(2))
+)
+
+func isXOnCurve(x *secp256k1.FieldElement) bool {
+	_, ok := secp256k1.LiftX(x)
+	return ok
+}
+
+// xSwiftEC decodes the field elements (u, t) to the x coordinate of a curve point
+func xSwiftEC(u, t *secp256k1.FieldElement) *secp256k1.FieldElement {
+	u, t = new(secp256k1.FieldElement).Set(u), new(secp256k1.FieldElement).Set(t)
+	if u.IsZero() {
+		u.SetUint64(1)
+	}
+	if t.IsZero() {
+		t.SetUint64(1)
+	}
+
+	var u3, t2, x, y, num, den secp256k1.FieldElement
+	u3.Square(u)
+	u3.Mul(&u3, u)
+	t2.Square(t)
+	if num.Add(&u3, &t2).Add(&num, fe(7)).IsZero() {
+		t.Add(t, t)
+		t2.Square(t)
+	}
+
+	// X = (u^3 + 7 - t^2) / 2t and Y = (X + t) / (c u)
+	num.Add(&u3, fe(7)).Sub(&num, &t2)
+	den.Add(t, t)
+	x.Mul(&num, den.Inv(&den))
+	num.Add(&x, t)
+	den.Mul(ellSwiftC, u)
+	y.Mul(&num, den.Inv(&den))
+
+	// The first of u + 4Y^2, (-X/Y - u)/2 and (X/Y - u)/2 on the curve
+	candidate := new(secp256k1.FieldElement).Square(&y)
+	candidate.Add(candidate, candidate).Add(candidate, candidate).Add(candidate, u)
+	if isXOnCurve(candidate) {
+		return candidate
+	}
+
+	xy := new(secp256k1.FieldElement).Inv(&y)
+	xy.Mul(&x, xy)
+	candidate.Neg(xy).Sub(candidate, u).Mul(candidate, fieldHalf)
+	if isXOnCurve(candidate) {
+		return candidate
+	}
+
+	return candidate.Sub(xy, u).Mul(candidate, fieldHalf)
+}
+
+// xSwiftECInv returns t such that xSwiftEC(u, t) = x, or nil. Each of the 8 cases
+// gives a different t, when it exists.
+func xSwiftECInv(u, x *secp256k1.FieldElement, c int) *secp256k1.FieldElement {
+	var v, s, u2, g, tmp secp256k1.FieldElement
+	u2.Square(u)
+	g.Mul(&u2, u).Add(&g, fe(7))
+
+	if c&2 == 0 {
+		if isXOnCurve(tmp.Add(x, u).Neg(&tmp)) {
+			return nil
+		}
+
+		// s = -g / (u^2 + uv + v^2)
+		v.Set(x)
+		tmp.Mul(u, &v).Add(&tmp, &u2).Add(&tmp, new(secp256k1.FieldElement).Square(&v))
+		s.Mul(&g, tmp.Inv(&tmp)).Neg(&s)
+	} else {
+		if s.Sub(x, u).IsZero() {
+			return nil
+		}
+
+		// r = sqrt(-s(4g + 3u^2 s)), v = (r/s - u)/2
+		tmp.Mul(&u2, &s).Mul(&tmp, fe(3))
+		tmp.Add(&tmp, new(secp256k1.FieldElement).Mul(&g, fe(4))).Mul(&tmp, &s).Neg(&tmp)
+		r, ok := new(secp256k1.FieldElement).Sqrt(&tmp)
+		if !ok || (c&1 == 1 && r.IsZero()) {
+			return nil
+		}
+
+		v.Mul(r, tmp.Inv(&s)).Sub(&v, u).Mul(&v, fieldHalf)
+	}
+
+	w, ok := new(secp256k1.FieldElement).Sqrt(&s)
+	if !ok {
+		return nil
+	}
+
+	// u(1-c)/2 + v or u(1+c)/2 + v, times w and negated by the cases
+	var t secp256k1.FieldElement
+	if c&1 == 0 {
+		t.Sub(fe(1), ellSwiftC)
+	} else {
+		t.Add(fe(1), ellSwiftC)
+	}
+	t.Mul(&t, u).Mul(&t, fieldHalf).Add(&t, &v).Mul(&t, w)
+	if c&5 == 0 || c&5 == 5 {
+		t.Neg(&t)
+	}
+	return &t
+}
+
+// ellSwiftEncode returns a random ElligatorSwift encoding u || t of x
+func ellSwiftEncode(x *secp256k1.FieldElement, rand io.Reader) ([EllSwiftSize]byte, error) {
+	var enc [EllSwiftSize]byte
+	var random [33]byte
+	for {
+		if _, err := io.ReadFull(rand, random[:]); err != nil {
+			return enc, err
+		}
+
+		u, _ := new(secp256k1.FieldElement).SetBytes(random[:32])
+		t := xSwiftECInv(u, x, int(random[32]&7))
+		if t != nil {
+			ub, tb := u.Bytes(), t.Bytes()
+			copy(enc[:32], ub[:])
+			copy(enc[32:], tb[:])
+			return enc, nil
+		}
+	}
+}
+
+// ellSwiftDecode returns the x coordinate of the point an ElligatorSwift encoding maps to
+func ellSwiftDecode(enc [EllSwiftSize]byte) *secp256k1.FieldElement {
+	u, _ := new(secp256k1.FieldElement).SetBytes(enc[:32])
+	t, _ := new(secp256k1.FieldElement).SetBytes(enc[32:])
+	return xSwiftEC(u, t)
+}
+
+// PrivateKey is a secp256k1 key of the ECDH of a v2 handshake, with an
+// ElligatorSwift encoding of its public key.
+type PrivateKey struct {
+	// The secret scalar, 32 bytes big endian
+	d        [32]byte
+	EllSwift [EllSwiftSize]byte
+}
+
+// NewPrivateKey returns the key of the 32 bytes secret, its public key encoded with randomness from rand
+func NewPrivateKey(secret []byte, rand io.Reader) (*PrivateKey, error) {
+	k := &PrivateKey{}
+	if len(secret) != len(k.d) {
+		return nil, ErrPrivateKey
+	}
+	copy(k.d[:], secret)
+	if !secp256k1.IsValidScalar(&k.d) {
+		return nil, ErrPrivateKey
+	}
+
+	// A valid scalar never gives the point at infinity
+	x, _ := new(secp256k1.Point).ScalarBaseMult(&k.d).AffineX()
+	enc, err := ellSwiftEncode(x, rand)
+	if err != nil {
+		return nil, err
+	}
+	k.EllSwift = enc
+
+	return k, nil
+}
+
+// GeneratePrivateKey returns a random key
+func GeneratePrivateKey(rand io.Reader) (*PrivateKey, error) {
+	secret := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(rand, secret); err != nil {
+			return nil, err
+		}
+
+		k, err := NewPrivateKey(secret, rand)
+		if err != ErrPrivateKey {
+			return k, err
+		}
+	}
+}
+
+// ECDH returns the BIP324 shared secret with the peer of encoded public key theirs:
+// the tagged hash of both encodings, the initiator one first, and the x coordinate
+// of the shared point.
+func (k *PrivateKey) ECDH(theirs [EllSwiftSize]byte, initiating bool) Hash {
+	var shared [32]byte
+	x := ellSwiftDecode(theirs)
+	if y, ok := secp256k1.LiftX(x); ok {
+		// The x coordinate of the product does not depend on the sign of y
+		p := new(secp256k1.Point).ScalarMult(secp256k1.NewPoint(x, y), &k.d)
+		if sx, ok := p.AffineX(); ok {
+			shared = sx.Bytes()
+		}
+	}
+
+	tag := sha256.Sum256([]byte("bip324_ellswift_xonly_ecdh"))
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	if initiating {
+		h.Write(k.EllSwift[:])
+		h.Write(theirs[:])
+	} else {
+		h.Write(theirs[:])
+		h.Write(k.EllSwift[:])
+	}
+	h.Write(shared[:])
+
+	var secret Hash
+	copy(secret[:], h.Sum(nil))
+	return secret
+}
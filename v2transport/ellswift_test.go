@@ -0,0 +1,233 @@
+package v2transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/detailyang/go-bcore/internal/secp256k1"
+)
+
+func TestXSwiftEC(t *testing.T) {
+	tests := []struct {
+		ellswift string
+		x        string
+	}{
+		{
+			"00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+			"edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+		{
+			"000000000000000000000000000000000000000000000000000000000000000001d3475bf7655b0fb2d852921035b2ef607f49069b97454e6795251062741771",
+			"b5da00b73cd6560520e7c364086e7cd23a34bf60d0e707be9fc34d4cd5fdfa2c",
+		},
+		{
+			"0000000000000000000000000000000000000000000000000000000000000000bde70df51939b94c9c24979fa7dd04ebd9b3572da7802290438af2a681895441",
+			"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa9fffffd6b",
+		},
+		{
+			"0000000000000000000000000000000000000000000000000000000000000000fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f",
+			"edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+		{
+			"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffb13f75c0fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f",
+			"4f51e0be078e0cddab2742156adba7e7a148e73157072fd618cd60942b146bd0",
+		},
+		{
+			"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffef64d162750546ce42b0431361e52d4f5242d8f24f33e6b1f99b591647cbc808f462af51",
+			"d41244d11ca4f65240687759f95ca9efbab767ededb38fd18c36e18cd3b6f6a9",
+		},
+		{
+			"fffffffffffffffffffffffffffffffffffffffffffffffffffffffff0e5be52372dd6e894b2a326fc3605a6e8f3c69c710bf27d630dfe2004988b78eb6eab36",
+			"64bf84dd5e03670fdb24c0f5d3c2c365736f51db6c92d95010716ad2d36134c8",
+		},
+		{
+			"fffffffffffffffffffffffffffffffffffffffffffffffffffffffffefbb982fffffffffffffffffffffffffffffffffffffffffffffffffffffffff6d6db1f",
+			"1c92ccdfcf4ac550c28db57cff0c8515cb26936c786584a70114008d6c33a34b",
+		},
+	}
+
+	for _, test := range tests {
+		var enc [EllSwiftSize]byte
+		hex.Decode(enc[:], []byte(test.ellswift))
+
+		if x := ellSwiftDecode(enc); !x.Equal(mustParseFieldElement(test.x)) {
+			t.Fatalf("xswiftec %s: expect %s, got %x", test.ellswift, test.x, x.Bytes())
+		}
+	}
+}
+
+func TestXSwiftECInv(t *testing.T) {
+	tests := []struct {
+		u     string
+		x     string
+		cases []string
+	}{
+		{
+			"05ff6bdad900fc3261bc7fe34e2fb0f569f06e091ae437d3a52e9da0cbfb9590",
+			"80cdf63774ec7022c89a5a8558e373a279170285e0ab27412dbce510bdfe23fc",
+			[]string{
+				"",
+				"",
+				"45654798ece071ba79286d04f7f3eb1c3f1d17dd883610f2ad2efd82a287466b",
+				"0aeaa886f6b76c7158452418cbf5033adc5747e9e9b5d3b2303db96936528557",
+				"",
+				"",
+				"ba9ab867131f8e4586d792fb080c14e3c0e2e82277c9ef0d52d1027c5d78b5c4",
+				"f51557790948938ea7badbe7340afcc523a8b816164a2c4dcfc24695c9ad76d8",
+			},
+		},
+		{
+			"1737a85f4c8d146cec96e3ffdca76d9903dcf3bd53061868d478c78c63c2aa9e",
+			"39e48dd150d2f429be088dfd5b61882e7e8407483702ae9a5ab35927b15f85ea",
+			[]string{
+				"1be8cc0b04be0c681d0c6a68f733f82c6c896e0c8a262fcd392918e303a7abf4",
+				"605b5814bf9b8cb066667c9e5480d22dc5b6c92f14b4af3ee0a9eb83b03685e3",
+				"",
+				"",
+				"e41733f4fb41f397e2f3959708cc07d3937691f375d9d032c6d6e71bfc58503b",
+				"9fa4a7eb4064734f99998361ab7f2dd23a4936d0eb4b50c11f56147b4fc9764c",
+				"",
+				"",
+			},
+		},
+		{
+			"1aaa1ccebf9c724191033df366b36f691c4d902c228033ff4516d122b2564f68",
+			"c75541259d3ba98f207eaa30c69634d187d0b6da594e719e420f4898638fc5b0",
+			[]string{"", "", "", "", "", "", "", ""},
+		},
+	}
+
+	for _, test := range tests {
+		u, x := mustParseFieldElement(test.u), mustParseFieldElement(test.x)
+		for c, expect := range test.cases {
+			got := xSwiftECInv(u, x, c)
+			if expect == "" {
+				if got != nil {
+					t.Fatalf("xswiftecinv %s case %d: expect none, got %x", test.u, c, got.Bytes())
+				}
+				continue
+			}
+
+			if got == nil || !got.Equal(mustParseFieldElement(expect)) {
+				t.Fatalf("xswiftecinv %s case %d: expect %s, got %v", test.u, c, expect, got)
+			}
+			if !xSwiftEC(u, got).Equal(x) {
+				t.Fatalf("xswiftecinv %s case %d: does not decode to x", test.u, c)
+			}
+		}
+	}
+}
+
+func TestEllSwiftEncode(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		key, err := GeneratePrivateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x, _ := new(secp256k1.Point).ScalarBaseMult(&key.d).AffineX()
+		if !ellSwiftDecode(key.EllSwift).Equal(x) {
+			t.Fatal("ellswift: encoding does not decode to the public key")
+		}
+	}
+}
+
+// newVectorKey returns the key of a test vector, with its given public key encoding
+func newVectorKey(t *testing.T, secret, ellswift string) *PrivateKey {
+	k := &PrivateKey{EllSwift: decodeVectorEllSwift(t, ellswift)}
+	if n, err := hex.Decode(k.d[:], []byte(secret)); err != nil || n != len(k.d) {
+		t.Fatalf("secret %s: %v", secret, err)
+	}
+	return k
+}
+
+func decodeVectorEllSwift(t *testing.T, s string) [EllSwiftSize]byte {
+	var enc [EllSwiftSize]byte
+	if n, err := hex.Decode(enc[:], []byte(s)); err != nil || n != EllSwiftSize {
+		t.Fatalf("ellswift %s: %v", s, err)
+	}
+	return enc
+}
+
+func TestECDH(t *testing.T) {
+	// Shared secrets of the BIP324 packet encoding test vectors
+	tests := []struct {
+		secret     string
+		ours       string
+		theirs     string
+		initiating bool
+		shared     string
+	}{
+		{
+			"61062ea5071d800bbfd59e2e8b53d47d194b095ae5a4df04936b49772ef0d4d7",
+			"ec0adff257bbfe500c188c80b4fdd640f6b45a482bbc15fc7cef5931deff0aa186f6eb9bba7b85dc4dcc28b28722de1e3d9108b985e2967045668f66098e475b",
+			"a4a94dfce69b4a2a0a099313d10f9f7e7d649d60501c9e1d274c300e0d89aafaffffffffffffffffffffffffffffffffffffffffffffffffffffffff8faf88d5",
+			true,
+			"c6992a117f5edbea70c3f511d32d26b9798be4b81a62eaee1a5acaa8459a3592",
+		},
+		{
+			"1f9c581b35231838f0f17cf0c979835baccb7f3abbbb96ffcc318ab71e6e126f",
+			"a1855e10e94e00baa23041d916e259f7044e491da6171269694763f018c7e63693d29575dcb464ac816baa1be353ba12e3876cba7628bd0bd8e755e721eb0140",
+			"fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f0000000000000000000000000000000000000000000000000000000000000000",
+			false,
+			"a0138f564f74d0ad70bc337dacc9d0bf1d2349364caf1188a1e6e8ddb3b7b184",
+		},
+		{
+			"6c77432d1fda31e9f942f8af44607e10f3ad38a65f8a4bddae823e5eff90dc38",
+			"d2685070c1e6376e633e825296634fd461fa9e5bdf2109bcebd735e5a91f3e587c5cb782abb797fbf6bb5074fd1542a474f2a45b673763ec2db7fb99b737bbb9",
+			"56bd0c06f10352c3a1a9f4b4c92f6fa2b26df124b57878353c1fc691c51abea77c8817daeeb9fa546b77c8daf79d89b22b0e1b87574ece42371f00237aa9d83a",
+			false,
+			"1918b741ef5f9d1d7670b050c152b4a4ead2c31be9aecb0681c0cd4324150853",
+		},
+	}
+
+	for _, test := range tests {
+		key := newVectorKey(t, test.secret, test.ours)
+		if shared := key.ECDH(decodeVectorEllSwift(t, test.theirs), test.initiating); hex.EncodeToString(shared[:]) != test.shared {
+			t.Fatalf("ecdh %s: expect %s, got %x", test.secret, test.shared, shared[:])
+		}
+	}
+
+	a, err := GeneratePrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GeneratePrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.ECDH(b.EllSwift, true) != b.ECDH(a.EllSwift, false) {
+		t.Fatal("ecdh: shared secrets mismatch")
+	}
+
+	if a.ECDH(b.EllSwift, true) == a.ECDH(b.EllSwift, false) {
+		t.Fatal("ecdh: shared secret does not depend on the side")
+	}
+}
+
+func TestNewPrivateKey(t *testing.T) {
+	curveN, _ := hex.DecodeString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	for _, secret := range [][]byte{
+		make([]byte, 32),
+		curveN,
+		make([]byte, 31),
+	} {
+		if _, err := NewPrivateKey(secret, rand.Reader); err != ErrPrivateKey {
+			t.Fatalf("expect %v, got %v", ErrPrivateKey, err)
+		}
+	}
+
+	// The generator itself, 1 times G
+	secret := make([]byte, 32)
+	secret[31] = 1
+	key, err := NewPrivateKey(secret, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x := ellSwiftDecode(key.EllSwift).Bytes(); hex.EncodeToString(x[:]) != "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" {
+		t.Fatal("ellswift: generator mismatch")
+	}
+}
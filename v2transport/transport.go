@@ -0,0 +1,420 @@
+// Package v2transport implements the BIP324 encrypted transport of the P2P protocol,
+// falling back to v1 framing for peers not speaking it.
+package v2transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrGarbageTooLong = errors.New("v2transport: garbage terminator not found")
+	ErrPacketTooLarge = errors.New("v2transport: packet too large")
+	ErrUnknownShortID = errors.New("v2transport: unknown short message id")
+	ErrEmptyContents  = errors.New("v2transport: empty packet contents")
+)
+
+const (
+	// MaxGarbageSize is the maximal size of the garbage following the public keys
+	MaxGarbageSize = 4095
+)
+
+// shortIDs are the one byte message types of BIP324, 0 announcing a 12 bytes command
+var shortIDs = []string{
+	1:  wire.CmdAddr,
+	2:  wire.CmdBlock,
+	3:  wire.CmdBlockTxn,
+	4:  wire.CmdCmpctBlock,
+	5:  wire.CmdFeeFilter,
+	6:  "filteradd",
+	7:  "filterclear",
+	8:  "filterload",
+	9:  wire.CmdGetBlocks,
+	10: wire.CmdGetBlockTxn,
+	11: wire.CmdGetData,
+	12: wire.CmdGetHeaders,
+	13: wire.CmdHeaders,
+	14: wire.CmdInv,
+	15: "mempool",
+	16: "merkleblock",
+	17: wire.CmdNotFound,
+	18: wire.CmdPing,
+	19: wire.CmdPong,
+	20: wire.CmdSendCmpct,
+	21: wire.CmdTx,
+	22: "getcfilters",
+	23: "cfilter",
+	24: "getcfheaders",
+	25: "cfheaders",
+	26: "getcfcheckpt",
+	27: "cfcheckpt",
+	28: wire.CmdAddrV2,
+}
+
+var shortIDsByCommand = func() map[string]byte {
+	ids := make(map[string]byte)
+	for id, command := range shortIDs {
+		if command != "" {
+			ids[command] = byte(id)
+		}
+	}
+	return ids
+}()
+
+// encodeContents returns the packet contents of msg, its short ID or command then its payload
+func encodeContents(msg wire.Message) ([]byte, error) {
+	command := msg.Command()
+	if len(command) > wire.CommandSize {
+		return nil, wire.ErrMessageBadCommand
+	}
+
+	payload := msg.Bytes()
+	if len(payload) > wire.MaxMessagePayload {
+		return nil, wire.ErrMessageTooLarge
+	}
+
+	if id, ok := shortIDsByCommand[command]; ok {
+		return append([]byte{id}, payload...), nil
+	}
+
+	contents := make([]byte, 1+wire.CommandSize, 1+wire.CommandSize+len(payload))
+	copy(contents[1:], command)
+	return append(contents, payload...), nil
+}
+
+// decodeContents returns the message of packet contents
+func decodeContents(contents []byte) (wire.Message, error) {
+	if len(contents) == 0 {
+		return nil, ErrEmptyContents
+	}
+
+	var command string
+	payload := contents[1:]
+	switch id := int(contents[0]); {
+	case id == 0:
+		if len(payload) < wire.CommandSize {
+			return nil, wire.ErrMessageBadCommand
+		}
+
+		var err error
+		if command, err = wire.ParseCommand(payload[:wire.CommandSize]); err != nil {
+			return nil, err
+		}
+		payload = payload[wire.CommandSize:]
+	case id < len(shortIDs):
+		command = shortIDs[id]
+	default:
+		return nil, ErrUnknownShortID
+	}
+
+	msg, err := wire.NewMessageFromBytes(command, payload)
+	if err != nil {
+		return nil, &wire.MessageError{Command: command, Err: err}
+	}
+
+	return msg, nil
+}
+
+// Conn exchanges messages over a connection, encrypted with BIP324 or with v1 framing
+type Conn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	magic uint32
+	// Maximal payload size of received messages
+	max    uint32
+	cipher *Cipher
+
+	rmu sync.Mutex
+	wmu sync.Mutex
+}
+
+// v1Prefix is the start of a v1 version message, which v2 initiators never send
+func v1Prefix(magic uint32) []byte {
+	prefix := make([]byte, 4+wire.CommandSize)
+	binary.LittleEndian.PutUint32(prefix, magic)
+	copy(prefix[4:], wire.CmdVersion)
+	return prefix
+}
+
+// randomGarbage returns up to MaxGarbageSize random bytes
+func randomGarbage() ([]byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(MaxGarbageSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := make([]byte, n.Int64())
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+// Initiate performs the v2 handshake on a connection we opened. A v1 peer drops
+// the connection and must be reconnected to with NewV1Conn, which Dial does.
+func Initiate(conn net.Conn, magic uint32) (*Conn, error) {
+	c := NewV1Conn(conn, magic)
+
+	// Our public key must not look like the start of a v1 message
+	var key *PrivateKey
+	var err error
+	for key == nil || bytes.Equal(key.EllSwift[:4], v1Prefix(magic)[:4]) {
+		if key, err = GeneratePrivateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.handshake(key, true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewV1Conn returns a connection speaking v1 framing, for an outbound peer not speaking v2
+func NewV1Conn(conn net.Conn, magic uint32) *Conn {
+	return &Conn{conn: conn, r: bufio.NewReader(conn), magic: magic, max: wire.MaxMessagePayload}
+}
+
+// Dial connects to address and performs the v2 handshake. When the peer drops the
+// connection during the handshake, it is reconnected to with v1 framing, as Bitcoin
+// Core does.
+func Dial(network, address string, magic uint32) (*Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Initiate(conn, magic)
+	if err == nil {
+		return c, nil
+	}
+	conn.Close()
+
+	if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, syscall.ECONNRESET) {
+		return nil, err
+	}
+
+	if conn, err = net.Dial(network, address); err != nil {
+		return nil, err
+	}
+	return NewV1Conn(conn, magic), nil
+}
+
+// Accept performs the handshake on a connection the peer opened, speaking v1 when
+// the peer starts with a v1 version message.
+func Accept(conn net.Conn, magic uint32) (*Conn, error) {
+	c := NewV1Conn(conn, magic)
+
+	prefix := v1Prefix(magic)
+	peek, err := c.r.Peek(len(prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, prefix) {
+		return c, nil
+	}
+
+	key, err := GeneratePrivateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.handshake(key, false); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake exchanges public keys and garbage, then the version packets
+func (c *Conn) handshake(key *PrivateKey, initiating bool) error {
+	garbage, err := randomGarbage()
+	if err != nil {
+		return err
+	}
+
+	// The responder knows the peer speaks v2 and answers at once, the initiator
+	// writes concurrently with reading the answer as pipes do not buffer.
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.conn.Write(append(key.EllSwift[:], garbage...))
+		errs <- err
+	}()
+
+	var theirs [EllSwiftSize]byte
+	if _, err := io.ReadFull(c.r, theirs[:]); err != nil {
+		return err
+	}
+
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	c.cipher = NewCipher(key, theirs, initiating, c.magic)
+
+	// The garbage terminator then the empty version packet, authenticating our garbage
+	out := append([]byte{}, c.cipher.SendGarbageTerminator()...)
+	out = append(out, c.cipher.Encrypt(nil, garbage, false)...)
+
+	go func() {
+		_, err := c.conn.Write(out)
+		errs <- err
+	}()
+
+	theirGarbage, err := c.readGarbage()
+	if err != nil {
+		return err
+	}
+
+	// The contents of the version packet are reserved for future extensions
+	if _, err := c.readPacket(theirGarbage); err != nil {
+		return err
+	}
+
+	return <-errs
+}
+
+// readGarbage returns the garbage of the peer, up to its terminator
+func (c *Conn) readGarbage() ([]byte, error) {
+	terminator := c.cipher.RecvGarbageTerminator()
+	data := make([]byte, 0, GarbageTerminatorSize)
+	for len(data) < MaxGarbageSize+GarbageTerminatorSize {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, b)
+		if n := len(data); n >= GarbageTerminatorSize && bytes.Equal(data[n-GarbageTerminatorSize:], terminator) {
+			return data[:n-GarbageTerminatorSize], nil
+		}
+	}
+
+	return nil, ErrGarbageTooLong
+}
+
+// readPacket returns the contents of the next packet which is not a decoy, aad
+// authenticating the first packet read
+func (c *Conn) readPacket(aad []byte) ([]byte, error) {
+	for {
+		var length [LengthSize]byte
+		if _, err := io.ReadFull(c.r, length[:]); err != nil {
+			return nil, err
+		}
+
+		n := c.cipher.DecryptLength(length[:])
+		if n > 1+wire.CommandSize+c.max {
+			return nil, ErrPacketTooLarge
+		}
+
+		data := make([]byte, int(n)+Expansion-LengthSize)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		contents, ignore, err := c.cipher.Decrypt(data, aad)
+		if err != nil {
+			return nil, err
+		}
+		aad = nil
+
+		if !ignore {
+			return contents, nil
+		}
+	}
+}
+
+// V2 reports whether the connection is encrypted
+func (c *Conn) V2() bool { return c.cipher != nil }
+
+// SessionID identifies an encrypted connection, both ends can compare it out of band
+func (c *Conn) SessionID() Hash {
+	if c.cipher == nil {
+		return HashZero
+	}
+	return c.cipher.SessionID()
+}
+
+// SetMaxMessageSize sets the maximal payload size of received messages
+func (c *Conn) SetMaxMessageSize(max uint32) {
+	c.rmu.Lock()
+	c.max = max
+	c.rmu.Unlock()
+}
+
+// ReadMessage reads and decodes the next message. Messages with unknown short IDs are skipped.
+func (c *Conn) ReadMessage() (wire.Message, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.cipher == nil {
+		return wire.ReadMessageMax(c.r, c.magic, c.max)
+	}
+
+	for {
+		contents, err := c.readPacket(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := decodeContents(contents)
+		switch err.(type) {
+		case nil, *wire.MessageError:
+			return msg, err
+		}
+		if err == ErrUnknownShortID {
+			continue
+		}
+		// The packet was authenticated, the stream is still in sync
+		return nil, &wire.MessageError{Err: err}
+	}
+}
+
+// WriteMessage encodes and writes msg
+func (c *Conn) WriteMessage(msg wire.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.cipher == nil {
+		return wire.WriteMessage(c.conn, c.magic, msg)
+	}
+
+	contents, err := encodeContents(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(c.cipher.Encrypt(contents, nil, false))
+	return err
+}
+
+// WriteDecoy writes a packet of contents the peer ignores, to hide traffic patterns
+func (c *Conn) WriteDecoy(contents []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.cipher == nil {
+		return nil
+	}
+
+	_, err := c.conn.Write(c.cipher.Encrypt(contents, nil, true))
+	return err
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,301 @@
+package v2transport
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/detailyang/go-bcore/wire"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const testMagic = 0xdab5bffa
+
+// newTestConns connects an initiator and a responder over a pipe
+func newTestConns(t *testing.T) (*Conn, *Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := Accept(b, testMagic)
+		accepted <- result{conn, err}
+	}()
+
+	initiator, err := Initiate(a, testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	return initiator, r.conn
+}
+
+// exchange writes msg on a and reads it back on b
+func exchange(t *testing.T, a, b *Conn, msg wire.Message) wire.Message {
+	errs := make(chan error, 1)
+	go func() { errs <- a.WriteMessage(msg) }()
+
+	got, err := b.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestTransport(t *testing.T) {
+	initiator, responder := newTestConns(t)
+
+	if !initiator.V2() || !responder.V2() || initiator.SessionID() != responder.SessionID() {
+		t.Fatal("transport: not a v2 connection")
+	}
+
+	for i := 0; i < RekeyInterval+10; i++ {
+		// A short ID, then a command without one
+		got := exchange(t, initiator, responder, &wire.MsgPing{Nonce: uint64(i)})
+		if m, ok := got.(*wire.MsgPing); !ok || m.Nonce != uint64(i) {
+			t.Fatalf("message %d: unexpected %#v", i, got)
+		}
+
+		got = exchange(t, responder, initiator, &wire.MsgSendHeaders{})
+		if _, ok := got.(*wire.MsgSendHeaders); !ok {
+			t.Fatalf("message %d: unexpected %#v", i, got)
+		}
+	}
+}
+
+func TestTransportDecoys(t *testing.T) {
+	initiator, responder := newTestConns(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		if err := initiator.WriteDecoy([]byte("decoy")); err != nil {
+			errs <- err
+			return
+		}
+
+		// A short ID not assigned yet
+		_, err := initiator.conn.Write(initiator.cipher.Encrypt([]byte{200, 1, 2}, nil, false))
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- initiator.WriteMessage(&wire.MsgPong{Nonce: 7})
+	}()
+
+	got, err := responder.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if m, ok := got.(*wire.MsgPong); !ok || m.Nonce != 7 {
+		t.Fatalf("unexpected %#v", got)
+	}
+}
+
+func TestTransportMalformed(t *testing.T) {
+	initiator, responder := newTestConns(t)
+
+	tx, err := encodeContents(&wire.MsgUnknown{Cmd: wire.CmdTx, Payload: NewBuffer().PutUint32(2).PutVarInt(1 << 62).Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{nil, append([]byte{0}, "ping\x00x\x00\x00\x00\x00\x00\x00"...), tx}
+
+	errs := make(chan error, 1)
+	go func() {
+		for _, contents := range packets {
+			if _, err := initiator.conn.Write(initiator.cipher.Encrypt(contents, nil, false)); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- initiator.WriteMessage(&wire.MsgPong{Nonce: 7})
+	}()
+
+	// Malformed messages fail alone, the stream stays in sync
+	for i := range packets {
+		if _, err := responder.ReadMessage(); err == nil {
+			t.Fatalf("packet %d: decoded", i)
+		} else if _, ok := err.(*wire.MessageError); !ok {
+			t.Fatalf("packet %d: expect a message error, got %v", i, err)
+		}
+	}
+
+	got, err := responder.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := got.(*wire.MsgPong); !ok || m.Nonce != 7 {
+		t.Fatalf("unexpected %#v", got)
+	}
+}
+
+func TestTransportV1Fallback(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	version := &wire.MsgVersion{
+		Version:  wire.ProtocolVersion,
+		AddrRecv: &wire.NetAddress{IP: net.IPv6zero},
+		AddrFrom: &wire.NetAddress{IP: net.IPv6zero},
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- wire.WriteMessage(a, testMagic, version) }()
+
+	responder, err := Accept(b, testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responder.V2() {
+		t.Fatal("transport: v1 peer accepted as v2")
+	}
+
+	got, err := responder.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if m, ok := got.(*wire.MsgVersion); !ok || m.Version != wire.ProtocolVersion {
+		t.Fatalf("unexpected %#v", got)
+	}
+
+	go func() { errs <- responder.WriteMessage(&wire.MsgVerack{}) }()
+	if msg, err := wire.ReadMessage(a, testMagic); err != nil || msg.Command() != wire.CmdVerack {
+		t.Fatalf("unexpected %v %v", msg, err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A v2 peer, then a v1 peer dropping the v2 handshake and reading v1 messages
+	errs := make(chan error, 1)
+	go func() {
+		errs <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			v2, err := Accept(conn, testMagic)
+			if err != nil {
+				return err
+			}
+			if err := v2.WriteMessage(&wire.MsgPing{Nonce: 1}); err != nil {
+				return err
+			}
+
+			conn, err = l.Accept()
+			if err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(conn, make([]byte, wire.MessageHeaderSize)); err != nil {
+				return err
+			}
+			conn.Close()
+
+			if conn, err = l.Accept(); err != nil {
+				return err
+			}
+			defer conn.Close()
+			if _, err := wire.ReadMessage(conn, testMagic); err != nil {
+				return err
+			}
+			return wire.WriteMessage(conn, testMagic, &wire.MsgPing{Nonce: 2})
+		}()
+	}()
+
+	for nonce, v2 := range []bool{true, false} {
+		c, err := Dial("tcp", l.Addr().String(), testMagic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.V2() != v2 {
+			t.Fatalf("dial %d: expect v2 %v", nonce, v2)
+		}
+
+		if !v2 {
+			if err := c.WriteMessage(&wire.MsgVerack{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := msg.(*wire.MsgPing); !ok || m.Nonce != uint64(nonce+1) {
+			t.Fatalf("dial %d: unexpected %#v", nonce, msg)
+		}
+		c.Close()
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContents(t *testing.T) {
+	contents, err := encodeContents(&wire.MsgPing{Nonce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents[0] != 18 || len(contents) != 9 {
+		t.Fatalf("ping: unexpected contents %x", contents)
+	}
+
+	contents, err = encodeContents(&wire.MsgUnknown{Cmd: "sendpackages", Payload: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents[0] != 0 || len(contents) != 1+wire.CommandSize+1 {
+		t.Fatalf("sendpackages: unexpected contents %x", contents)
+	}
+
+	msg, err := decodeContents(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := msg.(*wire.MsgUnknown); !ok || m.Cmd != "sendpackages" {
+		t.Fatalf("unexpected %#v", msg)
+	}
+
+	if _, err := decodeContents([]byte{29}); err != ErrUnknownShortID {
+		t.Fatalf("expect %v, got %v", ErrUnknownShortID, err)
+	}
+	if _, err := decodeContents(nil); err != ErrEmptyContents {
+		t.Fatalf("expect %v, got %v", ErrEmptyContents, err)
+	}
+}
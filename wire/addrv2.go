@@ -0,0 +1,260 @@
+package wire
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/detailyang/go-bcore/internal/sha3"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrAddrV2Size    = errors.New("wire: bad addrv2 address size")
+	ErrAddrV2Host    = errors.New("wire: bad address host")
+	ErrAddrV2Network = errors.New("wire: address network not convertible")
+)
+
+// Network IDs of addrv2 addresses, BIP155
+const (
+	NetIPv4 uint8 = 1
+	NetIPv6 uint8 = 2
+	// Tor v2 addresses are no longer supported by the Tor network
+	NetTorV2 uint8 = 3
+	NetTorV3 uint8 = 4
+	NetI2P   uint8 = 5
+	NetCJDNS uint8 = 6
+)
+
+const (
+	// MaxAddrV2Size is the maximal size of an addrv2 address, of any network
+	MaxAddrV2Size = 512
+)
+
+// netAddressSizes are the address sizes of the known networks
+var netAddressSizes = map[uint8]int{
+	NetIPv4:  net.IPv4len,
+	NetIPv6:  net.IPv6len,
+	NetTorV2: 10,
+	NetTorV3: 32,
+	NetI2P:   32,
+	NetCJDNS: net.IPv6len,
+}
+
+// base32Encoding encodes Tor and I2P addresses
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+const (
+	torV3Version = 3
+	onionSuffix  = ".onion"
+	i2pSuffix    = ".b32.i2p"
+)
+
+// torV3Checksum returns the checksum of a Tor v3 .onion address, of its ed25519 public key
+func torV3Checksum(pubkey []byte) []byte {
+	h := sha3.Sum256(append(append([]byte(".onion checksum"), pubkey...), torV3Version))
+	return h[:2]
+}
+
+// NetAddressV2 is a peer address of the addrv2 message, of any network
+type NetAddressV2 struct {
+	Timestamp uint32
+	Services  uint64
+	Network   uint8
+	Addr      []byte
+	Port      uint16
+}
+
+// NewNetAddressV2 returns the address of host, an IP, a Tor v3 .onion or an I2P .b32.i2p host
+func NewNetAddressV2(host string, port uint16, services uint64) (*NetAddressV2, error) {
+	na := &NetAddressV2{Services: services, Port: port}
+	lower := strings.ToLower(host)
+
+	switch {
+	case strings.HasSuffix(lower, onionSuffix):
+		data, err := base32Encoding.DecodeString(strings.ToUpper(strings.TrimSuffix(lower, onionSuffix)))
+		if err != nil || len(data) != 32+2+1 || data[34] != torV3Version || string(torV3Checksum(data[:32])) != string(data[32:34]) {
+			return nil, ErrAddrV2Host
+		}
+		na.Network, na.Addr = NetTorV3, data[:32]
+	case strings.HasSuffix(lower, i2pSuffix):
+		data, err := base32Encoding.DecodeString(strings.ToUpper(strings.TrimSuffix(lower, i2pSuffix)))
+		if err != nil || len(data) != 32 {
+			return nil, ErrAddrV2Host
+		}
+		na.Network, na.Addr = NetI2P, data
+	default:
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, ErrAddrV2Host
+		}
+		na.setIP(ip)
+	}
+
+	return na, nil
+}
+
+// NewNetAddressV2FromV1 returns the addrv2 form of an addr message address
+func NewNetAddressV2FromV1(na *NetAddress) *NetAddressV2 {
+	v2 := &NetAddressV2{Timestamp: na.Timestamp, Services: na.Services, Port: na.Port}
+	v2.setIP(na.IP)
+	return v2
+}
+
+func (na *NetAddressV2) setIP(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		na.Network, na.Addr = NetIPv4, append([]byte{}, ip4...)
+		return
+	}
+
+	ip16 := ip.To16()
+	if ip16 == nil {
+		ip16 = net.IPv6zero
+	}
+	na.Network, na.Addr = NetIPv6, append([]byte{}, ip16...)
+}
+
+// Supported reports whether the network is known and the address of its size. Tor v2
+// addresses are not supported.
+func (na *NetAddressV2) Supported() bool {
+	size, ok := netAddressSizes[na.Network]
+	return ok && na.Network != NetTorV2 && len(na.Addr) == size
+}
+
+// IP returns the IP of IPv4, IPv6 and CJDNS addresses, nil for other networks
+func (na *NetAddressV2) IP() net.IP {
+	switch na.Network {
+	case NetIPv4, NetIPv6, NetCJDNS:
+		return net.IP(na.Addr)
+	}
+	return nil
+}
+
+// ToV1 returns the addr message form of IPv4 and IPv6 addresses
+func (na *NetAddressV2) ToV1() (*NetAddress, error) {
+	if (na.Network != NetIPv4 && na.Network != NetIPv6) || !na.Supported() {
+		return nil, ErrAddrV2Network
+	}
+
+	return &NetAddress{Timestamp: na.Timestamp, Services: na.Services, IP: na.IP().To16(), Port: na.Port}, nil
+}
+
+// Host returns the IP or the .onion and .b32.i2p name of the address
+func (na *NetAddressV2) Host() string {
+	if !na.Supported() {
+		return "unknown network " + strconv.Itoa(int(na.Network))
+	}
+
+	switch na.Network {
+	case NetTorV3:
+		data := append(append(append([]byte{}, na.Addr...), torV3Checksum(na.Addr)...), torV3Version)
+		return strings.ToLower(base32Encoding.EncodeToString(data)) + onionSuffix
+	case NetI2P:
+		return strings.ToLower(base32Encoding.EncodeToString(na.Addr)) + i2pSuffix
+	}
+
+	return na.IP().String()
+}
+
+func (na *NetAddressV2) String() string {
+	return net.JoinHostPort(na.Host(), strconv.Itoa(int(na.Port)))
+}
+
+// Key identifies the address, its network, address and port, regardless of its services and time
+func (na *NetAddressV2) Key() string {
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, na.Port)
+	return string(append(append([]byte{na.Network}, na.Addr...), port...))
+}
+
+func (na *NetAddressV2) putBuffer(buffer *Buffer) {
+	buffer.PutUint32(na.Timestamp).
+		PutVarInt(na.Services).
+		PutUint8(na.Network).
+		PutVarBytes(na.Addr)
+
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, na.Port)
+	buffer.PutBytes(port)
+}
+
+func (na *NetAddressV2) Bytes() []byte {
+	buffer := NewBuffer()
+	na.putBuffer(buffer)
+	return buffer.Bytes()
+}
+
+// NewNetAddressV2FromBuffer decodes an address with its timestamp. Addresses of unknown
+// networks are decoded, those of known networks must have their size.
+func NewNetAddressV2FromBuffer(buffer *Buffer) (*NetAddressV2, error) {
+	na := &NetAddressV2{}
+
+	var err error
+	if na.Timestamp, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	if na.Services, err = buffer.GetVarInt(); err != nil {
+		return nil, err
+	}
+
+	if na.Network, err = buffer.GetUint8(); err != nil {
+		return nil, err
+	}
+
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if size, ok := netAddressSizes[na.Network]; n > MaxAddrV2Size || (ok && n != uint64(size)) {
+		return nil, ErrAddrV2Size
+	}
+
+	addr, err := buffer.GetBytes(int(n))
+	if err != nil {
+		return nil, err
+	}
+	na.Addr = append([]byte{}, addr...)
+
+	port, err := buffer.GetBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	na.Port = binary.BigEndian.Uint16(port)
+
+	return na, nil
+}
+
+// MsgAddrV2 relays known peer addresses of any network, BIP155
+type MsgAddrV2 struct {
+	Addresses []*NetAddressV2
+}
+
+func (m *MsgAddrV2) Command() string { return CmdAddrV2 }
+
+func (m *MsgAddrV2) Bytes() []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(m.Addresses)))
+	for _, na := range m.Addresses {
+		na.putBuffer(buffer)
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgAddrV2(buffer *Buffer) (Message, error) {
+	n, err := getCount(buffer, MaxAddrToSend)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgAddrV2{Addresses: make([]*NetAddressV2, n)}
+	for i := range m.Addresses {
+		if m.Addresses[i], err = NewNetAddressV2FromBuffer(buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,117 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+func TestNetAddressV2(t *testing.T) {
+	tests := []struct {
+		host    string
+		network uint8
+		size    int
+	}{
+		{"1.2.3.4", NetIPv4, 4},
+		{"::ffff:1.2.3.4", NetIPv4, 4},
+		{"2001:db8::1", NetIPv6, 16},
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", NetTorV3, 32},
+		{"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p", NetI2P, 32},
+	}
+
+	for _, test := range tests {
+		na, err := NewNetAddressV2(test.host, 8333, SFNodeNetwork)
+		if err != nil {
+			t.Fatalf("%s: %v", test.host, err)
+		}
+
+		if na.Network != test.network || len(na.Addr) != test.size || !na.Supported() {
+			t.Fatalf("%s: unexpected network %d of %d bytes", test.host, na.Network, len(na.Addr))
+		}
+
+		// Hosts are printed back, IPv4-mapped addresses as IPv4
+		expect := test.host
+		if ip := net.ParseIP(expect); ip != nil {
+			expect = ip.String()
+		}
+		if na.Host() != expect || na.String() != net.JoinHostPort(expect, "8333") {
+			t.Fatalf("%s: unexpected host %s", test.host, na.String())
+		}
+	}
+
+	for _, host := range []string{
+		"example.com",
+		// Bad checksum
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryc.onion",
+		// Tor v2
+		"6hzph5hv6337r6p2.onion",
+		"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnk.b32.i2p",
+	} {
+		if _, err := NewNetAddressV2(host, 8333, 0); err != ErrAddrV2Host {
+			t.Fatalf("%s: expect %v, got %v", host, ErrAddrV2Host, err)
+		}
+	}
+}
+
+func TestNetAddressV2Encoding(t *testing.T) {
+	na := &NetAddressV2{Timestamp: 0x61bc6649, Services: SFNodeNetwork | SFNodeWitness, Network: NetIPv4, Addr: []byte{1, 2, 3, 4}, Port: 8333}
+
+	// Services are a CompactSize, the port is big endian
+	expect := "4966bc61" + "09" + "01" + "04" + "01020304" + "208d"
+	if hex.EncodeToString(na.Bytes()) != expect {
+		t.Fatalf("expect %s, got %x", expect, na.Bytes())
+	}
+
+	decoded, err := NewNetAddressV2FromBuffer(NewReadBuffer(na.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Key() != na.Key() || decoded.Services != na.Services || decoded.Timestamp != na.Timestamp {
+		t.Fatalf("unexpected %+v", decoded)
+	}
+
+	// Known networks must have their size, unknown ones any size up to MaxAddrV2Size
+	bad := NewBuffer().PutUint32(0).PutVarInt(0).PutUint8(NetIPv6).PutVarBytes([]byte{1, 2, 3, 4}).PutBytes([]byte{0, 0})
+	if _, err := NewNetAddressV2FromBuffer(NewReadBuffer(bad.Bytes())); err != ErrAddrV2Size {
+		t.Fatalf("expect %v, got %v", ErrAddrV2Size, err)
+	}
+
+	large := NewBuffer().PutUint32(0).PutVarInt(0).PutUint8(42).PutVarBytes(make([]byte, MaxAddrV2Size+1)).PutBytes([]byte{0, 0})
+	if _, err := NewNetAddressV2FromBuffer(NewReadBuffer(large.Bytes())); err != ErrAddrV2Size {
+		t.Fatalf("expect %v, got %v", ErrAddrV2Size, err)
+	}
+
+	unknown := NewBuffer().PutUint32(0).PutVarInt(0).PutUint8(42).PutVarBytes([]byte{1}).PutBytes([]byte{0, 0})
+	decoded, err = NewNetAddressV2FromBuffer(NewReadBuffer(unknown.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Supported() {
+		t.Fatal("unknown network supported")
+	}
+}
+
+func TestNetAddressV2V1(t *testing.T) {
+	v1 := &NetAddress{Timestamp: 7, Services: SFNodeNetwork, IP: net.ParseIP("1.2.3.4").To16(), Port: 8333}
+
+	na := NewNetAddressV2FromV1(v1)
+	if na.Network != NetIPv4 || !bytes.Equal(na.Addr, []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected %+v", na)
+	}
+
+	back, err := na.ToV1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.IP.Equal(v1.IP) || back.Port != v1.Port || back.Timestamp != v1.Timestamp || back.Services != v1.Services {
+		t.Fatalf("unexpected %+v", back)
+	}
+
+	tor := &NetAddressV2{Network: NetTorV3, Addr: make([]byte, 32)}
+	if _, err := tor.ToV1(); err != ErrAddrV2Network {
+		t.Fatalf("expect %v, got %v", ErrAddrV2Network, err)
+	}
+}
@@ -0,0 +1,155 @@
+package wire
+
+import (
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// MaxLocatorSize is the maximal number of hashes of a block locator
+	MaxLocatorSize = 101
+	// MaxHeadersResults is the maximal number of headers of a headers message
+	MaxHeadersResults = 2000
+	// MaxBlocksResults is the maximal number of blocks announced in answer to getblocks
+	MaxBlocksResults = 500
+)
+
+// BlockLocator is the payload of getheaders and getblocks: hashes of the requester
+// chain from its tip backwards, and the hash to stop at, zero for as many as possible.
+type BlockLocator struct {
+	Version  uint32
+	Hashes   []Hash
+	HashStop Hash
+}
+
+func (l *BlockLocator) Bytes() []byte {
+	buffer := NewBuffer().PutUint32(l.Version).PutVarInt(uint64(len(l.Hashes)))
+	for _, hash := range l.Hashes {
+		buffer.PutHash(hash)
+	}
+	return buffer.PutHash(l.HashStop).Bytes()
+}
+
+func newBlockLocatorFromBuffer(buffer *Buffer) (*BlockLocator, error) {
+	l := &BlockLocator{}
+
+	var err error
+	if l.Version, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+
+	n, err := getCount(buffer, MaxLocatorSize)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Hashes = make([]Hash, n)
+	for i := range l.Hashes {
+		if l.Hashes[i], err = buffer.GetHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.HashStop, err = buffer.GetHash(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// MsgGetHeaders requests the headers following the locator
+type MsgGetHeaders struct {
+	BlockLocator
+}
+
+func (m *MsgGetHeaders) Command() string { return CmdGetHeaders }
+
+func decodeMsgGetHeaders(buffer *Buffer) (Message, error) {
+	l, err := newBlockLocatorFromBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgGetHeaders{BlockLocator: *l}, nil
+}
+
+// MsgGetBlocks requests an inv of the blocks following the locator
+type MsgGetBlocks struct {
+	BlockLocator
+}
+
+func (m *MsgGetBlocks) Command() string { return CmdGetBlocks }
+
+func decodeMsgGetBlocks(buffer *Buffer) (Message, error) {
+	l, err := newBlockLocatorFromBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgGetBlocks{BlockLocator: *l}, nil
+}
+
+// MsgHeaders carries block headers, each followed by a zero transaction count
+type MsgHeaders struct {
+	Headers []*bcore.BlockHeader
+}
+
+func (m *MsgHeaders) Command() string { return CmdHeaders }
+
+func (m *MsgHeaders) Bytes() []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(m.Headers)))
+	for _, h := range m.Headers {
+		buffer.PutBytes(h.Bytes()).PutVarInt(0)
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgHeaders(buffer *Buffer) (Message, error) {
+	n, err := getCount(buffer, MaxHeadersResults)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgHeaders{Headers: make([]*bcore.BlockHeader, n)}
+	for i := range m.Headers {
+		if m.Headers[i], err = bcore.NewBlockHeaderFromBuffer(buffer); err != nil {
+			return nil, err
+		}
+
+		if _, err := buffer.GetVarInt(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// MsgBlock carries a block with its witnesses
+type MsgBlock struct {
+	Block *bcore.Block
+}
+
+func (m *MsgBlock) Command() string { return CmdBlock }
+func (m *MsgBlock) Bytes() []byte   { return m.Block.Bytes() }
+
+func decodeMsgBlock(buffer *Buffer) (Message, error) {
+	block, err := bcore.NewBlockFromBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgBlock{Block: block}, nil
+}
+
+// MsgTx carries a transaction with its witnesses
+type MsgTx struct {
+	Tx *bcore.Transaction
+}
+
+func (m *MsgTx) Command() string { return CmdTx }
+func (m *MsgTx) Bytes() []byte   { return m.Tx.BytesWithWitness() }
+
+func decodeMsgTx(buffer *Buffer) (Message, error) {
+	tx, err := bcore.NewTransactionFromBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgTx{Tx: tx}, nil
+}
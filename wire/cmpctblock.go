@@ -0,0 +1,251 @@
+package wire
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	bcore "github.com/detailyang/go-bcore"
+	"github.com/detailyang/go-bcore/internal/siphash"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrCmpctIndexOverflow = errors.New("wire: compact block index overflow")
+)
+
+const (
+	// ShortIDSize is the size of the short transaction IDs of compact blocks
+	ShortIDSize = 6
+	// MaxCmpctBlockTxs is the maximal number of transactions of a block, by weight
+	MaxCmpctBlockTxs = 100000
+	// maxCmpctIndex is the largest transaction index of compact block messages
+	maxCmpctIndex = 0xffff
+)
+
+// PrefilledTx is a transaction sent in full in a compact block, Index being its
+// position in the block. Indexes are differentially encoded on the wire.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *bcore.Transaction
+}
+
+// MsgCmpctBlock announces a block with short IDs of the wtxids of its transactions,
+// BIP152 version 2. Transactions the receiver is unlikely to have are prefilled.
+type MsgCmpctBlock struct {
+	Header    *bcore.BlockHeader
+	Nonce     uint64
+	ShortIDs  []uint64
+	Prefilled []*PrefilledTx
+}
+
+// NewMsgCmpctBlock returns the compact block of block with the coinbase and the
+// transactions at the sorted indexes of prefill sent in full.
+func NewMsgCmpctBlock(block *bcore.Block, nonce uint64, prefill ...int) *MsgCmpctBlock {
+	m := &MsgCmpctBlock{Header: block.Header, Nonce: nonce}
+
+	prefilled := map[int]bool{0: true}
+	for _, i := range prefill {
+		prefilled[i] = true
+	}
+
+	for i, tx := range block.Transactions {
+		if prefilled[i] {
+			m.Prefilled = append(m.Prefilled, &PrefilledTx{Index: uint32(i), Tx: tx})
+			continue
+		}
+		m.ShortIDs = append(m.ShortIDs, m.ShortID(tx.WitnessHash()))
+	}
+
+	return m
+}
+
+func (m *MsgCmpctBlock) Command() string { return CmdCmpctBlock }
+
+// ShortIDKeys returns the SipHash key of the short IDs: the first 16 bytes of the
+// SHA256 of the header and the nonce.
+func (m *MsgCmpctBlock) ShortIDKeys() (uint64, uint64) {
+	h := sha256.Sum256(NewBuffer().PutBytes(m.Header.Bytes()).PutUint64(m.Nonce).Bytes())
+	return binary.LittleEndian.Uint64(h[0:8]), binary.LittleEndian.Uint64(h[8:16])
+}
+
+// ShortID returns the short ID of a wtxid in this block: its SipHash-2-4 keyed by
+// ShortIDKeys, truncated to 6 bytes.
+func (m *MsgCmpctBlock) ShortID(wtxid Hash) uint64 {
+	k0, k1 := m.ShortIDKeys()
+	return shortID(k0, k1, wtxid)
+}
+
+func shortID(k0, k1 uint64, wtxid Hash) uint64 {
+	return siphash.Sum64(k0, k1, wtxid[:]) & (1<<(8*ShortIDSize) - 1)
+}
+
+// TxCount returns the number of transactions of the block
+func (m *MsgCmpctBlock) TxCount() int {
+	return len(m.ShortIDs) + len(m.Prefilled)
+}
+
+func (m *MsgCmpctBlock) Bytes() []byte {
+	buffer := NewBuffer().
+		PutBytes(m.Header.Bytes()).
+		PutUint64(m.Nonce).
+		PutVarInt(uint64(len(m.ShortIDs)))
+
+	id := make([]byte, 8)
+	for _, shortID := range m.ShortIDs {
+		binary.LittleEndian.PutUint64(id, shortID)
+		buffer.PutBytes(id[:ShortIDSize])
+	}
+
+	buffer.PutVarInt(uint64(len(m.Prefilled)))
+	next := uint32(0)
+	for _, p := range m.Prefilled {
+		buffer.PutVarInt(uint64(p.Index - next)).PutBytes(p.Tx.BytesWithWitness())
+		next = p.Index + 1
+	}
+
+	return buffer.Bytes()
+}
+
+// getDiffIndex reads a differentially encoded index following the index before next
+func getDiffIndex(buffer *Buffer, next uint32) (uint32, error) {
+	diff, err := buffer.GetVarInt()
+	if err != nil {
+		return 0, err
+	}
+
+	if diff > maxCmpctIndex || uint64(next)+diff > maxCmpctIndex {
+		return 0, ErrCmpctIndexOverflow
+	}
+
+	return next + uint32(diff), nil
+}
+
+func decodeMsgCmpctBlock(buffer *Buffer) (Message, error) {
+	header, err := bcore.NewBlockHeaderFromBuffer(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgCmpctBlock{Header: header}
+	if m.Nonce, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	n, err := getCount(buffer, MaxCmpctBlockTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	m.ShortIDs = make([]uint64, n)
+	id := make([]byte, 8)
+	for i := range m.ShortIDs {
+		data, err := buffer.GetBytes(ShortIDSize)
+		if err != nil {
+			return nil, err
+		}
+		copy(id, data)
+		m.ShortIDs[i] = binary.LittleEndian.Uint64(id)
+	}
+
+	if n, err = getCount(buffer, MaxCmpctBlockTxs); err != nil {
+		return nil, err
+	}
+
+	m.Prefilled = make([]*PrefilledTx, n)
+	next := uint32(0)
+	for i := range m.Prefilled {
+		p := &PrefilledTx{}
+		if p.Index, err = getDiffIndex(buffer, next); err != nil {
+			return nil, err
+		}
+
+		if p.Tx, err = bcore.NewTransactionFromBuffer(buffer); err != nil {
+			return nil, err
+		}
+
+		m.Prefilled[i] = p
+		next = p.Index + 1
+	}
+
+	return m, nil
+}
+
+// MsgGetBlockTxn requests the transactions of a compact block at Indexes, which
+// are sorted and differentially encoded on the wire.
+type MsgGetBlockTxn struct {
+	BlockHash Hash
+	Indexes   []uint32
+}
+
+func (m *MsgGetBlockTxn) Command() string { return CmdGetBlockTxn }
+
+func (m *MsgGetBlockTxn) Bytes() []byte {
+	buffer := NewBuffer().PutHash(m.BlockHash).PutVarInt(uint64(len(m.Indexes)))
+	next := uint32(0)
+	for _, index := range m.Indexes {
+		buffer.PutVarInt(uint64(index - next))
+		next = index + 1
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgGetBlockTxn(buffer *Buffer) (Message, error) {
+	hash, err := buffer.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := getCount(buffer, MaxCmpctBlockTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgGetBlockTxn{BlockHash: hash, Indexes: make([]uint32, n)}
+	next := uint32(0)
+	for i := range m.Indexes {
+		if m.Indexes[i], err = getDiffIndex(buffer, next); err != nil {
+			return nil, err
+		}
+		next = m.Indexes[i] + 1
+	}
+
+	return m, nil
+}
+
+// MsgBlockTxn answers getblocktxn with the requested transactions in order
+type MsgBlockTxn struct {
+	BlockHash Hash
+	Txs       []*bcore.Transaction
+}
+
+func (m *MsgBlockTxn) Command() string { return CmdBlockTxn }
+
+func (m *MsgBlockTxn) Bytes() []byte {
+	buffer := NewBuffer().PutHash(m.BlockHash).PutVarInt(uint64(len(m.Txs)))
+	for _, tx := range m.Txs {
+		buffer.PutBytes(tx.BytesWithWitness())
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgBlockTxn(buffer *Buffer) (Message, error) {
+	hash, err := buffer.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := getCount(buffer, MaxCmpctBlockTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgBlockTxn{BlockHash: hash, Txs: make([]*bcore.Transaction, n)}
+	for i := range m.Txs {
+		if m.Txs[i], err = bcore.NewTransactionFromBuffer(buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
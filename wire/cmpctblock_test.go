@@ -0,0 +1,153 @@
+package wire
+
+import (
+	"reflect"
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// newTestCmpctBlock returns a block of a coinbase and n transactions
+func newTestCmpctBlock(n int) *bcore.Block {
+	coinbase := bcore.NewCoinbaseTransaction(100, nil, []*bcore.TransactionOutput{{Value: 5000000000, ScriptPubkey: []byte{0x51}}})
+	txs := []*bcore.Transaction{coinbase}
+	for i := 0; i < n; i++ {
+		tx := newTestTransaction()
+		tx.Outputs[0].Value = uint64(1000 + i)
+		txs = append(txs, tx)
+	}
+
+	block := bcore.NewBlock(&bcore.BlockHeader{
+		Version:  4,
+		PrevHash: Hash{1},
+		Time:     1600000000,
+		Bits:     NewCompact(0x207fffff),
+	}, txs)
+	block.Header.MerkleRoot = block.MerkleRoot()
+	return block
+}
+
+func TestCmpctBlockEncoding(t *testing.T) {
+	block := newTestCmpctBlock(5)
+	m := NewMsgCmpctBlock(block, 0x0102030405060708, 3)
+
+	if m.TxCount() != 6 || len(m.ShortIDs) != 4 || len(m.Prefilled) != 2 || m.Prefilled[0].Index != 0 || m.Prefilled[1].Index != 3 {
+		t.Fatalf("unexpected compact block %d short ids, %d prefilled", len(m.ShortIDs), len(m.Prefilled))
+	}
+
+	for _, id := range m.ShortIDs {
+		if id>>(8*ShortIDSize) != 0 {
+			t.Fatalf("short id %x wider than 6 bytes", id)
+		}
+	}
+
+	if m.ShortIDs[2] != m.ShortID(block.Transactions[4].WitnessHash()) {
+		t.Fatal("short ids skip prefilled transactions")
+	}
+
+	other := NewMsgCmpctBlock(block, 0x0102030405060709)
+	if other.ShortID(block.Transactions[1].WitnessHash()) == m.ShortID(block.Transactions[1].WitnessHash()) {
+		t.Fatal("short ids do not depend on the nonce")
+	}
+
+	decoded, err := NewMessageFromBytes(CmdCmpctBlock, m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := decoded.(*MsgCmpctBlock)
+	if d.Nonce != m.Nonce || !reflect.DeepEqual(d.ShortIDs, m.ShortIDs) || d.Prefilled[1].Index != 3 || d.Prefilled[1].Tx.WitnessHash() != block.Transactions[3].WitnessHash() {
+		t.Fatal("cmpctblock: roundtrip mismatch")
+	}
+
+	// Differential index of 0xffff after index 0
+	overflow := NewBuffer().PutHash(Hash{}).PutVarInt(2).PutVarInt(0).PutVarInt(0xffff).Bytes()
+	if _, err := NewMessageFromBytes(CmdGetBlockTxn, overflow); err != ErrCmpctIndexOverflow {
+		t.Fatalf("expect %v, got %v", ErrCmpctIndexOverflow, err)
+	}
+}
+
+func TestPartialBlock(t *testing.T) {
+	block := newTestCmpctBlock(6)
+	m := NewMsgCmpctBlock(block, 42)
+
+	// The mempool misses transactions 2 and 5 and holds unrelated ones
+	unrelated := newTestTransaction()
+	unrelated.Locktime = 7
+	pool := []*bcore.Transaction{unrelated, block.Transactions[1], block.Transactions[3], block.Transactions[4], block.Transactions[6]}
+
+	b, err := NewPartialBlock(m, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if missing := b.Missing(); !reflect.DeepEqual(missing, []uint32{2, 5}) {
+		t.Fatalf("expect missing [2 5], got %v", missing)
+	}
+
+	if _, err := b.Block(); err != ErrCmpctIncomplete {
+		t.Fatalf("expect %v, got %v", ErrCmpctIncomplete, err)
+	}
+
+	req := b.GetBlockTxn()
+	if req.BlockHash != block.Hash() {
+		t.Fatal("getblocktxn: unexpected block hash")
+	}
+
+	if _, err := b.Fill(&MsgBlockTxn{BlockHash: block.Hash(), Txs: []*bcore.Transaction{block.Transactions[2]}}); err != ErrCmpctWrongBlockTxn {
+		t.Fatalf("expect %v, got %v", ErrCmpctWrongBlockTxn, err)
+	}
+
+	full, err := b.Fill(&MsgBlockTxn{BlockHash: block.Hash(), Txs: []*bcore.Transaction{block.Transactions[2], block.Transactions[5]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if full.Hash() != block.Hash() || len(full.Transactions) != len(block.Transactions) {
+		t.Fatal("reconstructed block mismatch")
+	}
+	for i, tx := range full.Transactions {
+		if tx.WitnessHash() != block.Transactions[i].WitnessHash() {
+			t.Fatalf("transaction %d mismatch", i)
+		}
+	}
+
+	// Everything is in the mempool
+	b, err = NewPartialBlock(m, block.Transactions[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Block(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartialBlockErrors(t *testing.T) {
+	block := newTestCmpctBlock(3)
+	m := NewMsgCmpctBlock(block, 42)
+
+	if _, err := NewPartialBlock(&MsgCmpctBlock{Header: block.Header}, nil); err != ErrCmpctInvalid {
+		t.Fatalf("empty: expect %v, got %v", ErrCmpctInvalid, err)
+	}
+
+	bad := *m
+	bad.Prefilled = []*PrefilledTx{{Index: 4, Tx: block.Transactions[0]}}
+	if _, err := NewPartialBlock(&bad, nil); err != ErrCmpctInvalid {
+		t.Fatalf("prefilled index: expect %v, got %v", ErrCmpctInvalid, err)
+	}
+
+	dup := *m
+	dup.ShortIDs = []uint64{m.ShortIDs[0], m.ShortIDs[0], m.ShortIDs[2]}
+	if _, err := NewPartialBlock(&dup, nil); err != ErrCmpctShortIDCollision {
+		t.Fatalf("expect %v, got %v", ErrCmpctShortIDCollision, err)
+	}
+
+	// A wrong transaction for a short ID gives a merkle root mismatch
+	b, err := NewPartialBlock(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Fill(&MsgBlockTxn{BlockHash: block.Hash(), Txs: []*bcore.Transaction{block.Transactions[1], block.Transactions[3], block.Transactions[2]}}); err != ErrCmpctMerkleMismatch {
+		t.Fatalf("expect %v, got %v", ErrCmpctMerkleMismatch, err)
+	}
+}
@@ -0,0 +1,107 @@
+package wire
+
+import (
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// MsgPing checks that a connection is alive, the peer echoes the nonce in a MsgPong
+type MsgPing struct {
+	Nonce uint64
+}
+
+func (m *MsgPing) Command() string { return CmdPing }
+func (m *MsgPing) Bytes() []byte   { return NewBuffer().PutUint64(m.Nonce).Bytes() }
+
+func decodeMsgPing(buffer *Buffer) (Message, error) {
+	nonce, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+	return &MsgPing{Nonce: nonce}, nil
+}
+
+type MsgPong struct {
+	Nonce uint64
+}
+
+func (m *MsgPong) Command() string { return CmdPong }
+func (m *MsgPong) Bytes() []byte   { return NewBuffer().PutUint64(m.Nonce).Bytes() }
+
+func decodeMsgPong(buffer *Buffer) (Message, error) {
+	nonce, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+	return &MsgPong{Nonce: nonce}, nil
+}
+
+// MsgSendHeaders asks for new blocks to be announced with headers instead of inv, BIP130
+type MsgSendHeaders struct{}
+
+func (m *MsgSendHeaders) Command() string { return CmdSendHeaders }
+func (m *MsgSendHeaders) Bytes() []byte   { return nil }
+
+func decodeMsgSendHeaders(*Buffer) (Message, error) { return &MsgSendHeaders{}, nil }
+
+// MsgFeeFilter asks not to announce transactions below a fee rate in sat/kvB, BIP133
+type MsgFeeFilter struct {
+	FeeRate int64
+}
+
+func (m *MsgFeeFilter) Command() string { return CmdFeeFilter }
+func (m *MsgFeeFilter) Bytes() []byte   { return NewBuffer().PutUint64(uint64(m.FeeRate)).Bytes() }
+
+func decodeMsgFeeFilter(buffer *Buffer) (Message, error) {
+	feerate, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+	return &MsgFeeFilter{FeeRate: int64(feerate)}, nil
+}
+
+// MsgSendCmpct negotiates compact block relay, BIP152
+type MsgSendCmpct struct {
+	// Whether new blocks should be announced with cmpctblock messages
+	Announce bool
+	Version  uint64
+}
+
+func (m *MsgSendCmpct) Command() string { return CmdSendCmpct }
+
+func (m *MsgSendCmpct) Bytes() []byte {
+	announce := uint8(0)
+	if m.Announce {
+		announce = 1
+	}
+	return NewBuffer().PutUint8(announce).PutUint64(m.Version).Bytes()
+}
+
+func decodeMsgSendCmpct(buffer *Buffer) (Message, error) {
+	announce, err := buffer.GetUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MsgSendCmpct{Announce: announce != 0, Version: version}, nil
+}
+
+// MsgWtxidRelay announces transactions by wtxid, sent between version and verack, BIP339
+type MsgWtxidRelay struct{}
+
+func (m *MsgWtxidRelay) Command() string { return CmdWtxidRelay }
+func (m *MsgWtxidRelay) Bytes() []byte   { return nil }
+
+func decodeMsgWtxidRelay(*Buffer) (Message, error) { return &MsgWtxidRelay{}, nil }
+
+// MsgSendAddrV2 asks for addresses to be relayed with addrv2, sent between version and verack, BIP155
+type MsgSendAddrV2 struct{}
+
+func (m *MsgSendAddrV2) Command() string { return CmdSendAddrV2 }
+func (m *MsgSendAddrV2) Bytes() []byte   { return nil }
+
+func decodeMsgSendAddrV2(*Buffer) (Message, error) { return &MsgSendAddrV2{}, nil }
@@ -0,0 +1,138 @@
+package wire
+
+import (
+	"fmt"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// InvType is the type of object an inventory vector refers to
+type InvType uint32
+
+const (
+	InvTypeError         InvType = 0
+	InvTypeTx            InvType = 1
+	InvTypeBlock         InvType = 2
+	InvTypeFilteredBlock InvType = 3
+	InvTypeCmpctBlock    InvType = 4
+	InvTypeWTx           InvType = 5
+
+	// InvWitnessFlag requests the witness serialization in getdata, BIP144
+	InvWitnessFlag InvType = 1 << 30
+
+	InvTypeWitnessTx    = InvTypeTx | InvWitnessFlag
+	InvTypeWitnessBlock = InvTypeBlock | InvWitnessFlag
+)
+
+const (
+	// MaxInvSize is the maximal number of entries of inv, getdata and notfound messages
+	MaxInvSize = 50000
+)
+
+var invTypeNames = map[InvType]string{
+	InvTypeError:         "error",
+	InvTypeTx:            "tx",
+	InvTypeBlock:         "block",
+	InvTypeFilteredBlock: "filtered_block",
+	InvTypeCmpctBlock:    "cmpct_block",
+	InvTypeWTx:           "wtx",
+	InvTypeWitnessTx:     "witness_tx",
+	InvTypeWitnessBlock:  "witness_block",
+}
+
+func (t InvType) String() string {
+	if name, ok := invTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(t))
+}
+
+// InvVect identifies a transaction or a block
+type InvVect struct {
+	Type InvType
+	Hash Hash
+}
+
+func NewInvVect(t InvType, hash Hash) *InvVect {
+	return &InvVect{Type: t, Hash: hash}
+}
+
+func putInventory(inv []*InvVect) []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(inv)))
+	for _, iv := range inv {
+		buffer.PutUint32(uint32(iv.Type)).PutHash(iv.Hash)
+	}
+	return buffer.Bytes()
+}
+
+func getInventory(buffer *Buffer) ([]*InvVect, error) {
+	n, err := getCount(buffer, MaxInvSize)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := make([]*InvVect, n)
+	for i := range inv {
+		t, err := buffer.GetUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := buffer.GetHash()
+		if err != nil {
+			return nil, err
+		}
+
+		inv[i] = NewInvVect(InvType(t), hash)
+	}
+
+	return inv, nil
+}
+
+// MsgInv announces transactions or blocks
+type MsgInv struct {
+	Inventory []*InvVect
+}
+
+func (m *MsgInv) Command() string { return CmdInv }
+func (m *MsgInv) Bytes() []byte   { return putInventory(m.Inventory) }
+
+func decodeMsgInv(buffer *Buffer) (Message, error) {
+	inv, err := getInventory(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgInv{Inventory: inv}, nil
+}
+
+// MsgGetData requests announced transactions or blocks
+type MsgGetData struct {
+	Inventory []*InvVect
+}
+
+func (m *MsgGetData) Command() string { return CmdGetData }
+func (m *MsgGetData) Bytes() []byte   { return putInventory(m.Inventory) }
+
+func decodeMsgGetData(buffer *Buffer) (Message, error) {
+	inv, err := getInventory(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgGetData{Inventory: inv}, nil
+}
+
+// MsgNotFound answers the getdata entries that cannot be served
+type MsgNotFound struct {
+	Inventory []*InvVect
+}
+
+func (m *MsgNotFound) Command() string { return CmdNotFound }
+func (m *MsgNotFound) Bytes() []byte   { return putInventory(m.Inventory) }
+
+func decodeMsgNotFound(buffer *Buffer) (Message, error) {
+	inv, err := getInventory(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgNotFound{Inventory: inv}, nil
+}
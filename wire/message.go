@@ -0,0 +1,292 @@
+// Package wire encodes and decodes the messages of the bitcoin P2P protocol.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+var (
+	ErrMessageBadMagic     = errors.New("wire: bad network magic")
+	ErrMessageBadCommand   = errors.New("wire: bad command")
+	ErrMessageBadChecksum  = errors.New("wire: bad checksum")
+	ErrMessageTooLarge     = errors.New("wire: message too large")
+	ErrMessageTooManyItems = errors.New("wire: too many items")
+)
+
+const (
+	// ProtocolVersion is the latest protocol version implemented
+	ProtocolVersion = 70016
+	// SendHeadersVersion is the first version with sendheaders
+	SendHeadersVersion = 70012
+	// FeeFilterVersion is the first version with feefilter
+	FeeFilterVersion = 70013
+	// ShortIDsBlocksVersion is the first version with compact blocks
+	ShortIDsBlocksVersion = 70014
+	// WtxidRelayVersion is the first version with wtxidrelay
+	WtxidRelayVersion = 70016
+
+	// MessageHeaderSize is the size of the magic, command, length and checksum preceding every payload
+	MessageHeaderSize = 24
+	// CommandSize is the size of the zero padded command name
+	CommandSize = 12
+	// MaxMessagePayload is the maximal size of a payload, enough for a block
+	MaxMessagePayload = 4000000
+)
+
+const (
+	CmdVersion      = "version"
+	CmdVerack       = "verack"
+	CmdPing         = "ping"
+	CmdPong         = "pong"
+	CmdInv          = "inv"
+	CmdGetData      = "getdata"
+	CmdNotFound     = "notfound"
+	CmdGetHeaders   = "getheaders"
+	CmdHeaders      = "headers"
+	CmdGetBlocks    = "getblocks"
+	CmdBlock        = "block"
+	CmdTx           = "tx"
+	CmdAddr         = "addr"
+	CmdSendHeaders  = "sendheaders"
+	CmdFeeFilter    = "feefilter"
+	CmdSendCmpct    = "sendcmpct"
+	CmdWtxidRelay   = "wtxidrelay"
+	CmdSendAddrV2   = "sendaddrv2"
+	CmdCmpctBlock   = "cmpctblock"
+	CmdGetBlockTxn  = "getblocktxn"
+	CmdBlockTxn     = "blocktxn"
+	CmdAddrV2       = "addrv2"
+	CmdSendTxRcncl  = "sendtxrcncl"
+	CmdReqRecon     = "reqrecon"
+	CmdSketch       = "sketch"
+	CmdReconcilDiff = "reconcildiff"
+)
+
+// Message is a P2P message, Bytes returns its payload
+type Message interface {
+	Command() string
+	Bytes() []byte
+}
+
+// messageDecoders decode the payload of every known command
+var messageDecoders = map[string]func(buffer *Buffer) (Message, error){
+	CmdVersion:      decodeMsgVersion,
+	CmdVerack:       decodeMsgVerack,
+	CmdPing:         decodeMsgPing,
+	CmdPong:         decodeMsgPong,
+	CmdInv:          decodeMsgInv,
+	CmdGetData:      decodeMsgGetData,
+	CmdNotFound:     decodeMsgNotFound,
+	CmdGetHeaders:   decodeMsgGetHeaders,
+	CmdGetBlocks:    decodeMsgGetBlocks,
+	CmdHeaders:      decodeMsgHeaders,
+	CmdBlock:        decodeMsgBlock,
+	CmdTx:           decodeMsgTx,
+	CmdAddr:         decodeMsgAddr,
+	CmdSendHeaders:  decodeMsgSendHeaders,
+	CmdFeeFilter:    decodeMsgFeeFilter,
+	CmdSendCmpct:    decodeMsgSendCmpct,
+	CmdWtxidRelay:   decodeMsgWtxidRelay,
+	CmdSendAddrV2:   decodeMsgSendAddrV2,
+	CmdCmpctBlock:   decodeMsgCmpctBlock,
+	CmdGetBlockTxn:  decodeMsgGetBlockTxn,
+	CmdBlockTxn:     decodeMsgBlockTxn,
+	CmdAddrV2:       decodeMsgAddrV2,
+	CmdSendTxRcncl:  decodeMsgSendTxRcncl,
+	CmdReqRecon:     decodeMsgReqRecon,
+	CmdSketch:       decodeMsgSketch,
+	CmdReconcilDiff: decodeMsgReconcilDiff,
+}
+
+// MessageError is returned for a well framed message whose payload cannot be decoded,
+// the stream can still be read after it.
+type MessageError struct {
+	Command string
+	Err     error
+}
+
+func (e *MessageError) Error() string {
+	return "wire: bad " + e.Command + " message: " + e.Err.Error()
+}
+
+// MsgUnknown is a message with a command this package does not decode
+type MsgUnknown struct {
+	Cmd     string
+	Payload []byte
+}
+
+func (m *MsgUnknown) Command() string { return m.Cmd }
+func (m *MsgUnknown) Bytes() []byte   { return m.Payload }
+
+// NewMessageFromBytes decodes the payload of command, unknown commands give a MsgUnknown
+func NewMessageFromBytes(command string, payload []byte) (Message, error) {
+	decode, ok := messageDecoders[command]
+	if !ok {
+		return &MsgUnknown{Cmd: command, Payload: payload}, nil
+	}
+
+	return decode(NewReadBuffer(payload))
+}
+
+// MessageHeader precedes every message payload on the wire
+type MessageHeader struct {
+	Magic    uint32
+	Command  string
+	Length   uint32
+	Checksum [4]byte
+}
+
+// Checksum returns the first four bytes of the double SHA256 of a payload
+func Checksum(payload []byte) [4]byte {
+	var checksum [4]byte
+	h := DHash256(payload)
+	copy(checksum[:], h[:4])
+	return checksum
+}
+
+// ParseCommand returns the command of its CommandSize bytes encoding: printable
+// ASCII followed by zero padding only.
+func ParseCommand(data []byte) (string, error) {
+	if len(data) != CommandSize {
+		return "", ErrMessageBadCommand
+	}
+
+	n := bytes.IndexByte(data, 0)
+	if n < 0 {
+		n = CommandSize
+	}
+
+	for i, c := range data {
+		if (i < n && (c < 0x20 || c > 0x7e)) || (i >= n && c != 0) {
+			return "", ErrMessageBadCommand
+		}
+	}
+
+	return string(data[:n]), nil
+}
+
+func NewMessageHeaderFromBytes(data []byte) (*MessageHeader, error) {
+	if len(data) != MessageHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	command, err := ParseCommand(data[4 : 4+CommandSize])
+	if err != nil {
+		return nil, err
+	}
+
+	h := &MessageHeader{
+		Magic:   binary.LittleEndian.Uint32(data),
+		Command: command,
+		Length:  binary.LittleEndian.Uint32(data[4+CommandSize:]),
+	}
+	copy(h.Checksum[:], data[4+CommandSize+4:])
+
+	return h, nil
+}
+
+func (h *MessageHeader) Bytes() []byte {
+	data := make([]byte, MessageHeaderSize)
+	binary.LittleEndian.PutUint32(data, h.Magic)
+	copy(data[4:4+CommandSize], h.Command)
+	binary.LittleEndian.PutUint32(data[4+CommandSize:], h.Length)
+	copy(data[4+CommandSize+4:], h.Checksum[:])
+	return data
+}
+
+// EncodeMessage frames msg for the network with magic
+func EncodeMessage(magic uint32, msg Message) ([]byte, error) {
+	command := msg.Command()
+	if len(command) > CommandSize {
+		return nil, ErrMessageBadCommand
+	}
+
+	payload := msg.Bytes()
+	if len(payload) > MaxMessagePayload {
+		return nil, ErrMessageTooLarge
+	}
+
+	h := &MessageHeader{
+		Magic:    magic,
+		Command:  command,
+		Length:   uint32(len(payload)),
+		Checksum: Checksum(payload),
+	}
+
+	return append(h.Bytes(), payload...), nil
+}
+
+// WriteMessage writes msg framed for the network with magic
+func WriteMessage(w io.Writer, magic uint32, msg Message) error {
+	data, err := EncodeMessage(magic, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadMessage reads and decodes the next message, which must be for the network with magic
+func ReadMessage(r io.Reader, magic uint32) (Message, error) {
+	return ReadMessageMax(r, magic, MaxMessagePayload)
+}
+
+// ReadMessageMax is ReadMessage failing with ErrMessageTooLarge on payloads larger than max
+func ReadMessageMax(r io.Reader, magic uint32, max uint32) (Message, error) {
+	data := make([]byte, MessageHeaderSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	h, err := NewMessageHeaderFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Magic != magic {
+		return nil, ErrMessageBadMagic
+	}
+
+	if h.Length > max {
+		return nil, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, h.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if Checksum(payload) != h.Checksum {
+		return nil, &MessageError{Command: h.Command, Err: ErrMessageBadChecksum}
+	}
+
+	msg, err := NewMessageFromBytes(h.Command, payload)
+	if err != nil {
+		return nil, &MessageError{Command: h.Command, Err: err}
+	}
+
+	return msg, nil
+}
+
+// getCount reads a CompactSize item count, failing above max
+func getCount(buffer *Buffer, max int) (int, error) {
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > uint64(max) {
+		return 0, ErrMessageTooManyItems
+	}
+
+	return int(n), nil
+}
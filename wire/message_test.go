@@ -0,0 +1,272 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	bcore "github.com/detailyang/go-bcore"
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const testMagic = 0xd9b4bef9
+
+func TestMessageVerack(t *testing.T) {
+	expect := "f9beb4d976657261636b000000000000000000005df6e0e2"
+
+	data, err := EncodeMessage(testMagic, &MsgVerack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != expect {
+		t.Fatalf("expect %s, got %x", expect, data)
+	}
+
+	msg, err := ReadMessage(bytes.NewReader(data), testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*MsgVerack); !ok {
+		t.Fatalf("expect verack, got %T", msg)
+	}
+}
+
+func newTestTransaction() *bcore.Transaction {
+	return &bcore.Transaction{
+		Version: 2,
+		Inputs: []*bcore.TransactionInput{{
+			PrevOutput:    bcore.NewOutPoint(Hash{1}, 3),
+			ScriptSig:     []byte{},
+			Sequence:      0xfffffffd,
+			ScriptWitness: bcore.NewScriptWitness([][]byte{{1, 2}, {3}}),
+		}},
+		Outputs: []*bcore.TransactionOutput{{Value: 5000, ScriptPubkey: []byte{0x51}}},
+	}
+}
+
+func TestMessageRoundtrip(t *testing.T) {
+	header := &bcore.BlockHeader{
+		Version:    4,
+		PrevHash:   Hash{2},
+		MerkleRoot: Hash{3},
+		Time:       1600000000,
+		Bits:       NewCompact(0x207fffff),
+		Nonce:      7,
+	}
+	tx := newTestTransaction()
+	addr := &NetAddress{Services: SFNodeNetwork | SFNodeWitness, IP: net.ParseIP("10.0.0.1").To16(), Port: 8333}
+
+	msgs := []Message{
+		&MsgVersion{
+			Version:     ProtocolVersion,
+			Services:    SFNodeNetwork | SFNodeWitness,
+			Timestamp:   1700000000,
+			AddrRecv:    addr,
+			AddrFrom:    &NetAddress{IP: net.IPv6zero},
+			Nonce:       0x1122334455667788,
+			UserAgent:   "/bcore:0.1/",
+			StartHeight: 800000,
+			Relay:       true,
+		},
+		&MsgVerack{},
+		&MsgPing{Nonce: 42},
+		&MsgPong{Nonce: 42},
+		&MsgInv{Inventory: []*InvVect{NewInvVect(InvTypeWTx, Hash{4}), NewInvVect(InvTypeBlock, Hash{5})}},
+		&MsgGetData{Inventory: []*InvVect{NewInvVect(InvTypeWitnessBlock, Hash{5})}},
+		&MsgNotFound{Inventory: []*InvVect{NewInvVect(InvTypeWitnessTx, Hash{6})}},
+		&MsgGetHeaders{BlockLocator{Version: ProtocolVersion, Hashes: []Hash{{7}, {8}}, HashStop: Hash{9}}},
+		&MsgGetBlocks{BlockLocator{Version: ProtocolVersion, Hashes: []Hash{{7}}}},
+		&MsgHeaders{Headers: []*bcore.BlockHeader{header, header}},
+		&MsgBlock{Block: bcore.NewBlock(header, []*bcore.Transaction{tx})},
+		&MsgTx{Tx: tx},
+		&MsgAddr{Addresses: []*NetAddress{{Timestamp: 1700000000, Services: SFNodeNetwork, IP: net.ParseIP("2001:db8::1"), Port: 18444}}},
+		&MsgSendHeaders{},
+		&MsgFeeFilter{FeeRate: 1000},
+		&MsgSendCmpct{Announce: true, Version: 2},
+		&MsgWtxidRelay{},
+		&MsgSendAddrV2{},
+		&MsgCmpctBlock{Header: header, Nonce: 9, ShortIDs: []uint64{0xffffffffffff, 1}, Prefilled: []*PrefilledTx{{Index: 0, Tx: tx}, {Index: 3, Tx: tx}}},
+		&MsgGetBlockTxn{BlockHash: Hash{10}, Indexes: []uint32{1, 2, 7}},
+		&MsgBlockTxn{BlockHash: Hash{10}, Txs: []*bcore.Transaction{tx, tx}},
+		&MsgAddrV2{Addresses: []*NetAddressV2{
+			{Timestamp: 1700000000, Services: SFNodeNetwork | SFNodeP2PV2, Network: NetIPv4, Addr: []byte{1, 2, 3, 4}, Port: 8333},
+			{Timestamp: 1700000000, Network: NetTorV3, Addr: bytes.Repeat([]byte{5}, 32), Port: 8333},
+			{Network: 42, Addr: []byte{1, 2, 3}},
+		}},
+		&MsgSendTxRcncl{Version: TxReconciliationVersion, Salt: 0x1122334455667788},
+		&MsgReqRecon{SetSize: 300, Q: 8191},
+		&MsgSketch{Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		&MsgReconcilDiff{Success: true, AskShortIDs: []uint32{1, 0xffffffff}},
+	}
+
+	var stream bytes.Buffer
+	for _, msg := range msgs {
+		if err := WriteMessage(&stream, testMagic, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, expect := range msgs {
+		msg, err := ReadMessage(&stream, testMagic)
+		if err != nil {
+			t.Fatalf("%s: %v", expect.Command(), err)
+		}
+
+		if msg.Command() != expect.Command() || !bytes.Equal(msg.Bytes(), expect.Bytes()) {
+			t.Fatalf("%s: expect %x, got %s %x", expect.Command(), expect.Bytes(), msg.Command(), msg.Bytes())
+		}
+
+		if reflect.TypeOf(msg) != reflect.TypeOf(expect) {
+			t.Fatalf("%s: expect %T, got %T", expect.Command(), expect, msg)
+		}
+	}
+
+	if _, err := ReadMessage(&stream, testMagic); err != io.EOF {
+		t.Fatalf("expect %v, got %v", io.EOF, err)
+	}
+}
+
+func TestMessageErrors(t *testing.T) {
+	data, err := EncodeMessage(testMagic, &MsgPing{Nonce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadMessage(bytes.NewReader(data), 0x0709110b); err != ErrMessageBadMagic {
+		t.Fatalf("expect %v, got %v", ErrMessageBadMagic, err)
+	}
+
+	bad := append([]byte{}, data...)
+	bad[len(bad)-1] ^= 1
+	if _, err := ReadMessage(bytes.NewReader(bad), testMagic); err == nil || err.(*MessageError).Err != ErrMessageBadChecksum {
+		t.Fatalf("expect %v, got %v", ErrMessageBadChecksum, err)
+	}
+
+	short, err := EncodeMessage(testMagic, &MsgUnknown{Cmd: CmdPing, Payload: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMessage(bytes.NewReader(short), testMagic); err == nil || err.(*MessageError).Command != CmdPing {
+		t.Fatalf("expect ping message error, got %v", err)
+	}
+
+	if _, err := ReadMessageMax(bytes.NewReader(data), testMagic, 7); err != ErrMessageTooLarge {
+		t.Fatalf("expect %v, got %v", ErrMessageTooLarge, err)
+	}
+
+	if _, err := ReadMessage(bytes.NewReader(data[:len(data)-1]), testMagic); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	large := (&MessageHeader{Magic: testMagic, Command: CmdBlock, Length: MaxMessagePayload + 1}).Bytes()
+	if _, err := ReadMessage(bytes.NewReader(large), testMagic); err != ErrMessageTooLarge {
+		t.Fatalf("expect %v, got %v", ErrMessageTooLarge, err)
+	}
+
+	command := append([]byte{}, data...)
+	copy(command[4:], "ping\x00x")
+	if _, err := ReadMessage(bytes.NewReader(command), testMagic); err != ErrMessageBadCommand {
+		t.Fatalf("expect %v, got %v", ErrMessageBadCommand, err)
+	}
+
+	if _, err := NewMessageFromBytes(CmdInv, NewBuffer().PutVarInt(MaxInvSize+1).Bytes()); err != ErrMessageTooManyItems {
+		t.Fatalf("expect %v, got %v", ErrMessageTooManyItems, err)
+	}
+
+	version := (&MsgVersion{AddrRecv: &NetAddress{}, AddrFrom: &NetAddress{}}).Bytes()
+	version = NewBuffer().PutBytes(version[:len(version)-6]).PutVarInt(1 << 62).Bytes()
+	if _, err := NewMessageFromBytes(CmdVersion, version); err != ErrMessageTooLarge {
+		t.Fatalf("expect %v, got %v", ErrMessageTooLarge, err)
+	}
+
+	msg, err := NewMessageFromBytes("sendpackages", []byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := msg.(*MsgUnknown); !ok || u.Command() != "sendpackages" || !bytes.Equal(u.Bytes(), []byte{1, 2}) {
+		t.Fatalf("unknown command: got %#v", msg)
+	}
+}
+
+func TestMessageOversizedCounts(t *testing.T) {
+	huge := uint64(1) << 62
+	input := newTestTransaction().Inputs[0]
+	inputs := NewBuffer().PutVarInt(1).PutBytes(input.Bytes()).Bytes()
+	tx := NewBuffer().PutUint32(2).PutVarInt(huge).Bytes()
+
+	txs := map[string][]byte{
+		"inputs":         tx,
+		"witness inputs": NewBuffer().PutUint32(2).PutUint8(0).PutUint8(1).PutVarInt(huge).Bytes(),
+		"outputs":        NewBuffer().PutUint32(2).PutBytes(inputs).PutVarInt(huge).Bytes(),
+		"witness items":  NewBuffer().PutUint32(2).PutUint8(0).PutUint8(1).PutBytes(inputs).PutVarInt(0).PutVarInt(huge).Bytes(),
+		"scriptSig":      NewBuffer().PutUint32(2).PutVarInt(1).PutBytes(input.PrevOutput.Bytes()).PutVarInt(huge).Bytes(),
+		"scriptPubkey":   NewBuffer().PutUint32(2).PutBytes(inputs).PutVarInt(1).PutUint64(1).PutVarInt(huge).Bytes(),
+		"witness item":   NewBuffer().PutUint32(2).PutUint8(0).PutUint8(1).PutBytes(inputs).PutVarInt(0).PutVarInt(1).PutVarInt(huge).Bytes(),
+	}
+	for name, payload := range txs {
+		if _, err := NewMessageFromBytes(CmdTx, payload); err != bcore.ErrTransactionTooManyItems {
+			t.Fatalf("%s: expect %v, got %v", name, bcore.ErrTransactionTooManyItems, err)
+		}
+	}
+
+	header := newTestCmpctBlock(0).Header.Bytes()
+	payloads := map[string][]byte{
+		CmdBlock:      NewBuffer().PutBytes(header).PutVarInt(huge).Bytes(),
+		CmdCmpctBlock: NewBuffer().PutBytes(header).PutUint64(1).PutVarInt(0).PutVarInt(1).PutVarInt(0).PutBytes(tx).Bytes(),
+		CmdBlockTxn:   NewBuffer().PutHash(Hash{1}).PutVarInt(1).PutBytes(tx).Bytes(),
+	}
+	for command, payload := range payloads {
+		if _, err := NewMessageFromBytes(command, payload); err != bcore.ErrTransactionTooManyItems {
+			t.Fatalf("%s: expect %v, got %v", command, bcore.ErrTransactionTooManyItems, err)
+		}
+	}
+}
+
+func TestMsgVersionRelay(t *testing.T) {
+	m := &MsgVersion{
+		Version:  70001,
+		AddrRecv: &NetAddress{IP: net.IPv6zero},
+		AddrFrom: &NetAddress{IP: net.IPv6zero},
+	}
+
+	// Old peers omit the relay flag, which defaults to true
+	payload := m.Bytes()
+	msg, err := NewMessageFromBytes(CmdVersion, payload[:len(payload)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.(*MsgVersion).Relay {
+		t.Fatal("missing relay flag should default to true")
+	}
+
+	msg, err = NewMessageFromBytes(CmdVersion, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.(*MsgVersion).Relay {
+		t.Fatal("relay flag should be false")
+	}
+}
+
+func TestMsgVersionNoAddresses(t *testing.T) {
+	m := &MsgVersion{Version: ProtocolVersion, UserAgent: "/bcore:0.1/"}
+
+	msg, err := NewMessageFromBytes(CmdVersion, m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing addresses are sent as zero ones
+	v := msg.(*MsgVersion)
+	for _, na := range []*NetAddress{v.AddrRecv, v.AddrFrom} {
+		if na.Services != 0 || !na.IP.Equal(net.IPv6zero) || na.Port != 0 {
+			t.Fatalf("unexpected address %+v", na)
+		}
+	}
+	if v.UserAgent != m.UserAgent {
+		t.Fatalf("unexpected %#v", v)
+	}
+}
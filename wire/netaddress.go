@@ -0,0 +1,126 @@
+package wire
+
+import (
+	"encoding/binary"
+	"net"
+
+	. "github.com/detailyang/go-bprimitives"
+)
+
+// Service flags advertised in version and addr messages
+const (
+	SFNodeNetwork        uint64 = 1 << 0
+	SFNodeBloom          uint64 = 1 << 2
+	SFNodeWitness        uint64 = 1 << 3
+	SFNodeCompactFilters uint64 = 1 << 6
+	SFNodeNetworkLimited uint64 = 1 << 10
+	SFNodeP2PV2          uint64 = 1 << 11
+)
+
+const (
+	// NetAddressSize is the size of an address without its timestamp, as in version messages
+	NetAddressSize = 26
+)
+
+// NetAddress is a peer address, IPv4 addresses are IPv4-mapped IPv6 addresses on the wire
+type NetAddress struct {
+	// Last time the address was seen, absent in version messages
+	Timestamp uint32
+	Services  uint64
+	IP        net.IP
+	Port      uint16
+}
+
+func NewNetAddress(addr *net.TCPAddr, services uint64) *NetAddress {
+	return &NetAddress{
+		Services: services,
+		IP:       addr.IP,
+		Port:     uint16(addr.Port),
+	}
+}
+
+func (na *NetAddress) TCPAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: na.IP, Port: int(na.Port)}
+}
+
+func (na *NetAddress) putBuffer(buffer *Buffer, timestamp bool) {
+	if timestamp {
+		buffer.PutUint32(na.Timestamp)
+	}
+	buffer.PutUint64(na.Services)
+
+	ip := na.IP.To16()
+	if ip == nil {
+		ip = net.IPv6zero
+	}
+	buffer.PutBytes(ip)
+
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, na.Port)
+	buffer.PutBytes(port)
+}
+
+func newNetAddressFromBuffer(buffer *Buffer, timestamp bool) (*NetAddress, error) {
+	na := &NetAddress{}
+
+	var err error
+	if timestamp {
+		if na.Timestamp, err = buffer.GetUint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	if na.Services, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	ip, err := buffer.GetBytes(net.IPv6len)
+	if err != nil {
+		return nil, err
+	}
+	na.IP = net.IP(append([]byte{}, ip...))
+
+	port, err := buffer.GetBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	na.Port = binary.BigEndian.Uint16(port)
+
+	return na, nil
+}
+
+const (
+	// MaxAddrToSend is the maximal number of addresses in an addr message
+	MaxAddrToSend = 1000
+)
+
+// MsgAddr relays known peer addresses
+type MsgAddr struct {
+	Addresses []*NetAddress
+}
+
+func (m *MsgAddr) Command() string { return CmdAddr }
+
+func (m *MsgAddr) Bytes() []byte {
+	buffer := NewBuffer().PutVarInt(uint64(len(m.Addresses)))
+	for _, na := range m.Addresses {
+		na.putBuffer(buffer, true)
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgAddr(buffer *Buffer) (Message, error) {
+	n, err := getCount(buffer, MaxAddrToSend)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgAddr{Addresses: make([]*NetAddress, n)}
+	for i := range m.Addresses {
+		if m.Addresses[i], err = newNetAddressFromBuffer(buffer, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,129 @@
+package wire
+
+import (
+	"errors"
+
+	bcore "github.com/detailyang/go-bcore"
+)
+
+var (
+	ErrCmpctInvalid          = errors.New("wire: invalid compact block")
+	ErrCmpctShortIDCollision = errors.New("wire: compact block short id collision")
+	ErrCmpctIncomplete       = errors.New("wire: compact block transactions missing")
+	ErrCmpctWrongBlockTxn    = errors.New("wire: blocktxn does not match the missing transactions")
+	ErrCmpctMerkleMismatch   = errors.New("wire: reconstructed block merkle root mismatch")
+)
+
+// PartialBlock reconstructs a block from a compact block, the transactions of a
+// mempool and the missing ones fetched with getblocktxn.
+type PartialBlock struct {
+	header *bcore.BlockHeader
+	txs    []*bcore.Transaction
+}
+
+// NewPartialBlock places the prefilled transactions of m, and the transactions of
+// pool matching its short IDs. A short ID matched by several pool transactions is
+// left missing. It fails with ErrCmpctShortIDCollision when two transactions of
+// the block share a short ID, the full block must then be requested instead.
+func NewPartialBlock(m *MsgCmpctBlock, pool []*bcore.Transaction) (*PartialBlock, error) {
+	count := m.TxCount()
+	if m.Header == nil || count == 0 || count > MaxCmpctBlockTxs {
+		return nil, ErrCmpctInvalid
+	}
+
+	b := &PartialBlock{header: m.Header, txs: make([]*bcore.Transaction, count)}
+
+	last := -1
+	for _, p := range m.Prefilled {
+		if p.Tx == nil || int(p.Index) <= last || int(p.Index) >= count {
+			return nil, ErrCmpctInvalid
+		}
+		b.txs[p.Index] = p.Tx
+		last = int(p.Index)
+	}
+
+	// Positions of the short IDs skip the prefilled transactions
+	positions := make(map[uint64]int, len(m.ShortIDs))
+	pos := 0
+	for _, id := range m.ShortIDs {
+		for b.txs[pos] != nil {
+			pos++
+		}
+
+		if _, ok := positions[id]; ok {
+			return nil, ErrCmpctShortIDCollision
+		}
+		positions[id] = pos
+		pos++
+	}
+
+	k0, k1 := m.ShortIDKeys()
+	collided := make(map[int]bool)
+	for _, tx := range pool {
+		wtxid := tx.WitnessHash()
+		pos, ok := positions[shortID(k0, k1, wtxid)]
+		if !ok || collided[pos] {
+			continue
+		}
+
+		if b.txs[pos] == nil {
+			b.txs[pos] = tx
+		} else if b.txs[pos].WitnessHash() != wtxid {
+			b.txs[pos] = nil
+			collided[pos] = true
+		}
+	}
+
+	return b, nil
+}
+
+// Missing returns the positions of the transactions to fetch with getblocktxn
+func (b *PartialBlock) Missing() []uint32 {
+	var missing []uint32
+	for i, tx := range b.txs {
+		if tx == nil {
+			missing = append(missing, uint32(i))
+		}
+	}
+	return missing
+}
+
+// GetBlockTxn returns the request for the missing transactions
+func (b *PartialBlock) GetBlockTxn() *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{BlockHash: b.header.Hash(), Indexes: b.Missing()}
+}
+
+// Block returns the reconstructed block once no transaction is missing. A merkle
+// root mismatch means a short ID matched the wrong transaction, the full block
+// must then be requested.
+func (b *PartialBlock) Block() (*bcore.Block, error) {
+	for _, tx := range b.txs {
+		if tx == nil {
+			return nil, ErrCmpctIncomplete
+		}
+	}
+
+	block := bcore.NewBlock(b.header, append([]*bcore.Transaction(nil), b.txs...))
+	if block.MerkleRoot() != b.header.MerkleRoot {
+		return nil, ErrCmpctMerkleMismatch
+	}
+
+	return block, nil
+}
+
+// Fill places the transactions of a blocktxn answering GetBlockTxn and returns the block
+func (b *PartialBlock) Fill(m *MsgBlockTxn) (*bcore.Block, error) {
+	missing := b.Missing()
+	if m.BlockHash != b.header.Hash() || len(m.Txs) != len(missing) {
+		return nil, ErrCmpctWrongBlockTxn
+	}
+
+	for i, pos := range missing {
+		if m.Txs[i] == nil {
+			return nil, ErrCmpctWrongBlockTxn
+		}
+		b.txs[pos] = m.Txs[i]
+	}
+
+	return b.Block()
+}
@@ -0,0 +1,135 @@
+package wire
+
+import (
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// TxReconciliationVersion is the version of set reconciliation implemented, BIP330
+	TxReconciliationVersion = 1
+	// MaxSketchCapacity is the maximal number of differences a sketch message decodes
+	MaxSketchCapacity = 2 << 12
+	// SketchElementSize is the size of a sketch element, a short transaction ID
+	SketchElementSize = 4
+)
+
+// MsgSendTxRcncl announces support for transaction reconciliation with our salt of
+// short transaction IDs, sent between version and verack, BIP330
+type MsgSendTxRcncl struct {
+	Version uint32
+	Salt    uint64
+}
+
+func (m *MsgSendTxRcncl) Command() string { return CmdSendTxRcncl }
+
+func (m *MsgSendTxRcncl) Bytes() []byte {
+	return NewBuffer().PutUint32(m.Version).PutUint64(m.Salt).Bytes()
+}
+
+func decodeMsgSendTxRcncl(buffer *Buffer) (Message, error) {
+	m := &MsgSendTxRcncl{}
+
+	var err error
+	if m.Version, err = buffer.GetUint32(); err != nil {
+		return nil, err
+	}
+	if m.Salt, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// MsgReqRecon starts a reconciliation round, sent by the peer which opened the connection
+type MsgReqRecon struct {
+	// Number of transactions the initiator has to announce
+	SetSize uint16
+	// Coefficient of the difference estimate, scaled by 32767
+	Q uint16
+}
+
+func (m *MsgReqRecon) Command() string { return CmdReqRecon }
+
+func (m *MsgReqRecon) Bytes() []byte {
+	return NewBuffer().
+		PutBytes([]byte{byte(m.SetSize), byte(m.SetSize >> 8)}).
+		PutBytes([]byte{byte(m.Q), byte(m.Q >> 8)}).
+		Bytes()
+}
+
+func decodeMsgReqRecon(buffer *Buffer) (Message, error) {
+	data, err := buffer.GetBytes(4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MsgReqRecon{
+		SetSize: uint16(data[0]) | uint16(data[1])<<8,
+		Q:       uint16(data[2]) | uint16(data[3])<<8,
+	}, nil
+}
+
+// MsgSketch answers reqrecon with the sketch of the short IDs the responder has to announce
+type MsgSketch struct {
+	Data []byte
+}
+
+func (m *MsgSketch) Command() string { return CmdSketch }
+func (m *MsgSketch) Bytes() []byte   { return NewBuffer().PutVarBytes(m.Data).Bytes() }
+
+func decodeMsgSketch(buffer *Buffer) (Message, error) {
+	n, err := getCount(buffer, MaxSketchCapacity*SketchElementSize)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := buffer.GetBytes(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MsgSketch{Data: append([]byte{}, data...)}, nil
+}
+
+// MsgReconcilDiff ends a reconciliation round, asking for the transactions of the
+// short IDs the initiator lacks. On failure the responder announces all its transactions.
+type MsgReconcilDiff struct {
+	Success     bool
+	AskShortIDs []uint32
+}
+
+func (m *MsgReconcilDiff) Command() string { return CmdReconcilDiff }
+
+func (m *MsgReconcilDiff) Bytes() []byte {
+	success := uint8(0)
+	if m.Success {
+		success = 1
+	}
+
+	buffer := NewBuffer().PutUint8(success).PutVarInt(uint64(len(m.AskShortIDs)))
+	for _, id := range m.AskShortIDs {
+		buffer.PutUint32(id)
+	}
+	return buffer.Bytes()
+}
+
+func decodeMsgReconcilDiff(buffer *Buffer) (Message, error) {
+	success, err := buffer.GetUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := getCount(buffer, MaxSketchCapacity)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MsgReconcilDiff{Success: success != 0, AskShortIDs: make([]uint32, n)}
+	for i := range m.AskShortIDs {
+		if m.AskShortIDs[i], err = buffer.GetUint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
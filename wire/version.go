@@ -0,0 +1,117 @@
+package wire
+
+import (
+	. "github.com/detailyang/go-bprimitives"
+)
+
+const (
+	// MaxUserAgentSize is the maximal size of the user agent of a version message
+	MaxUserAgentSize = 256
+)
+
+// MsgVersion opens the handshake of a connection
+type MsgVersion struct {
+	Version     int32
+	Services    uint64
+	Timestamp   int64
+	AddrRecv    *NetAddress
+	AddrFrom    *NetAddress
+	Nonce       uint64
+	UserAgent   string
+	StartHeight int32
+	// Whether the peer wants transactions announced before sending a filter, BIP37
+	Relay bool
+}
+
+func (m *MsgVersion) Command() string { return CmdVersion }
+
+func (m *MsgVersion) Bytes() []byte {
+	buffer := NewBuffer().
+		PutUint32(uint32(m.Version)).
+		PutUint64(m.Services).
+		PutUint64(uint64(m.Timestamp))
+	for _, na := range []*NetAddress{m.AddrRecv, m.AddrFrom} {
+		// A missing address is sent as the zero one
+		if na == nil {
+			na = &NetAddress{}
+		}
+		na.putBuffer(buffer, false)
+	}
+	buffer.PutUint64(m.Nonce).
+		PutVarBytes([]byte(m.UserAgent)).
+		PutUint32(uint32(m.StartHeight))
+
+	if m.Relay {
+		buffer.PutUint8(1)
+	} else {
+		buffer.PutUint8(0)
+	}
+
+	return buffer.Bytes()
+}
+
+func decodeMsgVersion(buffer *Buffer) (Message, error) {
+	m := &MsgVersion{}
+
+	version, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	m.Version = int32(version)
+
+	if m.Services, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	timestamp, err := buffer.GetUint64()
+	if err != nil {
+		return nil, err
+	}
+	m.Timestamp = int64(timestamp)
+
+	if m.AddrRecv, err = newNetAddressFromBuffer(buffer, false); err != nil {
+		return nil, err
+	}
+
+	if m.AddrFrom, err = newNetAddressFromBuffer(buffer, false); err != nil {
+		return nil, err
+	}
+
+	if m.Nonce, err = buffer.GetUint64(); err != nil {
+		return nil, err
+	}
+
+	n, err := buffer.GetVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxUserAgentSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	userAgent, err := buffer.GetBytes(int(n))
+	if err != nil {
+		return nil, err
+	}
+	m.UserAgent = string(userAgent)
+
+	height, err := buffer.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	m.StartHeight = int32(height)
+
+	// The relay flag is optional and defaults to true
+	relay, err := buffer.GetUint8()
+	m.Relay = err != nil || relay != 0
+
+	return m, nil
+}
+
+// MsgVerack acknowledges a version message
+type MsgVerack struct{}
+
+func (m *MsgVerack) Command() string { return CmdVerack }
+func (m *MsgVerack) Bytes() []byte   { return nil }
+
+func decodeMsgVerack(*Buffer) (Message, error) { return &MsgVerack{}, nil }